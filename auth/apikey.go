@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/db"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/internal/generators"
+	"github.com/rs/zerolog"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	KindAPIKey       = "api_key"
+	APIKeyCollection = model.APIKeyCollection
+
+	// APIKeyPrefix starts every API key, which tells them apart from access tokens
+	APIKeyPrefix = "port_"
+	// ScopeGenerate is the scope family of generating a single kind of code, like "generate:qr"
+	ScopeGenerate = "generate"
+	// LastUsedPrecision is how stale the last use recorded on a key may get, which saves a write on most calls
+	LastUsedPrecision = time.Minute
+
+	ErrInvalidAPIKey = errors.New("api key is invalid, expired or revoked")
+	ErrNoScopes      = errors.New("api key must have at least one scope")
+)
+
+// Scopes returns every scope API keys may have: an action on a resource, like "users:read", every action on a
+// resource, like "codes:*", or generating a single kind of code, like "generate:qr"
+func Scopes() []string {
+	scopes := []string{}
+	for _, r := range Resources {
+		for _, a := range []Action{ActionCreate, ActionRead, ActionUpdate, ActionDelete} {
+			scopes = append(scopes, Need(r, a).String())
+		}
+		scopes = append(scopes, r+":*")
+	}
+	kinds := generators.Kinds()
+	if i := sort.SearchStrings(kinds, "qr"); i == len(kinds) || kinds[i] != "qr" {
+		kinds = append(kinds, "qr")
+	}
+	for _, kind := range kinds {
+		scopes = append(scopes, ScopeGenerate+":"+kind)
+	}
+	return scopes
+}
+
+// ValidateScopes checks that a key has scopes, and that each is one of Scopes
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return ErrNoScopes
+	}
+	valid := map[string]bool{}
+	for _, s := range Scopes() {
+		valid[s] = true
+	}
+	for _, s := range scopes {
+		if !valid[s] {
+			return fmt.Errorf("scope %q is not one of %v", s, strings.Join(Scopes(), ", "))
+		}
+	}
+	return nil
+}
+
+// ScopesAllow checks that the scopes of a key cover a call needing the permission passed in. Calls to a narrower
+// scope, like "generate:qr", are also covered by that scope alone.
+func ScopesAllow(scopes []string, need Requirement, narrow string) bool {
+	for _, s := range scopes {
+		if s == need.String() || s == need.Resource+":*" || (narrow != "" && s == narrow) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyDirector defines a master that issues API keys to users and service accounts, and authenticates calls made
+// with them
+type APIKeyDirector struct {
+	log    *zerolog.Logger
+	ReqCtx context.Context
+	db     db.DB
+	opts   *model.UnitOptions
+}
+
+func NewAPIKeyDirector(ctx context.Context) *APIKeyDirector {
+	return &APIKeyDirector{ReqCtx: ctx, db: GetDBFromCtx(ctx), log: GetLoggerFromCtx(ctx), opts: resolveOpts(KindAPIKey).(*model.UnitOptions)}
+}
+
+// Create issues an API key to the user with the id passed in, and returns it with the key itself. The key is only
+// ever returned here: only the hash of its secret is kept. A zero rate limit leaves the key to the default, and a
+// nil expiry keeps it valid until it is revoked.
+func (d *APIKeyDirector) Create(userID, name string, scopes []string, rateLimit int, expiresAt *time.Time) (string, *model.APIKey, error) {
+	if err := ValidateScopes(scopes); err != nil {
+		return "", nil, err
+	}
+	if _, err := NewUserDirector(d.ReqCtx).Get(userID); err != nil {
+		return "", nil, err
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	secret, err := newEditToken()
+	if err != nil {
+		return "", nil, err
+	}
+	id := hex.EncodeToString(b)
+	sorted := append([]string{}, scopes...)
+	sort.Strings(sorted)
+	key := &model.APIKey{
+		ID:        id,
+		UserID:    userID,
+		Name:      name,
+		Hash:      hashToken(secret),
+		Scopes:    sorted,
+		RateLimit: rateLimit,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now().UTC(),
+	}
+	if dbResp := d.db.Create(d.ReqCtx, key, d.opts); dbResp.Err != nil {
+		return "", nil, dbResp.Err
+	}
+	return APIKeyPrefix + id + "_" + secret, key, nil
+}
+
+// Authenticate checks an API key, and returns it as stored. It returns ErrInvalidAPIKey for keys that are
+// malformed, unknown, revoked or expired.
+func (d *APIKeyDirector) Authenticate(raw string) (*model.APIKey, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(raw, APIKeyPrefix), "_")
+	if !strings.HasPrefix(raw, APIKeyPrefix) || !ok || id == "" || secret == "" {
+		return nil, ErrInvalidAPIKey
+	}
+	key := &model.APIKey{}
+	dbResp := d.db.Read(d.ReqCtx, model.ByID(id), key, d.opts)
+	if errors.Is(dbResp.Err, model.ErrNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(key.Hash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	now := time.Now().UTC()
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return nil, ErrInvalidAPIKey
+	}
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= LastUsedPrecision {
+		// a failure to record the use doesn't fail the call
+		if dbResp := d.db.Update(d.ReqCtx, model.ByID(id), model.Fields{"last_used_at": now}, d.opts); dbResp.Err != nil {
+			d.log.Warn().Str("method", "APIKeyDirector.Authenticate()").Msgf("recording the use of api key %v failed with %v", id, dbResp.Err)
+		} else {
+			key.LastUsedAt = &now
+		}
+	}
+	return key, nil
+}
+
+// List retrieves the API keys of the user with the id passed in, in the order they were created
+func (d *APIKeyDirector) List(userID string) ([]*model.APIKey, error) {
+	var keys []*model.APIKey
+	dbResp := d.db.List(d.ReqCtx, model.Filter{"user_id": userID}, &keys, &model.ListOpts{Sort: []model.Sort{{Field: "created_at"}}}, d.opts)
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	return keys, nil
+}
+
+// Revoke deletes the API key with the id passed in, of the user passed in, for good. It returns model.ErrNotFound
+// when the user has no such key.
+func (d *APIKeyDirector) Revoke(userID, id string) error {
+	return d.db.Delete(d.ReqCtx, model.Filter{"_id": id, "user_id": userID}, model.HardDelete, d.opts).Err
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/db/memory"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/util"
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+	"time"
+)
+
+type APIKeyTest struct {
+	ctx  context.Context
+	user *model.User
+	suite.Suite
+}
+
+func (s *APIKeyTest) SetupTest() {
+	ctx := context.WithValue(context.Background(), util.LoggerInContext, config.NewLoggerWithWarn())
+	mem, err := memory.NewMemoryClient(ctx, "")
+	s.Require().NoError(err)
+	s.ctx = context.WithValue(ctx, util.DBInContext, mem)
+	s.user, err = NewUserDirector(s.ctx).CreateUser(*NewServiceAccount("nightly-cron"))
+	s.Require().NoError(err)
+}
+
+// TestServiceAccount tests that service accounts are created with the vanilla role, and without a way to log in
+func (s *APIKeyTest) TestServiceAccount() {
+	s.Assert().True(s.user.Service)
+	s.Assert().Nil(s.user.Email)
+	s.Assert().Empty(s.user.PasswordHash)
+	s.Assert().Equal([]string{VanillaUser.Name}, s.user.Roles.Names())
+}
+
+// TestAuthenticate tests that keys authenticate as created, that only the hash of their secret is kept, and that
+// tampered, expired and revoked keys don't authenticate
+func (s *APIKeyTest) TestAuthenticate() {
+	keys := NewAPIKeyDirector(s.ctx)
+	raw, key, err := keys.Create(s.user.ID, "cron", []string{"generate:qr", "users:read"}, 10, nil)
+	s.Require().NoError(err)
+	s.Assert().True(strings.HasPrefix(raw, APIKeyPrefix+key.ID+"_"))
+	s.Assert().NotContains(key.Hash, strings.TrimPrefix(raw, APIKeyPrefix+key.ID+"_"))
+	s.Assert().Equal([]string{"generate:qr", "users:read"}, key.Scopes)
+
+	found, err := keys.Authenticate(raw)
+	s.Require().NoError(err)
+	s.Assert().Equal(s.user.ID, found.UserID)
+	s.Assert().NotNil(found.LastUsedAt)
+	listed, err := keys.List(s.user.ID)
+	s.Require().NoError(err)
+	s.Require().Len(listed, 1)
+	s.Assert().NotNil(listed[0].LastUsedAt)
+
+	for _, bad := range []string{raw + "0", strings.TrimPrefix(raw, APIKeyPrefix), APIKeyPrefix + key.ID, "port_unknown_secret"} {
+		_, err = keys.Authenticate(bad)
+		s.Assert().ErrorIs(err, ErrInvalidAPIKey, bad)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	expired, _, err := keys.Create(s.user.ID, "expired", []string{"codes:read"}, 0, &past)
+	s.Require().NoError(err)
+	_, err = keys.Authenticate(expired)
+	s.Assert().ErrorIs(err, ErrInvalidAPIKey)
+
+	s.Require().NoError(keys.Revoke(s.user.ID, key.ID))
+	_, err = keys.Authenticate(raw)
+	s.Assert().ErrorIs(err, ErrInvalidAPIKey)
+	s.Assert().ErrorIs(keys.Revoke(s.user.ID, key.ID), model.ErrNotFound)
+}
+
+// TestScopes tests that keys are only created with known scopes, and that scopes cover the calls they name
+func (s *APIKeyTest) TestScopes() {
+	keys := NewAPIKeyDirector(s.ctx)
+	_, _, err := keys.Create(s.user.ID, "none", nil, 0, nil)
+	s.Assert().ErrorIs(err, ErrNoScopes)
+	_, _, err = keys.Create(s.user.ID, "unknown", []string{"users:fly"}, 0, nil)
+	s.Assert().Error(err)
+	_, _, err = keys.Create("unknown", "cron", []string{"users:read"}, 0, nil)
+	s.Assert().ErrorIs(err, model.ErrNotFound)
+
+	generate := Need(ResourceCodes, ActionCreate)
+	s.Assert().True(ScopesAllow([]string{"generate:qr"}, generate, "generate:qr"))
+	s.Assert().False(ScopesAllow([]string{"generate:qr"}, generate, "generate:code128"))
+	s.Assert().False(ScopesAllow([]string{"generate:qr"}, Need(ResourceCodes, ActionRead), ""))
+	s.Assert().True(ScopesAllow([]string{"codes:*"}, generate, "generate:code128"))
+	s.Assert().True(ScopesAllow([]string{"codes:create"}, generate, ""))
+	s.Assert().False(ScopesAllow([]string{"users:read"}, Need(ResourceUsers, ActionDelete), ""))
+}
+
+func TestAPIKeyTest(t *testing.T) {
+	suite.Run(t, new(APIKeyTest))
+}
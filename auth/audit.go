@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"github.com/dark-enstein/port/db"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"time"
+)
+
+var (
+	KindAudit       = "audit"
+	AuditCollection = model.AuditCollection
+
+	// The actions the audit log records
+	AuditRoleCreate = "role.create"
+	AuditRoleUpdate = "role.update"
+	AuditRoleDelete = "role.delete"
+	AuditRoleGrant  = "role.grant"
+	AuditRoleRevoke = "role.revoke"
+	AuditKeyCreate  = "key.create"
+	AuditKeyRevoke  = "key.revoke"
+	// AuditServiceAccount records creating a service account
+	AuditServiceAccount = "service_account.create"
+	AuditTOTPEnable     = "2fa.enable"
+	AuditTOTPDisable    = "2fa.disable"
+)
+
+// AuditDirector defines a master that records changes to who may do what on Port, and lists them
+type AuditDirector struct {
+	log    *zerolog.Logger
+	ReqCtx context.Context
+	db     db.DB
+	opts   *model.UnitOptions
+}
+
+func NewAuditDirector(ctx context.Context) *AuditDirector {
+	return &AuditDirector{ReqCtx: ctx, db: GetDBFromCtx(ctx), log: GetLoggerFromCtx(ctx), opts: resolveOpts(KindAudit).(*model.UnitOptions)}
+}
+
+// AuditUser returns the audit target naming the user with the id passed in
+func AuditUser(id string) string {
+	return "user:" + id
+}
+
+// AuditRole returns the audit target naming the role with the name passed in
+func AuditRole(name string) string {
+	return "role:" + name
+}
+
+// Record persists an entry of the audit log, made now. Entries have time ordered ids, which order the entries made
+// within the same millisecond.
+func (d *AuditDirector) Record(actor, action, target string, detail map[string]interface{}) (*model.AuditEntry, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	entry := &model.AuditEntry{ID: id.String(), At: time.Now().UTC(), Actor: actor, Action: action, Target: target, Detail: detail}
+	dbResp := d.db.Create(d.ReqCtx, entry, d.opts)
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	return entry, nil
+}
+
+// List retrieves a page of the entries of the audit log matching the filter, latest first
+func (d *AuditDirector) List(filter model.Filter, offset, limit int) ([]*model.AuditEntry, error) {
+	var entries []*model.AuditEntry
+	list := &model.ListOpts{Sort: []model.Sort{{Field: "at", Desc: true}, {Field: "_id", Desc: true}}, Offset: offset, Limit: limit}
+	dbResp := d.db.List(d.ReqCtx, filter, &entries, list, d.opts)
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	return entries, nil
+}
@@ -1,4 +1,15 @@
 package auth
 
+import (
+	"context"
+	"github.com/dark-enstein/port/db/model"
+)
+
+// Authentication checks the credentials of users
 type Authentication interface {
+	// Authenticate returns the user the email and password belong to. Users with two-factor authentication also
+	// pass in a code of their authenticator app, or a recovery code. It returns ErrInvalidCredentials when they
+	// don't belong to any, ErrSecondFactorRequired or ErrInvalidSecondFactor when the code is missing or wrong, and
+	// a *LockedError when the user is locked out.
+	Authenticate(ctx context.Context, email, password, code string) (*model.User, error)
 }
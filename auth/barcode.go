@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/internal/generators"
+	"github.com/dark-enstein/port/internal/generators/barcode"
+	"github.com/dark-enstein/port/internal/generators/qr"
+	"github.com/google/uuid"
+)
+
+// BarcodeDirector defines a master that generates the symbols of every registered symbology but QR codes, which
+// QRDirector generates
+type BarcodeDirector struct {
+	cfg     *config.Config
+	uid     string
+	encoder generators.Encoder
+	content string
+	opts    generators.Options
+	width   int
+	height  int
+	format  qr.Format
+	ctx     context.Context
+	code    *barcode.Code
+}
+
+func NewBarcodeDirector(ctx context.Context, uid uuid.UUID, encoder generators.Encoder, content string, opts generators.Options, width, height int, format qr.Format, config *config.Config) *BarcodeDirector {
+	return &BarcodeDirector{
+		ctx:     ctx,
+		cfg:     config,
+		uid:     uid.String(),
+		encoder: encoder,
+		content: content,
+		opts:    opts,
+		width:   width,
+		height:  height,
+		format:  format,
+	}
+}
+
+func (b *BarcodeDirector) Generate() (string, error) {
+	return b.SetUp().code.Generate()
+}
+
+// Render renders the symbol in memory, without uploading it
+func (b *BarcodeDirector) Render() (*qr.Result, error) {
+	return b.SetUp().code.Render()
+}
+
+// Result returns the description of the symbol produced by the last call to Generate or Render
+func (b *BarcodeDirector) Result() *qr.Result {
+	if b.code == nil {
+		return nil
+	}
+	return b.code.Result()
+}
+
+// SetUp sets up all the dependent structs and data, and readies director for execution
+func (b *BarcodeDirector) SetUp() *BarcodeDirector {
+	b.code = barcode.NewCode(b.ctx, b.uid, b.encoder, b.content, b.opts, b.width, b.height, b.format)
+	return b
+}
+
+func (b *BarcodeDirector) PingDependencies() (bool, error) {
+	return pingDependencies()
+}
+
+// IsEmpty checks if BarcodeDirector is empty, in other words, if SetUp() has been called.
+// IsEmpty implements the Director interface
+func (b *BarcodeDirector) IsEmpty() bool {
+	return b.code == nil
+}
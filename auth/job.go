@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/dark-enstein/port/db"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/rs/zerolog"
+	"time"
+)
+
+var (
+	KindJob       = "job"
+	JobCollection = model.JobCollection
+)
+
+// JobDirector defines a master that persists the state of asynchronous generation jobs
+type JobDirector struct {
+	log    *zerolog.Logger
+	ReqCtx context.Context
+	db     db.DB
+	opts   *model.UnitOptions
+}
+
+func NewJobDirector(ctx context.Context) *JobDirector {
+	return &JobDirector{ReqCtx: ctx, db: GetDBFromCtx(ctx), log: GetLoggerFromCtx(ctx), opts: resolveOpts(KindJob).(*model.UnitOptions)}
+}
+
+// Create persists a new job
+func (d *JobDirector) Create(job *model.Job) error {
+	log := d.log.With().Str("method", "JobDirector.Create()").Logger()
+	dbResp := d.db.Create(d.ReqCtx, job, d.opts)
+	if dbResp.Err != nil {
+		log.Info().Msgf("cannot create job %v due to error: %v", job.ID, dbResp.Err)
+		return dbResp.Err
+	}
+	return nil
+}
+
+// Get retrieves the job with the id passed in. It returns model.ErrNotFound if there is none
+func (d *JobDirector) Get(id string) (*model.Job, error) {
+	job := &model.Job{}
+	dbResp := d.db.Read(d.ReqCtx, model.ByID(id), job, d.opts)
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	return job, nil
+}
+
+// Start moves a queued job to running
+func (d *JobDirector) Start(id string) error {
+	return d.transition(id, model.JobQueued, model.Fields{"status": model.JobRunning})
+}
+
+// Requeue moves a running job back to queued, so it is run again
+func (d *JobDirector) Requeue(id string) error {
+	return d.transition(id, model.JobRunning, model.Fields{"status": model.JobQueued})
+}
+
+// Unfinished lists the jobs in status queued or running, the ones a stopped process left behind
+func (d *JobDirector) Unfinished() ([]*model.Job, error) {
+	var unfinished []*model.Job
+	for _, status := range []model.JobStatus{model.JobQueued, model.JobRunning} {
+		var jobs []*model.Job
+		dbResp := d.db.List(d.ReqCtx, model.Filter{"status": status}, &jobs, nil, d.opts)
+		if dbResp.Err != nil {
+			return nil, dbResp.Err
+		}
+		unfinished = append(unfinished, jobs...)
+	}
+	return unfinished, nil
+}
+
+// Succeed moves a running job to succeeded, recording the code it generated
+func (d *JobDirector) Succeed(id string, res *model.JobResult) error {
+	return d.transition(id, model.JobRunning, model.Fields{"status": model.JobSucceeded, "result": res})
+}
+
+// Fail moves a job in status from to failed, recording why
+func (d *JobDirector) Fail(id string, from model.JobStatus, cause error) error {
+	return d.transition(id, from, model.Fields{"status": model.JobFailed, "error": cause.Error()})
+}
+
+// RecordCallback records the outcome of notifying the callback URL of a job
+func (d *JobDirector) RecordCallback(id, status string) error {
+	dbResp := d.db.Update(d.ReqCtx, model.ByID(id), model.Fields{"callback_status": status, "updated_at": time.Now().UTC()}, d.opts)
+	return dbResp.Err
+}
+
+// transition moves the job from status from to the status in fields. The move only happens if the job is still in
+// status from, so concurrent runs of a job can't both succeed.
+func (d *JobDirector) transition(id string, from model.JobStatus, fields model.Fields) error {
+	fields["updated_at"] = time.Now().UTC()
+	dbResp := d.db.Update(d.ReqCtx, model.Filter{"_id": id, "status": from}, fields, d.opts)
+	if dbResp.Err != nil {
+		return fmt.Errorf("moving job %v from %v to %v failed: %w", id, from, fields["status"], dbResp.Err)
+	}
+	return nil
+}
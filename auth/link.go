@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/db"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/rs/zerolog"
+	"math/big"
+	"time"
+)
+
+var (
+	KindLink       = "link"
+	LinkCollection = model.LinkCollection
+
+	// SlugLength is the number of characters in the slug of a short link
+	SlugLength   = 8
+	slugAlphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	slugAttempts = 5
+
+	ErrInvalidToken = errors.New("edit token is invalid")
+)
+
+// LinkDirector defines a master that manages the short links behind dynamic QR codes
+type LinkDirector struct {
+	log    *zerolog.Logger
+	ReqCtx context.Context
+	db     db.DB
+	opts   *model.UnitOptions
+}
+
+func NewLinkDirector(ctx context.Context) *LinkDirector {
+	return &LinkDirector{ReqCtx: ctx, db: GetDBFromCtx(ctx), log: GetLoggerFromCtx(ctx), opts: resolveOpts(KindLink).(*model.UnitOptions)}
+}
+
+// Create persists a new short link with the id passed in, pointing at target. It returns the link, along with the
+// edit token that authorizes changes to it. Only a hash of the token is kept, so it can't be recovered later.
+func (d *LinkDirector) Create(id, target string) (*model.Link, string, error) {
+	log := d.log.With().Str("method", "LinkDirector.Create()").Logger()
+	slug, err := d.freeSlug()
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := newEditToken()
+	if err != nil {
+		return nil, "", err
+	}
+	link := model.NewLink(id, slug, target, hashToken(token))
+	dbResp := d.db.Create(d.ReqCtx, link, d.opts)
+	if dbResp.Err != nil {
+		log.Info().Msgf("cannot create link %v due to error: %v", id, dbResp.Err)
+		return nil, "", dbResp.Err
+	}
+	return link, token, nil
+}
+
+// Resolve retrieves the link with the slug passed in. It returns model.ErrNotFound if there is none
+func (d *LinkDirector) Resolve(slug string) (*model.Link, error) {
+	return d.read(model.Filter{"slug": slug})
+}
+
+// Get retrieves the link with the id passed in. It returns model.ErrNotFound if there is none
+func (d *LinkDirector) Get(id string) (*model.Link, error) {
+	return d.read(model.ByID(id))
+}
+
+// Delete removes the link with the id passed in for good, along with its slug
+func (d *LinkDirector) Delete(id string) error {
+	return d.db.Delete(d.ReqCtx, model.ByID(id), model.HardDelete, d.opts).Err
+}
+
+// Retarget points the link with the id passed in at a new target, if token is its edit token. It returns
+// ErrInvalidToken when it isn't, and model.ErrConflict when the link changed since it was authorized.
+func (d *LinkDirector) Retarget(id, token, target string) (*model.Link, error) {
+	link, err := d.Authorize(id, token)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	filter := model.Filter{"_id": id, model.VersionField: link.Version}
+	dbResp := d.db.Update(d.ReqCtx, filter, model.Fields{"target": target, "updated_at": now}, d.opts)
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	link.Target, link.UpdatedAt = target, now
+	link.Version++
+	return link, nil
+}
+
+// Authorize retrieves the link with the id passed in, if token is its edit token. It returns ErrInvalidToken when
+// it isn't.
+func (d *LinkDirector) Authorize(id, token string) (*model.Link, error) {
+	link, err := d.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(link.TokenHash)) != 1 {
+		return nil, ErrInvalidToken
+	}
+	return link, nil
+}
+
+func (d *LinkDirector) read(filter model.Filter) (*model.Link, error) {
+	link := &model.Link{}
+	dbResp := d.db.Read(d.ReqCtx, filter, link, d.opts)
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	return link, nil
+}
+
+// freeSlug draws random slugs until it finds one no link uses
+func (d *LinkDirector) freeSlug() (string, error) {
+	for i := 0; i < slugAttempts; i++ {
+		slug, err := newSlug()
+		if err != nil {
+			return "", err
+		}
+		_, err = d.Resolve(slug)
+		if errors.Is(err, model.ErrNotFound) {
+			return slug, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free slug found in %d attempts", slugAttempts)
+}
+
+// newSlug returns a random slug. The alphabet leaves out characters that are easily confused when read back.
+func newSlug() (string, error) {
+	b := make([]byte, SlugLength)
+	max := big.NewInt(int64(len(slugAlphabet)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = slugAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+func newEditToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/rs/zerolog"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	KindPasswordReset       = "password_reset"
+	PasswordResetCollection = model.PasswordResetCollection
+
+	// MaxFailedLogins is the number of logins a user may fail in a row before being locked out
+	MaxFailedLogins = 5
+	// LockoutDuration is how long a user is locked out for, after failing too many logins
+	LockoutDuration = 15 * time.Minute
+	// ResetTokenTTL is how long a password reset token can be used for
+	ResetTokenTTL = time.Hour
+
+	ErrInvalidCredentials = errors.New("email or password is invalid")
+	ErrInvalidResetToken  = errors.New("password reset token is invalid, expired or already used")
+
+	// loginAttempts bounds the retries of recording a login against a user that changed in the meantime
+	loginAttempts = 3
+
+	// decoy is hashed with the hasher's cost, for logins with emails no user has to take as long as the others
+	decoy     string
+	decoyOnce sync.Once
+)
+
+// LockedError is the response to logging in as a user that is locked out
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed logins: locked out until %v", e.Until.Format(time.RFC3339))
+}
+
+// ResetSender hands password reset tokens to the users they were issued to
+type ResetSender interface {
+	SendReset(ctx context.Context, user *model.User, token string, expires time.Time) error
+}
+
+// LogResetSender hands password reset tokens to the operator, through the log. It stands in for sending them by
+// email, which Port can't do yet.
+type LogResetSender struct {
+	Log *zerolog.Logger
+}
+
+func (s *LogResetSender) SendReset(ctx context.Context, user *model.User, token string, expires time.Time) error {
+	s.Log.Warn().Str("method", "LogResetSender.SendReset()").Msgf("password reset token for user %v, valid until %v: %v",
+		user.ID, expires.Format(time.RFC3339), token)
+	return nil
+}
+
+// NormalizeEmail returns the form emails are stored and looked up in
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Login checks the password of the user with the email passed in, and returns the user. Users with two-factor
+// authentication also pass in a code of their authenticator app, or a recovery code, which is then used up. Failed
+// logins are counted, and MaxFailedLogins in a row lock the user out for LockoutDuration. A successful login resets
+// the count, and hashes the password again if the cost of its hash is out of date.
+func (d *UserDirector) Login(email, password, code string) (*model.User, error) {
+	log := d.log.With().Str("method", "UserDirector.Login()").Logger()
+	user, err := d.GetByEmail(email)
+	if errors.Is(err, model.ErrNotFound) {
+		decoyOnce.Do(func() { decoy, _ = Passwords.Hash("decoy password") })
+		_, _, _ = Passwords.Verify(decoy, password)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		now := time.Now().UTC()
+		if user.LockedUntil != nil && user.LockedUntil.After(now) {
+			return nil, &LockedError{Until: *user.LockedUntil}
+		}
+		if user.PasswordHash == "" {
+			return nil, ErrInvalidCredentials
+		}
+		ok, stale, err := Passwords.Verify(user.PasswordHash, password)
+		if err != nil {
+			return nil, err
+		}
+		fields, passed := model.Fields{}, ok
+		var used model.Fields
+		if ok && user.TOTPEnabled {
+			if code == "" {
+				// the password is right, but the login isn't done yet: it counts neither way
+				return nil, ErrSecondFactorRequired
+			}
+			if used, ok = secondFactor(user, code, now); ok {
+				fields = used
+			}
+		}
+
+		switch {
+		case !ok && user.FailedLogins+1 >= MaxFailedLogins:
+			until := now.Add(LockoutDuration)
+			fields["failed_logins"], fields["locked_until"] = 0, until
+			log.Warn().Msgf("user %v failed %d logins in a row: locked out until %v", user.ID, MaxFailedLogins, until)
+		case !ok:
+			fields["failed_logins"] = user.FailedLogins + 1
+		case user.FailedLogins > 0 || user.LockedUntil != nil:
+			fields["failed_logins"], fields["locked_until"] = 0, nil
+		}
+		if ok && stale {
+			if hash, err := Passwords.Hash(password); err == nil {
+				fields["password_hash"] = hash
+			}
+		}
+
+		if len(fields) > 0 {
+			filter := model.Filter{"_id": user.ID, model.VersionField: user.Version}
+			dbResp := d.db.Update(d.ReqCtx, filter, fields, d.opts())
+			if errors.Is(dbResp.Err, model.ErrConflict) && attempt < loginAttempts {
+				// another login changed the user: count this one against what it did
+				if user, err = d.Get(user.ID); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if dbResp.Err != nil && used != nil {
+				// a code not recorded as used could be used again
+				return nil, fmt.Errorf("recording the second factor of user %v failed: %w", user.ID, dbResp.Err)
+			}
+			if dbResp.Err != nil {
+				log.Info().Msgf("cannot record login of user %v due to error: %v", user.ID, dbResp.Err)
+			} else if ok {
+				user.FailedLogins, user.LockedUntil = 0, nil
+				user.Version++
+			}
+		}
+		if passed && !ok {
+			return nil, ErrInvalidSecondFactor
+		}
+		if !ok {
+			return nil, ErrInvalidCredentials
+		}
+		return user, nil
+	}
+}
+
+// RequestReset issues a password reset token to the user with the email passed in, and hands it to the user through
+// the sender. It returns model.ErrNotFound if there is no such user. Only the hash of the token is kept.
+func (d *UserDirector) RequestReset(email string, sender ResetSender) error {
+	user, err := d.GetByEmail(email)
+	if err != nil {
+		return err
+	}
+	token, err := newEditToken()
+	if err != nil {
+		return err
+	}
+	reset := model.NewPasswordReset(hashToken(token), user.ID, ResetTokenTTL)
+	dbResp := d.db.Create(d.ReqCtx, reset, resolveOpts(KindPasswordReset).(*model.UnitOptions))
+	if dbResp.Err != nil {
+		return dbResp.Err
+	}
+	return sender.SendReset(d.ReqCtx, user, token, reset.ExpiresAt)
+}
+
+// ResetPassword sets the password of the user the reset token was issued to, and lifts any lockout. A token resets
+// a password once: it returns ErrInvalidResetToken for tokens that are unknown, expired or used.
+func (d *UserDirector) ResetPassword(token, password string) (*model.User, error) {
+	if err := ValidatePassword(password); err != nil {
+		return nil, err
+	}
+	opts := resolveOpts(KindPasswordReset).(*model.UnitOptions)
+	reset := &model.PasswordReset{}
+	dbResp := d.db.Read(d.ReqCtx, model.ByID(hashToken(token)), reset, opts)
+	if errors.Is(dbResp.Err, model.ErrNotFound) {
+		return nil, ErrInvalidResetToken
+	}
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	if reset.UsedAt != nil || !time.Now().Before(reset.ExpiresAt) {
+		return nil, ErrInvalidResetToken
+	}
+	// claiming the token is conditional on it being unused, so of two resets racing with it, one fails
+	dbResp = d.db.Update(d.ReqCtx, model.Filter{"_id": reset.ID, "used_at": nil}, model.Fields{"used_at": time.Now().UTC()}, opts)
+	if errors.Is(dbResp.Err, model.ErrNotFound) {
+		return nil, ErrInvalidResetToken
+	}
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+
+	hash, err := Passwords.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+	return d.Update(reset.UserID, model.Fields{"password_hash": hash, "failed_logins": 0, "locked_until": nil})
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/db/memory"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/db/sqldb"
+	"github.com/dark-enstein/port/util"
+	"github.com/stretchr/testify/suite"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type LoginTest struct {
+	director *UserDirector
+	sent     map[string]string
+	suite.Suite
+}
+
+// SendReset keeps the tokens handed out, by user id
+func (s *LoginTest) SendReset(ctx context.Context, user *model.User, token string, expires time.Time) error {
+	s.sent[user.ID] = token
+	return nil
+}
+
+func (s *LoginTest) SetupTest() {
+	Passwords = NewPasswordHasher(1, 8*1024, 1)
+	ctx := context.WithValue(context.Background(), util.LoggerInContext, config.NewLoggerWithWarn())
+	mem, err := memory.NewMemoryClient(ctx, "")
+	s.Require().NoError(err)
+	s.director = NewUserDirector(context.WithValue(ctx, util.DBInContext, mem))
+	s.sent = map[string]string{}
+}
+
+func (s *LoginTest) register(email, password string) *model.User {
+	u := (&User{Name: "ada lovelace", Birth: "10/12/1815", Email: email, Password: password}).IntoInternal()
+	user, err := s.director.CreateUser(*u)
+	s.Require().NoError(err)
+	return user
+}
+
+// TestLogin tests that logins check the password, and that emails match whatever their case
+func (s *LoginTest) TestLogin() {
+	created := s.register("Ada@Example.com", "correct horse battery")
+	s.Assert().NotContains(created.PasswordHash, "correct horse battery")
+
+	user, err := s.director.Login("ada@example.com", "correct horse battery", "")
+	s.Require().NoError(err)
+	s.Assert().Equal(created.ID, user.ID)
+	_, err = s.director.Login("ada@example.com", "correct horse staple", "")
+	s.Assert().ErrorIs(err, ErrInvalidCredentials)
+	_, err = s.director.Login("nobody@example.com", "correct horse battery", "")
+	s.Assert().ErrorIs(err, ErrInvalidCredentials)
+
+	_, err = s.director.CreateUser(*(&User{Name: "ada king", Birth: "10/12/1815", Email: "ADA@example.com", Password: "another password"}).IntoInternal())
+	s.Assert().ErrorIs(err, model.ErrDuplicate)
+}
+
+// TestReregister tests that the email of a deleted user can be registered again, against a DB keeping emails unique
+func (s *LoginTest) TestReregister() {
+	ctx := context.WithValue(context.Background(), util.LoggerInContext, config.NewLoggerWithWarn())
+	db, err := sqldb.NewSQLClient(ctx, sqldb.SQLite, filepath.Join(s.T().TempDir(), "port.db"))
+	s.Require().NoError(err)
+	defer db.Close(ctx)
+	s.Require().NoError(db.EnsureDBScaffold(ctx, true))
+	s.director = NewUserDirector(context.WithValue(ctx, util.DBInContext, db))
+
+	deleted := s.register("ada@example.com", "correct horse battery")
+	s.Require().NoError(s.director.Delete(deleted.ID))
+	s.Assert().ErrorIs(s.director.Delete(deleted.ID), model.ErrNotFound)
+	_, err = s.director.Login("ada@example.com", "correct horse battery", "")
+	s.Assert().ErrorIs(err, ErrInvalidCredentials)
+
+	user := s.register("ada@example.com", "another password")
+	s.Assert().NotEqual(deleted.ID, user.ID)
+	user, err = s.director.Login("ada@example.com", "another password", "")
+	s.Require().NoError(err)
+	s.Assert().NotEqual(deleted.ID, user.ID)
+}
+
+// TestLockout tests that too many failed logins lock the user out, even with the right password, and that a
+// successful login resets the count
+func (s *LoginTest) TestLockout() {
+	s.register("ada@example.com", "correct horse battery")
+	for i := 0; i < MaxFailedLogins-1; i++ {
+		_, err := s.director.Login("ada@example.com", "wrong password", "")
+		s.Require().ErrorIs(err, ErrInvalidCredentials)
+	}
+	_, err := s.director.Login("ada@example.com", "correct horse battery", "")
+	s.Require().NoError(err)
+	user, _ := s.director.GetByEmail("ada@example.com")
+	s.Assert().Zero(user.FailedLogins)
+
+	for i := 0; i < MaxFailedLogins; i++ {
+		_, err = s.director.Login("ada@example.com", "wrong password", "")
+		s.Require().ErrorIs(err, ErrInvalidCredentials)
+	}
+	_, err = s.director.Login("ada@example.com", "correct horse battery", "")
+	var locked *LockedError
+	s.Require().True(errors.As(err, &locked))
+	s.Assert().WithinDuration(time.Now().Add(LockoutDuration), locked.Until, time.Minute)
+}
+
+// TestReset tests that reset tokens set the password once, lift lockouts, and expire
+func (s *LoginTest) TestReset() {
+	user := s.register("ada@example.com", "correct horse battery")
+	for i := 0; i < MaxFailedLogins; i++ {
+		_, _ = s.director.Login("ada@example.com", "wrong password", "")
+	}
+
+	s.Assert().ErrorIs(s.director.RequestReset("nobody@example.com", s), model.ErrNotFound)
+	s.Require().NoError(s.director.RequestReset("ada@example.com", s))
+	token := s.sent[user.ID]
+	s.Require().NotEmpty(token)
+
+	_, err := s.director.ResetPassword("not a token", "a new password")
+	s.Assert().ErrorIs(err, ErrInvalidResetToken)
+	_, err = s.director.ResetPassword(token, "a new password")
+	s.Require().NoError(err)
+	_, err = s.director.ResetPassword(token, "another new password")
+	s.Assert().ErrorIs(err, ErrInvalidResetToken)
+
+	_, err = s.director.Login("ada@example.com", "a new password", "")
+	s.Assert().NoError(err)
+
+	ttl := ResetTokenTTL
+	defer func() { ResetTokenTTL = ttl }()
+	ResetTokenTTL = -time.Second
+	s.Require().NoError(s.director.RequestReset("ada@example.com", s))
+	_, err = s.director.ResetPassword(s.sent[user.ID], "yet another password")
+	s.Assert().ErrorIs(err, ErrInvalidResetToken)
+}
+
+// TestSessions tests that refresh tokens are exchanged once for the next of their family, that presenting one again
+// revokes the family, and that logging out ends the session
+func (s *LoginTest) TestSessions() {
+	user := s.register("ada@example.com", "correct horse battery")
+	first, expires, err := s.director.StartSession(user.ID)
+	s.Require().NoError(err)
+	s.Assert().WithinDuration(time.Now().Add(RefreshTokenTTL), expires, time.Minute)
+
+	refreshed, second, _, err := s.director.RefreshSession(first)
+	s.Require().NoError(err)
+	s.Assert().Equal(user.ID, refreshed.ID)
+	s.Assert().NotEqual(first, second)
+
+	_, _, _, err = s.director.RefreshSession(first)
+	s.Assert().ErrorIs(err, ErrInvalidRefreshToken)
+	_, _, _, err = s.director.RefreshSession(second)
+	s.Assert().ErrorIs(err, ErrInvalidRefreshToken)
+
+	third, _, err := s.director.StartSession(user.ID)
+	s.Require().NoError(err)
+	s.Require().NoError(s.director.EndSession(third))
+	_, _, _, err = s.director.RefreshSession(third)
+	s.Assert().ErrorIs(err, ErrInvalidRefreshToken)
+	s.Assert().NoError(s.director.EndSession("not a token"))
+}
+
+func TestLoginTest(t *testing.T) {
+	suite.Run(t, new(LoginTest))
+}
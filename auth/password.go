@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+var (
+	// MinPasswordLength is the fewest characters a password may have
+	MinPasswordLength = 10
+	// MaxPasswordLength bounds the work a single login can ask of the hasher
+	MaxPasswordLength = 256
+
+	// Passwords hashes the passwords of users. It is set up from the config on startup.
+	Passwords = NewPasswordHasher(3, 64*1024, 2)
+
+	ErrMalformedHash = errors.New("password hash is malformed")
+
+	saltLength = 16
+	keyLength  = uint32(32)
+)
+
+// PasswordHasher hashes passwords with argon2id. Its cost is tunable: the number of passes over the memory, the
+// memory in KiB, and the threads. Hashes carry the cost they were made with, so changing it doesn't invalidate them.
+type PasswordHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+func NewPasswordHasher(time, memory uint32, threads uint8) *PasswordHasher {
+	return &PasswordHasher{Time: time, Memory: memory, Threads: threads}
+}
+
+// Hash hashes the password with a random salt, into the PHC string format:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks the password against a hash. It also reports if the hash was made with a cost other than the
+// hasher's, so it can be made again with the current one.
+func (h *PasswordHasher) Verify(hash, password string) (ok bool, stale bool, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, false, ErrMalformedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, ErrMalformedHash
+	}
+	cost := &PasswordHasher{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &cost.Memory, &cost.Time, &cost.Threads); err != nil {
+		return false, false, ErrMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, ErrMalformedHash
+	}
+	computed := argon2.IDKey([]byte(password), salt, cost.Time, cost.Memory, cost.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return false, false, nil
+	}
+	return true, *cost != *h, nil
+}
+
+// ValidatePassword checks that a password is long enough to be a password, and short enough to hash
+func ValidatePassword(password string) error {
+	n := len([]rune(password))
+	if n < MinPasswordLength {
+		return fmt.Errorf("password must have at least %d characters", MinPasswordLength)
+	}
+	if n > MaxPasswordLength {
+		return fmt.Errorf("password must have at most %d characters", MaxPasswordLength)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+)
+
+type PasswordTest struct {
+	hasher *PasswordHasher
+	suite.Suite
+}
+
+func (s *PasswordTest) SetupTest() {
+	s.hasher = NewPasswordHasher(1, 8*1024, 1)
+}
+
+// TestHashVerify tests that passwords verify against their hashes only, and that salts make hashes differ
+func (s *PasswordTest) TestHashVerify() {
+	hash, err := s.hasher.Hash("correct horse battery")
+	s.Require().NoError(err)
+	s.Assert().True(strings.HasPrefix(hash, "$argon2id$v=19$m=8192,t=1,p=1$"))
+
+	ok, stale, err := s.hasher.Verify(hash, "correct horse battery")
+	s.Require().NoError(err)
+	s.Assert().True(ok)
+	s.Assert().False(stale)
+	ok, _, err = s.hasher.Verify(hash, "correct horse staple")
+	s.Require().NoError(err)
+	s.Assert().False(ok)
+
+	again, _ := s.hasher.Hash("correct horse battery")
+	s.Assert().NotEqual(hash, again)
+}
+
+// TestStale tests that hashes made with another cost still verify, and are reported stale
+func (s *PasswordTest) TestStale() {
+	hash, err := NewPasswordHasher(2, 8*1024, 1).Hash("correct horse battery")
+	s.Require().NoError(err)
+	ok, stale, err := s.hasher.Verify(hash, "correct horse battery")
+	s.Require().NoError(err)
+	s.Assert().True(ok)
+	s.Assert().True(stale)
+}
+
+// TestMalformed tests that hashes that aren't argon2id PHC strings are refused
+func (s *PasswordTest) TestMalformed() {
+	for _, hash := range []string{"", "plain", "$2a$10$abcdefghijklmnopqrstuv", "$argon2id$v=19$m=x,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=19$m=8,t=1,p=1$!!$a2V5"} {
+		_, _, err := s.hasher.Verify(hash, "password")
+		s.Assert().ErrorIs(err, ErrMalformedHash, hash)
+	}
+	s.Assert().Error(ValidatePassword("short"))
+	s.Assert().Error(ValidatePassword(strings.Repeat("a", MaxPasswordLength+1)))
+	s.Assert().NoError(ValidatePassword("long enough password"))
+}
+
+func TestPasswordTest(t *testing.T) {
+	suite.Run(t, new(PasswordTest))
+}
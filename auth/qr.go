@@ -3,6 +3,7 @@ package auth
 import (
 	"context"
 	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/db/model"
 	"github.com/dark-enstein/port/internal/generators"
 	"github.com/dark-enstein/port/internal/generators/qr"
 	"github.com/dark-enstein/port/util"
@@ -17,32 +18,105 @@ type QRDirector struct {
 	content       string
 	size          int
 	recoveryLevel int
+	format        qr.Format
+	verify        bool
+	style         *qr.Style
+	cached        *qr.Result
 	ctx           context.Context
 	code          *qr.QR
 	generator     generators.Generator
 }
 
+// Generate renders the QR code and uploads it. When a render cache is in the context, and an identical code was
+// uploaded before, the earlier upload is reused instead.
 func (q *QRDirector) Generate() (string, error) {
 	// TODO: hide the details of the QR package and only expose its functionality via the Generator interface
-	return q.SetUp().code.Generate()
+	q.SetUp()
+	renders := RetrieveRenderCacheFromCtx(q.ctx)
+	if renders == nil {
+		return q.code.Generate()
+	}
+	log := util.RetrieveLoggerFromCtx(q.ctx).WithMethod("QRDirector.Generate()")
+
+	fingerprint, err := q.code.Fingerprint()
+	if err != nil {
+		return "", err
+	}
+	render, reusable := renders.Lookup(q.ctx, fingerprint, q.verify)
+	if reusable {
+		log.Debug().Msgf("reusing render %v uploaded as %v", fingerprint, render.Key)
+		q.cached = &qr.Result{
+			ID:       q.uid,
+			Key:      render.Key,
+			URL:      render.URL,
+			Format:   qr.Format(render.Format),
+			Width:    render.Width,
+			Height:   render.Height,
+			Bytes:    render.Bytes,
+			Checksum: render.Checksum,
+			Cached:   true,
+		}
+		return render.URL, nil
+	}
+
+	url, err := q.code.Generate()
+	if err != nil {
+		return "", err
+	}
+	res := q.code.Result()
+	stored := model.NewRender(fingerprint, res.Key, res.URL, res.Format.String(), res.Width, res.Height, res.Size(), res.Checksum, q.verify)
+	if err := renders.Store(q.ctx, stored, render != nil); err != nil {
+		// a concurrent identical request may have indexed the fingerprint first, which is as good
+		log.Info().Msgf("indexing render %v failed with: %v", fingerprint, err)
+	}
+	return url, nil
+}
+
+// Render renders the QR code in memory, without writing it to disk or uploading it
+func (q *QRDirector) Render() (*qr.Result, error) {
+	return q.SetUp().code.Render()
+}
+
+// Result returns the description of the QR code produced by the last call to Generate or Render
+func (q *QRDirector) Result() *qr.Result {
+	if q.cached != nil {
+		return q.cached
+	}
+	if q.code == nil {
+		return nil
+	}
+	return q.code.Result()
 }
 
 func (q *QRDirector) PingDependencies() (bool, error) {
 	return pingDependencies()
 }
 
-func NewQRDirector(ctx context.Context, uid uuid.UUID, content, recoveryLevel string, size int, config *config.Config) *QRDirector {
+func NewQRDirector(ctx context.Context, uid uuid.UUID, content, recoveryLevel string, size int, format qr.Format, config *config.Config) *QRDirector {
 	rec, _ := strconv.Atoi(recoveryLevel)
 	return &QRDirector{
 		ctx:           ctx,
 		cfg:           config,
 		recoveryLevel: rec,
 		size:          size,
+		format:        format,
 		content:       content,
 		uid:           uid.String(),
 	}
 }
 
+// WithVerify sets whether the code is decoded after it is rendered, to check that it reads back as its content
+func (q *QRDirector) WithVerify(verify bool) *QRDirector {
+	q.verify = verify
+	return q
+}
+
+// WithStyle sets the style the code is drawn in
+func (q *QRDirector) WithStyle(style *qr.Style) *QRDirector {
+	q.style = style
+	return q
+}
+
 // SetUp sets up all the dependent structs and data, and readies director for execution
 func (q *QRDirector) SetUp() *QRDirector {
 	log := util.RetrieveLoggerFromCtx(q.ctx).WithMethod("QRDirector.SetUp()")
@@ -50,7 +124,8 @@ func (q *QRDirector) SetUp() *QRDirector {
 	//	log.Debug().Msgf("director not empty with %v. SetUp() likely already called", q)
 	//	return q
 	//}
-	q.code = qr.NewQRWithArgs(q.ctx, q.uid, q.content, q.size, qrcode.RecoveryLevel(q.recoveryLevel))
+	q.cached = nil
+	q.code = qr.NewQRWithArgs(q.ctx, q.uid, q.content, q.size, qrcode.RecoveryLevel(q.recoveryLevel)).WithFormat(q.format).WithVerify(q.verify).WithStyle(q.style)
 	log.Debug().Msg("setting up qr struct")
 	log.Debug().Msgf("qr struct setup complete: %v", q.code)
 	return q
@@ -59,7 +134,7 @@ func (q *QRDirector) SetUp() *QRDirector {
 // IsEmpty checks if QRDirector is empty, in other words, if SetUp() has been called.
 // IsEmpty implements the Director interface
 func (q *QRDirector) IsEmpty() bool {
-	if q.uid == "" && q.content == "" && q.size == 0 && q.recoveryLevel == 1 && q.generator == nil && q.code == nil {
+	if q.uid == "" && q.content == "" && q.size == 0 && q.recoveryLevel == 1 && q.format == "" && q.generator == nil && q.code == nil {
 		return true
 	}
 	return false
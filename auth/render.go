@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/dark-enstein/port/db"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/internal/cache"
+	"github.com/dark-enstein/port/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	KindRender       = "render"
+	RenderCollection = model.RenderCollection
+
+	TierMemory = "memory"
+	TierDB     = "db"
+
+	renderCacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "port",
+		Name:      "render_cache_hits_total",
+		Help:      "Generations that reused an identical code rendered earlier, by the cache tier it was found in.",
+	}, []string{"tier"})
+	renderCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "port",
+		Name:      "render_cache_misses_total",
+		Help:      "Generations that found no identical code rendered earlier, and were rendered and uploaded.",
+	})
+	renderCacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "port",
+		Name:      "render_cache_evictions_total",
+		Help:      "Renders evicted from the in-memory tier of the render cache to make room.",
+	})
+)
+
+// RenderCache indexes uploaded codes by the fingerprint of their render parameters. It keeps the most recently used
+// renders in memory, in front of a persistent index in the DB in the request context.
+type RenderCache struct {
+	lru *cache.LRU[string, *model.Render]
+}
+
+func NewRenderCache(size int) *RenderCache {
+	return &RenderCache{lru: cache.NewLRU[string, *model.Render](size)}
+}
+
+// RetrieveRenderCacheFromCtx returns the RenderCache stored in the context, or nil if there is none
+func RetrieveRenderCacheFromCtx(ctx context.Context) *RenderCache {
+	c, _ := ctx.Value(util.CacheInContext).(*RenderCache)
+	return c
+}
+
+// Lookup returns the render indexed under the fingerprint, looking in memory first and in the DB next. It returns
+// nil if the fingerprint hasn't been rendered before. The render is only reusable when it was verified, if
+// verified is set; otherwise it has to be rendered again, and stored in place of the one indexed.
+func (c *RenderCache) Lookup(ctx context.Context, fingerprint string, verified bool) (render *model.Render, reusable bool) {
+	log := util.RetrieveLoggerFromCtx(ctx).WithMethod("RenderCache.Lookup()")
+	tier := TierMemory
+	render, ok := c.lru.Get(fingerprint)
+	if store := renderDB(ctx); !ok && store != nil {
+		tier, render = TierDB, &model.Render{}
+		dbResp := store.Read(ctx, model.ByID(fingerprint), render, resolveOpts(KindRender).(*model.UnitOptions))
+		switch {
+		case dbResp.Err == nil:
+			ok = true
+			c.add(render)
+		case !errors.Is(dbResp.Err, model.ErrNotFound):
+			log.Info().Msgf("reading render %v failed with: %v", fingerprint, dbResp.Err)
+		}
+	}
+	if !ok {
+		renderCacheMisses.Inc()
+		return nil, false
+	}
+	if verified && !render.Verified {
+		renderCacheMisses.Inc()
+		return render, false
+	}
+	renderCacheHits.WithLabelValues(tier).Inc()
+	return render, true
+}
+
+// Store indexes a render in memory and in the DB. replace is set when the fingerprint is already indexed, and
+// the render takes the place of the one indexed.
+func (c *RenderCache) Store(ctx context.Context, render *model.Render, replace bool) error {
+	c.add(render)
+	store := renderDB(ctx)
+	if store == nil {
+		return nil
+	}
+	opts := resolveOpts(KindRender).(*model.UnitOptions)
+	var dbResp *model.DBResponse
+	if replace {
+		dbResp = store.Update(ctx, model.ByID(render.ID), model.Fields{
+			"key":      render.Key,
+			"url":      render.URL,
+			"format":   render.Format,
+			"width":    render.Width,
+			"height":   render.Height,
+			"bytes":    render.Bytes,
+			"checksum": render.Checksum,
+			"verified": render.Verified,
+		}, opts)
+	} else {
+		dbResp = store.Create(ctx, render, opts)
+	}
+	return dbResp.Err
+}
+
+func (c *RenderCache) add(render *model.Render) {
+	if c.lru.Add(render.ID, render) {
+		renderCacheEvictions.Inc()
+	}
+}
+
+// renderDB returns the DB in the context. The persistent tier of the cache is skipped when there is none.
+func renderDB(ctx context.Context) db.DB {
+	store, _ := ctx.Value(util.DBInContext).(db.DB)
+	return store
+}
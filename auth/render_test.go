@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/internal/cloud/memory"
+	"github.com/dark-enstein/port/util"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type RenderTest struct {
+	ctx context.Context
+	suite.Suite
+}
+
+func (s *RenderTest) SetupTest() {
+	s.ctx = context.WithValue(context.Background(), util.LoggerInContext, config.NewLoggerWithWarn())
+	s.ctx = context.WithValue(s.ctx, util.StorageInContext, memory.NewStore("http://localhost"))
+}
+
+// TestLookup tests that stored renders are found again, and that unverified renders aren't reused for requests
+// asking for verification
+func (s *RenderTest) TestLookup() {
+	c := NewRenderCache(8)
+	render, reusable := c.Lookup(s.ctx, "fp", false)
+	s.Assert().Nil(render)
+	s.Assert().False(reusable)
+
+	s.Require().NoError(c.Store(s.ctx, model.NewRender("fp", "a.png", "http://localhost/files/a.png", "png", 64, 64, 100, "sha256:00", false), false))
+	render, reusable = c.Lookup(s.ctx, "fp", false)
+	s.Require().NotNil(render)
+	s.Assert().True(reusable)
+	s.Assert().Equal("a.png", render.Key)
+
+	render, reusable = c.Lookup(s.ctx, "fp", true)
+	s.Require().NotNil(render)
+	s.Assert().False(reusable)
+}
+
+// TestGenerate tests that a director reuses the upload of an identical code, and renders codes that differ
+func (s *RenderTest) TestGenerate() {
+	ctx := context.WithValue(s.ctx, util.CacheInContext, NewRenderCache(8))
+	generate := func(content string, size int) (string, bool) {
+		director := NewQRDirector(ctx, uuid.New(), content, "1", size, "png", nil)
+		url, err := director.Generate()
+		s.Require().NoError(err)
+		return url, director.Result().Cached
+	}
+
+	first, cached := generate("https://github.com/dark-enstein/port", 128)
+	s.Assert().False(cached)
+	again, cached := generate("https://github.com/dark-enstein/port", 128)
+	s.Assert().True(cached)
+	s.Assert().Equal(first, again)
+
+	other, cached := generate("https://github.com/dark-enstein/port", 256)
+	s.Assert().False(cached)
+	s.Assert().NotEqual(first, other)
+}
+
+func TestRenderTest(t *testing.T) {
+	suite.Run(t, new(RenderTest))
+}
@@ -0,0 +1,379 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/db"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/rs/zerolog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The resources permissions are granted on
+var (
+	ResourceUsers   = "users"
+	ResourceRoles   = "roles"
+	ResourceCodes   = "codes"
+	ResourceTickets = "tickets"
+	ResourceKeys    = "keys"
+
+	// Resources lists the resources in the order their permissions are packed into masks, 4 bits each. Masks are
+	// stored on users, so resources are only ever appended, up to 16 of them.
+	Resources = []string{ResourceUsers, ResourceRoles, ResourceCodes, ResourceTickets, ResourceKeys}
+)
+
+var (
+	KindRole       = "role"
+	RoleCollection = model.RoleCollection
+
+	// Administrator manages Port: it may do anything
+	Administrator = Role{Name: "Administrator", User: PermissionSet{
+		crud(ResourceUsers), crud(ResourceRoles), crud(ResourceCodes), crud(ResourceTickets), crud(ResourceKeys)}}
+	// Developer builds on Port: it generates codes, runs events, and reads who the users are
+	Developer = Role{Name: "Developer", User: PermissionSet{
+		{Name: ResourceUsers, Read: true}, crud(ResourceCodes), crud(ResourceTickets), crud(ResourceKeys)}}
+	// VanillaUser is the role users are created with: it generates and reads codes
+	VanillaUser = Role{Name: "VanillaUser", User: PermissionSet{{Name: ResourceCodes, Create: true, Read: true}}}
+
+	// BuiltinRoles are the roles created on startup, when they don't exist yet
+	BuiltinRoles = RoleSet{Administrator, Developer, VanillaUser}
+
+	ErrBuiltinRole = errors.New("built in roles can't be deleted")
+	ErrRoleNotHeld = errors.New("user doesn't hold the role")
+
+	// roleName is what the names of roles are made of
+	roleName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,63}$`)
+	// roleAttempts bounds the retries of changing the roles of a user that changed in the meantime
+	roleAttempts = 3
+)
+
+// Action is what a call does to a resource
+type Action int
+
+const (
+	ActionCreate Action = iota
+	ActionRead
+	ActionUpdate
+	ActionDelete
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionCreate:
+		return "create"
+	case ActionRead:
+		return "read"
+	case ActionUpdate:
+		return "update"
+	case ActionDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("action(%d)", int(a))
+}
+
+// Requirement is the permission a call needs: an action on a resource, written like "codes:create"
+type Requirement struct {
+	Resource string
+	Action   Action
+}
+
+// Need returns the requirement of the action on the resource
+func Need(resource string, action Action) Requirement {
+	return Requirement{Resource: resource, Action: action}
+}
+
+func (r Requirement) String() string {
+	return r.Resource + ":" + r.Action.String()
+}
+
+func crud(resource string) Permission {
+	return Permission{Name: resource, Create: true, Read: true, Update: true, Delete: true}
+}
+
+func resourceIndex(resource string) int {
+	for i, r := range Resources {
+		if r == resource {
+			return i
+		}
+	}
+	return -1
+}
+
+// MaskPermissions unpacks a mask into the permissions it grants, one per resource with any, in the order of Resources
+func MaskPermissions(mask int64) PermissionSet {
+	ps := PermissionSet{}
+	for i, resource := range Resources {
+		if bin := (mask >> (4 * i)) & 0xF; bin != 0 {
+			ps = append(ps, PermissionFromBinary(resource, bin))
+		}
+	}
+	return ps
+}
+
+// DecodeRoleSet decodes the role set users are stored with back into roles, and the permissions they grant
+func DecodeRoleSet(rs *model.RoleSet) RoleSet {
+	roles := RoleSet{}
+	if rs == nil {
+		return roles
+	}
+	for _, held := range *rs {
+		names := make([]string, 0, len(held))
+		for name := range held {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			var mask int64
+			if held[name] != nil {
+				mask = *held[name]
+			}
+			roles = append(roles, Role{Name: name, User: MaskPermissions(mask)})
+		}
+	}
+	return roles
+}
+
+// RoleFromModel decodes a role read from the DB
+func RoleFromModel(m *model.Role) Role {
+	r := Role{Name: m.ID, User: PermissionSet{}}
+	for _, resource := range Resources {
+		if bin, ok := m.Permissions[resource]; ok && bin != nil && *bin != 0 {
+			r.User = append(r.User, PermissionFromBinary(resource, *bin))
+		}
+	}
+	return r
+}
+
+// IntoModel makes the role ready for the DB
+func (r *Role) IntoModel() *model.Role {
+	now := time.Now().UTC()
+	return &model.Role{ID: r.Name, Permissions: r.User.ToBinary(), CreatedAt: now, UpdatedAt: now}
+}
+
+// RoleDirector manages the named roles of Port
+type RoleDirector struct {
+	log    *zerolog.Logger
+	ReqCtx context.Context
+	db     db.DB
+	opts   *model.UnitOptions
+}
+
+func NewRoleDirector(ctx context.Context) *RoleDirector {
+	return &RoleDirector{ReqCtx: ctx, db: GetDBFromCtx(ctx), log: GetLoggerFromCtx(ctx), opts: resolveOpts(KindRole).(*model.UnitOptions)}
+}
+
+// Get retrieves the role with the name passed in. It returns model.ErrNotFound if there is none
+func (d *RoleDirector) Get(name string) (*Role, error) {
+	m, err := d.Read(name)
+	if err != nil {
+		return nil, err
+	}
+	r := RoleFromModel(m)
+	return &r, nil
+}
+
+// EnsureBuiltins creates the built in roles that don't exist yet. Roles that do are left as they are, so changes
+// made to them survive restarts.
+func (d *RoleDirector) EnsureBuiltins() error {
+	log := d.log.With().Str("method", "RoleDirector.EnsureBuiltins()").Logger()
+	for _, r := range BuiltinRoles {
+		_, err := d.Get(r.Name)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, model.ErrNotFound) {
+			return err
+		}
+		m := r.IntoModel()
+		m.Builtin = true
+		if dbResp := d.db.Create(d.ReqCtx, m, d.opts); dbResp.Err != nil && !errors.Is(dbResp.Err, model.ErrDuplicate) {
+			return dbResp.Err
+		}
+		log.Info().Msgf("created built in role %v", r.Name)
+	}
+	return nil
+}
+
+// ValidateRole checks that a role has a name made of letters, digits, dashes and underscores, and only grants
+// permissions on the resources of Resources
+func ValidateRole(r Role) error {
+	if !roleName.MatchString(r.Name) {
+		return fmt.Errorf("role name must start with a letter, and hold up to 64 letters, digits, - and _")
+	}
+	for _, p := range r.User {
+		if resourceIndex(p.Name) < 0 {
+			return fmt.Errorf("resource %q is not one of %v", p.Name, strings.Join(Resources, ", "))
+		}
+	}
+	return nil
+}
+
+// Read retrieves the role with the name passed in, as stored. It returns model.ErrNotFound if there is none
+func (d *RoleDirector) Read(name string) (*model.Role, error) {
+	m := &model.Role{}
+	dbResp := d.db.Read(d.ReqCtx, model.ByID(name), m, d.opts)
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	return m, nil
+}
+
+// List retrieves every role, ordered by name
+func (d *RoleDirector) List() ([]*model.Role, error) {
+	var roles []*model.Role
+	dbResp := d.db.List(d.ReqCtx, model.Filter{}, &roles, &model.ListOpts{Sort: []model.Sort{{Field: "_id"}}}, d.opts)
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	return roles, nil
+}
+
+// Create persists a new role. It returns model.ErrDuplicate when a role has the name already.
+func (d *RoleDirector) Create(r Role, description string) (*model.Role, error) {
+	if err := ValidateRole(r); err != nil {
+		return nil, err
+	}
+	m := r.IntoModel()
+	m.Description = description
+	dbResp := d.db.Create(d.ReqCtx, m, d.opts)
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	return m, nil
+}
+
+// Update sets the fields on the role with the name passed in, and brings the permissions of the users holding it up
+// to date. It returns the updated role, and the number of users updated. It returns model.ErrConflict when the role
+// changed between being read and updated.
+func (d *RoleDirector) Update(name string, fields model.Fields) (*model.Role, int, error) {
+	m, err := d.Read(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	fields["updated_at"] = time.Now().UTC()
+	dbResp := d.db.Update(d.ReqCtx, model.Filter{"_id": name, model.VersionField: m.Version}, fields, d.opts)
+	if dbResp.Err != nil {
+		return nil, 0, dbResp.Err
+	}
+	if m, err = d.Read(name); err != nil {
+		return nil, 0, err
+	}
+	updated, err := NewUserDirector(d.ReqCtx).ApplyRole(RoleFromModel(m))
+	if err != nil {
+		return m, updated, fmt.Errorf("updating the users holding role %v: %w", name, err)
+	}
+	return m, updated, nil
+}
+
+// Delete revokes the role with the name passed in from the users holding it, and deletes it for good, so the name
+// can be used again. It returns the number of users updated, and ErrBuiltinRole for the built in roles.
+func (d *RoleDirector) Delete(name string) (int, error) {
+	m, err := d.Read(name)
+	if err != nil {
+		return 0, err
+	}
+	if m.Builtin {
+		return 0, ErrBuiltinRole
+	}
+	updated, err := NewUserDirector(d.ReqCtx).RevokeFromAll(name)
+	if err != nil {
+		return updated, fmt.Errorf("revoking role %v from the users holding it: %w", name, err)
+	}
+	return updated, d.db.Delete(d.ReqCtx, model.ByID(name), model.HardDelete, d.opts).Err
+}
+
+// GrantRole grants the role to the user with the id passed in, with the permissions the role grants now, and returns
+// the updated user. Granting a role the user holds brings its permissions up to date.
+func (d *UserDirector) GrantRole(id string, role Role) (*model.User, error) {
+	return d.changeRoles(id, func(roles RoleSet) (RoleSet, error) {
+		for i := range roles {
+			if roles[i].Name == role.Name {
+				roles[i] = role
+				return roles, nil
+			}
+		}
+		return append(roles, role), nil
+	})
+}
+
+// RevokeRole revokes the role with the name passed in from the user with the id passed in, and returns the updated
+// user. It returns ErrRoleNotHeld when the user doesn't hold the role.
+func (d *UserDirector) RevokeRole(id, name string) (*model.User, error) {
+	return d.changeRoles(id, func(roles RoleSet) (RoleSet, error) {
+		for i := range roles {
+			if roles[i].Name == name {
+				return append(roles[:i], roles[i+1:]...), nil
+			}
+		}
+		return nil, ErrRoleNotHeld
+	})
+}
+
+// ApplyRole brings the permissions of the role up to date on every user holding it, after the role changed. It
+// returns the number of users updated.
+func (d *UserDirector) ApplyRole(role Role) (int, error) {
+	page := 100
+	updated := 0
+	for offset := 0; ; offset += page {
+		users, err := d.List(model.Filter{model.RoleField: role.Name}, offset, page)
+		if err != nil {
+			return updated, err
+		}
+		for _, u := range users {
+			if _, err := d.GrantRole(u.ID, role); err != nil {
+				return updated, err
+			}
+			updated++
+		}
+		if len(users) < page {
+			return updated, nil
+		}
+	}
+}
+
+// RevokeFromAll revokes the role with the name passed in from every user holding it, before the role is deleted. It
+// returns the number of users updated.
+func (d *UserDirector) RevokeFromAll(name string) (int, error) {
+	updated := 0
+	for {
+		// users holding the role are revoked it, so the first page is always the next one
+		users, err := d.List(model.Filter{model.RoleField: name}, 0, 100)
+		if err != nil || len(users) == 0 {
+			return updated, err
+		}
+		for _, u := range users {
+			if _, err := d.RevokeRole(u.ID, name); err != nil {
+				return updated, err
+			}
+			updated++
+		}
+	}
+}
+
+// changeRoles stores the roles change returns for the roles a user holds, reading the user again when it changed
+// in the meantime
+func (d *UserDirector) changeRoles(id string, change func(RoleSet) (RoleSet, error)) (*model.User, error) {
+	for attempt := 0; ; attempt++ {
+		user, err := d.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		roles, err := change(DecodeRoleSet(user.Roles))
+		if err != nil {
+			return nil, err
+		}
+		fields := model.Fields{"role_set": model.RoleSet(roles.ToBinary()), "updated_at": time.Now().UTC()}
+		dbResp := d.db.Update(d.ReqCtx, model.Filter{"_id": user.ID, model.VersionField: user.Version}, fields, d.opts())
+		if errors.Is(dbResp.Err, model.ErrConflict) && attempt < roleAttempts {
+			continue
+		}
+		if dbResp.Err != nil {
+			return nil, dbResp.Err
+		}
+		return d.Get(id)
+	}
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/db/memory"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/util"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type RoleTest struct {
+	ctx context.Context
+	suite.Suite
+}
+
+func (s *RoleTest) SetupTest() {
+	Passwords = NewPasswordHasher(1, 8*1024, 1)
+	ctx := context.WithValue(context.Background(), util.LoggerInContext, config.NewLoggerWithWarn())
+	mem, err := memory.NewMemoryClient(ctx, "")
+	s.Require().NoError(err)
+	s.ctx = context.WithValue(ctx, util.DBInContext, mem)
+}
+
+// TestMasks tests that permissions survive being packed into masks, and that role sets merge their masks
+func (s *RoleTest) TestMasks() {
+	ps := PermissionSet{{Name: ResourceUsers, Read: true}, {Name: ResourceCodes, Create: true, Delete: true}, {Name: "unknown", Read: true}}
+	s.Assert().Equal(int64(0b1001_0000_0100), ps.Mask())
+	s.Assert().Equal(PermissionSet{{Name: ResourceUsers, Read: true}, {Name: ResourceCodes, Create: true, Delete: true}}, MaskPermissions(ps.Mask()))
+
+	roles := RoleSet{VanillaUser, {Name: "Auditor", User: PermissionSet{{Name: ResourceUsers, Read: true}, {Name: ResourceCodes, Read: true}}}}
+	effective := roles.Permissions()
+	s.Assert().True(effective.Allows(Need(ResourceCodes, ActionCreate)))
+	s.Assert().True(effective.Allows(Need(ResourceUsers, ActionRead)))
+	s.Assert().False(effective.Allows(Need(ResourceUsers, ActionDelete)))
+	s.Assert().Equal("users:delete", Need(ResourceUsers, ActionDelete).String())
+
+	stored := model.RoleSet(roles.ToBinary())
+	s.Assert().Equal([]string{"VanillaUser", "Auditor"}, stored.Names())
+	s.Assert().Equal(roles, DecodeRoleSet(&stored))
+}
+
+// TestGrant tests that users are created with the stored vanilla role, and that granting a role adds to their
+// permissions
+func (s *RoleTest) TestGrant() {
+	roles := NewRoleDirector(s.ctx)
+	s.Require().NoError(roles.EnsureBuiltins())
+	s.Require().NoError(roles.EnsureBuiltins())
+	admin, err := roles.Get(Administrator.Name)
+	s.Require().NoError(err)
+	s.Assert().Equal(Administrator, *admin)
+
+	users := NewUserDirector(s.ctx)
+	user, err := users.CreateUser(*(&User{Name: "ada lovelace", Birth: "10/12/1815", Email: "ada@example.com", Password: "correct horse battery"}).IntoInternal())
+	s.Require().NoError(err)
+	held := DecodeRoleSet(user.Roles)
+	s.Assert().Equal(RoleSet{VanillaUser}, held)
+	effective := held.Permissions()
+	s.Assert().False(effective.Allows(Need(ResourceUsers, ActionRead)))
+
+	user, err = users.GrantRole(user.ID, *admin)
+	s.Require().NoError(err)
+	held = DecodeRoleSet(user.Roles)
+	s.Assert().Equal([]string{"VanillaUser", "Administrator"}, held.Names())
+	effective = held.Permissions()
+	s.Assert().True(effective.Allows(Need(ResourceUsers, ActionRead)))
+
+	found, err := users.List(model.Filter{model.RoleField: Administrator.Name}, 0, 10)
+	s.Require().NoError(err)
+	s.Assert().Len(found, 1)
+}
+
+// TestStoredVanilla tests that users are created with the vanilla role as it is stored, once it was edited
+func (s *RoleTest) TestStoredVanilla() {
+	roles := NewRoleDirector(s.ctx)
+	s.Require().NoError(roles.EnsureBuiltins())
+	edited := PermissionSet{{Name: ResourceCodes, Read: true}}
+	_, _, err := roles.Update(VanillaUser.Name, model.Fields{"permissions": edited.ToBinary()})
+	s.Require().NoError(err)
+
+	user, err := NewUserDirector(s.ctx).CreateUser(*(&User{Name: "ada lovelace", Birth: "10/12/1815", Email: "ada@example.com", Password: "correct horse battery"}).IntoInternal())
+	s.Require().NoError(err)
+	s.Require().NotNil(user.Roles)
+	s.Assert().Equal(edited.Mask(), *(*user.Roles)[0][VanillaUser.Name])
+	held := DecodeRoleSet(user.Roles)
+	effective := held.Permissions()
+	s.Assert().False(effective.Allows(Need(ResourceCodes, ActionCreate)))
+
+	stored, err := NewUserDirector(s.ctx).Get(user.ID)
+	s.Require().NoError(err)
+	s.Assert().Equal(user.Roles, stored.Roles)
+}
+
+// TestAdminister tests that editing a role updates the users holding it, and that deleting a role revokes it
+func (s *RoleTest) TestAdminister() {
+	roles := NewRoleDirector(s.ctx)
+	s.Require().NoError(roles.EnsureBuiltins())
+	auditor := Role{Name: "Auditor", User: PermissionSet{{Name: ResourceUsers, Read: true}}}
+	_, err := roles.Create(auditor, "reads who the users are")
+	s.Require().NoError(err)
+	_, err = roles.Create(auditor, "")
+	s.Assert().ErrorIs(err, model.ErrDuplicate)
+	_, err = roles.Create(Role{Name: "Bad", User: PermissionSet{{Name: "unknown", Read: true}}}, "")
+	s.Assert().Error(err)
+	listed, err := roles.List()
+	s.Require().NoError(err)
+	s.Assert().Len(listed, 4)
+
+	users := NewUserDirector(s.ctx)
+	user, err := users.CreateUser(*(&User{Name: "ada lovelace", Birth: "10/12/1815", Email: "ada@example.com", Password: "correct horse battery"}).IntoInternal())
+	s.Require().NoError(err)
+	_, err = users.GrantRole(user.ID, auditor)
+	s.Require().NoError(err)
+
+	edited := PermissionSet{{Name: ResourceUsers, Read: true}, {Name: ResourceRoles, Read: true}}
+	m, updated, err := roles.Update(auditor.Name, model.Fields{"permissions": edited.ToBinary()})
+	s.Require().NoError(err)
+	s.Assert().Equal(1, updated)
+	s.Assert().Equal(edited, RoleFromModel(m).User)
+	user, err = users.Get(user.ID)
+	s.Require().NoError(err)
+	held := DecodeRoleSet(user.Roles)
+	effective := held.Permissions()
+	s.Assert().True(effective.Allows(Need(ResourceRoles, ActionRead)))
+
+	_, err = roles.Delete(VanillaUser.Name)
+	s.Assert().ErrorIs(err, ErrBuiltinRole)
+	revoked, err := roles.Delete(auditor.Name)
+	s.Require().NoError(err)
+	s.Assert().Equal(1, revoked)
+	user, err = users.Get(user.ID)
+	s.Require().NoError(err)
+	s.Assert().Equal([]string{"VanillaUser"}, user.Roles.Names())
+	_, err = users.RevokeRole(user.ID, auditor.Name)
+	s.Assert().ErrorIs(err, ErrRoleNotHeld)
+	_, err = roles.Get(auditor.Name)
+	s.Assert().ErrorIs(err, model.ErrNotFound)
+}
+
+// TestAudit tests that audit entries are listed latest first, and filtered by their fields
+func (s *RoleTest) TestAudit() {
+	audit := NewAuditDirector(s.ctx)
+	_, err := audit.Record("admin", AuditRoleGrant, AuditUser("ada"), map[string]interface{}{"role": "Developer"})
+	s.Require().NoError(err)
+	_, err = audit.Record("admin", AuditRoleRevoke, AuditUser("ada"), map[string]interface{}{"role": "Developer"})
+	s.Require().NoError(err)
+	_, err = audit.Record("admin", AuditRoleCreate, AuditRole("Auditor"), nil)
+	s.Require().NoError(err)
+
+	entries, err := audit.List(model.Filter{"target": AuditUser("ada")}, 0, 10)
+	s.Require().NoError(err)
+	s.Require().Len(entries, 2)
+	s.Assert().Equal(AuditRoleRevoke, entries[0].Action)
+	s.Assert().Equal(AuditRoleGrant, entries[1].Action)
+
+	entries, err = audit.List(model.Filter{"actor": "admin"}, 1, 1)
+	s.Require().NoError(err)
+	s.Assert().Len(entries, 1)
+}
+
+func TestRoleTest(t *testing.T) {
+	suite.Run(t, new(RoleTest))
+}
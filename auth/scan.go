@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/dark-enstein/port/db"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/rs/zerolog"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	KindScan       = "scan"
+	ScanCollection = model.ScanCollection
+
+	BucketHour = "hour"
+	BucketDay  = "day"
+	Buckets    = []string{BucketHour, BucketDay}
+
+	AgentBot     = "bot"
+	AgentMobile  = "mobile"
+	AgentTablet  = "tablet"
+	AgentDesktop = "desktop"
+	AgentOther   = "other"
+	Agents       = []string{AgentBot, AgentMobile, AgentTablet, AgentDesktop, AgentOther}
+
+	// StatsWindow is how far back the scans of a code are summarized, unless asked otherwise
+	StatsWindow = 30 * 24 * time.Hour
+	// MaxStatsWindow bounds how long a window of scans is summarized in one go
+	MaxStatsWindow = 366 * 24 * time.Hour
+)
+
+// ScanStats summarizes the scans of a dynamic QR code
+type ScanStats struct {
+	ID     string         `json:"id"`
+	From   time.Time      `json:"from"`
+	To     time.Time      `json:"to"`
+	Total  int            `json:"total"`
+	Unique int            `json:"unique"`
+	Agents map[string]int `json:"agents"`
+	Bucket string         `json:"bucket"`
+	Series []*ScanBucket  `json:"series"`
+}
+
+// ScanBucket counts the scans of a dynamic QR code that fall in the bucket starting at Start
+type ScanBucket struct {
+	Start  time.Time `json:"start"`
+	Total  int       `json:"total"`
+	Unique int       `json:"unique"`
+}
+
+// ScanDirector defines a master that records and summarizes the scans of dynamic QR codes
+type ScanDirector struct {
+	log    *zerolog.Logger
+	ReqCtx context.Context
+	db     db.DB
+	opts   *model.UnitOptions
+}
+
+func NewScanDirector(ctx context.Context) *ScanDirector {
+	return &ScanDirector{ReqCtx: ctx, db: GetDBFromCtx(ctx), log: GetLoggerFromCtx(ctx), opts: resolveOpts(KindScan).(*model.UnitOptions)}
+}
+
+// Record persists a batch of scans with a single call to the DB
+func (d *ScanDirector) Record(scans []*model.Scan) error {
+	units := make([]model.Unit, len(scans))
+	for i, s := range scans {
+		units[i] = s
+	}
+	dbResp := d.db.CreateAll(d.ReqCtx, units, d.opts)
+	if dbResp.Err != nil {
+		return fmt.Errorf("recording %d scans failed: %w", len(scans), dbResp.Err)
+	}
+	return nil
+}
+
+// Stats summarizes the scans of the link with the id passed in made from from and before to, in a series of hour or
+// day long buckets. Only the scans of the window are read, off the index on the link and the time of scans.
+func (d *ScanDirector) Stats(linkID, bucket string, from, to time.Time) (*ScanStats, error) {
+	var scans []*model.Scan
+	filter := model.Filter{"link_id": linkID, "at": model.Between(from.UTC(), to.UTC())}
+	dbResp := d.db.List(d.ReqCtx, filter, &scans, &model.ListOpts{Sort: []model.Sort{{Field: "at"}}}, d.opts)
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	stats := Summarize(linkID, scans, bucket)
+	stats.From, stats.To = from.UTC(), to.UTC()
+	return stats, nil
+}
+
+// Summarize counts scans, overall and in a series of buckets, ordered by their start
+func Summarize(linkID string, scans []*model.Scan, bucket string) *ScanStats {
+	stats := &ScanStats{ID: linkID, Bucket: bucket, Agents: map[string]int{}, Series: []*ScanBucket{}}
+	unique := map[string]struct{}{}
+	buckets := map[time.Time]*ScanBucket{}
+	bucketUnique := map[time.Time]map[string]struct{}{}
+	for _, s := range scans {
+		stats.Total++
+		stats.Agents[s.Agent]++
+		unique[s.IPHash] = struct{}{}
+
+		start := truncate(s.At, bucket)
+		b, ok := buckets[start]
+		if !ok {
+			b = &ScanBucket{Start: start}
+			buckets[start] = b
+			bucketUnique[start] = map[string]struct{}{}
+			stats.Series = append(stats.Series, b)
+		}
+		b.Total++
+		bucketUnique[start][s.IPHash] = struct{}{}
+		b.Unique = len(bucketUnique[start])
+	}
+	stats.Unique = len(unique)
+	sort.Slice(stats.Series, func(i, j int) bool { return stats.Series[i].Start.Before(stats.Series[j].Start) })
+	return stats
+}
+
+func truncate(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	if bucket == BucketHour {
+		return t.Truncate(time.Hour)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// HashIP hashes the address of a scanner. The hash is salted with the link, so scanners can be counted on one
+// link but not followed across links.
+func HashIP(linkID, ip string) string {
+	sum := sha256.Sum256([]byte(linkID + "|" + ip))
+	return hex.EncodeToString(sum[:])
+}
+
+// ClassifyAgent sorts a User-Agent header into a coarse class
+func ClassifyAgent(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case ua == "":
+		return AgentOther
+	case strings.Contains(ua, "bot") || strings.Contains(ua, "crawler") || strings.Contains(ua, "spider") ||
+		strings.Contains(ua, "curl") || strings.Contains(ua, "wget"):
+		return AgentBot
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") ||
+		(strings.Contains(ua, "android") && !strings.Contains(ua, "mobile")):
+		return AgentTablet
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "android"):
+		return AgentMobile
+	case strings.Contains(ua, "windows") || strings.Contains(ua, "macintosh") || strings.Contains(ua, "x11") ||
+		strings.Contains(ua, "cros"):
+		return AgentDesktop
+	}
+	return AgentOther
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/db/memory"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/util"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type ScanTest struct {
+	suite.Suite
+}
+
+// TestClassifyAgent tests that user agents are sorted into their coarse class
+func (s *ScanTest) TestClassifyAgent() {
+	cases := map[string]string{
+		"":              AgentOther,
+		"curl/8.4.0":    AgentBot,
+		"Googlebot/2.1": AgentBot,
+		"Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X)":           AgentTablet,
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari":  AgentMobile,
+		"Mozilla/5.0 (Linux; Android 14; SM-X710) Safari":         AgentTablet,
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)":  AgentMobile,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0":  AgentDesktop,
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) Safari/605": AgentDesktop,
+		"SomethingElse/1.0": AgentOther,
+	}
+	for ua, expected := range cases {
+		s.Require().Equal(expected, ClassifyAgent(ua), ua)
+	}
+}
+
+// TestSummarize tests that scans are counted overall and per bucket, with unique scanners counted by their hash
+func (s *ScanTest) TestSummarize() {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	scans := []*model.Scan{
+		{LinkID: "l", At: day.Add(25*time.Hour + 5*time.Minute), Agent: AgentMobile, IPHash: HashIP("l", "1.1.1.1")},
+		{LinkID: "l", At: day.Add(10 * time.Minute), Agent: AgentMobile, IPHash: HashIP("l", "1.1.1.1")},
+		{LinkID: "l", At: day.Add(20 * time.Minute), Agent: AgentDesktop, IPHash: HashIP("l", "2.2.2.2")},
+		{LinkID: "l", At: day.Add(90 * time.Minute), Agent: AgentBot, IPHash: HashIP("l", "1.1.1.1")},
+	}
+
+	stats := Summarize("l", scans, BucketDay)
+	s.Require().Equal(4, stats.Total)
+	s.Require().Equal(2, stats.Unique)
+	s.Require().Equal(map[string]int{AgentMobile: 2, AgentDesktop: 1, AgentBot: 1}, stats.Agents)
+	s.Require().Len(stats.Series, 2)
+	s.Require().Equal(day, stats.Series[0].Start)
+	s.Require().Equal(3, stats.Series[0].Total)
+	s.Require().Equal(2, stats.Series[0].Unique)
+	s.Require().Equal(1, stats.Series[1].Total)
+
+	stats = Summarize("l", scans, BucketHour)
+	s.Require().Len(stats.Series, 3)
+	s.Require().Equal(day, stats.Series[0].Start)
+	s.Require().Equal(2, stats.Series[0].Total)
+	s.Require().Equal(day.Add(time.Hour), stats.Series[1].Start)
+}
+
+// TestStats tests that only the scans of the window asked for are summarized
+func (s *ScanTest) TestStats() {
+	ctx := context.WithValue(context.Background(), util.LoggerInContext, config.NewLoggerWithWarn())
+	mem, err := memory.NewMemoryClient(ctx, "")
+	s.Require().NoError(err)
+	scans := NewScanDirector(context.WithValue(ctx, util.DBInContext, mem))
+	day := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	var recorded []*model.Scan
+	for i, at := range []time.Time{day.Add(-time.Hour), day, day.Add(time.Hour), day.Add(24 * time.Hour)} {
+		scan := model.NewScan(fmt.Sprint(i), "l", AgentMobile, "", HashIP("l", fmt.Sprint(i)))
+		scan.At = at
+		recorded = append(recorded, scan)
+	}
+	recorded = append(recorded, model.NewScan("other", "m", AgentMobile, "", "hash"))
+	s.Require().NoError(scans.Record(recorded))
+
+	stats, err := scans.Stats("l", BucketHour, day, day.Add(24*time.Hour))
+	s.Require().NoError(err)
+	s.Assert().Equal(2, stats.Total)
+	s.Assert().Equal(day, stats.From)
+	s.Require().Len(stats.Series, 2)
+	s.Assert().Equal(day, stats.Series[0].Start)
+}
+
+// TestHashIP tests that addresses hash differently on different links
+func (s *ScanTest) TestHashIP() {
+	s.Require().Equal(HashIP("a", "1.1.1.1"), HashIP("a", "1.1.1.1"))
+	s.Require().NotEqual(HashIP("a", "1.1.1.1"), HashIP("b", "1.1.1.1"))
+}
+
+func TestScanTest(t *testing.T) {
+	suite.Run(t, new(ScanTest))
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"errors"
+	"github.com/dark-enstein/port/db/model"
+	"time"
+)
+
+var (
+	KindRefreshToken       = "refresh_token"
+	RefreshTokenCollection = model.RefreshTokenCollection
+
+	// RefreshTokenTTL is how long a refresh token can be exchanged for. Exchanging it starts the time again, for the
+	// refresh token handed out in its place.
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid, expired or already used")
+)
+
+// StartSession issues the first refresh token of a new family to the user, on login. It returns the token, and
+// when it expires. Only the hash of the token is kept.
+func (d *UserDirector) StartSession(userID string) (string, time.Time, error) {
+	family, err := newEditToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return d.issueRefresh(userID, hashToken(family))
+}
+
+// RefreshSession exchanges a refresh token for the next one of its family, and returns the user it was issued to
+// with the next token and when it expires. A refresh token is exchanged once: when one is presented again, it has
+// leaked, and every token of its family is revoked, which logs out whoever holds the latest one too.
+func (d *UserDirector) RefreshSession(token string) (*model.User, string, time.Time, error) {
+	log := d.log.With().Str("method", "UserDirector.RefreshSession()").Logger()
+	opts := resolveOpts(KindRefreshToken).(*model.UnitOptions)
+	refresh := &model.RefreshToken{}
+	dbResp := d.db.Read(d.ReqCtx, model.ByID(hashToken(token)), refresh, opts)
+	if errors.Is(dbResp.Err, model.ErrNotFound) {
+		return nil, "", time.Time{}, ErrInvalidRefreshToken
+	}
+	if dbResp.Err != nil {
+		return nil, "", time.Time{}, dbResp.Err
+	}
+	if refresh.UsedAt != nil {
+		log.Warn().Msgf("refresh token of user %v was used again: revoking its family", refresh.UserID)
+		if err := d.revokeFamily(refresh.Family); err != nil {
+			return nil, "", time.Time{}, err
+		}
+		return nil, "", time.Time{}, ErrInvalidRefreshToken
+	}
+	if !time.Now().Before(refresh.ExpiresAt) {
+		return nil, "", time.Time{}, ErrInvalidRefreshToken
+	}
+	// claiming the token is conditional on it being unused, so of two exchanges racing with it, one fails
+	dbResp = d.db.Update(d.ReqCtx, model.Filter{"_id": refresh.ID, "used_at": nil}, model.Fields{"used_at": time.Now().UTC()}, opts)
+	if errors.Is(dbResp.Err, model.ErrNotFound) {
+		return nil, "", time.Time{}, ErrInvalidRefreshToken
+	}
+	if dbResp.Err != nil {
+		return nil, "", time.Time{}, dbResp.Err
+	}
+
+	user, err := d.Get(refresh.UserID)
+	if errors.Is(err, model.ErrNotFound) {
+		// the user was deleted since
+		return nil, "", time.Time{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	next, expires, err := d.issueRefresh(user.ID, refresh.Family)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	return user, next, expires, nil
+}
+
+// EndSession revokes the family of a refresh token, on logout. Unknown tokens are ignored.
+func (d *UserDirector) EndSession(token string) error {
+	refresh := &model.RefreshToken{}
+	dbResp := d.db.Read(d.ReqCtx, model.ByID(hashToken(token)), refresh, resolveOpts(KindRefreshToken).(*model.UnitOptions))
+	if errors.Is(dbResp.Err, model.ErrNotFound) {
+		return nil
+	}
+	if dbResp.Err != nil {
+		return dbResp.Err
+	}
+	return d.revokeFamily(refresh.Family)
+}
+
+func (d *UserDirector) issueRefresh(userID, family string) (string, time.Time, error) {
+	token, err := newEditToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	refresh := model.NewRefreshToken(hashToken(token), userID, family, RefreshTokenTTL)
+	dbResp := d.db.Create(d.ReqCtx, refresh, resolveOpts(KindRefreshToken).(*model.UnitOptions))
+	if dbResp.Err != nil {
+		return "", time.Time{}, dbResp.Err
+	}
+	return token, refresh.ExpiresAt, nil
+}
+
+func (d *UserDirector) revokeFamily(family string) error {
+	dbResp := d.db.Delete(d.ReqCtx, model.Filter{"family": family}, model.HardDelete, resolveOpts(KindRefreshToken).(*model.UnitOptions))
+	if dbResp.Err != nil && !errors.Is(dbResp.Err, model.ErrNotFound) {
+		return dbResp.Err
+	}
+	return nil
+}
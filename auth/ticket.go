@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/dark-enstein/port/db"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/ticket"
+	"github.com/rs/zerolog"
+	"time"
+)
+
+var (
+	KindTicket        = "ticket"
+	TicketCollection  = model.TicketCollection
+	KindCheckIn       = "checkin"
+	CheckInCollection = model.CheckInCollection
+
+	ErrAlreadyRedeemed = errors.New("ticket already redeemed")
+)
+
+// TicketDirector defines a master that issues signed tickets to events
+type TicketDirector struct {
+	log    *zerolog.Logger
+	ReqCtx context.Context
+	db     db.DB
+	opts   *model.UnitOptions
+}
+
+func NewTicketDirector(ctx context.Context) *TicketDirector {
+	return &TicketDirector{ReqCtx: ctx, db: GetDBFromCtx(ctx), log: GetLoggerFromCtx(ctx), opts: resolveOpts(KindTicket).(*model.UnitOptions)}
+}
+
+// Issue signs the ticket with the signer passed in and persists it. It returns the token the ticket's code encodes.
+func (d *TicketDirector) Issue(t *model.Ticket, signer *ticket.Signer) (string, error) {
+	log := d.log.With().Str("method", "TicketDirector.Issue()").Logger()
+	t.KeyID = signer.KeyID()
+	token, err := signer.Sign(Claims(t))
+	if err != nil {
+		return "", err
+	}
+	dbResp := d.db.Create(d.ReqCtx, t, d.opts)
+	if dbResp.Err != nil {
+		log.Info().Msgf("cannot create ticket %v due to error: %v", t.ID, dbResp.Err)
+		return "", dbResp.Err
+	}
+	return token, nil
+}
+
+// Get retrieves the ticket with the id passed in. It returns model.ErrNotFound if there is none
+func (d *TicketDirector) Get(id string) (*model.Ticket, error) {
+	t := &model.Ticket{}
+	dbResp := d.db.Read(d.ReqCtx, model.ByID(id), t, d.opts)
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	return t, nil
+}
+
+// Redeem marks the ticket with the id passed in as used by the device passed in. Only the first redemption of a
+// ticket succeeds, however many devices scan it at once: the others get ErrAlreadyRedeemed, along with the ticket
+// recording which device redeemed it, and when. It returns model.ErrNotFound if there is no such ticket.
+func (d *TicketDirector) Redeem(id, deviceID string) (*model.Ticket, error) {
+	log := d.log.With().Str("method", "TicketDirector.Redeem()").Logger()
+	dbResp := d.db.Update(d.ReqCtx, model.Filter{"_id": id, "redeemed_at": nil}, model.Fields{
+		"redeemed_at": time.Now().UTC(),
+		"redeemed_by": deviceID,
+	}, d.opts)
+	if dbResp.Err != nil && !errors.Is(dbResp.Err, model.ErrNotFound) {
+		log.Info().Msgf("cannot redeem ticket %v due to error: %v", id, dbResp.Err)
+		return nil, dbResp.Err
+	}
+	t, err := d.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if dbResp.Err != nil {
+		return t, ErrAlreadyRedeemed
+	}
+	return t, nil
+}
+
+// RecordCheckIn persists a check-in
+func (d *TicketDirector) RecordCheckIn(c *model.CheckIn) error {
+	dbResp := d.db.Create(d.ReqCtx, c, resolveOpts(KindCheckIn).(*model.UnitOptions))
+	if dbResp.Err != nil {
+		d.log.Info().Str("method", "TicketDirector.RecordCheckIn()").Msgf("cannot record check-in of ticket %v due to error: %v", c.TicketID, dbResp.Err)
+	}
+	return dbResp.Err
+}
+
+// Claims returns the claims a ticket's token is signed from
+func Claims(t *model.Ticket) *ticket.Claims {
+	return &ticket.Claims{
+		ID:        t.ID,
+		Event:     t.Event,
+		Seat:      t.Seat,
+		Holder:    t.UserID,
+		NotBefore: t.ValidFrom.Unix(),
+		Expires:   t.ValidTo.Unix(),
+	}
+}
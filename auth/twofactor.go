@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/totp"
+	"strings"
+	"time"
+)
+
+var (
+	// RecoveryCodeCount is the number of recovery codes handed out when two-factor authentication is confirmed
+	RecoveryCodeCount = 10
+
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotPending  = errors.New("two-factor authentication must be enrolled first")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrSecondFactorRequired = errors.New("login must carry the code of the authenticator app, or a recovery code")
+	ErrInvalidSecondFactor  = errors.New("code is invalid, or already used")
+
+	recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// EnrollTOTP creates the secret of a new authenticator app for the user with the id passed in, and returns it. The
+// secret replaces any enrolled before, but is only required at login once ConfirmTOTP checks a code of it.
+func (d *UserDirector) EnrollTOTP(id string) (*model.User, string, error) {
+	user, err := d.Get(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if user.TOTPEnabled {
+		return nil, "", ErrTwoFactorEnabled
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	user, err = d.Update(id, model.Fields{"totp_secret": secret})
+	if err != nil {
+		return nil, "", err
+	}
+	return user, secret, nil
+}
+
+// ConfirmTOTP checks the first code of the authenticator app enrolled by the user with the id passed in, and enables
+// two-factor authentication. It returns the recovery codes of the user, which are never shown again: only their
+// hashes are kept.
+func (d *UserDirector) ConfirmTOTP(id, code string) ([]string, error) {
+	user, err := d.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotPending
+	}
+	step, err := totp.Validate(user.TOTPSecret, code, time.Now())
+	if err != nil {
+		return nil, ErrInvalidSecondFactor
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	fields := model.Fields{"totp_enabled": true, "totp_step": step, "recovery_codes": hashes, "updated_at": time.Now().UTC()}
+	dbResp := d.db.Update(d.ReqCtx, model.Filter{"_id": id, model.VersionField: user.Version}, fields, d.opts())
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off for the user with the id passed in, and forgets its secret and
+// recovery codes. Unless the caller is trusted to, a code of the user must be passed in.
+func (d *UserDirector) DisableTOTP(id, code string, trusted bool) error {
+	user, err := d.Get(id)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled && user.TOTPSecret == "" {
+		return ErrTwoFactorNotEnabled
+	}
+	if !trusted && user.TOTPEnabled {
+		if _, ok := secondFactor(user, code, time.Now()); !ok {
+			return ErrInvalidSecondFactor
+		}
+	}
+	fields := model.Fields{"totp_secret": "", "totp_enabled": false, "totp_step": 0, "recovery_codes": []string{}, "updated_at": time.Now().UTC()}
+	return d.db.Update(d.ReqCtx, model.Filter{"_id": id, model.VersionField: user.Version}, fields, d.opts()).Err
+}
+
+// secondFactor checks a code of the authenticator app of the user, or one of its recovery codes, and returns the
+// fields using it up: codes of the authenticator app can't be used twice, and recovery codes once only.
+func secondFactor(user *model.User, code string, now time.Time) (model.Fields, bool) {
+	if code == "" {
+		return nil, false
+	}
+	if step, err := totp.Validate(user.TOTPSecret, code, now); err == nil {
+		if step <= user.TOTPStep {
+			return nil, false
+		}
+		return model.Fields{"totp_step": step}, true
+	}
+	hash := hashToken(normalizeRecoveryCode(code))
+	for i, h := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			left := append(append([]string{}, user.RecoveryCodes[:i]...), user.RecoveryCodes[i+1:]...)
+			return model.Fields{"recovery_codes": left}, true
+		}
+	}
+	return nil, false
+}
+
+// newRecoveryCodes returns RecoveryCodeCount recovery codes, written like "abcd-efgh", and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, hashes := make([]string, RecoveryCodeCount), make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
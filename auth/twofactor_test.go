@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/db"
+	"github.com/dark-enstein/port/db/memory"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/totp"
+	"github.com/dark-enstein/port/util"
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingUpdates is a DB whose updates fail once fail is set
+type failingUpdates struct {
+	db.DB
+	fail bool
+}
+
+func (f *failingUpdates) Update(ctx context.Context, filter model.Filter, fields model.Fields, opts model.Opts) *model.DBResponse {
+	if f.fail {
+		return &model.DBResponse{Err: errors.New("db is down")}
+	}
+	return f.DB.Update(ctx, filter, fields, opts)
+}
+
+type TwoFactorTest struct {
+	director *UserDirector
+	db       *failingUpdates
+	user     *model.User
+	suite.Suite
+}
+
+func (s *TwoFactorTest) SetupTest() {
+	Passwords = NewPasswordHasher(1, 8*1024, 1)
+	ctx := context.WithValue(context.Background(), util.LoggerInContext, config.NewLoggerWithWarn())
+	mem, err := memory.NewMemoryClient(ctx, "")
+	s.Require().NoError(err)
+	s.db = &failingUpdates{DB: mem}
+	s.director = NewUserDirector(context.WithValue(ctx, util.DBInContext, s.db))
+	u := (&User{Name: "ada lovelace", Birth: "10/12/1815", Email: "ada@example.com", Password: "correct horse battery"}).IntoInternal()
+	s.user, err = s.director.CreateUser(*u)
+	s.Require().NoError(err)
+}
+
+// enable enrolls an authenticator app for the user and confirms it, returning its secret and the recovery codes
+func (s *TwoFactorTest) enable() (string, []string) {
+	_, secret, err := s.director.EnrollTOTP(s.user.ID)
+	s.Require().NoError(err)
+	code, err := totp.Code(secret, time.Now())
+	s.Require().NoError(err)
+	codes, err := s.director.ConfirmTOTP(s.user.ID, code)
+	s.Require().NoError(err)
+	return secret, codes
+}
+
+// TestEnroll tests that logins don't need a code until the authenticator app is confirmed, that confirming checks
+// the code, and that only the hashes of the recovery codes are kept
+func (s *TwoFactorTest) TestEnroll() {
+	_, err := s.director.ConfirmTOTP(s.user.ID, "123456")
+	s.Assert().ErrorIs(err, ErrTwoFactorNotPending)
+
+	user, secret, err := s.director.EnrollTOTP(s.user.ID)
+	s.Require().NoError(err)
+	s.Assert().Equal(secret, user.TOTPSecret)
+	s.Assert().False(user.TOTPEnabled)
+	_, err = s.director.Login("ada@example.com", "correct horse battery", "")
+	s.Assert().NoError(err)
+
+	code, err := totp.Code(secret, time.Now().Add(-time.Hour))
+	s.Require().NoError(err)
+	_, err = s.director.ConfirmTOTP(s.user.ID, code)
+	s.Assert().ErrorIs(err, ErrInvalidSecondFactor)
+
+	code, err = totp.Code(secret, time.Now())
+	s.Require().NoError(err)
+	codes, err := s.director.ConfirmTOTP(s.user.ID, code)
+	s.Require().NoError(err)
+	s.Assert().Len(codes, RecoveryCodeCount)
+	user, err = s.director.Get(s.user.ID)
+	s.Require().NoError(err)
+	s.Assert().True(user.TOTPEnabled)
+	s.Require().Len(user.RecoveryCodes, RecoveryCodeCount)
+	for _, hash := range user.RecoveryCodes {
+		s.Assert().NotContains(codes, hash)
+	}
+
+	_, _, err = s.director.EnrollTOTP(s.user.ID)
+	s.Assert().ErrorIs(err, ErrTwoFactorEnabled)
+}
+
+// TestLogin tests that logins need a code once two-factor authentication is enabled, that wrong codes count as failed
+// logins, and that codes can't be used twice
+func (s *TwoFactorTest) TestLogin() {
+	secret, _ := s.enable()
+
+	_, err := s.director.Login("ada@example.com", "correct horse battery", "")
+	s.Assert().ErrorIs(err, ErrSecondFactorRequired)
+	_, err = s.director.Login("ada@example.com", "wrong", "000000")
+	s.Assert().ErrorIs(err, ErrInvalidCredentials)
+	_, err = s.director.Login("ada@example.com", "correct horse battery", "000000")
+	s.Assert().ErrorIs(err, ErrInvalidSecondFactor)
+	user, err := s.director.Get(s.user.ID)
+	s.Require().NoError(err)
+	s.Assert().Equal(2, user.FailedLogins)
+
+	// the code confirming the app was used up, so the next step's is used
+	code, err := totp.Code(secret, time.Now().Add(totp.Period))
+	s.Require().NoError(err)
+	user, err = s.director.Login("ada@example.com", "correct horse battery", code)
+	s.Require().NoError(err)
+	s.Assert().Zero(user.FailedLogins)
+	_, err = s.director.Login("ada@example.com", "correct horse battery", code)
+	s.Assert().ErrorIs(err, ErrInvalidSecondFactor)
+}
+
+// TestRecoveryCodes tests that recovery codes log in once each, however they are written
+func (s *TwoFactorTest) TestRecoveryCodes() {
+	_, codes := s.enable()
+
+	_, err := s.director.Login("ada@example.com", "correct horse battery", strings.ToUpper(codes[3]))
+	s.Require().NoError(err)
+	_, err = s.director.Login("ada@example.com", "correct horse battery", codes[3])
+	s.Assert().ErrorIs(err, ErrInvalidSecondFactor)
+	user, err := s.director.Get(s.user.ID)
+	s.Require().NoError(err)
+	s.Assert().Len(user.RecoveryCodes, RecoveryCodeCount-1)
+}
+
+// TestUnrecorded tests that logins fail when the code they use can't be recorded as used, so it can't be used again
+func (s *TwoFactorTest) TestUnrecorded() {
+	_, codes := s.enable()
+	s.db.fail = true
+	_, err := s.director.Login("ada@example.com", "correct horse battery", codes[0])
+	s.Assert().Error(err)
+	s.Assert().NotErrorIs(err, ErrInvalidSecondFactor)
+
+	s.db.fail = false
+	_, err = s.director.Login("ada@example.com", "correct horse battery", codes[0])
+	s.Assert().NoError(err)
+}
+
+// TestDisable tests that users disable two-factor authentication with a code, and trusted callers without one
+func (s *TwoFactorTest) TestDisable() {
+	s.Assert().ErrorIs(s.director.DisableTOTP(s.user.ID, "", false), ErrTwoFactorNotEnabled)
+	_, codes := s.enable()
+	s.Assert().ErrorIs(s.director.DisableTOTP(s.user.ID, "", false), ErrInvalidSecondFactor)
+	s.Require().NoError(s.director.DisableTOTP(s.user.ID, codes[0], false))
+
+	user, err := s.director.Get(s.user.ID)
+	s.Require().NoError(err)
+	s.Assert().False(user.TOTPEnabled)
+	s.Assert().Empty(user.TOTPSecret)
+	s.Assert().Empty(user.RecoveryCodes)
+	_, err = s.director.Login("ada@example.com", "correct horse battery", "")
+	s.Assert().NoError(err)
+
+	s.enable()
+	s.Require().NoError(s.director.DisableTOTP(s.user.ID, "", true))
+}
+
+func TestTwoFactor(t *testing.T) {
+	suite.Run(t, new(TwoFactorTest))
+}
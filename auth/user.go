@@ -2,11 +2,13 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/dark-enstein/port/config"
 	"github.com/dark-enstein/port/db"
 	"github.com/dark-enstein/port/db/model"
 	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,40 +16,55 @@ import (
 )
 
 var (
-	VanillaUser    = Role{}
-	Administrator  = RoleSet{}
-	Developer      = RoleSet{}
 	ulog           = config.NewLogger(zerolog.GlobalLevel().String())
 	KindUser       = "user"
 	UserDB         = config.DefaultDBName
 	UserTable      = "users"
-	UserCollection = "users"
+	UserCollection = model.UserCollection
 )
 
 // RoleSet defines a list of roles that can be attached to an application user; eg PowerUser on AWS, which consist EC2 Admin, IAM Admin, etc
 type RoleSet []Role
 
-// ToBinary returns the binary representation of a RoleSet, where 1 == true, 0 == false
+// ToBinary returns the binary representation of a RoleSet, the role set users are stored with: one map per role,
+// holding the mask of the role by its name
 func (rs *RoleSet) ToBinary() []map[string]*int64 {
-	res := make([]map[string]*int64, len(*rs))
+	res := make([]map[string]*int64, 0, len(*rs))
 	for _, v := range *rs {
 		res = append(res, v.ToBinary())
 	}
 	return res
 }
 
+// Permissions returns the effective permissions of the roles in the set, merging their masks
+func (rs *RoleSet) Permissions() PermissionSet {
+	var mask int64
+	for _, r := range *rs {
+		mask |= r.User.Mask()
+	}
+	return MaskPermissions(mask)
+}
+
+// Names returns the names of the roles in the set
+func (rs *RoleSet) Names() []string {
+	names := make([]string, len(*rs))
+	for i, r := range *rs {
+		names[i] = r.Name
+	}
+	return names
+}
+
 // Role defines a list of permissions that can be attached to an application user; eg IAM Administrator role, EC2 creator permission
 type Role struct {
+	Name string
+	// User holds the permissions the role grants its users
 	User PermissionSet
 }
 
-// ToBinary returns the binary representation of a role, where 1 == true, 0 == false
+// ToBinary returns the binary representation of a role: its mask, by its name
 func (r *Role) ToBinary() map[string]*int64 {
-	res := make(map[string]*int64, len(r.User))
-	for _, v := range r.User {
-		res[v.Name] = v.ToBinary()
-	}
-	return res
+	mask := r.User.Mask()
+	return map[string]*int64{r.Name: &mask}
 }
 
 // PermissionSet is a list of permissions present in a role;
@@ -62,6 +79,28 @@ func (ps *PermissionSet) ToBinary() map[string]*int64 {
 	return res
 }
 
+// Mask packs the permissions of the set into a single mask, 4 bits per resource, in the order of Resources.
+// Permissions on resources that aren't in Resources are left out.
+func (ps *PermissionSet) Mask() int64 {
+	var mask int64
+	for _, p := range *ps {
+		if i := resourceIndex(p.Name); i >= 0 {
+			mask |= *p.ToBinary() << (4 * i)
+		}
+	}
+	return mask
+}
+
+// Allows checks that the set grants the permission a call needs
+func (ps *PermissionSet) Allows(need Requirement) bool {
+	for _, p := range *ps {
+		if p.Name == need.Resource && p.Allows(need.Action) {
+			return true
+		}
+	}
+	return false
+}
+
 // Permission is a structure for a permission unit. This defines the permissions on a particular resource. It consist the name of the resource, and the permissions boolean
 type Permission struct {
 	Name   string
@@ -71,6 +110,26 @@ type Permission struct {
 	Delete bool
 }
 
+// PermissionFromBinary decodes the binary representation of a permission on the resource passed in
+func PermissionFromBinary(name string, bin int64) Permission {
+	return Permission{Name: name, Create: bin&8 != 0, Read: bin&4 != 0, Update: bin&2 != 0, Delete: bin&1 != 0}
+}
+
+// Allows checks that the permission grants the action
+func (p *Permission) Allows(a Action) bool {
+	switch a {
+	case ActionCreate:
+		return p.Create
+	case ActionRead:
+		return p.Read
+	case ActionUpdate:
+		return p.Update
+	case ActionDelete:
+		return p.Delete
+	}
+	return false
+}
+
 // ToBinary converts a permission struct into it's binary representation, where 1 == true, 0 == false
 func (p *Permission) ToBinary() *int64 {
 	bin := ""
@@ -108,14 +167,23 @@ func (p *Permission) ToBinary() *int64 {
 }
 
 type User struct {
-	Name  string `json:"name"`
-	Birth string `json:"dob"`
+	Name     string `json:"name"`
+	Birth    string `json:"dob"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
 }
 
 // InternalUser struct for performing various computing before making it ready for the DB
 type InternalUser struct {
 	name  Name
 	birth DateOfBirth
+	email string
+	// password is only held until it is hashed
+	password string
+	// roles is a pointer, so users stay comparable
+	roles *RoleSet
+	// service marks service accounts: users only ever calling Port with API keys
+	service bool
 }
 
 func (u InternalUser) Name() *Name {
@@ -131,6 +199,10 @@ func (u InternalUser) BirthDate() *string {
 	return &str
 }
 
+func (u InternalUser) Email() string {
+	return u.email
+}
+
 func (u InternalUser) Kind() string {
 	return KindUser
 }
@@ -139,22 +211,36 @@ func (u InternalUser) GetTime() time.Time {
 	return time.Now()
 }
 
+// GetPermissions returns the effective permissions of the user, merged from its roles
 func (u InternalUser) GetPermissions() PermissionSet {
-	// db calls
-	return PermissionSet{}
+	roles := u.GetRoles()
+	return roles.Permissions()
 }
 
+// GetRoles returns the roles of the user. Users are created with the vanilla role.
 func (u InternalUser) GetRoles() RoleSet {
-	// db calls
-	return RoleSet{}
+	if u.roles == nil || len(*u.roles) == 0 {
+		return RoleSet{VanillaUser}
+	}
+	return *u.roles
 }
 
 func (u InternalUser) IntoUserModel(ctx context.Context) *model.User {
 	log := GetLoggerFromCtx(ctx).With().Str("method", "InternalUser.IntoUserModel()").Logger()
 	mU := model.NewUser(ctx).WithName(
-		&model.Name{FirstName: u.Name().RetrieveFirstName(), LastName: u.Name().RetrieveLastName()}).WithBirthDate(
-		u.BirthDate()).WithRoleSet(
-		&model.RoleSet{VanillaUser.ToBinary()})
+		&model.Name{FirstName: u.Name().RetrieveFirstName(), LastName: u.Name().RetrieveLastName()})
+	if u.service {
+		mU.Service = true
+	} else {
+		mU.WithBirthDate(u.BirthDate())
+	}
+	roles := u.GetRoles()
+	set := model.RoleSet(roles.ToBinary())
+	mU.WithRoleSet(&set)
+	if u.email != "" {
+		email := u.email
+		mU.Email = &email
+	}
 	log.Debug().Msgf("converted Internal user %v into Model user %v successfully", u, mU)
 	return mU
 }
@@ -232,10 +318,18 @@ func (u *User) String() string {
 	return fmt.Sprintf("%s %s", u.Name, u.Birth)
 }
 
+// NewServiceAccount returns a service account named as passed in. Service accounts have no email or password, so
+// they can't log in: they call Port with the API keys issued to them.
+func NewServiceAccount(name string) *InternalUser {
+	return &InternalUser{name: Name{firstName: name}, service: true}
+}
+
 func (u *User) IntoInternal() *InternalUser {
 	return &InternalUser{
-		name:  *NewName(u.Name),
-		birth: *NewDateOfBirth(u.Birth),
+		name:     *NewName(u.Name),
+		birth:    *NewDateOfBirth(u.Birth),
+		email:    NormalizeEmail(u.Email),
+		password: u.Password,
 	}
 }
 
@@ -298,3 +392,105 @@ func (d *UserDirector) CreateUsers(u []InternalUser) func() ([]string, error) {
 		return createIDs, nil // these errors are server errors, or for tracing, not one to be returned to the client
 	}
 }
+
+// CreateUser persists the user passed in, with an id of its own, the vanilla role and the hash of its password,
+// and returns it. It returns model.ErrDuplicate when another user has the same email.
+func (d *UserDirector) CreateUser(u InternalUser) (*model.User, error) {
+	log := d.log.With().Str("method", "UserDirector.CreateUser()").Logger()
+	if u.roles == nil {
+		// users get the vanilla role as it is stored, which may have been changed since it was created
+		vanilla, err := NewRoleDirector(d.ReqCtx).Get(VanillaUser.Name)
+		if err != nil && !errors.Is(err, model.ErrNotFound) {
+			return nil, err
+		}
+		if vanilla != nil {
+			u.roles = &RoleSet{*vanilla}
+		}
+	}
+	user := u.IntoUserModel(d.ReqCtx)
+	if u.email != "" {
+		_, err := d.GetByEmail(u.email)
+		if err == nil {
+			return nil, fmt.Errorf("%w: email %v is taken", model.ErrDuplicate, u.email)
+		}
+		if !errors.Is(err, model.ErrNotFound) {
+			return nil, err
+		}
+	}
+	if u.password != "" {
+		hash, err := Passwords.Hash(u.password)
+		if err != nil {
+			return nil, err
+		}
+		user.PasswordHash = hash
+	}
+	user.ID = primitive.NewObjectID().Hex()
+	user.CreatedAt = time.Now().UTC()
+	user.UpdatedAt = user.CreatedAt
+	dbResp := d.db.Create(d.ReqCtx, user, d.opts())
+	if dbResp.Err != nil {
+		log.Info().Msgf("cannot create the user %v due to error: %v", u.NameStr(), dbResp.Err)
+		return nil, dbResp.Err
+	}
+	return user, nil
+}
+
+// Get retrieves the user with the id passed in. It returns model.ErrNotFound if there is none
+func (d *UserDirector) Get(id string) (*model.User, error) {
+	user := &model.User{}
+	dbResp := d.db.Read(d.ReqCtx, model.ByID(id), user, d.opts())
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	return user, nil
+}
+
+// GetByEmail retrieves the user with the email passed in. It returns model.ErrNotFound if there is none
+func (d *UserDirector) GetByEmail(email string) (*model.User, error) {
+	user := &model.User{}
+	dbResp := d.db.Read(d.ReqCtx, model.Filter{model.EmailField: NormalizeEmail(email)}, user, d.opts())
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	return user, nil
+}
+
+// List retrieves a page of the users matching the filter, in the order they were created
+func (d *UserDirector) List(filter model.Filter, offset, limit int) ([]*model.User, error) {
+	var users []*model.User
+	list := &model.ListOpts{Sort: []model.Sort{{Field: "_id"}}, Offset: offset, Limit: limit}
+	dbResp := d.db.List(d.ReqCtx, filter, &users, list, d.opts())
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	return users, nil
+}
+
+// Update sets the fields on the user with the id passed in, and returns the updated user. It returns
+// model.ErrConflict when the user changed between being read and updated.
+func (d *UserDirector) Update(id string, fields model.Fields) (*model.User, error) {
+	user, err := d.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	fields["updated_at"] = time.Now().UTC()
+	dbResp := d.db.Update(d.ReqCtx, model.Filter{"_id": id, model.VersionField: user.Version}, fields, d.opts())
+	if dbResp.Err != nil {
+		return nil, dbResp.Err
+	}
+	return d.Get(id)
+}
+
+// Delete deletes the user with the id passed in. Users are deleted softly, so their records can be restored, but
+// their email is cleared first: emails are unique among the records kept, deleted or not, and can be registered again.
+func (d *UserDirector) Delete(id string) error {
+	dbResp := d.db.Update(d.ReqCtx, model.ByID(id), model.Fields{"email": nil, "updated_at": time.Now().UTC()}, d.opts())
+	if dbResp.Err != nil {
+		return dbResp.Err
+	}
+	return d.db.Delete(d.ReqCtx, model.ByID(id), model.SoftDelete, d.opts()).Err
+}
+
+func (d *UserDirector) opts() *model.UserOptions {
+	return resolveOpts(KindUser).(*model.UserOptions)
+}
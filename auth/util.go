@@ -25,6 +25,28 @@ func resolveOpts(kind string) Options {
 			Table:            UserTable,
 			CreateOnNotExist: true,
 		}
+	case KindJob:
+		return model.NewUnitOptions(UserDB, JobCollection)
+	case KindLink:
+		return model.NewUnitOptions(UserDB, LinkCollection)
+	case KindScan:
+		return model.NewUnitOptions(UserDB, ScanCollection)
+	case KindRender:
+		return model.NewUnitOptions(UserDB, RenderCollection)
+	case KindTicket:
+		return model.NewUnitOptions(UserDB, TicketCollection)
+	case KindCheckIn:
+		return model.NewUnitOptions(UserDB, CheckInCollection)
+	case KindPasswordReset:
+		return model.NewUnitOptions(UserDB, PasswordResetCollection)
+	case KindRefreshToken:
+		return model.NewUnitOptions(UserDB, RefreshTokenCollection)
+	case KindRole:
+		return model.NewUnitOptions(UserDB, RoleCollection)
+	case KindAudit:
+		return model.NewUnitOptions(UserDB, AuditCollection)
+	case KindAPIKey:
+		return model.NewUnitOptions(UserDB, APIKeyCollection)
 	}
 	return nil
 }
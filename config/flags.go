@@ -2,6 +2,8 @@ package config
 
 import (
 	"flag"
+	"path/filepath"
+	"time"
 )
 
 const (
@@ -9,23 +11,98 @@ const (
 	FlagPort       = "port"
 	FlagDB         = "db"
 	FlagDBHost     = "db-host"
+	FlagDBSnapshot = "db-snapshot"
+	FlagDBMigrate  = "db-migrate"
 	FlagProvider   = "provider"
 	FlagLOC        = ""
+	FlagStorage    = "storage"
+	FlagStorageDir = "storage-dir"
+	FlagPublicURL  = "public-url"
+	FlagS3Bucket   = "s3-bucket"
+	FlagAWSProfile = "aws-profile"
+	FlagAWSRegion  = "aws-region"
+	FlagBatchWork  = "batch-workers"
+	FlagBatchMax   = "batch-max-items"
+	FlagJobWork    = "job-workers"
+	FlagCallbacks  = "callback-allow"
+	FlagScanBatch  = "scan-batch"
+	FlagScanFlush  = "scan-flush-interval"
+	FlagCacheSize  = "cache-size"
+	FlagTicketKey  = "ticket-key"
+	FlagHashTime   = "password-hash-time"
+	FlagHashMemory = "password-hash-memory"
+	FlagHashThread = "password-hash-threads"
+	FlagMaxFailure = "login-max-failures"
+	FlagLockout    = "login-lockout"
+	FlagJWTIssuer  = "jwt-issuer"
+	FlagJWTAud     = "jwt-audience"
+	FlagAccessTTL  = "jwt-access-ttl"
+	FlagRefreshTTL = "jwt-refresh-ttl"
+	FlagKeyRotate  = "jwt-key-rotation"
+	FlagAuthExempt = "auth-exempt"
+	FlagAdmins     = "admin-emails"
+	FlagKeyRate    = "api-key-rate-limit"
+	FlagProxies    = "trusted-proxies"
 	NoFlagLogLevel = ""
 )
 
 var (
-	DefaultFLagLogLevel = "info"
-	DefaultFlagDB       = "mongo"
-	DefaultFlagDBHost   = "mongodb://localhost:27017/"
-	DefaultFlagPort     = "8090"
-	DefaultDBName       = "port"
-	DefaultFlagProvider = "aws"
-	DefaultFlagLOC      = "~/.aws/credentials"
+	DefaultFLagLogLevel   = "info"
+	DefaultFlagDB         = "mongo"
+	DefaultFlagDBHost     = "mongodb://localhost:27017/"
+	DefaultFlagDBSnapshot = ""
+	DefaultFlagDBMigrate  = false
+	DefaultSQLiteFile     = "port.db"
+	DefaultFlagPort       = "8090"
+	DefaultDBName         = "port"
+	DefaultFlagProvider   = "aws"
+	DefaultFlagLOC        = "~/.aws/credentials"
+	DefaultFlagStorage    = StorageLocal
+	DefaultFlagStorageDir = filepath.Join(".qr", "generated")
+	DefaultFlagPublicURL  = ""
+	DefaultFlagS3Bucket   = "port-elvis-gargantuan-panda"
+	DefaultFlagAWSProfile = "elvis"
+	DefaultFlagAWSRegion  = "us-west-2"
+	DefaultFlagBatchWork  = 8
+	DefaultFlagBatchMax   = 1000
+	DefaultFlagJobWork    = 4
+	DefaultFlagCallbacks  = ""
+	DefaultFlagScanBatch  = 100
+	DefaultFlagScanFlush  = 5 * time.Second
+	DefaultFlagCacheSize  = 4096
+	DefaultFlagTicketKey  = ""
+	DefaultFlagHashTime   = uint(3)
+	DefaultFlagHashMemory = uint(64 * 1024)
+	DefaultFlagHashThread = uint(2)
+	DefaultFlagMaxFailure = 5
+	DefaultFlagLockout    = 15 * time.Minute
+	DefaultFlagJWTIssuer  = ""
+	DefaultFlagJWTAud     = "port"
+	DefaultFlagAccessTTL  = 15 * time.Minute
+	DefaultFlagRefreshTTL = 30 * 24 * time.Hour
+	DefaultFlagKeyRotate  = 24 * time.Hour
+	DefaultFlagAuthExempt = "/ping,/metrics"
+	DefaultFlagAdmins     = ""
+	DefaultFlagKeyRate    = 60
+	DefaultFlagProxies    = ""
 )
 
 var (
-	LogLevels = []string{"info", "debug", "warn"}
+	DBMongo    = "mongo"
+	DBMemory   = "memory"
+	DBSQLite   = "sqlite"
+	DBPostgres = "postgres"
+)
+
+var (
+	StorageLocal  = "local"
+	StorageS3     = "s3"
+	StorageMemory = "memory"
+)
+
+var (
+	LogLevels       = []string{"info", "debug", "warn"}
+	StorageBackends = []string{StorageLocal, StorageS3, StorageMemory}
 )
 
 var CommandLine = flag.NewFlagSet("port", flag.ExitOnError)
@@ -1,5 +1,12 @@
 package config
 
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
 type Configurer interface {
 	// String returns the string representation of all the environment variables
 	String() string
@@ -15,11 +22,27 @@ type Configurer interface {
 //}
 
 type Config struct {
-	LogLevel  string      `json:"log_level"`
-	Port      string      `json:"server_port"`
-	EnabledDB string      `json:"enabled_db"`
-	DBHost    string      `json:"db_host"`
-	Cloud     CloudConfig `json:"cloud"`
+	LogLevel   string        `json:"log_level"`
+	Port       string        `json:"server_port"`
+	EnabledDB  string        `json:"enabled_db"`
+	DBHost     string        `json:"db_host"`
+	DBSnapshot string        `json:"db_snapshot"`
+	DBMigrate  bool          `json:"db_migrate"`
+	PublicURL  string        `json:"public_url"`
+	Cloud      CloudConfig   `json:"cloud"`
+	Storage    StorageConfig `json:"storage"`
+	Batch      BatchConfig   `json:"batch"`
+	Jobs       JobsConfig    `json:"jobs"`
+	Scans      ScansConfig   `json:"scans"`
+	Cache      CacheConfig   `json:"cache"`
+	Tickets    TicketsConfig `json:"tickets"`
+	Logins     LoginsConfig  `json:"logins"`
+	Tokens     TokensConfig  `json:"tokens"`
+	// Admins lists the emails of the users granted the Administrator role on startup, comma separated
+	Admins string `json:"admins"`
+	// TrustedProxies lists the networks, comma separated IPs or CIDRs, of the proxies whose X-Forwarded-For header
+	// names the clients of requests
+	TrustedProxies string `json:"trusted_proxies"`
 }
 
 type CloudConfig struct {
@@ -27,6 +50,109 @@ type CloudConfig struct {
 	LOC      string `json:"loc"`
 }
 
+// BatchConfig holds the limits of batch generation
+type BatchConfig struct {
+	Workers  int `json:"workers"`
+	MaxItems int `json:"max_items"`
+}
+
+// JobsConfig holds the limits of asynchronous generation. CallbackAllow lists the networks, comma separated IPs or
+// CIDRs, callbacks may be delivered to though they're private, loopback or link-local.
+type JobsConfig struct {
+	Workers       int    `json:"workers"`
+	CallbackAllow string `json:"callback_allow"`
+}
+
+// CallbackNetworks returns the networks callbacks may be delivered to though they aren't public
+func (j *JobsConfig) CallbackNetworks() ([]*net.IPNet, error) {
+	return networks(j.CallbackAllow)
+}
+
+// ScansConfig holds how the scans of dynamic codes are buffered before they're written to the DB
+type ScansConfig struct {
+	BatchSize     int           `json:"batch_size"`
+	FlushInterval time.Duration `json:"flush_interval"`
+}
+
+// CacheConfig holds the size of the in-memory tier of the render cache. A size of zero leaves it to the DB index.
+type CacheConfig struct {
+	Size int `json:"size"`
+}
+
+// TicketsConfig holds where the Ed25519 key tickets are signed with is read from
+type TicketsConfig struct {
+	KeyFile string `json:"key_file"`
+}
+
+// LoginsConfig holds the argon2id cost passwords are hashed with, and how failed logins lock users out. HashMemory
+// is in KiB.
+type LoginsConfig struct {
+	HashTime    uint          `json:"hash_time"`
+	HashMemory  uint          `json:"hash_memory"`
+	HashThreads uint          `json:"hash_threads"`
+	MaxFailures int           `json:"max_failures"`
+	Lockout     time.Duration `json:"lockout"`
+}
+
+// TokensConfig holds how the tokens API calls are authenticated with are issued: who issues them and for whom, how
+// long access and refresh tokens last, and how often the key signing them rotates. Exempt lists the paths, comma
+// separated, served without a token. KeyRateLimit is how many calls an API key makes a minute, unless the key says.
+type TokensConfig struct {
+	Issuer       string        `json:"issuer"`
+	Audience     string        `json:"audience"`
+	AccessTTL    time.Duration `json:"access_ttl"`
+	RefreshTTL   time.Duration `json:"refresh_ttl"`
+	KeyRotation  time.Duration `json:"key_rotation"`
+	Exempt       string        `json:"exempt"`
+	KeyRateLimit int           `json:"key_rate_limit"`
+}
+
+// ExemptPaths returns the paths served without a token
+func (t *TokensConfig) ExemptPaths() []string {
+	return list(t.Exempt)
+}
+
+// list splits a comma separated list, leaving out blank entries
+func list(s string) []string {
+	var entries []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// networks parses a comma separated list of IPs and CIDRs
+func networks(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, e := range list(s) {
+		if ip := net.ParseIP(e); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an IP nor a CIDR", e)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// StorageConfig holds the configuration of the blob store generated codes are saved to
+type StorageConfig struct {
+	Kind    string `json:"kind"`
+	Dir     string `json:"dir"`
+	Bucket  string `json:"bucket"`
+	Profile string `json:"profile"`
+	Region  string `json:"region"`
+}
+
 type EnvBuffer []byte
 
 var env Config
@@ -41,9 +167,50 @@ func NewEnvConfigWithAll(llevel string) *Config {
 	}
 }
 
+// ConstructDBHost returns where the enabled DB is reached: the host of mongo or postgres, the file of sqlite, or
+// the snapshot file of the memory DB. Sqlite defaults to a file in the working directory.
+func (e *Config) ConstructDBHost() string {
+	switch e.EnabledDB {
+	case DBMemory:
+		return e.DBSnapshot
+	case DBSQLite:
+		if e.DBHost == "" || e.DBHost == DefaultFlagDBHost {
+			return DefaultSQLiteFile
+		}
+	}
+	return e.DBHost
+}
+
 func (e *Config) ConstructPort() string {
 	if e.Port == "" {
 		e.Port = "8080"
 	}
 	return ":" + e.Port
 }
+
+// AdminEmails returns the emails of the users granted the Administrator role on startup
+func (e *Config) AdminEmails() []string {
+	return list(e.Admins)
+}
+
+// ProxyNetworks returns the networks of the proxies trusted to name the clients of requests
+func (e *Config) ProxyNetworks() ([]*net.IPNet, error) {
+	return networks(e.TrustedProxies)
+}
+
+// ConstructIssuer returns the issuer of the tokens API calls are authenticated with. It defaults to the public URL.
+func (e *Config) ConstructIssuer() string {
+	if e.Tokens.Issuer == "" {
+		return e.ConstructPublicURL()
+	}
+	return e.Tokens.Issuer
+}
+
+// ConstructPublicURL returns the base URL clients reach the server on, without a trailing slash.
+// It defaults to the server port on localhost.
+func (e *Config) ConstructPublicURL() string {
+	if e.PublicURL == "" {
+		return "http://localhost" + e.ConstructPort()
+	}
+	return strings.TrimRight(e.PublicURL, "/")
+}
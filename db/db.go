@@ -3,15 +3,22 @@ package db
 import (
 	"context"
 	"errors"
+	"github.com/dark-enstein/port/db/memory"
 	"github.com/dark-enstein/port/db/mongo"
+	"github.com/dark-enstein/port/db/sqldb"
 	"github.com/dark-enstein/port/util"
 )
 
 var (
-	SupportedDBs = []string{Mongo}
+	SupportedDBs = []string{Mongo, Memory, SQLite, Postgres}
 	Mongo        = "mongo"
+	Memory       = "memory"
+	SQLite       = "sqlite"
+	Postgres     = "postgres"
 )
 
+// NewClient returns a client of the enabled DB. For mongo and postgres, host is the URI of the server; for sqlite,
+// it is the DB file; for memory, it is the file records are snapshotted to, which may be empty.
 func NewClient(ctx context.Context, enabled, host string) (DB, error) {
 	dblog := GetLoggerFromCtx(ctx).With().Str("method", "NewClient()").Logger()
 	if !util.IsIn(enabled, SupportedDBs) {
@@ -24,6 +31,10 @@ func NewClient(ctx context.Context, enabled, host string) (DB, error) {
 	case Mongo:
 		cli, err := mongo.NewMongoClient(ctx, host)
 		return cli, err
+	case Memory:
+		return memory.NewMemoryClient(ctx, host)
+	case SQLite, Postgres:
+		return sqldb.NewSQLClient(ctx, enabled, host)
 	}
 
 	return nil, nil
@@ -2,18 +2,24 @@ package db
 
 import (
 	"context"
+	"fmt"
 	"github.com/dark-enstein/port/config"
 	"github.com/dark-enstein/port/db/model"
 	"github.com/dark-enstein/port/db/mongo"
+	"github.com/dark-enstein/port/util"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/suite"
+	"os"
 	"testing"
+	"time"
 )
 
 var (
 	GlobalTestGeneration = 10
-	DefaultTestClient    = "mongo"
+	// DefaultTestClient is the DB the tests run against. Set PORT_TEST_DB=mongo to run them against a live
+	// MongoDB at mongo.LocalMongoHost instead.
+	DefaultTestClient = Memory
 )
 
 var (
@@ -50,12 +56,16 @@ func InitTestCreateUnit() TestCreateUnit {
 func (s *DBTest) SetupTest() {
 	s.log = config.NewLoggerWithDebug()
 	s.log.Info().Msg("Starting tests...")
-	s.ctx = context.Background()
+	s.ctx = context.WithValue(context.Background(), util.LoggerInContext, s.log)
 	var err error
-	s.db, err = NewClient(s.ctx, DefaultTestClient, mongo.LocalMongoHost)
+	kind, host := DefaultTestClient, ""
+	if os.Getenv("PORT_TEST_DB") == Mongo {
+		kind, host = Mongo, mongo.LocalMongoHost
+	}
+	s.db, err = NewClient(s.ctx, kind, host)
+	s.Require().NoError(err)
 	s.config.kind = s.db.Kind()
 	s.config.host = s.db.Host()
-	s.Assert().NoError(err)
 
 	s.log.Info().Msg("Tests startup complete...")
 }
@@ -66,15 +76,16 @@ func (s *DBTest) TestPermission() {
 	s.units = InitTestCreateUnit()
 	log.Debug().Msg("created test table")
 	a, b, c, d, e, f := int64(110100), int64(101001), int64(01101), int64(0101), int64(10011), int64(11111)
+	birth := "22/07/1999"
 	if len(s.units.users) == 0 {
 		s.units = TestCreateUnit{users: []model.User{
 			{
-				Name: model.Name{
+				Name: &model.Name{
 					FirstName: "ayobami",
 					LastName:  "bamigboye",
 				},
-				Birth: "22/07/1999",
-				Roles: []map[string]*int64{
+				Birth: &birth,
+				Roles: &model.RoleSet{
 					{
 						"gama":    &a,
 						"beta":    &b,
@@ -91,12 +102,63 @@ func (s *DBTest) TestPermission() {
 	}
 
 	for i := 0; i < len(s.units.users); i++ {
-		dbResp := s.db.Create(s.units.users[i], model.CreateOpts{TargetTable: "user"})
+		dbResp := s.db.Create(s.ctx, &s.units.users[i], model.NewUnitOptions(model.UserDB, s.units.targetTable))
 		s.Assert().NoError(dbResp.Err)
 		s.Assert().NotEmpty(dbResp.ID)
 	}
 }
 
+// TestCRUD tests the calls of the DB on links: bulk creation with per-item errors, paged and sorted listing,
+// versioned updates, and soft and hard deletes
+func (s *DBTest) TestCRUD() {
+	opts := model.NewUnitOptions(model.UserDB, "links_test")
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	units := make([]model.Unit, 5)
+	for i := range units {
+		units[i] = model.NewLink(fmt.Sprintf("%v-%d", suffix, i), fmt.Sprintf("%v-%d", suffix, i), fmt.Sprintf("https://example.com/%d", i), suffix)
+	}
+	dbResp := s.db.CreateAll(s.ctx, units, opts)
+	s.Require().NoError(dbResp.Err)
+	s.Assert().Equal(5, dbResp.Count)
+
+	dbResp = s.db.CreateAll(s.ctx, []model.Unit{model.NewLink(suffix+"-new", suffix+"-new", "https://example.com", suffix), units[0]}, opts)
+	s.Assert().ErrorIs(dbResp.Err, model.ErrPartialWrite)
+	s.Assert().Equal(1, dbResp.Count)
+	s.Require().Len(dbResp.Errors, 2)
+	s.Assert().NoError(dbResp.Errors[0])
+	s.Assert().Error(dbResp.Errors[1])
+
+	filter := model.Filter{"token_hash": suffix}
+	var links []*model.Link
+	dbResp = s.db.List(s.ctx, filter, &links, &model.ListOpts{Sort: []model.Sort{{Field: "target", Desc: true}}, Offset: 1, Limit: 2}, opts)
+	s.Require().NoError(dbResp.Err)
+	s.Require().Equal(2, dbResp.Count)
+	s.Assert().Equal("https://example.com/3", links[0].Target)
+	s.Assert().Equal("https://example.com/2", links[1].Target)
+
+	id := units[0].(*model.Link).ID
+	dbResp = s.db.Update(s.ctx, model.Filter{"_id": id, model.VersionField: 0}, model.Fields{"target": "https://example.com/v1"}, opts)
+	s.Assert().NoError(dbResp.Err)
+	dbResp = s.db.Update(s.ctx, model.Filter{"_id": id, model.VersionField: 0}, model.Fields{"target": "https://example.com/stale"}, opts)
+	s.Assert().ErrorIs(dbResp.Err, model.ErrConflict)
+	link := &model.Link{}
+	s.Require().NoError(s.db.Read(s.ctx, model.ByID(id), link, opts).Err)
+	s.Assert().Equal("https://example.com/v1", link.Target)
+	s.Assert().EqualValues(1, link.Version)
+
+	s.Assert().ErrorIs(s.db.Delete(s.ctx, model.Filter{}, model.HardDelete, opts).Err, model.ErrEmptyFilter)
+	dbResp = s.db.Delete(s.ctx, model.ByID(id), model.SoftDelete, opts)
+	s.Assert().NoError(dbResp.Err)
+	s.Assert().Equal(1, dbResp.Count)
+	s.Assert().ErrorIs(s.db.Read(s.ctx, model.ByID(id), link, opts).Err, model.ErrNotFound)
+
+	// soft deleted records still go with hard deletes
+	dbResp = s.db.Delete(s.ctx, filter, model.HardDelete, opts)
+	s.Assert().NoError(dbResp.Err)
+	s.Assert().Equal(6, dbResp.Count)
+	s.Assert().ErrorIs(s.db.Delete(s.ctx, model.ByID(id), model.SoftDelete, opts).Err, model.ErrNotFound)
+}
+
 // TestPingDB tests the connection integrity to the database by pinging
 func (s *DBTest) TestPingDB() {
 	log := s.log
@@ -0,0 +1,611 @@
+// Package memory is a db.DB holding its records in memory, for tests, and for running port on a single node without
+// a database server. Records are kept as bson documents, so they decode into units exactly like they do from mongo.
+// The records can be snapshotted to a file when the DB is closed, and loaded back from it when it is created.
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/util"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	Memory = "memory"
+
+	// ErrUnsupportedFilter is the response to filters using query operators. The memory DB only matches on values.
+	ErrUnsupportedFilter = errors.New("memory db only supports filtering on values")
+)
+
+// collection holds the documents of a collection by id, in the order they were created
+type collection struct {
+	docs  map[interface{}]bson.M
+	order []interface{}
+}
+
+func newCollection() *collection {
+	return &collection{docs: map[interface{}]bson.M{}}
+}
+
+func (c *collection) insert(doc bson.M) error {
+	if _, ok := c.docs[doc["_id"]]; ok {
+		return fmt.Errorf("%w: %v", model.ErrDuplicate, doc["_id"])
+	}
+	c.docs[doc["_id"]] = doc
+	c.order = append(c.order, doc["_id"])
+	return nil
+}
+
+func (c *collection) remove(id interface{}) {
+	delete(c.docs, id)
+	for i, o := range c.order {
+		if o == id {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			return
+		}
+	}
+}
+
+// find returns the documents matching the filter, in the order they were created
+func (c *collection) find(filter bson.M, live bool) []bson.M {
+	var found []bson.M
+	for _, id := range c.order {
+		if doc := c.docs[id]; match(doc, filter, live) {
+			found = append(found, doc)
+		}
+	}
+	return found
+}
+
+// MemoryClient is the memory db.DB. A single lock guards all of its records, so every call is atomic, and
+// conditional updates can't race each other.
+type MemoryClient struct {
+	sync.RWMutex
+	databases map[string]map[string]*collection
+	path      string
+}
+
+// NewMemoryClient returns an empty memory DB, or one holding the records snapshotted to path, if the file exists.
+// An empty path leaves the records in memory only.
+func NewMemoryClient(ctx context.Context, path string) (*MemoryClient, error) {
+	m := &MemoryClient{databases: map[string]map[string]*collection{}, path: path}
+	if path == "" {
+		return m, nil
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	logger(ctx, "NewMemoryClient()").Info().Msgf("loaded snapshot %v", path)
+	return m, nil
+}
+
+// Create creates the unit argument. Units without an id get an ObjectID, like they do in mongo.
+func (m *MemoryClient) Create(ctx context.Context, unit model.Unit, opts model.Opts) *model.DBResponse {
+	doc, err := document(unit)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	m.Lock()
+	defer m.Unlock()
+	if err := m.collection(opts).insert(doc); err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	logger(ctx, "Create()").Debug().Msgf("created %v record with ID: %v", unit.Kind(), id(doc))
+	return &model.DBResponse{ID: id(doc)}
+}
+
+// CreateAll creates all the unit arguments at once. A failing unit doesn't stop the others.
+func (m *MemoryClient) CreateAll(ctx context.Context, units []model.Unit, opts model.Opts) *model.DBResponse {
+	if len(units) == 0 {
+		return &model.DBResponse{}
+	}
+	errs := make([]error, len(units))
+	failed := 0
+	m.Lock()
+	defer m.Unlock()
+	c := m.collection(opts)
+	for i, unit := range units {
+		doc, err := document(unit)
+		if err == nil {
+			err = c.insert(doc)
+		}
+		if err != nil {
+			errs[i] = err
+			failed++
+		}
+	}
+	if failed > 0 {
+		logger(ctx, "CreateAll()").Info().Msgf("creating %d of %d %v records failed", failed, len(units), units[0].Kind())
+		return &model.DBResponse{Err: fmt.Errorf("%w: %d of %d", model.ErrPartialWrite, failed, len(units)), Count: len(units) - failed, Errors: errs}
+	}
+	return &model.DBResponse{Count: len(units)}
+}
+
+// Read decodes the first record matching the filter into the unit argument
+func (m *MemoryClient) Read(ctx context.Context, filter model.Filter, unit model.Unit, opts model.Opts) *model.DBResponse {
+	query, err := normalize(filter)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	m.RLock()
+	defer m.RUnlock()
+	found := m.collection(opts).find(query, true)
+	if len(found) == 0 {
+		return &model.DBResponse{Err: model.ErrNotFound}
+	}
+	if err := decode(found[0], unit); err != nil {
+		logger(ctx, "Read()").Info().Msgf("decoding %v record failed with: %v", unit.Kind(), err)
+		return &model.DBResponse{Err: err}
+	}
+	return &model.DBResponse{ID: id(found[0])}
+}
+
+// Update sets the fields on the first record matching the filter, and increments its version
+func (m *MemoryClient) Update(ctx context.Context, filter model.Filter, fields model.Fields, opts model.Opts) *model.DBResponse {
+	query, err := normalize(filter)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	set, err := normalize(model.Filter(fields))
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	m.Lock()
+	defer m.Unlock()
+	c := m.collection(opts)
+	found := c.find(query, true)
+	if len(found) == 0 {
+		if _, versioned := query[model.VersionField]; versioned {
+			delete(query, model.VersionField)
+			if len(c.find(query, true)) > 0 {
+				ref, _ := filter["_id"].(string)
+				return &model.DBResponse{ID: ref, Err: model.ErrConflict}
+			}
+		}
+		return &model.DBResponse{Err: model.ErrNotFound}
+	}
+	doc := found[0]
+	for k, v := range set {
+		if k != model.VersionField && k != "_id" {
+			doc[k] = v
+		}
+	}
+	bump(doc)
+	return &model.DBResponse{ID: id(doc)}
+}
+
+// List decodes the records matching the filter into the slice units points at
+func (m *MemoryClient) List(ctx context.Context, filter model.Filter, units interface{}, list *model.ListOpts, opts model.Opts) *model.DBResponse {
+	query, err := normalize(filter)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	slice := reflect.ValueOf(units)
+	if slice.Kind() != reflect.Pointer || slice.Elem().Kind() != reflect.Slice {
+		return &model.DBResponse{Err: fmt.Errorf("units must be a pointer to a slice, not a %T", units)}
+	}
+	m.RLock()
+	defer m.RUnlock()
+	found := m.collection(opts).find(query, true)
+	if list != nil {
+		sort.SliceStable(found, func(i, j int) bool {
+			for _, s := range list.Sort {
+				if c := compare(found[i][s.Field], found[j][s.Field]); c != 0 {
+					return (c < 0) != s.Desc
+				}
+			}
+			return false
+		})
+		if list.Offset < len(found) {
+			found = found[list.Offset:]
+		} else {
+			found = nil
+		}
+		if list.Limit > 0 && list.Limit < len(found) {
+			found = found[:list.Limit]
+		}
+	}
+
+	elemType := slice.Elem().Type().Elem()
+	decoded := reflect.MakeSlice(slice.Elem().Type(), 0, len(found))
+	for _, doc := range found {
+		elem := reflect.New(elemType)
+		if elemType.Kind() == reflect.Pointer {
+			elem.Elem().Set(reflect.New(elemType.Elem()))
+			if err := decode(doc, elem.Elem().Interface()); err != nil {
+				return &model.DBResponse{Err: err}
+			}
+		} else if err := decode(doc, elem.Interface()); err != nil {
+			return &model.DBResponse{Err: err}
+		}
+		decoded = reflect.Append(decoded, elem.Elem())
+	}
+	slice.Elem().Set(decoded)
+	return &model.DBResponse{Count: len(found)}
+}
+
+// Delete soft deletes every record matching the filter by marking it, or removes it for good
+func (m *MemoryClient) Delete(ctx context.Context, filter model.Filter, mode model.DeleteMode, opts model.Opts) *model.DBResponse {
+	if len(filter) == 0 {
+		return &model.DBResponse{Err: model.ErrEmptyFilter}
+	}
+	query, err := normalize(filter)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	m.Lock()
+	defer m.Unlock()
+	c := m.collection(opts)
+	found := c.find(query, mode != model.HardDelete)
+	if len(found) == 0 {
+		return &model.DBResponse{Err: model.ErrNotFound}
+	}
+	now := primitive.NewDateTimeFromTime(time.Now().UTC())
+	for _, doc := range found {
+		if mode == model.HardDelete {
+			c.remove(doc["_id"])
+			continue
+		}
+		doc[model.DeletedField] = now
+		bump(doc)
+	}
+	logger(ctx, "Delete()").Debug().Msgf("deleted %d records", len(found))
+	return &model.DBResponse{ID: id(found[0]), Count: len(found)}
+}
+
+func (m *MemoryClient) Ping() bool {
+	return true
+}
+
+func (m *MemoryClient) Kind() string {
+	return Memory
+}
+
+// Host returns the file the records are snapshotted to
+func (m *MemoryClient) Host() string {
+	return m.path
+}
+
+// EnsureDBScaffold ensures the DB can hold records. Collections are created on their first use, so there is never
+// anything missing to create.
+func (m *MemoryClient) EnsureDBScaffold(ctx context.Context, override bool) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.databases == nil {
+		m.databases = map[string]map[string]*collection{}
+	}
+	return nil
+}
+
+// Close snapshots the records to the file the DB was created with, if any
+func (m *MemoryClient) Close(ctx context.Context) error {
+	if m.path == "" {
+		return nil
+	}
+	if err := m.Snapshot(); err != nil {
+		return err
+	}
+	logger(ctx, "Close()").Info().Msgf("snapshotted records to %v", m.path)
+	return nil
+}
+
+// collection returns the collection the opts point at, creating it on first use. The caller must hold the lock.
+func (m *MemoryClient) collection(opts model.Opts) *collection {
+	db, ok := m.databases[opts.RetrieveDatabase()]
+	if !ok {
+		db = map[string]*collection{}
+		m.databases[opts.RetrieveDatabase()] = db
+	}
+	c, ok := db[opts.RetrieveCollection()]
+	if !ok {
+		c = newCollection()
+		db[opts.RetrieveCollection()] = c
+	}
+	return c
+}
+
+// logger returns the *zerolog.Logger stored in the request context
+func logger(ctx context.Context, caller string) *zerolog.Logger {
+	log := ctx.Value(util.LoggerInContext).(*zerolog.Logger).With().Str("method", caller).Logger()
+	return &log
+}
+
+// document encodes a unit into the document it is stored as
+func document(unit model.Unit) (bson.M, error) {
+	raw, err := bson.Marshal(unit)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if v, ok := doc["_id"]; !ok || v == nil || v == "" {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	return doc, nil
+}
+
+func decode(doc bson.M, unit interface{}) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, unit)
+}
+
+// id returns the id of a document the way mongo reports it
+func id(doc bson.M) string {
+	if oid, ok := doc["_id"].(primitive.ObjectID); ok {
+		return oid.Hex()
+	}
+	return fmt.Sprint(doc["_id"])
+}
+
+// normalize round trips a filter through bson, so its values compare with the values of documents
+func normalize(filter model.Filter) (bson.M, error) {
+	query := bson.M{}
+	if len(filter) == 0 {
+		return query, nil
+	}
+	raw, err := bson.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := bson.Unmarshal(raw, &query); err != nil {
+		return nil, err
+	}
+	for k, v := range query {
+		if _, ok := bounds(v); !ok && operator(v) {
+			return nil, fmt.Errorf("%w: field %v", ErrUnsupportedFilter, k)
+		}
+	}
+	return query, nil
+}
+
+// operator checks if a filter value is a query operator, like {"$in": [...]}
+func operator(v interface{}) bool {
+	switch d := v.(type) {
+	case bson.M:
+		for k := range d {
+			if strings.HasPrefix(k, "$") {
+				return true
+			}
+		}
+	case bson.D:
+		for _, e := range d {
+			if strings.HasPrefix(e.Key, "$") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bounds returns the bounds of a filter value built by model.Between
+func bounds(v interface{}) (bson.M, bool) {
+	d, ok := v.(bson.M)
+	if !ok || len(d) == 0 {
+		return nil, false
+	}
+	for k := range d {
+		if k != model.OpFrom && k != model.OpBefore {
+			return nil, false
+		}
+	}
+	return d, true
+}
+
+func isBounds(v interface{}) bool {
+	_, ok := bounds(v)
+	return ok
+}
+
+// within checks if a document value falls within bounds. Unset values fall within none.
+func within(got interface{}, b bson.M) bool {
+	if got == nil {
+		return false
+	}
+	if from, ok := b[model.OpFrom]; ok && compare(got, from) < 0 {
+		return false
+	}
+	if before, ok := b[model.OpBefore]; ok && compare(got, before) >= 0 {
+		return false
+	}
+	return true
+}
+
+// holds checks if the role set of a user document holds the role
+func holds(doc bson.M, role interface{}) bool {
+	set, _ := doc["role_set"].(bson.A)
+	for _, roles := range set {
+		if m, ok := roles.(bson.M); ok {
+			if _, ok := m[fmt.Sprint(role)]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// match checks if a document matches the filter. model.RoleField matches users holding the role. A nil value
+// matches unset fields, and version 0 matches records
+// created before they were versioned. Live matches leave soft deleted documents out, unless the filter holds
+// model.DeletedField.
+func match(doc, filter bson.M, live bool) bool {
+	if _, ok := filter[model.DeletedField]; live && !ok && doc[model.DeletedField] != nil {
+		return false
+	}
+	for k, want := range filter {
+		got, ok := doc[k]
+		switch {
+		case k == model.RoleField:
+			if !holds(doc, want) {
+				return false
+			}
+		case want == nil:
+			if got != nil {
+				return false
+			}
+		case isBounds(want):
+			if !within(got, want.(bson.M)) {
+				return false
+			}
+		case !ok && k == model.VersionField:
+			if n, isNumber := number(want); !isNumber || n != 0 {
+				return false
+			}
+		case !equal(got, want):
+			return false
+		}
+	}
+	return true
+}
+
+// bump increments the version of a document
+func bump(doc bson.M) {
+	n, _ := number(doc[model.VersionField])
+	doc[model.VersionField] = int64(n) + 1
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare orders the values of documents for sorting. Unset values come first, like they do in mongo.
+func compare(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y)
+		}
+	case primitive.DateTime:
+		if y, ok := b.(primitive.DateTime); ok {
+			return compare(int64(x), int64(y))
+		}
+	case bool:
+		if y, ok := b.(bool); ok && x != y {
+			if x {
+				return 1
+			}
+			return -1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// snapshot is what the records are snapshotted as: their documents by collection by database, in creation order
+type snapshot struct {
+	Databases map[string]map[string][]bson.M `bson:"databases"`
+}
+
+// Snapshot writes every record to the file the DB was created with, as canonical extended JSON. The file is
+// replaced atomically, so a crash mid-snapshot leaves the last snapshot intact.
+func (m *MemoryClient) Snapshot() error {
+	m.RLock()
+	snap := snapshot{Databases: map[string]map[string][]bson.M{}}
+	for name, db := range m.databases {
+		snap.Databases[name] = map[string][]bson.M{}
+		for cname, c := range db {
+			docs := make([]bson.M, 0, len(c.order))
+			for _, id := range c.order {
+				docs = append(docs, c.docs[id])
+			}
+			snap.Databases[name][cname] = docs
+		}
+	}
+	data, err := bson.MarshalExtJSON(snap, true, false)
+	m.RUnlock()
+	if err != nil {
+		return fmt.Errorf("encoding snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*")
+	if err != nil {
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
+
+// load reads the records back from the snapshot file. A missing file is an empty snapshot.
+func (m *MemoryClient) load() error {
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+	var snap snapshot
+	if err := bson.UnmarshalExtJSON(data, true, &snap); err != nil {
+		return fmt.Errorf("decoding snapshot %v: %w", m.path, err)
+	}
+	for name, db := range snap.Databases {
+		m.databases[name] = map[string]*collection{}
+		for cname, docs := range db {
+			c := newCollection()
+			for _, doc := range docs {
+				if err := c.insert(doc); err != nil {
+					return fmt.Errorf("decoding snapshot %v: %w", m.path, err)
+				}
+			}
+			m.databases[name][cname] = c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/util"
+	"github.com/stretchr/testify/suite"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type MemoryTest struct {
+	ctx  context.Context
+	opts *model.UnitOptions
+	suite.Suite
+}
+
+func (s *MemoryTest) SetupTest() {
+	s.ctx = context.WithValue(context.Background(), util.LoggerInContext, config.NewLoggerWithWarn())
+	s.opts = model.NewUnitOptions("port", model.TicketCollection)
+}
+
+// TestConcurrentUpdates tests that of many conditional updates racing for the same record, exactly one wins
+func (s *MemoryTest) TestConcurrentUpdates() {
+	m, err := NewMemoryClient(s.ctx, "")
+	s.Require().NoError(err)
+	t := model.NewTicket("t1", "u1", "gophercon", "", time.Now(), time.Now().Add(time.Hour))
+	s.Require().NoError(m.Create(s.ctx, t, s.opts).Err)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	won, lost := 0, 0
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(device int) {
+			defer wg.Done()
+			dbResp := m.Update(s.ctx, model.Filter{"_id": "t1", "redeemed_at": nil}, model.Fields{"redeemed_at": time.Now(), "redeemed_by": device}, s.opts)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case dbResp.Err == nil:
+				won++
+			case errors.Is(dbResp.Err, model.ErrNotFound):
+				lost++
+			}
+		}(i)
+	}
+	wg.Wait()
+	s.Assert().Equal(1, won)
+	s.Assert().Equal(31, lost)
+}
+
+// TestSnapshot tests that records, soft deleted ones included, survive a snapshot and load
+func (s *MemoryTest) TestSnapshot() {
+	path := filepath.Join(s.T().TempDir(), "port.snapshot")
+	m, err := NewMemoryClient(s.ctx, path)
+	s.Require().NoError(err)
+	created := time.Date(2026, 6, 1, 18, 0, 0, 0, time.UTC)
+	for _, id := range []string{"t1", "t2"} {
+		s.Require().NoError(m.Create(s.ctx, model.NewTicket(id, "u1", "gophercon", "B-12", created, created.Add(time.Hour)), s.opts).Err)
+	}
+	s.Require().NoError(m.Delete(s.ctx, model.ByID("t2"), model.SoftDelete, s.opts).Err)
+	s.Require().NoError(m.Close(s.ctx))
+
+	loaded, err := NewMemoryClient(s.ctx, path)
+	s.Require().NoError(err)
+	t := &model.Ticket{}
+	s.Require().NoError(loaded.Read(s.ctx, model.ByID("t1"), t, s.opts).Err)
+	s.Assert().Equal("B-12", t.Seat)
+	s.Assert().True(created.Equal(t.ValidFrom))
+	s.Assert().ErrorIs(loaded.Read(s.ctx, model.ByID("t2"), t, s.opts).Err, model.ErrNotFound)
+	s.Assert().Equal(2, loaded.Delete(s.ctx, model.Filter{"event": "gophercon"}, model.HardDelete, s.opts).Count)
+}
+
+// TestFilters tests that filters holding query operators are refused, rather than silently matching nothing, and
+// that model.RoleField selects users by role
+func (s *MemoryTest) TestFilters() {
+	m, _ := NewMemoryClient(s.ctx, "")
+	var tickets []*model.Ticket
+	dbResp := m.List(s.ctx, model.Filter{"seat": map[string]interface{}{"$in": []string{"A-1"}}}, &tickets, nil, s.opts)
+	s.Assert().ErrorIs(dbResp.Err, ErrUnsupportedFilter)
+
+	users := model.NewUnitOptions(model.UserDB, model.UserCollection)
+	admin := int64(7)
+	s.Require().NoError(m.Create(s.ctx, &model.User{ID: "u1", Roles: &model.RoleSet{{"admin": &admin}}}, users).Err)
+	s.Require().NoError(m.Create(s.ctx, &model.User{ID: "u2", Roles: &model.RoleSet{{}}}, users).Err)
+	var found []*model.User
+	s.Require().NoError(m.List(s.ctx, model.Filter{model.RoleField: "admin"}, &found, nil, users).Err)
+	s.Require().Len(found, 1)
+	s.Assert().Equal("u1", found[0].ID)
+}
+
+// TestRanges tests that model.Between selects the records within its bounds, leaving unset bounds open
+func (s *MemoryTest) TestRanges() {
+	m, _ := NewMemoryClient(s.ctx, "")
+	opts := model.NewUnitOptions("port", model.ScanCollection)
+	start := time.Date(2026, 6, 1, 18, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		scan := model.NewScan(fmt.Sprintf("s%d", i), "l1", "mobile", "", "hash")
+		scan.At = start.Add(time.Duration(i) * time.Hour)
+		s.Require().NoError(m.Create(s.ctx, scan, opts).Err)
+	}
+	var scans []*model.Scan
+	s.Require().NoError(m.List(s.ctx, model.Filter{"link_id": "l1", "at": model.Between(start.Add(time.Hour), start.Add(3*time.Hour))}, &scans, &model.ListOpts{Sort: []model.Sort{{Field: "at"}}}, opts).Err)
+	s.Require().Len(scans, 2)
+	s.Assert().Equal("s1", scans[0].ID)
+	s.Assert().Equal("s2", scans[1].ID)
+
+	s.Require().NoError(m.List(s.ctx, model.Filter{"at": model.Between(start.Add(2*time.Hour), nil)}, &scans, nil, opts).Err)
+	s.Assert().Len(scans, 2)
+}
+
+func TestMemoryTest(t *testing.T) {
+	suite.Run(t, new(MemoryTest))
+}
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+var (
+	UnitAPIKey       = "api_key"
+	APIKeyCollection = "api_keys"
+)
+
+// APIKey holds an API key a user or service account calls Port with, and it is ready for working with the DB. The
+// id is the public part of the key; only the hash of its secret is kept. Scopes restrict the calls the key may make
+// to a part of what its owner may do, and RateLimit bounds the calls it makes a minute, when set.
+type APIKey struct {
+	ID         string     `bson:"_id" json:"id"`
+	UserID     string     `bson:"user_id" json:"user_id"`
+	Name       string     `bson:"name" json:"name"`
+	Hash       string     `bson:"hash" json:"-"`
+	Scopes     []string   `bson:"scopes" json:"scopes"`
+	RateLimit  int        `bson:"rate_limit,omitempty" json:"rate_limit,omitempty"`
+	ExpiresAt  *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+}
+
+func (k *APIKey) GetTime() time.Time {
+	return k.CreatedAt
+}
+
+func (k *APIKey) Kind() string {
+	return UnitAPIKey
+}
@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+var (
+	UnitAudit       = "audit"
+	AuditCollection = "audit_log"
+)
+
+// AuditEntry records a change made to who may do what on Port, and it is ready for working with the DB. Actor is
+// the id of the user who made the change, Action what the change was, like "role.grant", and Target what it was
+// made to, like "user:<id>" or "role:<name>". Detail holds what changed.
+type AuditEntry struct {
+	ID     string                 `bson:"_id"`
+	At     time.Time              `bson:"at"`
+	Actor  string                 `bson:"actor"`
+	Action string                 `bson:"action"`
+	Target string                 `bson:"target"`
+	Detail map[string]interface{} `bson:"detail,omitempty"`
+}
+
+func (a *AuditEntry) GetTime() time.Time {
+	return a.At
+}
+
+func (a *AuditEntry) Kind() string {
+	return UnitAudit
+}
@@ -1,19 +1,110 @@
 package model
 
-import "github.com/dark-enstein/port/util"
+import (
+	"errors"
+	"github.com/dark-enstein/port/util"
+)
 
 var (
 	Tables         = map[string]string{}
 	UserDB         = "users"
-	UserCollection = "user-info"
+	UserCollection = "users"
 )
 
 type DBResponse struct {
-	Err      error
-	ID       string
+	Err   error
+	ID    string
+	Count int
+	// Errors holds the outcome of every unit of a bulk call, in the order the units were passed in. It is only
+	// set when some of them failed, and holds nil for the units that succeeded.
+	Errors   []error
 	Metadata Metadata
 }
 
+var (
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicate is the response to creating a record with the id of another
+	ErrDuplicate = errors.New("record already exists")
+	// ErrConflict is the response to an update made against a version of a record that has since changed
+	ErrConflict = errors.New("record was modified concurrently")
+	// ErrPartialWrite is the response to a bulk call where some of the units failed. DBResponse.Errors says which.
+	ErrPartialWrite = errors.New("some records failed")
+	// ErrEmptyFilter is the response to a delete that would have matched every record
+	ErrEmptyFilter = errors.New("filter must not be empty")
+)
+
+const (
+	// VersionField is the field records are versioned by. Every update increments it, and an update whose filter
+	// holds it only applies to that version of the record, responding with ErrConflict otherwise.
+	VersionField = "version"
+	// DeletedField is the field soft deletes mark records with. Calls leave soft deleted records out, unless
+	// their filter holds it.
+	DeletedField = "deleted_at"
+)
+
+// Filter selects the records a DB call operates on by their field values. Keys are the bson names of the fields.
+// A nil value selects the records where the field is unset.
+type Filter map[string]interface{}
+
+// ByID returns a Filter selecting the record with the id passed in
+func ByID(id string) Filter {
+	return Filter{"_id": id}
+}
+
+const (
+	// OpFrom and OpBefore bound the values a field of a Filter selects. Between builds them.
+	OpFrom   = "$gte"
+	OpBefore = "$lt"
+)
+
+// Between returns the value of a Filter field selecting the records where the field is at least from, and before to.
+// A nil bound leaves that side open.
+func Between(from, to interface{}) map[string]interface{} {
+	bounds := map[string]interface{}{}
+	if from != nil {
+		bounds[OpFrom] = from
+	}
+	if to != nil {
+		bounds[OpBefore] = to
+	}
+	return bounds
+}
+
+// Fields holds the field values an update sets on a record. Keys are the bson names of the fields.
+type Fields map[string]interface{}
+
+// Sort orders listed records by a field
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// ListOpts pages through, and orders, the records a List call decodes. A zero Limit decodes every record from
+// Offset on.
+type ListOpts struct {
+	Sort   []Sort
+	Offset int
+	Limit  int
+}
+
+// DeleteMode picks how a Delete call deletes records
+type DeleteMode int
+
+const (
+	// SoftDelete marks records as deleted, keeping them around for auditing and recovery
+	SoftDelete DeleteMode = iota
+	// HardDelete removes records for good, soft deleted or not
+	HardDelete
+)
+
+// Migration is a versioned step of the schema of a DB, with the steps to apply and to revert it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
 type Metadata interface {
 	String() string
 }
@@ -46,3 +137,36 @@ type Opts interface {
 	RetrieveCollection() string
 	RetrieveOverride() bool
 }
+
+// UnitOptions holds the request options of a unit, and it is ready for working with the DB
+type UnitOptions struct {
+	Database         string
+	Collection       string
+	Table            string
+	CreateOnNotExist bool
+}
+
+func NewUnitOptions(database, collection string) *UnitOptions {
+	return &UnitOptions{Database: database, Collection: collection, Table: collection, CreateOnNotExist: true}
+}
+
+func (u *UnitOptions) IsValid() bool {
+	//TODO
+	return true
+}
+
+func (u *UnitOptions) RetrieveDatabase() string {
+	return u.Database
+}
+
+func (u *UnitOptions) RetrieveTable() string {
+	return u.Table
+}
+
+func (u *UnitOptions) RetrieveCollection() string {
+	return u.Collection
+}
+
+func (u *UnitOptions) RetrieveOverride() bool {
+	return u.CreateOnNotExist
+}
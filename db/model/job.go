@@ -0,0 +1,84 @@
+package model
+
+import (
+	"github.com/dark-enstein/port/internal/generators/qr"
+	"time"
+)
+
+var (
+	UnitJob       = "job"
+	JobCollection = "jobs"
+)
+
+// JobStatus is the state of an asynchronous generation job
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// IsDone checks if the job has reached a final status
+func (s JobStatus) IsDone() bool {
+	return s == JobSucceeded || s == JobFailed
+}
+
+// Job holds an asynchronous generation job, and it is ready for working with the DB. UserID is the subject of the
+// call that created it.
+type Job struct {
+	ID          string     `bson:"_id" json:"id"`
+	Type        string     `bson:"type" json:"type"`
+	Status      JobStatus  `bson:"status" json:"status"`
+	Spec        JobSpec    `bson:"spec" json:"spec"`
+	CallbackURL string     `bson:"callback_url,omitempty" json:"callback_url,omitempty"`
+	UserID      string     `bson:"user_id,omitempty" json:"-"`
+	Result      *JobResult `bson:"result,omitempty" json:"result,omitempty"`
+	Error       string     `bson:"error,omitempty" json:"error,omitempty"`
+	Callback    string     `bson:"callback_status,omitempty" json:"callback_status,omitempty"`
+	CreatedAt   time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time  `bson:"updated_at" json:"updated_at"`
+}
+
+// JobSpec holds everything needed to run the generation of a job again
+type JobSpec struct {
+	Content       string `bson:"content" json:"-"`
+	Size          int    `bson:"size" json:"size"`
+	RecoveryLevel string `bson:"recovery_level" json:"recovery_level"`
+	Format        string `bson:"format" json:"format"`
+	Verify        bool   `bson:"verify,omitempty" json:"verify,omitempty"`
+	// Style is persisted with the logo it references by ID, or carries inline
+	Style *qr.Style `bson:"style,omitempty" json:"-"`
+}
+
+// JobResult describes the code a job generated
+type JobResult struct {
+	URL      string `bson:"url" json:"url"`
+	Format   string `bson:"format" json:"format"`
+	Width    int    `bson:"width" json:"width"`
+	Height   int    `bson:"height" json:"height"`
+	Bytes    int    `bson:"bytes" json:"bytes"`
+	Checksum string `bson:"checksum" json:"checksum"`
+}
+
+func NewJob(id, kind string, spec JobSpec, callbackURL string) *Job {
+	now := time.Now().UTC()
+	return &Job{
+		ID:          id,
+		Type:        kind,
+		Status:      JobQueued,
+		Spec:        spec,
+		CallbackURL: callbackURL,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+func (j *Job) GetTime() time.Time {
+	return j.UpdatedAt
+}
+
+func (j *Job) Kind() string {
+	return UnitJob
+}
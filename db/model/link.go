@@ -0,0 +1,40 @@
+package model
+
+import "time"
+
+var (
+	UnitLink       = "link"
+	LinkCollection = "links"
+)
+
+// Link holds the redirect behind a dynamic QR code, and it is ready for working with the DB. The code encodes the
+// short link of its slug, so the target can change without regenerating the code.
+type Link struct {
+	ID        string    `bson:"_id" json:"id"`
+	Slug      string    `bson:"slug" json:"slug"`
+	Target    string    `bson:"target" json:"target"`
+	TokenHash string    `bson:"token_hash" json:"-"`
+	Version   int64     `bson:"version" json:"version"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+func NewLink(id, slug, target, tokenHash string) *Link {
+	now := time.Now().UTC()
+	return &Link{
+		ID:        id,
+		Slug:      slug,
+		Target:    target,
+		TokenHash: tokenHash,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func (l *Link) GetTime() time.Time {
+	return l.UpdatedAt
+}
+
+func (l *Link) Kind() string {
+	return UnitLink
+}
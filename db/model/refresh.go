@@ -0,0 +1,39 @@
+package model
+
+import "time"
+
+var (
+	UnitRefreshToken       = "refresh_token"
+	RefreshTokenCollection = "refresh_tokens"
+)
+
+// RefreshToken holds a refresh token handed out to a user at login, and it is ready for working with the DB. Only
+// the hash of the token is kept, as its id. A refresh token is exchanged once, for new tokens and the next refresh
+// token of its family; the family is every refresh token descending from the same login.
+type RefreshToken struct {
+	ID        string     `bson:"_id" json:"-"`
+	UserID    string     `bson:"user_id" json:"user_id"`
+	Family    string     `bson:"family" json:"family"`
+	ExpiresAt time.Time  `bson:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time `bson:"used_at,omitempty" json:"used_at,omitempty"`
+	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
+}
+
+func NewRefreshToken(tokenHash, userID, family string, ttl time.Duration) *RefreshToken {
+	now := time.Now().UTC()
+	return &RefreshToken{
+		ID:        tokenHash,
+		UserID:    userID,
+		Family:    family,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+}
+
+func (r *RefreshToken) GetTime() time.Time {
+	return r.CreatedAt
+}
+
+func (r *RefreshToken) Kind() string {
+	return UnitRefreshToken
+}
@@ -0,0 +1,47 @@
+package model
+
+import "time"
+
+var (
+	UnitRender       = "render"
+	RenderCollection = "renders"
+)
+
+// Render indexes a rendered code by the fingerprint of the parameters it was rendered from, so an identical request
+// can reuse the object it was uploaded as, and it is ready for working with the DB.
+type Render struct {
+	ID       string `bson:"_id" json:"id"`
+	Key      string `bson:"key" json:"key"`
+	URL      string `bson:"url" json:"url"`
+	Format   string `bson:"format" json:"format"`
+	Width    int    `bson:"width" json:"width"`
+	Height   int    `bson:"height" json:"height"`
+	Bytes    int    `bson:"bytes" json:"bytes"`
+	Checksum string `bson:"checksum" json:"checksum"`
+	// Verified is set when the code was decoded back after it was rendered
+	Verified  bool      `bson:"verified" json:"verified"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+func NewRender(fingerprint, key, url, format string, width, height, bytes int, checksum string, verified bool) *Render {
+	return &Render{
+		ID:        fingerprint,
+		Key:       key,
+		URL:       url,
+		Format:    format,
+		Width:     width,
+		Height:    height,
+		Bytes:     bytes,
+		Checksum:  checksum,
+		Verified:  verified,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+func (r *Render) GetTime() time.Time {
+	return r.CreatedAt
+}
+
+func (r *Render) Kind() string {
+	return UnitRender
+}
@@ -0,0 +1,36 @@
+package model
+
+import "time"
+
+var (
+	UnitPasswordReset       = "password_reset"
+	PasswordResetCollection = "password_resets"
+)
+
+// PasswordReset holds a password reset token handed out to a user, and it is ready for working with the DB. Only
+// the hash of the token is kept, as its id. A token resets a password once, until it expires.
+type PasswordReset struct {
+	ID        string     `bson:"_id" json:"-"`
+	UserID    string     `bson:"user_id" json:"user_id"`
+	ExpiresAt time.Time  `bson:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time `bson:"used_at,omitempty" json:"used_at,omitempty"`
+	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
+}
+
+func NewPasswordReset(tokenHash, userID string, ttl time.Duration) *PasswordReset {
+	now := time.Now().UTC()
+	return &PasswordReset{
+		ID:        tokenHash,
+		UserID:    userID,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+}
+
+func (r *PasswordReset) GetTime() time.Time {
+	return r.CreatedAt
+}
+
+func (r *PasswordReset) Kind() string {
+	return UnitPasswordReset
+}
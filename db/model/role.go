@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+var (
+	UnitRole       = "role"
+	RoleCollection = "roles"
+)
+
+// Role holds a named role, and it is ready for working with the DB. Permissions holds the 4 bit CRUD permission the
+// role grants on each resource, by resource name. Built in roles are created by port on startup.
+type Role struct {
+	ID          string            `bson:"_id"`
+	Description string            `bson:"description,omitempty"`
+	Permissions map[string]*int64 `bson:"permissions"`
+	Builtin     bool              `bson:"builtin,omitempty"`
+	Version     int64             `bson:"version"`
+	CreatedAt   time.Time         `bson:"created_at"`
+	UpdatedAt   time.Time         `bson:"updated_at"`
+}
+
+func (r *Role) GetTime() time.Time {
+	return r.CreatedAt
+}
+
+func (r *Role) Kind() string {
+	return UnitRole
+}
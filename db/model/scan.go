@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+var (
+	UnitScan       = "scan"
+	ScanCollection = "scans"
+)
+
+// Scan records a single redirect through the short link of a dynamic QR code, and it is ready for working with
+// the DB. The address of the scanner is only kept as a hash, good for counting unique scanners and nothing else.
+type Scan struct {
+	ID       string    `bson:"_id" json:"id"`
+	LinkID   string    `bson:"link_id" json:"link_id"`
+	At       time.Time `bson:"at" json:"at"`
+	Agent    string    `bson:"agent" json:"agent"`
+	Referrer string    `bson:"referrer,omitempty" json:"referrer,omitempty"`
+	IPHash   string    `bson:"ip_hash" json:"-"`
+}
+
+func NewScan(id, linkID, agent, referrer, ipHash string) *Scan {
+	return &Scan{
+		ID:       id,
+		LinkID:   linkID,
+		At:       time.Now().UTC(),
+		Agent:    agent,
+		Referrer: referrer,
+		IPHash:   ipHash,
+	}
+}
+
+func (s *Scan) GetTime() time.Time {
+	return s.At
+}
+
+func (s *Scan) Kind() string {
+	return UnitScan
+}
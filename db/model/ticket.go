@@ -0,0 +1,85 @@
+package model
+
+import "time"
+
+var (
+	UnitTicket        = "ticket"
+	TicketCollection  = "tickets"
+	UnitCheckIn       = "checkin"
+	CheckInCollection = "checkins"
+)
+
+// CheckInOutcome is what came of presenting a ticket at the door
+type CheckInOutcome string
+
+const (
+	CheckInRedeemed        CheckInOutcome = "redeemed"
+	CheckInAlreadyRedeemed CheckInOutcome = "already_redeemed"
+	CheckInRejected        CheckInOutcome = "rejected"
+)
+
+// Ticket holds a ticket to an event, issued to a user, and it is ready for working with the DB. The token the
+// ticket's code encodes is signed from these fields, and isn't kept: it can be signed again from them.
+type Ticket struct {
+	ID        string    `bson:"_id" json:"id"`
+	UserID    string    `bson:"user_id" json:"user_id"`
+	Event     string    `bson:"event" json:"event"`
+	Seat      string    `bson:"seat,omitempty" json:"seat,omitempty"`
+	ValidFrom time.Time `bson:"valid_from" json:"valid_from"`
+	ValidTo   time.Time `bson:"valid_until" json:"valid_until"`
+	KeyID     string    `bson:"kid" json:"kid"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	// RedeemedAt and RedeemedBy are set once, by the first check-in that redeems the ticket
+	RedeemedAt *time.Time `bson:"redeemed_at,omitempty" json:"redeemed_at,omitempty"`
+	RedeemedBy string     `bson:"redeemed_by,omitempty" json:"redeemed_by,omitempty"`
+}
+
+func NewTicket(id, userID, event, seat string, validFrom, validTo time.Time) *Ticket {
+	return &Ticket{
+		ID:        id,
+		UserID:    userID,
+		Event:     event,
+		Seat:      seat,
+		ValidFrom: validFrom.UTC().Truncate(time.Second),
+		ValidTo:   validTo.UTC().Truncate(time.Second),
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+func (t *Ticket) GetTime() time.Time {
+	return t.CreatedAt
+}
+
+func (t *Ticket) Kind() string {
+	return UnitTicket
+}
+
+// CheckIn records a ticket being presented to a scanner at the door, whatever came of it, and it is ready for
+// working with the DB
+type CheckIn struct {
+	ID       string         `bson:"_id" json:"id"`
+	TicketID string         `bson:"ticket_id" json:"ticket_id"`
+	DeviceID string         `bson:"device_id" json:"device_id"`
+	Outcome  CheckInOutcome `bson:"outcome" json:"outcome"`
+	Reason   string         `bson:"reason,omitempty" json:"reason,omitempty"`
+	At       time.Time      `bson:"at" json:"at"`
+}
+
+func NewCheckIn(id, ticketID, deviceID string, outcome CheckInOutcome, reason string) *CheckIn {
+	return &CheckIn{
+		ID:       id,
+		TicketID: ticketID,
+		DeviceID: deviceID,
+		Outcome:  outcome,
+		Reason:   reason,
+		At:       time.Now().UTC(),
+	}
+}
+
+func (c *CheckIn) GetTime() time.Time {
+	return c.At
+}
+
+func (c *CheckIn) Kind() string {
+	return UnitCheckIn
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 )
 
@@ -11,46 +12,49 @@ var (
 	glog     = &Logger{}
 )
 
+// The fields users are filtered and updated on. Birth dates have always been kept under "name".
+var (
+	FirstNameField = "firstname"
+	LastNameField  = "lastname"
+	BirthField     = "name"
+	EmailField     = "email"
+	// RoleField isn't a field of users: a filter on it selects the users holding the role in their role set
+	RoleField = "role"
+)
+
 // User holds the user information, and it is ready for working with the DB
 type User struct {
+	ID    string   `bson:"_id,omitempty"`
 	Name  *Name    `bson:"name,inline"`
 	Birth *string  `bson:"name"`
 	Roles *RoleSet `bson:"role_set,omitempty"`
+	// Email is how the user logs in. Users registered before there were logins have none.
+	Email        *string `bson:"email,omitempty"`
+	PasswordHash string  `bson:"password_hash,omitempty"`
+	// FailedLogins counts the logins failed in a row. Too many lock the user out until LockedUntil.
+	FailedLogins int        `bson:"failed_logins,omitempty"`
+	LockedUntil  *time.Time `bson:"locked_until,omitempty"`
+	// TOTPSecret is the secret of the authenticator app of the user, enrolled but not confirmed until TOTPEnabled.
+	// TOTPStep is the step of the last code accepted, so codes aren't used twice, and RecoveryCodes hold the hashes
+	// of the recovery codes left.
+	TOTPSecret    string   `bson:"totp_secret,omitempty"`
+	TOTPEnabled   bool     `bson:"totp_enabled,omitempty"`
+	TOTPStep      int64    `bson:"totp_step,omitempty"`
+	RecoveryCodes []string `bson:"recovery_codes,omitempty"`
+	// Service marks service accounts, which call Port with API keys only
+	Service   bool      `bson:"service,omitempty"`
+	Version   int64     `bson:"version"`
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
 }
 
 // UserOptions holds the user request options, and it is ready for working with the DB
-type UserOptions struct {
-	Database         string
-	Collection       string
-	Table            string
-	CreateOnNotExist bool
-}
+type UserOptions = UnitOptions
 
 func NewUserOptions() *UserOptions {
 	return &UserOptions{}
 }
 
-func (u *UserOptions) IsValid() bool {
-	//TODO
-	return true
-}
-
-func (u *UserOptions) RetrieveDatabase() string {
-	return u.Database
-}
-
-func (u *UserOptions) RetrieveTable() string {
-	return u.Table
-}
-
-func (u *UserOptions) RetrieveCollection() string {
-	return u.Collection
-}
-
-func (u *UserOptions) RetrieveOverride() bool {
-	return u.CreateOnNotExist
-}
-
 func NewUser(ctx context.Context) *User {
 	glog = RetrieveLoggerFromCtx(ctx)
 	return &User{}
@@ -95,6 +99,10 @@ func (u *User) Kind() string {
 }
 
 func (u *User) NameStr() string {
+	if u.Name.LastName == "" {
+		// service accounts have a single name
+		return u.Name.FirstName
+	}
 	return fmt.Sprintf("%v %v", u.Name.FirstName, u.Name.LastName)
 }
 
@@ -108,3 +116,20 @@ func (n *Name) GetTime() time.Time {
 }
 
 type RoleSet []map[string]*int64
+
+// Names returns the names of the roles in the set, in the order they are held
+func (rs *RoleSet) Names() []string {
+	var names []string
+	if rs == nil {
+		return names
+	}
+	for _, roles := range *rs {
+		held := make([]string, 0, len(roles))
+		for name := range roles {
+			held = append(held, name)
+		}
+		sort.Strings(held)
+		names = append(names, held...)
+	}
+	return names
+}
@@ -0,0 +1,337 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/db/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+)
+
+var (
+	// MigrationsCollection records the migrations applied to the DB, by version
+	MigrationsCollection = "_migrations"
+	// MigrationsDatabase is the database the migrations are recorded in, and the one every unit is kept in
+	MigrationsDatabase = config.DefaultDBName
+	// TicketRetention is how long tickets are kept after they expire. Their check-ins are kept for good.
+	TicketRetention = 30 * 24 * time.Hour
+	// ResetRetention is how long password reset tokens are kept after they expire
+	ResetRetention = 24 * time.Hour
+	// RefreshRetention is how long refresh tokens are kept after they expire, so reuse of them is still caught
+	RefreshRetention = 24 * time.Hour
+)
+
+// index is an index a migration creates, and drops when it is reverted
+type index struct {
+	collection string
+	name       string
+	keys       bson.D
+	unique     bool
+	// ttl, when set, makes the index expire documents that long after the time in its only key
+	ttl *time.Duration
+	// partial limits the index to the documents matching the filter
+	partial bson.M
+}
+
+func (i index) String() string {
+	keys := make([]string, len(i.keys))
+	for n, k := range i.keys {
+		keys[n] = fmt.Sprintf("%v: %v", k.Key, k.Value)
+	}
+	desc := fmt.Sprintf("index %v on %v {%v}", i.name, i.collection, strings.Join(keys, ", "))
+	if i.unique {
+		desc += ", unique"
+	}
+	if i.ttl != nil {
+		desc += fmt.Sprintf(", expiring after %v", *i.ttl)
+	}
+	if i.partial != nil {
+		desc += fmt.Sprintf(", where %v", i.partial)
+	}
+	return desc
+}
+
+// validator is a JSON schema a migration makes a collection validate its documents against. Documents already in
+// the collection that don't validate can still be updated, but not made any less valid.
+type validator struct {
+	collection string
+	schema     bson.M
+}
+
+func (v validator) String() string {
+	required, _ := v.schema["required"].(bson.A)
+	return fmt.Sprintf("validator on %v, requiring %v", v.collection, required)
+}
+
+// migration is a versioned step of the mongo schema: the indexes and validators of its collections
+type migration struct {
+	version    int
+	name       string
+	indexes    []index
+	validators []validator
+}
+
+// Migration describes the migration, with the operations of its steps in place of statements
+func (m migration) Migration() model.Migration {
+	var up, down []string
+	for _, i := range m.indexes {
+		up = append(up, "create "+i.String())
+		down = append(down, "drop "+i.String())
+	}
+	for _, v := range m.validators {
+		up = append(up, "apply "+v.String())
+		down = append(down, "remove "+v.String())
+	}
+	return model.Migration{Version: m.version, Name: m.name, Up: strings.Join(up, "\n"), Down: strings.Join(down, "\n")}
+}
+
+func (m migration) up(ctx context.Context, db *mongo.Database) error {
+	for _, i := range m.indexes {
+		opts := options.Index().SetName(i.name).SetUnique(i.unique)
+		if i.ttl != nil {
+			opts.SetExpireAfterSeconds(int32(i.ttl.Seconds()))
+		}
+		if i.partial != nil {
+			opts.SetPartialFilterExpression(i.partial)
+		}
+		if _, err := db.Collection(i.collection).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: i.keys, Options: opts}); err != nil {
+			return fmt.Errorf("creating %v: %w", i, err)
+		}
+	}
+	for _, v := range m.validators {
+		if err := setValidator(ctx, db, v.collection, bson.M{"$jsonSchema": v.schema}); err != nil {
+			return fmt.Errorf("applying %v: %w", v, err)
+		}
+	}
+	return nil
+}
+
+func (m migration) down(ctx context.Context, db *mongo.Database) error {
+	for _, v := range m.validators {
+		if err := setValidator(ctx, db, v.collection, bson.M{}); err != nil {
+			return fmt.Errorf("removing %v: %w", v, err)
+		}
+	}
+	for _, i := range m.indexes {
+		_, err := db.Collection(i.collection).Indexes().DropOne(ctx, i.name)
+		if err != nil && !missing(err) {
+			return fmt.Errorf("dropping %v: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// setValidator sets the validator of a collection, creating the collection if it doesn't exist yet
+func setValidator(ctx context.Context, db *mongo.Database, collection string, validator bson.M) error {
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": collection})
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return db.CreateCollection(ctx, collection, options.CreateCollection().SetValidator(validator).SetValidationLevel("moderate"))
+	}
+	return db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: collection},
+		{Key: "validator", Value: validator},
+		{Key: "validationLevel", Value: "moderate"},
+	}).Err()
+}
+
+// missing checks if an error is mongo reporting that what was to be dropped doesn't exist
+func missing(err error) bool {
+	var cmdErr mongo.CommandError
+	// 26 is NamespaceNotFound, 27 is IndexNotFound
+	return errors.As(err, &cmdErr) && (cmdErr.Code == 26 || cmdErr.Code == 27)
+}
+
+func ttl(d time.Duration) *time.Duration {
+	return &d
+}
+
+// migrations are the migrations of the mongo schema, ordered by version. New ones go at the end; applied ones
+// never change.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "indexes",
+		indexes: []index{
+			{collection: model.LinkCollection, name: "slug_unique", keys: bson.D{{Key: "slug", Value: 1}}, unique: true},
+			{collection: model.ScanCollection, name: "link_id_at", keys: bson.D{{Key: "link_id", Value: 1}, {Key: "at", Value: 1}}},
+			{collection: model.JobCollection, name: "status", keys: bson.D{{Key: "status", Value: 1}}},
+			{collection: model.TicketCollection, name: "user_id", keys: bson.D{{Key: "user_id", Value: 1}}},
+			{collection: model.CheckInCollection, name: "ticket_id_at", keys: bson.D{{Key: "ticket_id", Value: 1}, {Key: "at", Value: 1}}},
+			// users without an email don't take part in its uniqueness
+			{collection: model.UserCollection, name: "email_unique", keys: bson.D{{Key: "email", Value: 1}}, unique: true,
+				partial: bson.M{"email": bson.M{"$type": "string"}}},
+		},
+	},
+	{
+		version: 2,
+		name:    "expiring_tickets",
+		indexes: []index{
+			{collection: model.TicketCollection, name: "valid_until_ttl", keys: bson.D{{Key: "valid_until", Value: 1}}, ttl: ttl(TicketRetention)},
+		},
+	},
+	{
+		version: 3,
+		name:    "validators",
+		validators: []validator{
+			{collection: model.LinkCollection, schema: bson.M{
+				"bsonType": "object",
+				"required": bson.A{"_id", "slug", "target", "token_hash", "created_at"},
+				"properties": bson.M{
+					"slug":       bson.M{"bsonType": "string", "minLength": 1},
+					"target":     bson.M{"bsonType": "string", "minLength": 1},
+					"token_hash": bson.M{"bsonType": "string"},
+					"version":    bson.M{"bsonType": bson.A{"int", "long"}, "minimum": 0},
+					"created_at": bson.M{"bsonType": "date"},
+				},
+			}},
+			{collection: model.JobCollection, schema: bson.M{
+				"bsonType": "object",
+				"required": bson.A{"_id", "type", "status", "created_at"},
+				"properties": bson.M{
+					"status": bson.M{"enum": bson.A{string(model.JobQueued), string(model.JobRunning), string(model.JobSucceeded), string(model.JobFailed)}},
+				},
+			}},
+			{collection: model.TicketCollection, schema: bson.M{
+				"bsonType": "object",
+				"required": bson.A{"_id", "event", "valid_from", "valid_until", "kid"},
+				"properties": bson.M{
+					"event":       bson.M{"bsonType": "string", "minLength": 1},
+					"valid_from":  bson.M{"bsonType": "date"},
+					"valid_until": bson.M{"bsonType": "date"},
+					"redeemed_at": bson.M{"bsonType": "date"},
+				},
+			}},
+			{collection: model.CheckInCollection, schema: bson.M{
+				"bsonType": "object",
+				"required": bson.A{"_id", "ticket_id", "device_id", "outcome", "at"},
+				"properties": bson.M{
+					"outcome": bson.M{"enum": bson.A{string(model.CheckInRedeemed), string(model.CheckInAlreadyRedeemed), string(model.CheckInRejected)}},
+					"at":      bson.M{"bsonType": "date"},
+				},
+			}},
+		},
+	},
+	{
+		version: 4,
+		name:    "password_resets",
+		indexes: []index{
+			{collection: model.PasswordResetCollection, name: "expires_at_ttl", keys: bson.D{{Key: "expires_at", Value: 1}}, ttl: ttl(ResetRetention)},
+		},
+	},
+	{
+		version: 5,
+		name:    "refresh_tokens",
+		indexes: []index{
+			{collection: model.RefreshTokenCollection, name: "family", keys: bson.D{{Key: "family", Value: 1}}},
+			{collection: model.RefreshTokenCollection, name: "expires_at_ttl", keys: bson.D{{Key: "expires_at", Value: 1}}, ttl: ttl(RefreshRetention)},
+		},
+	},
+	{
+		version: 6,
+		name:    "audit_log",
+		indexes: []index{
+			{collection: model.AuditCollection, name: "target_at", keys: bson.D{{Key: "target", Value: 1}, {Key: "at", Value: -1}}},
+			{collection: model.AuditCollection, name: "actor_at", keys: bson.D{{Key: "actor", Value: 1}, {Key: "at", Value: -1}}},
+		},
+	},
+	{
+		version: 7,
+		name:    "api_keys",
+		indexes: []index{
+			{collection: model.APIKeyCollection, name: "user_id_created_at", keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		},
+	},
+}
+
+// Migrations lists the migrations of the mongo schema, ordered by version
+func (m *MongoClient) Migrations() []model.Migration {
+	described := make([]model.Migration, len(migrations))
+	for i, mig := range migrations {
+		described[i] = mig.Migration()
+	}
+	return described
+}
+
+// SchemaVersion returns the version of the last migration applied, 0 before any
+func (m *MongoClient) SchemaVersion(ctx context.Context) (int, error) {
+	var last struct {
+		Version int `bson:"_id"`
+	}
+	err := m.migrations().FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})).Decode(&last)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return last.Version, err
+}
+
+// MigrateTo applies, or reverts, migrations one at a time until the schema is at the target version, recording
+// each in the migrations collection. Index builds are idempotent, so instances migrating at once don't conflict.
+func (m *MongoClient) MigrateTo(ctx context.Context, target int) error {
+	log := RetrieveLoggerFromCtx(ctx, "MigrateTo()")
+	current, err := m.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if target != 0 && !hasMigration(target) {
+		return fmt.Errorf("there is no migration %d", target)
+	}
+	if target >= current {
+		for _, mig := range migrations {
+			if mig.version <= current || mig.version > target {
+				continue
+			}
+			if err := mig.up(ctx, m.conn.Database(MigrationsDatabase)); err != nil {
+				return fmt.Errorf("applying migration %d_%v: %w", mig.version, mig.name, err)
+			}
+			_, err := m.migrations().InsertOne(ctx, bson.M{"_id": mig.version, "name": mig.name, "applied_at": time.Now().UTC()})
+			if err != nil && !mongo.IsDuplicateKeyError(err) {
+				return fmt.Errorf("recording migration %d_%v: %w", mig.version, mig.name, err)
+			}
+			log.Info().Msgf("applied migration %d_%v", mig.version, mig.name)
+		}
+		return nil
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		mig := migrations[i]
+		if mig.version > current || mig.version <= target {
+			continue
+		}
+		if err := mig.down(ctx, m.conn.Database(MigrationsDatabase)); err != nil {
+			return fmt.Errorf("reverting migration %d_%v: %w", mig.version, mig.name, err)
+		}
+		if _, err := m.migrations().DeleteOne(ctx, bson.M{"_id": mig.version}); err != nil {
+			return fmt.Errorf("recording migration %d_%v: %w", mig.version, mig.name, err)
+		}
+		log.Info().Msgf("reverted migration %d_%v", mig.version, mig.name)
+	}
+	return nil
+}
+
+func (m *MongoClient) migrations() *mongo.Collection {
+	return m.conn.Database(MigrationsDatabase).Collection(MigrationsCollection)
+}
+
+func hasMigration(version int) bool {
+	for _, mig := range migrations {
+		if mig.version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// latest returns the version of the last migration
+func latest() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].version
+}
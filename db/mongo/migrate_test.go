@@ -0,0 +1,53 @@
+package mongo
+
+import (
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type MigrateTest struct {
+	suite.Suite
+}
+
+// TestMigrations tests that the migrations are ordered by version, and describe both of their steps
+func (s *MigrateTest) TestMigrations() {
+	m := &MongoClient{}
+	described := m.Migrations()
+	s.Require().Len(described, len(migrations))
+	for i, mig := range described {
+		if i > 0 {
+			s.Assert().Greater(mig.Version, described[i-1].Version)
+		}
+		s.Assert().NotEmpty(mig.Name)
+		s.Assert().NotEmpty(mig.Up)
+		s.Assert().NotEmpty(mig.Down)
+	}
+	s.Assert().Equal(described[len(described)-1].Version, latest())
+	s.Assert().True(hasMigration(latest()))
+	s.Assert().False(hasMigration(latest() + 1))
+}
+
+// TestIndexes tests that index names are unique per collection, so reverting a migration drops only its own, and
+// that TTL indexes have a single key
+func (s *MigrateTest) TestIndexes() {
+	names := map[string]bool{}
+	for _, mig := range migrations {
+		for _, i := range mig.indexes {
+			name := i.collection + "." + i.name
+			s.Assert().False(names[name], "index %v is created twice", name)
+			names[name] = true
+			s.Assert().NotEmpty(i.keys)
+			if i.ttl != nil {
+				s.Assert().Len(i.keys, 1)
+				s.Assert().Positive(*i.ttl)
+			}
+		}
+		for _, v := range mig.validators {
+			s.Assert().Contains(v.schema, "required")
+		}
+	}
+}
+
+func TestMigrateTest(t *testing.T) {
+	suite.Run(t, new(MigrateTest))
+}
@@ -6,14 +6,14 @@ import (
 	"fmt"
 	"github.com/dark-enstein/port/config"
 	"github.com/dark-enstein/port/db/model"
-	"github.com/dark-enstein/port/util"
 	"github.com/rs/zerolog"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
-	"strings"
+	"reflect"
+	"time"
 )
 
 var (
@@ -36,7 +36,6 @@ type MongoClient struct {
 		host string
 	}
 	conn        *mongo.Client
-	collections map[string]*mongo.Collection
 	databases   map[string]*mongo.Database
 	Opts        Opts
 	isConnected bool
@@ -115,31 +114,222 @@ func (m *MongoClient) Create(ctx context.Context, unit model.Unit, opts model.Op
 	llog := RetrieveLoggerFromCtx(ctx, "Create()")
 	m.ctx = ctx
 	switch unit.Kind() {
-	case model.UnitUser:
-		u := unit.(*model.User)
-		m.Opts.ClientOpts = &MongoOptions{
-			Database:         opts.RetrieveDatabase(),
-			Collection:       opts.RetrieveCollection(),
-			Table:            opts.RetrieveTable(),
-			CreateOnNotExist: opts.RetrieveOverride(),
+	case model.UnitUser, model.UnitJob, model.UnitLink, model.UnitScan, model.UnitRender, model.UnitTicket, model.UnitCheckIn, model.UnitPasswordReset, model.UnitRefreshToken, model.UnitRole, model.UnitAudit, model.UnitAPIKey:
+		one, err := m.collection(opts).InsertOne(ctx, unit)
+		if mongo.IsDuplicateKeyError(err) {
+			return &model.DBResponse{Err: fmt.Errorf("%w: %v", model.ErrDuplicate, err)}
 		}
-		err := m.EnsureDBScaffold(m.ctx, true)
-		//m.collections[m.Opts.ClientOpts.Collection].Find(m.ctx, bson.D{})
-		one, err := m.collections[m.Opts.ClientOpts.Collection].InsertOne(m.ctx, u)
 		if err != nil {
 			return &model.DBResponse{Err: err}
 		}
-		idey := strings.TrimRight(strings.TrimLeft(one.InsertedID.(primitive.ObjectID).String(), `ObjectID(\"`), `\")`)
-		llog.Info().Msgf("created record with ID: %s", idey)
-		return &model.DBResponse{ID: idey, Err: err}
+		id := insertedID(one.InsertedID)
+		llog.Info().Msgf("created %v record with ID: %s", unit.Kind(), id)
+		return &model.DBResponse{ID: id}
 	}
 
 	llog.Info().Msgf("inferred unit %v doesn't exist", unit.Kind())
 	return &model.DBResponse{Err: errors.New("inferred unit doesn't exist")}
 }
 
-func (m *MongoClient) CreateAll(ctx context.Context, unit []model.Unit, opts model.CreateOpts) *model.DBResponse {
-	return nil
+// CreateAll creates all the unit arguments in mongo with a single unordered InsertMany, so a failing unit doesn't
+// stop the others
+func (m *MongoClient) CreateAll(ctx context.Context, units []model.Unit, opts model.Opts) *model.DBResponse {
+	llog := RetrieveLoggerFromCtx(ctx, "CreateAll()")
+	if len(units) == 0 {
+		return &model.DBResponse{}
+	}
+	docs := make([]interface{}, len(units))
+	for i, u := range units {
+		docs[i] = u
+	}
+	many, err := m.collection(opts).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 {
+		errs := make([]error, len(units))
+		for _, we := range bulkErr.WriteErrors {
+			errs[we.Index] = we
+			if we.Code == 11000 {
+				errs[we.Index] = fmt.Errorf("%w: %v", model.ErrDuplicate, we)
+			}
+		}
+		failed := len(bulkErr.WriteErrors)
+		llog.Info().Msgf("creating %d of %d %v records failed, first with: %v", failed, len(units), units[0].Kind(), bulkErr.WriteErrors[0])
+		return &model.DBResponse{Err: fmt.Errorf("%w: %d of %d", model.ErrPartialWrite, failed, len(units)), Count: len(units) - failed, Errors: errs}
+	}
+	if err != nil {
+		llog.Info().Msgf("creating %d %v records failed with: %v", len(units), units[0].Kind(), err)
+		return &model.DBResponse{Err: err}
+	}
+	llog.Debug().Msgf("created %d %v records", len(many.InsertedIDs), units[0].Kind())
+	return &model.DBResponse{Count: len(many.InsertedIDs)}
+}
+
+// Read decodes the first record matching the filter into the unit argument
+func (m *MongoClient) Read(ctx context.Context, filter model.Filter, unit model.Unit, opts model.Opts) *model.DBResponse {
+	llog := RetrieveLoggerFromCtx(ctx, "Read()")
+	err := m.collection(opts).FindOne(ctx, live(filter)).Decode(unit)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &model.DBResponse{Err: model.ErrNotFound}
+	}
+	if err != nil {
+		llog.Info().Msgf("reading %v record failed with: %v", unit.Kind(), err)
+		return &model.DBResponse{Err: err}
+	}
+	id, _ := filter["_id"].(string)
+	return &model.DBResponse{ID: id}
+}
+
+// Update sets the fields on the first record matching the filter, and increments its version
+func (m *MongoClient) Update(ctx context.Context, filter model.Filter, fields model.Fields, opts model.Opts) *model.DBResponse {
+	llog := RetrieveLoggerFromCtx(ctx, "Update()")
+	set := bson.M{}
+	for k, v := range fields {
+		if k != model.VersionField {
+			set[k] = v
+		}
+	}
+	update := bson.M{"$inc": bson.M{model.VersionField: 1}}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	res, err := m.collection(opts).UpdateOne(ctx, live(filter), update)
+	if err != nil {
+		llog.Info().Msgf("updating record failed with: %v", err)
+		return &model.DBResponse{Err: err}
+	}
+	id, _ := filter["_id"].(string)
+	if res.MatchedCount > 0 {
+		return &model.DBResponse{ID: id}
+	}
+	if _, versioned := filter[model.VersionField]; versioned {
+		unversioned := model.Filter{}
+		for k, v := range filter {
+			if k != model.VersionField {
+				unversioned[k] = v
+			}
+		}
+		n, err := m.collection(opts).CountDocuments(ctx, live(unversioned), options.Count().SetLimit(1))
+		if err != nil {
+			return &model.DBResponse{Err: err}
+		}
+		if n > 0 {
+			return &model.DBResponse{ID: id, Err: model.ErrConflict}
+		}
+	}
+	return &model.DBResponse{Err: model.ErrNotFound}
+}
+
+// List decodes the records matching the filter into the slice units points at
+func (m *MongoClient) List(ctx context.Context, filter model.Filter, units interface{}, list *model.ListOpts, opts model.Opts) *model.DBResponse {
+	llog := RetrieveLoggerFromCtx(ctx, "List()")
+	find := options.Find()
+	if list != nil {
+		sort := bson.D{}
+		for _, s := range list.Sort {
+			order := 1
+			if s.Desc {
+				order = -1
+			}
+			sort = append(sort, bson.E{Key: s.Field, Value: order})
+		}
+		if len(sort) > 0 {
+			find.SetSort(sort)
+		}
+		find.SetSkip(int64(list.Offset)).SetLimit(int64(list.Limit))
+	}
+	cursor, err := m.collection(opts).Find(ctx, live(filter), find)
+	if err != nil {
+		llog.Info().Msgf("listing records failed with: %v", err)
+		return &model.DBResponse{Err: err}
+	}
+	if err := cursor.All(ctx, units); err != nil {
+		llog.Info().Msgf("decoding records failed with: %v", err)
+		return &model.DBResponse{Err: err}
+	}
+	return &model.DBResponse{Count: reflect.ValueOf(units).Elem().Len()}
+}
+
+// Delete soft deletes every record matching the filter by marking it, or removes it for good
+func (m *MongoClient) Delete(ctx context.Context, filter model.Filter, mode model.DeleteMode, opts model.Opts) *model.DBResponse {
+	llog := RetrieveLoggerFromCtx(ctx, "Delete()")
+	if len(filter) == 0 {
+		return &model.DBResponse{Err: model.ErrEmptyFilter}
+	}
+	var count int64
+	switch mode {
+	case model.HardDelete:
+		res, err := m.collection(opts).DeleteMany(ctx, query(filter))
+		if err != nil {
+			llog.Info().Msgf("deleting records failed with: %v", err)
+			return &model.DBResponse{Err: err}
+		}
+		count = res.DeletedCount
+	default:
+		res, err := m.collection(opts).UpdateMany(ctx, live(filter), bson.M{
+			"$set": bson.M{model.DeletedField: time.Now().UTC()},
+			"$inc": bson.M{model.VersionField: 1},
+		})
+		if err != nil {
+			llog.Info().Msgf("soft deleting records failed with: %v", err)
+			return &model.DBResponse{Err: err}
+		}
+		count = res.MatchedCount
+	}
+	if count == 0 {
+		return &model.DBResponse{Err: model.ErrNotFound}
+	}
+	id, _ := filter["_id"].(string)
+	return &model.DBResponse{ID: id, Count: int(count)}
+}
+
+// live turns the filter into a query leaving soft deleted records out, unless the filter holds
+// model.DeletedField. Version 0 also selects records created before they were versioned.
+func live(filter model.Filter) bson.M {
+	q := query(filter)
+	if _, ok := filter[model.DeletedField]; !ok {
+		q[model.DeletedField] = nil
+	}
+	if v, ok := filter[model.VersionField]; ok && reflect.ValueOf(v).IsValid() && reflect.ValueOf(v).IsZero() {
+		q[model.VersionField] = bson.M{"$in": bson.A{v, nil}}
+	}
+	return q
+}
+
+// query turns the filter into a query. model.RoleField selects the users holding the role, and ids that are the
+// hex of an ObjectID also select the record mongo gave that ObjectID, as users created before they were given ids
+// of their own have one.
+func query(filter model.Filter) bson.M {
+	q := bson.M{}
+	for k, v := range filter {
+		switch k {
+		case model.RoleField:
+			q[fmt.Sprintf("role_set.%v", v)] = bson.M{"$exists": true}
+		case "_id":
+			q[k] = v
+			if s, ok := v.(string); ok {
+				if oid, err := primitive.ObjectIDFromHex(s); err == nil {
+					q[k] = bson.M{"$in": bson.A{s, oid}}
+				}
+			}
+		default:
+			q[k] = v
+		}
+	}
+	return q
+}
+
+// insertedID returns the id mongo reports for an inserted record: its own, or the hex of the ObjectID mongo gave it
+func insertedID(v interface{}) string {
+	if oid, ok := v.(primitive.ObjectID); ok {
+		return oid.Hex()
+	}
+	id, _ := v.(string)
+	return id
+}
+
+// collection returns the collection the opts point at
+func (m *MongoClient) collection(opts model.Opts) *mongo.Collection {
+	return m.conn.Database(opts.RetrieveDatabase()).Collection(opts.RetrieveCollection())
 }
 
 func (m *MongoClient) Ping() bool {
@@ -150,6 +340,11 @@ func (m *MongoClient) Ping() bool {
 	return true
 }
 
+// Close disconnects from mongo
+func (m *MongoClient) Close(ctx context.Context) error {
+	return m.conn.Disconnect(ctx)
+}
+
 func (m *MongoClient) Kind() string {
 	return m.config.kind
 }
@@ -158,69 +353,19 @@ func (m *MongoClient) Host() string {
 	return m.config.host
 }
 
-// EnsureDBScaffold ensures the target database and collections to be used exist
+// EnsureDBScaffold ensures the indexes and validators of the collections are in place. Collections are created on
+// their first use, so only pending migrations are missing scaffold: they are applied when override is set, and
+// refused otherwise.
 func (m *MongoClient) EnsureDBScaffold(ctx context.Context, override bool) error {
-	log := RetrieveLoggerFromCtx(ctx, "EnsureDBScaffold()")
-	m.collections = make(map[string]*mongo.Collection)
-	onlyNames := true
-	_ = &options.DatabaseOptions{
-		ReadConcern:    nil,
-		WriteConcern:   nil,
-		ReadPreference: nil,
-		BSONOptions:    nil,
-		Registry:       nil,
-	} // unused for now: TODO
-
-	_ = &options.CollectionOptions{
-		ReadConcern:    nil,
-		WriteConcern:   nil,
-		ReadPreference: nil,
-		BSONOptions:    nil,
-		Registry:       nil,
-	} // unused for now: TODO
-
-	//listDBOpts := &options.ListDatabasesOptions{
-	//	NameOnly: &onlyNames,
-	//}
-	//db, err := m.conn.ListDatabases(ctx, bson.D{{}}, listDBOpts)
-	//if err != nil {
-	//	return nil, err
-	//}
-
-	collectionOpts := &options.ListCollectionsOptions{
-		NameOnly: &onlyNames,
-	}
-
-	isConnected := m.Ping()
-	if !isConnected {
-		log.Info().Msg("cannot ping database")
-	} else {
-		log.Info().Msgf("ping to database successful")
-	}
-
-	dbase := m.conn.Database(model.UserDB)
-
-	collSlice, err := dbase.ListCollectionNames(ctx, bson.D{{"options.capped", true}}, collectionOpts)
-	log.Info().Msgf("collection slice", collSlice)
+	version, err := m.SchemaVersion(ctx)
 	if err != nil {
-		log.Info().Msgf("error connecting to DB", err.Error())
 		return err
 	}
-	if len(collSlice) < 1 {
-		log.Info().Msgf("no collections in specified database: %v", m.Opts.ClientOpts.Database)
-		if !override {
-			return fmt.Errorf("no collections in specified database: %v", m.Opts.ClientOpts.Database)
-		}
-		m.collections[m.Opts.ClientOpts.Collection] = dbase.Collection(m.Opts.ClientOpts.Collection)
-	} else {
-		if !util.IsIn(m.Opts.ClientOpts.Collection, collSlice) {
-			log.Info().Msgf("collection isn't present in specified database: %v", m.Opts.ClientOpts.Database)
-			if !override {
-				return fmt.Errorf("collection isn't present in specified database: %v", m.Opts.ClientOpts.Database)
-			}
-			m.collections[m.Opts.ClientOpts.Collection] = dbase.Collection(m.Opts.ClientOpts.Collection)
-		}
+	if version >= latest() {
+		return nil
+	}
+	if !override {
+		return fmt.Errorf("mongo schema is at version %d, and %d is the latest: run port migrate up", version, latest())
 	}
-	log.Info().Msgf("returned collection %v", m.collections[m.Opts.ClientOpts.Collection].Name())
-	return nil
+	return m.MigrateTo(ctx, latest())
 }
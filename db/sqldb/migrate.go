@@ -0,0 +1,177 @@
+package sqldb
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/dark-enstein/port/db/model"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the migrations of every dialect, as <version>_<name>.up.sql and <version>_<name>.down.sql
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// loadMigrations reads the embedded migrations of a dialect, ordered by version
+func loadMigrations(dialect string) ([]model.Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for %v: %w", dialect, err)
+	}
+	byVersion := map[int]*model.Migration{}
+	for _, e := range entries {
+		name, direction, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %v isn't named <version>_<name>.up.sql or .down.sql", e.Name())
+		}
+		v, label, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(v)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %v doesn't start with a version", e.Name())
+		}
+		data, err := migrationFiles.ReadFile(path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &model.Migration{Version: version, Name: label}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+	migrations := make([]model.Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%v must have both up and down steps", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrations lists the migrations of the dialect of the DB, ordered by version
+func (c *SQLClient) Migrations() []model.Migration {
+	return c.migrations
+}
+
+// SchemaVersion returns the version of the last migration applied, 0 before any
+func (c *SQLClient) SchemaVersion(ctx context.Context) (int, error) {
+	if _, err := c.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at TEXT NOT NULL
+)`); err != nil {
+		return 0, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+	var version int
+	err := c.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// MigrateTo applies, or reverts, migrations one at a time until the schema is at the target version. Each step
+// runs in a transaction of its own, with its record in schema_migrations, so a failing step leaves the schema at
+// the version before it.
+func (c *SQLClient) MigrateTo(ctx context.Context, target int) error {
+	log := logger(ctx, "MigrateTo()")
+	current, err := c.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if target != 0 && !c.hasMigration(target) {
+		return fmt.Errorf("there is no migration %d", target)
+	}
+	if target >= current {
+		for _, m := range c.migrations {
+			if m.Version <= current || m.Version > target {
+				continue
+			}
+			if err := c.step(ctx, m.Up, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+				m.Version, m.Name, time.Now().UTC().Format(time.RFC3339)); err != nil {
+				return fmt.Errorf("applying migration %d_%v: %w", m.Version, m.Name, err)
+			}
+			log.Info().Msgf("applied migration %d_%v", m.Version, m.Name)
+		}
+		return nil
+	}
+	for i := len(c.migrations) - 1; i >= 0; i-- {
+		m := c.migrations[i]
+		if m.Version > current || m.Version <= target {
+			continue
+		}
+		if err := c.step(ctx, m.Down, `DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+			return fmt.Errorf("reverting migration %d_%v: %w", m.Version, m.Name, err)
+		}
+		log.Info().Msgf("reverted migration %d_%v", m.Version, m.Name)
+	}
+	return nil
+}
+
+// step runs the statements of a migration step, and records it, in a single transaction
+func (c *SQLClient) step(ctx context.Context, statements, record string, args ...interface{}) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range splitStatements(statements) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%w, in: %v", err, stmt)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c *SQLClient) hasMigration(version int) bool {
+	for _, m := range c.migrations {
+		if m.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// latest returns the version of the last migration, the one EnsureDBScaffold migrates to
+func (c *SQLClient) latest() int {
+	if len(c.migrations) == 0 {
+		return 0
+	}
+	return c.migrations[len(c.migrations)-1].Version
+}
+
+// splitStatements splits a migration into its statements, leaving out comments. Statements end with a semicolon
+// at the end of a line.
+func splitStatements(migration string) []string {
+	var statements []string
+	var stmt strings.Builder
+	for _, line := range strings.Split(migration, "\n") {
+		if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		stmt.WriteString(line)
+		stmt.WriteString("\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			statements = append(statements, strings.TrimSpace(stmt.String()))
+			stmt.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(stmt.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
@@ -0,0 +1,8 @@
+-- roles holds the named roles users are granted, by name
+CREATE TABLE roles (
+    seq        BIGSERIAL PRIMARY KEY,
+    id         TEXT NOT NULL UNIQUE,
+    doc        BYTEA NOT NULL,
+    version    BIGINT NOT NULL DEFAULT 0,
+    deleted_at TEXT
+);
@@ -0,0 +1,13 @@
+-- audit_log holds the changes made to roles, and to the roles of users
+CREATE TABLE audit_log (
+    seq        BIGSERIAL PRIMARY KEY,
+    id         TEXT NOT NULL UNIQUE,
+    doc        BYTEA NOT NULL,
+    version    BIGINT NOT NULL DEFAULT 0,
+    deleted_at TEXT,
+    at         TEXT,
+    actor      TEXT,
+    action     TEXT,
+    target     TEXT
+);
+CREATE INDEX audit_log_target ON audit_log (target);
@@ -0,0 +1,8 @@
+DROP TABLE checkins;
+DROP TABLE tickets;
+DROP TABLE renders;
+DROP TABLE scans;
+DROP TABLE links;
+DROP TABLE jobs;
+DROP TABLE user_roles;
+DROP TABLE users;
@@ -0,0 +1,82 @@
+-- Every table keeps its units whole, as bson, in doc. The fields units are filtered and sorted on are copied into
+-- columns of the same name; times as fixed width UTC text, so they sort as they compare.
+
+CREATE TABLE users (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    id         TEXT NOT NULL UNIQUE,
+    doc        BLOB NOT NULL,
+    version    INTEGER NOT NULL DEFAULT 0,
+    deleted_at TEXT,
+    firstname  TEXT,
+    lastname   TEXT
+);
+
+-- user_roles holds the role sets of users: one row per role of each set
+CREATE TABLE user_roles (
+    user_id    TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    position   INTEGER NOT NULL,
+    role       TEXT NOT NULL,
+    permission INTEGER,
+    PRIMARY KEY (user_id, position, role)
+);
+
+CREATE TABLE jobs (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    id         TEXT NOT NULL UNIQUE,
+    doc        BLOB NOT NULL,
+    version    INTEGER NOT NULL DEFAULT 0,
+    deleted_at TEXT,
+    status     TEXT
+);
+
+CREATE TABLE links (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    id         TEXT NOT NULL UNIQUE,
+    doc        BLOB NOT NULL,
+    version    INTEGER NOT NULL DEFAULT 0,
+    deleted_at TEXT,
+    slug       TEXT
+);
+CREATE UNIQUE INDEX links_slug ON links (slug);
+
+CREATE TABLE scans (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    id         TEXT NOT NULL UNIQUE,
+    doc        BLOB NOT NULL,
+    version    INTEGER NOT NULL DEFAULT 0,
+    deleted_at TEXT,
+    link_id    TEXT,
+    at         TEXT
+);
+CREATE INDEX scans_link_id ON scans (link_id, at);
+
+CREATE TABLE renders (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    id         TEXT NOT NULL UNIQUE,
+    doc        BLOB NOT NULL,
+    version    INTEGER NOT NULL DEFAULT 0,
+    deleted_at TEXT
+);
+
+CREATE TABLE tickets (
+    seq         INTEGER PRIMARY KEY AUTOINCREMENT,
+    id          TEXT NOT NULL UNIQUE,
+    doc         BLOB NOT NULL,
+    version     INTEGER NOT NULL DEFAULT 0,
+    deleted_at  TEXT,
+    user_id     TEXT,
+    event       TEXT,
+    redeemed_at TEXT
+);
+
+CREATE TABLE checkins (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    id         TEXT NOT NULL UNIQUE,
+    doc        BLOB NOT NULL,
+    version    INTEGER NOT NULL DEFAULT 0,
+    deleted_at TEXT,
+    ticket_id  TEXT,
+    device_id  TEXT,
+    at         TEXT
+);
+CREATE INDEX checkins_ticket_id ON checkins (ticket_id, at);
@@ -0,0 +1,14 @@
+-- Users log in with their email, so it is a column, unique among the users who have one
+ALTER TABLE users ADD COLUMN email TEXT;
+CREATE UNIQUE INDEX users_email ON users (email);
+
+-- password_resets holds the password reset tokens handed out, by the hash of the token
+CREATE TABLE password_resets (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    id         TEXT NOT NULL UNIQUE,
+    doc        BLOB NOT NULL,
+    version    INTEGER NOT NULL DEFAULT 0,
+    deleted_at TEXT,
+    user_id    TEXT,
+    used_at    TEXT
+);
@@ -0,0 +1,12 @@
+-- refresh_tokens holds the refresh tokens handed out at login, by the hash of the token
+CREATE TABLE refresh_tokens (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    id         TEXT NOT NULL UNIQUE,
+    doc        BLOB NOT NULL,
+    version    INTEGER NOT NULL DEFAULT 0,
+    deleted_at TEXT,
+    user_id    TEXT,
+    family     TEXT,
+    used_at    TEXT
+);
+CREATE INDEX refresh_tokens_family ON refresh_tokens (family);
@@ -0,0 +1,8 @@
+-- roles holds the named roles users are granted, by name
+CREATE TABLE roles (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    id         TEXT NOT NULL UNIQUE,
+    doc        BLOB NOT NULL,
+    version    INTEGER NOT NULL DEFAULT 0,
+    deleted_at TEXT
+);
@@ -0,0 +1,13 @@
+-- audit_log holds the changes made to roles, and to the roles of users
+CREATE TABLE audit_log (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    id         TEXT NOT NULL UNIQUE,
+    doc        BLOB NOT NULL,
+    version    INTEGER NOT NULL DEFAULT 0,
+    deleted_at TEXT,
+    at         TEXT,
+    actor      TEXT,
+    action     TEXT,
+    target     TEXT
+);
+CREATE INDEX audit_log_target ON audit_log (target);
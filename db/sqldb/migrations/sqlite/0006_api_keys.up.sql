@@ -0,0 +1,11 @@
+-- api_keys holds the API keys of users and service accounts, by the public id of the key
+CREATE TABLE api_keys (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    id         TEXT NOT NULL UNIQUE,
+    doc        BLOB NOT NULL,
+    version    INTEGER NOT NULL DEFAULT 0,
+    deleted_at TEXT,
+    user_id    TEXT,
+    created_at TEXT
+);
+CREATE INDEX api_keys_user_id ON api_keys (user_id);
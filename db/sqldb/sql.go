@@ -0,0 +1,758 @@
+// Package sqldb is a db.DB on SQL databases: SQLite, file based and needing no server, and PostgreSQL. Every
+// collection maps to a table, registered in Tables and created by the embedded migrations. A table keeps its units
+// whole, as bson, so they decode exactly like they do from mongo, and copies the fields filters and sorts use into
+// columns of the same name.
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/util"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+var (
+	SQLite   = "sqlite"
+	Postgres = "postgres"
+
+	// ErrUnsupportedFilter is the response to filters on fields without a column, or using query operators
+	ErrUnsupportedFilter = errors.New("filter isn't supported by the sql db")
+	// ErrNoTable is the response to calls on collections without a table
+	ErrNoTable = errors.New("collection has no table")
+
+	// timeLayout is the fixed width layout times are stored in, so they sort as they compare
+	timeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+)
+
+// Table maps the units of a collection to a table. Columns are the bson names of the fields copied out of the
+// units, which filters and sorts can use. New entities add a migration creating their table, and an entry here.
+type Table struct {
+	Name    string
+	Columns []string
+}
+
+// Tables holds the table of every collection, by collection name
+var Tables = map[string]*Table{
+	model.UserCollection:          {Name: "users", Columns: []string{"firstname", "lastname", "email"}},
+	model.JobCollection:           {Name: "jobs", Columns: []string{"status"}},
+	model.LinkCollection:          {Name: "links", Columns: []string{"slug"}},
+	model.ScanCollection:          {Name: "scans", Columns: []string{"link_id", "at"}},
+	model.RenderCollection:        {Name: "renders"},
+	model.TicketCollection:        {Name: "tickets", Columns: []string{"user_id", "event", "redeemed_at"}},
+	model.CheckInCollection:       {Name: "checkins", Columns: []string{"ticket_id", "device_id", "at"}},
+	model.PasswordResetCollection: {Name: "password_resets", Columns: []string{"user_id", "used_at"}},
+	model.RefreshTokenCollection:  {Name: "refresh_tokens", Columns: []string{"user_id", "family", "used_at"}},
+	model.RoleCollection:          {Name: "roles"},
+	model.AuditCollection:         {Name: "audit_log", Columns: []string{"at", "actor", "action", "target"}},
+	model.APIKeyCollection:        {Name: "api_keys", Columns: []string{"user_id", "created_at"}},
+}
+
+// column returns the column a filter or sort key maps to
+func (t *Table) column(key string) (string, bool) {
+	switch key {
+	case "_id":
+		return "id", true
+	case model.VersionField, model.DeletedField:
+		return key, true
+	}
+	for _, c := range t.Columns {
+		if c == key {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// SQLClient is the SQL db.DB
+type SQLClient struct {
+	db         *sql.DB
+	dialect    string
+	dsn        string
+	migrations []model.Migration
+}
+
+// NewSQLClient opens the SQL DB of the dialect passed in. For sqlite, dsn is the path of the DB file; for postgres,
+// it is a connection URI.
+func NewSQLClient(ctx context.Context, dialect, dsn string) (*SQLClient, error) {
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	c := &SQLClient{dialect: dialect, dsn: dsn, migrations: migrations}
+	switch dialect {
+	case SQLite:
+		c.db, err = sql.Open("sqlite", "file:"+dsn+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+		if err != nil {
+			return nil, err
+		}
+		// sqlite allows a single writer: one connection serializes the calls rather than failing them as busy
+		c.db.SetMaxOpenConns(1)
+	case Postgres:
+		c.db, err = sql.Open("pgx", dsn)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("sql dialect %v isn't supported", dialect)
+	}
+	if err := c.db.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("connecting to %v: %w", dialect, err)
+	}
+	return c, nil
+}
+
+// Create creates the unit argument. Units without an id get the hex of an ObjectID, like mongo reports them.
+func (c *SQLClient) Create(ctx context.Context, unit model.Unit, opts model.Opts) *model.DBResponse {
+	t, err := table(opts)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	doc, err := document(unit)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	err = c.tx(ctx, func(tx *sql.Tx) error {
+		return c.insert(ctx, tx, t, doc)
+	})
+	if err != nil {
+		logger(ctx, "Create()").Info().Msgf("creating %v record failed with: %v", unit.Kind(), err)
+		return &model.DBResponse{Err: err}
+	}
+	return &model.DBResponse{ID: doc["_id"].(string)}
+}
+
+// CreateAll creates all the unit arguments in a single transaction. A failing unit doesn't stop the others: each
+// is inserted under a savepoint, rolled back on failure.
+func (c *SQLClient) CreateAll(ctx context.Context, units []model.Unit, opts model.Opts) *model.DBResponse {
+	if len(units) == 0 {
+		return &model.DBResponse{}
+	}
+	t, err := table(opts)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	errs := make([]error, len(units))
+	failed := 0
+	err = c.tx(ctx, func(tx *sql.Tx) error {
+		for i, unit := range units {
+			doc, err := document(unit)
+			if err == nil {
+				err = c.savepoint(ctx, tx, func() error { return c.insert(ctx, tx, t, doc) })
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				errs[i] = err
+				failed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger(ctx, "CreateAll()").Info().Msgf("creating %d %v records failed with: %v", len(units), units[0].Kind(), err)
+		return &model.DBResponse{Err: err}
+	}
+	if failed > 0 {
+		logger(ctx, "CreateAll()").Info().Msgf("creating %d of %d %v records failed", failed, len(units), units[0].Kind())
+		return &model.DBResponse{Err: fmt.Errorf("%w: %d of %d", model.ErrPartialWrite, failed, len(units)), Count: len(units) - failed, Errors: errs}
+	}
+	return &model.DBResponse{Count: len(units)}
+}
+
+// Read decodes the first record matching the filter into the unit argument
+func (c *SQLClient) Read(ctx context.Context, filter model.Filter, unit model.Unit, opts model.Opts) *model.DBResponse {
+	t, err := table(opts)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	where, args, err := t.where(filter, true)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	var id string
+	var raw []byte
+	err = c.db.QueryRowContext(ctx, "SELECT id, doc FROM "+t.Name+where+" ORDER BY seq LIMIT 1", args...).Scan(&id, &raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &model.DBResponse{Err: model.ErrNotFound}
+	}
+	if err == nil {
+		err = bson.Unmarshal(raw, unit)
+	}
+	if err != nil {
+		logger(ctx, "Read()").Info().Msgf("reading %v record failed with: %v", unit.Kind(), err)
+		return &model.DBResponse{Err: err}
+	}
+	return &model.DBResponse{ID: id}
+}
+
+// Update sets the fields on the first record matching the filter, and increments its version. The record is
+// locked while it is updated, and the filter checked again, so of concurrent conditional updates only one applies.
+func (c *SQLClient) Update(ctx context.Context, filter model.Filter, fields model.Fields, opts model.Opts) *model.DBResponse {
+	t, err := table(opts)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	where, args, err := t.where(filter, true)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	set, err := normalize(bson.M(fields))
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	var id string
+	err = c.tx(ctx, func(tx *sql.Tx) error {
+		var raw []byte
+		err := tx.QueryRowContext(ctx, "SELECT id, doc FROM "+t.Name+where+" ORDER BY seq LIMIT 1"+c.forUpdate(), args...).Scan(&id, &raw)
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.missing(ctx, tx, t, filter)
+		}
+		if err != nil {
+			return err
+		}
+		doc := bson.M{}
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			return err
+		}
+		for k, v := range set {
+			if k != "_id" && k != model.VersionField {
+				doc[k] = v
+			}
+		}
+		return c.save(ctx, tx, t, doc)
+	})
+	if err != nil {
+		if !errors.Is(err, model.ErrNotFound) && !errors.Is(err, model.ErrConflict) {
+			logger(ctx, "Update()").Info().Msgf("updating record failed with: %v", err)
+		}
+		ref, _ := filter["_id"].(string)
+		if errors.Is(err, model.ErrConflict) {
+			return &model.DBResponse{ID: ref, Err: err}
+		}
+		return &model.DBResponse{Err: err}
+	}
+	return &model.DBResponse{ID: id}
+}
+
+// missing tells apart an update matching no record, and one made against a version its record moved past
+func (c *SQLClient) missing(ctx context.Context, tx *sql.Tx, t *Table, filter model.Filter) error {
+	if _, versioned := filter[model.VersionField]; !versioned {
+		return model.ErrNotFound
+	}
+	unversioned := model.Filter{}
+	for k, v := range filter {
+		if k != model.VersionField {
+			unversioned[k] = v
+		}
+	}
+	where, args, err := t.where(unversioned, true)
+	if err != nil {
+		return err
+	}
+	var n int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+t.Name+where, args...).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return model.ErrConflict
+	}
+	return model.ErrNotFound
+}
+
+// List decodes the records matching the filter into the slice units points at
+func (c *SQLClient) List(ctx context.Context, filter model.Filter, units interface{}, list *model.ListOpts, opts model.Opts) *model.DBResponse {
+	t, err := table(opts)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	slice := reflect.ValueOf(units)
+	if slice.Kind() != reflect.Pointer || slice.Elem().Kind() != reflect.Slice {
+		return &model.DBResponse{Err: fmt.Errorf("units must be a pointer to a slice, not a %T", units)}
+	}
+	where, args, err := t.where(filter, true)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	query := "SELECT doc FROM " + t.Name + where
+	order := []string{}
+	if list != nil {
+		for _, s := range list.Sort {
+			col, ok := t.column(s.Field)
+			if !ok {
+				return &model.DBResponse{Err: fmt.Errorf("%w: %v has no column %v to sort on", ErrUnsupportedFilter, t.Name, s.Field)}
+			}
+			// unset values come first, like they do in mongo
+			if s.Desc {
+				order = append(order, col+" IS NULL, "+col+" DESC")
+			} else {
+				order = append(order, col+" IS NOT NULL, "+col)
+			}
+		}
+	}
+	query += " ORDER BY " + strings.Join(append(order, "seq"), ", ")
+	if list != nil && (list.Limit > 0 || list.Offset > 0) {
+		limit := int64(list.Limit)
+		if limit <= 0 {
+			limit = -1
+			if c.dialect == Postgres {
+				limit = 1<<63 - 1
+			}
+		}
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", limit, list.Offset)
+	}
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger(ctx, "List()").Info().Msgf("listing records failed with: %v", err)
+		return &model.DBResponse{Err: err}
+	}
+	defer rows.Close()
+	elemType := slice.Elem().Type().Elem()
+	decoded := reflect.MakeSlice(slice.Elem().Type(), 0, 0)
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return &model.DBResponse{Err: err}
+		}
+		elem := reflect.New(elemType)
+		target := elem.Interface()
+		if elemType.Kind() == reflect.Pointer {
+			elem.Elem().Set(reflect.New(elemType.Elem()))
+			target = elem.Elem().Interface()
+		}
+		if err := bson.Unmarshal(raw, target); err != nil {
+			logger(ctx, "List()").Info().Msgf("decoding records failed with: %v", err)
+			return &model.DBResponse{Err: err}
+		}
+		decoded = reflect.Append(decoded, elem.Elem())
+	}
+	if err := rows.Err(); err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	slice.Elem().Set(decoded)
+	return &model.DBResponse{Count: decoded.Len()}
+}
+
+// Delete soft deletes every record matching the filter by marking it, or removes it for good
+func (c *SQLClient) Delete(ctx context.Context, filter model.Filter, mode model.DeleteMode, opts model.Opts) *model.DBResponse {
+	if len(filter) == 0 {
+		return &model.DBResponse{Err: model.ErrEmptyFilter}
+	}
+	t, err := table(opts)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	where, args, err := t.where(filter, mode != model.HardDelete)
+	if err != nil {
+		return &model.DBResponse{Err: err}
+	}
+	var count int64
+	err = c.tx(ctx, func(tx *sql.Tx) error {
+		if mode == model.HardDelete {
+			res, err := tx.ExecContext(ctx, "DELETE FROM "+t.Name+where, args...)
+			if err != nil {
+				return err
+			}
+			count, err = res.RowsAffected()
+			return err
+		}
+		rows, err := tx.QueryContext(ctx, "SELECT doc FROM "+t.Name+where+" ORDER BY seq"+c.forUpdate(), args...)
+		if err != nil {
+			return err
+		}
+		var docs []bson.M
+		for rows.Next() {
+			var raw []byte
+			doc := bson.M{}
+			if err := rows.Scan(&raw); err != nil {
+				rows.Close()
+				return err
+			}
+			if err := bson.Unmarshal(raw, &doc); err != nil {
+				rows.Close()
+				return err
+			}
+			docs = append(docs, doc)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		now := primitive.NewDateTimeFromTime(time.Now().UTC())
+		for _, doc := range docs {
+			doc[model.DeletedField] = now
+			if err := c.save(ctx, tx, t, doc); err != nil {
+				return err
+			}
+		}
+		count = int64(len(docs))
+		return nil
+	})
+	if err != nil {
+		logger(ctx, "Delete()").Info().Msgf("deleting records failed with: %v", err)
+		return &model.DBResponse{Err: err}
+	}
+	if count == 0 {
+		return &model.DBResponse{Err: model.ErrNotFound}
+	}
+	ref, _ := filter["_id"].(string)
+	return &model.DBResponse{ID: ref, Count: int(count)}
+}
+
+func (c *SQLClient) Ping() bool {
+	return c.db.Ping() == nil
+}
+
+func (c *SQLClient) Kind() string {
+	return c.dialect
+}
+
+func (c *SQLClient) Host() string {
+	return c.dsn
+}
+
+// EnsureDBScaffold ensures every migration is applied. With override, it applies the pending ones; without, it
+// fails naming them.
+func (c *SQLClient) EnsureDBScaffold(ctx context.Context, override bool) error {
+	version, err := c.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version >= c.latest() {
+		return nil
+	}
+	if !override {
+		return fmt.Errorf("%v schema is at version %d, and %d is the latest: run port migrate up", c.dialect, version, c.latest())
+	}
+	return c.MigrateTo(ctx, c.latest())
+}
+
+// Close closes the connections to the DB
+func (c *SQLClient) Close(ctx context.Context) error {
+	return c.db.Close()
+}
+
+// insert inserts a document into its table, with its columns, and its role set when it is a user's
+func (c *SQLClient) insert(ctx context.Context, tx *sql.Tx, t *Table, doc bson.M) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	cols := []string{"id", "doc", "version", "deleted_at"}
+	version, _ := number(doc[model.VersionField])
+	deleted, err := value(doc[model.DeletedField])
+	if err != nil {
+		return err
+	}
+	args := []interface{}{doc["_id"], raw, version, deleted}
+	for _, col := range t.Columns {
+		v, err := value(doc[col])
+		if err != nil {
+			return fmt.Errorf("column %v: %w", col, err)
+		}
+		cols = append(cols, col)
+		args = append(args, v)
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO "+t.Name+" ("+strings.Join(cols, ", ")+") VALUES ("+placeholders(1, len(cols))+")", args...)
+	if err != nil {
+		if duplicate(err) {
+			return fmt.Errorf("%w: %v", model.ErrDuplicate, err)
+		}
+		return err
+	}
+	if t.Name == Tables[model.UserCollection].Name {
+		return c.saveRoles(ctx, tx, doc)
+	}
+	return nil
+}
+
+// save writes an updated document back over its record, incrementing its version
+func (c *SQLClient) save(ctx context.Context, tx *sql.Tx, t *Table, doc bson.M) error {
+	version, _ := number(doc[model.VersionField])
+	doc[model.VersionField] = version + 1
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	deleted, err := value(doc[model.DeletedField])
+	if err != nil {
+		return err
+	}
+	sets := []string{"doc = $1", "version = $2", "deleted_at = $3"}
+	args := []interface{}{raw, version + 1, deleted}
+	for _, col := range t.Columns {
+		v, err := value(doc[col])
+		if err != nil {
+			return fmt.Errorf("column %v: %w", col, err)
+		}
+		args = append(args, v)
+		sets = append(sets, fmt.Sprintf("%v = $%d", col, len(args)))
+	}
+	args = append(args, doc["_id"])
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("UPDATE %v SET %v WHERE id = $%d", t.Name, strings.Join(sets, ", "), len(args)), args...)
+	if err != nil && duplicate(err) {
+		return fmt.Errorf("%w: %v", model.ErrDuplicate, err)
+	}
+	if err == nil && t.Name == Tables[model.UserCollection].Name {
+		return c.saveRoles(ctx, tx, doc)
+	}
+	return err
+}
+
+// saveRoles maps the role set of a user to user_roles, one row per role
+func (c *SQLClient) saveRoles(ctx context.Context, tx *sql.Tx, doc bson.M) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_roles WHERE user_id = $1", doc["_id"]); err != nil {
+		return err
+	}
+	set, _ := doc["role_set"].(bson.A)
+	for position, roles := range set {
+		m, _ := roles.(bson.M)
+		for role, permission := range m {
+			var perm interface{}
+			if n, ok := number(permission); ok {
+				perm = n
+			}
+			if _, err := tx.ExecContext(ctx, "INSERT INTO user_roles (user_id, position, role, permission) VALUES ($1, $2, $3, $4)",
+				doc["_id"], position, role, perm); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// where turns a filter into a WHERE clause and its arguments. Live clauses leave soft deleted records out, unless
+// the filter holds model.DeletedField. model.RoleField selects the users holding the role, through user_roles.
+func (t *Table) where(filter model.Filter, live bool) (string, []interface{}, error) {
+	query, err := normalize(bson.M(filter))
+	if err != nil {
+		return "", nil, err
+	}
+	var clauses []string
+	var args []interface{}
+	if _, ok := query[model.DeletedField]; live && !ok {
+		clauses = append(clauses, "deleted_at IS NULL")
+	}
+	for k, v := range query {
+		if k == model.RoleField && t.Name == Tables[model.UserCollection].Name {
+			args = append(args, fmt.Sprint(v))
+			clauses = append(clauses, fmt.Sprintf("id IN (SELECT user_id FROM user_roles WHERE role = $%d)", len(args)))
+			continue
+		}
+		col, ok := t.column(k)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: %v has no column %v", ErrUnsupportedFilter, t.Name, k)
+		}
+		if b, ok := bounds(v); ok {
+			for _, op := range []struct{ key, sign string }{{model.OpFrom, ">="}, {model.OpBefore, "<"}} {
+				bound, set := b[op.key]
+				if !set {
+					continue
+				}
+				arg, err := value(bound)
+				if err != nil || arg == nil {
+					return "", nil, fmt.Errorf("%w: field %v: bound %v is unusable", ErrUnsupportedFilter, k, bound)
+				}
+				args = append(args, arg)
+				clauses = append(clauses, fmt.Sprintf("%v %v $%d", col, op.sign, len(args)))
+			}
+			continue
+		}
+		if operator(v) {
+			return "", nil, fmt.Errorf("%w: field %v uses an operator", ErrUnsupportedFilter, k)
+		}
+		arg, err := value(v)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: field %v: %v", ErrUnsupportedFilter, k, err)
+		}
+		if arg == nil {
+			clauses = append(clauses, col+" IS NULL")
+			continue
+		}
+		args = append(args, arg)
+		clauses = append(clauses, fmt.Sprintf("%v = $%d", col, len(args)))
+	}
+	if len(clauses) == 0 {
+		return "", nil, nil
+	}
+	return " WHERE " + strings.Join(clauses, " AND "), args, nil
+}
+
+// tx runs f in a transaction, committing it if f succeeds
+func (c *SQLClient) tx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// savepoint runs f under a savepoint of tx, rolling back to it if f fails, so the transaction carries on
+func (c *SQLClient) savepoint(ctx context.Context, tx *sql.Tx, f func() error) error {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT unit"); err != nil {
+		return err
+	}
+	if err := f(); err != nil {
+		if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT unit"); rbErr != nil {
+			return rbErr
+		}
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT unit")
+	return err
+}
+
+// forUpdate locks the rows a select reads until the end of the transaction. SQLite needs no lock: it serializes
+// transactions over its single connection.
+func (c *SQLClient) forUpdate() string {
+	if c.dialect == Postgres {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// table returns the table of the collection the opts point at
+func table(opts model.Opts) (*Table, error) {
+	t, ok := Tables[opts.RetrieveCollection()]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNoTable, opts.RetrieveCollection())
+	}
+	return t, nil
+}
+
+// logger returns the *zerolog.Logger stored in the request context
+func logger(ctx context.Context, caller string) *zerolog.Logger {
+	log := ctx.Value(util.LoggerInContext).(*zerolog.Logger).With().Str("method", caller).Logger()
+	return &log
+}
+
+// document encodes a unit into the document it is stored as
+func document(unit model.Unit) (bson.M, error) {
+	raw, err := bson.Marshal(unit)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	switch id := doc["_id"].(type) {
+	case string:
+		if id != "" {
+			return doc, nil
+		}
+	case primitive.ObjectID:
+		doc["_id"] = id.Hex()
+		return doc, nil
+	}
+	doc["_id"] = primitive.NewObjectID().Hex()
+	return doc, nil
+}
+
+// normalize round trips a filter through bson, so its values compare with the values of documents
+func normalize(m bson.M) (bson.M, error) {
+	normalized := bson.M{}
+	if len(m) == 0 {
+		return normalized, nil
+	}
+	raw, err := bson.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return normalized, bson.Unmarshal(raw, &normalized)
+}
+
+// operator checks if a filter value is a query operator, like {"$in": [...]}
+func operator(v interface{}) bool {
+	switch d := v.(type) {
+	case bson.M:
+		for k := range d {
+			if strings.HasPrefix(k, "$") {
+				return true
+			}
+		}
+	case bson.D:
+		for _, e := range d {
+			if strings.HasPrefix(e.Key, "$") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bounds returns the bounds of a filter value built by model.Between
+func bounds(v interface{}) (bson.M, bool) {
+	d, ok := v.(bson.M)
+	if !ok || len(d) == 0 {
+		return nil, false
+	}
+	for k := range d {
+		if k != model.OpFrom && k != model.OpBefore {
+			return nil, false
+		}
+	}
+	return d, true
+}
+
+// value converts the value of a document field into the value of its column
+func value(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case string, bool, float64:
+		return x, nil
+	case int32:
+		return int64(x), nil
+	case int64:
+		return x, nil
+	case primitive.DateTime:
+		return x.Time().UTC().Format(timeLayout), nil
+	case primitive.ObjectID:
+		return x.Hex(), nil
+	}
+	return nil, fmt.Errorf("%T values can't be stored in a column", v)
+}
+
+func number(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// placeholders returns n numbered placeholders, starting at from
+func placeholders(from, n int) string {
+	p := make([]string, n)
+	for i := range p {
+		p[i] = fmt.Sprintf("$%d", from+i)
+	}
+	return strings.Join(p, ", ")
+}
+
+// duplicate checks if an error is a unique constraint violation, in either dialect
+func duplicate(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "UNIQUE constraint failed") || strings.Contains(msg, "SQLSTATE 23505")
+}
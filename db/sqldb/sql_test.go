@@ -0,0 +1,194 @@
+package sqldb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/util"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type SQLTest struct {
+	ctx context.Context
+	db  *SQLClient
+	suite.Suite
+}
+
+// SetupTest opens a fresh sqlite DB. Set PORT_TEST_POSTGRES to the URI of a postgres server to run the tests
+// against it instead; its schema is reverted and migrated again before every test.
+func (s *SQLTest) SetupTest() {
+	s.ctx = context.WithValue(context.Background(), util.LoggerInContext, config.NewLoggerWithWarn())
+	var err error
+	if dsn := os.Getenv("PORT_TEST_POSTGRES"); dsn != "" {
+		s.db, err = NewSQLClient(s.ctx, Postgres, dsn)
+		s.Require().NoError(err)
+		s.Require().NoError(s.db.MigrateTo(s.ctx, 0))
+	} else {
+		s.db, err = NewSQLClient(s.ctx, SQLite, filepath.Join(s.T().TempDir(), "port.db"))
+		s.Require().NoError(err)
+	}
+	s.Require().NoError(s.db.EnsureDBScaffold(s.ctx, true))
+}
+
+func (s *SQLTest) TearDownTest() {
+	s.Assert().NoError(s.db.Close(s.ctx))
+}
+
+// TestMigrations tests that migrations go down and back up, and that a DB with pending ones is refused
+func (s *SQLTest) TestMigrations() {
+	version, err := s.db.SchemaVersion(s.ctx)
+	s.Require().NoError(err)
+	s.Assert().Equal(s.db.latest(), version)
+
+	s.Require().NoError(s.db.MigrateTo(s.ctx, 0))
+	version, _ = s.db.SchemaVersion(s.ctx)
+	s.Assert().Equal(0, version)
+	s.Assert().Error(s.db.EnsureDBScaffold(s.ctx, false))
+	s.Assert().Error(s.db.Create(s.ctx, model.NewLink("l1", "s1", "https://example.com", "hash"), model.NewUnitOptions("port", model.LinkCollection)).Err)
+
+	s.Require().NoError(s.db.EnsureDBScaffold(s.ctx, true))
+	s.Assert().NoError(s.db.EnsureDBScaffold(s.ctx, false))
+}
+
+// TestCRUD tests the calls of the DB on links: bulk creation with per-item errors, paged and sorted listing,
+// versioned updates, and soft and hard deletes
+func (s *SQLTest) TestCRUD() {
+	opts := model.NewUnitOptions("port", model.LinkCollection)
+	units := make([]model.Unit, 5)
+	for i := range units {
+		units[i] = model.NewLink(fmt.Sprintf("l%d", i), fmt.Sprintf("s%d", i), fmt.Sprintf("https://example.com/%d", i), "hash")
+	}
+	dbResp := s.db.CreateAll(s.ctx, units, opts)
+	s.Require().NoError(dbResp.Err)
+	s.Assert().Equal(5, dbResp.Count)
+
+	dbResp = s.db.CreateAll(s.ctx, []model.Unit{model.NewLink("l5", "s5", "https://example.com", "hash"), units[0]}, opts)
+	s.Assert().ErrorIs(dbResp.Err, model.ErrPartialWrite)
+	s.Assert().Equal(1, dbResp.Count)
+	s.Require().Len(dbResp.Errors, 2)
+	s.Assert().NoError(dbResp.Errors[0])
+	s.Assert().ErrorIs(dbResp.Errors[1], model.ErrDuplicate)
+
+	var links []*model.Link
+	dbResp = s.db.List(s.ctx, model.Filter{}, &links, &model.ListOpts{Sort: []model.Sort{{Field: "slug", Desc: true}}, Offset: 1, Limit: 2}, opts)
+	s.Require().NoError(dbResp.Err)
+	s.Require().Equal(2, dbResp.Count)
+	s.Assert().Equal("s4", links[0].Slug)
+	s.Assert().Equal("s3", links[1].Slug)
+
+	dbResp = s.db.Update(s.ctx, model.Filter{"_id": "l0", model.VersionField: 0}, model.Fields{"target": "https://example.com/v1"}, opts)
+	s.Assert().NoError(dbResp.Err)
+	dbResp = s.db.Update(s.ctx, model.Filter{"_id": "l0", model.VersionField: 0}, model.Fields{"target": "https://example.com/stale"}, opts)
+	s.Assert().ErrorIs(dbResp.Err, model.ErrConflict)
+	link := &model.Link{}
+	s.Require().NoError(s.db.Read(s.ctx, model.Filter{"slug": "s0"}, link, opts).Err)
+	s.Assert().Equal("https://example.com/v1", link.Target)
+	s.Assert().EqualValues(1, link.Version)
+	s.Assert().ErrorIs(s.db.Read(s.ctx, model.Filter{"target": "https://example.com/v1"}, link, opts).Err, ErrUnsupportedFilter)
+
+	s.Assert().ErrorIs(s.db.Delete(s.ctx, model.Filter{}, model.HardDelete, opts).Err, model.ErrEmptyFilter)
+	dbResp = s.db.Delete(s.ctx, model.ByID("l0"), model.SoftDelete, opts)
+	s.Assert().NoError(dbResp.Err)
+	s.Assert().Equal(1, dbResp.Count)
+	s.Assert().ErrorIs(s.db.Read(s.ctx, model.ByID("l0"), link, opts).Err, model.ErrNotFound)
+
+	dbResp = s.db.Delete(s.ctx, model.Filter{model.VersionField: 0}, model.HardDelete, opts)
+	s.Assert().NoError(dbResp.Err)
+	s.Assert().Equal(5, dbResp.Count)
+	// soft deleted records still go with hard deletes
+	dbResp = s.db.Delete(s.ctx, model.ByID("l0"), model.HardDelete, opts)
+	s.Assert().NoError(dbResp.Err)
+	s.Assert().Equal(1, dbResp.Count)
+}
+
+// TestConcurrentUpdates tests that of many conditional updates racing for the same ticket, exactly one wins
+func (s *SQLTest) TestConcurrentUpdates() {
+	opts := model.NewUnitOptions("port", model.TicketCollection)
+	s.Require().NoError(s.db.Create(s.ctx, model.NewTicket("t1", "u1", "gophercon", "", time.Now(), time.Now().Add(time.Hour)), opts).Err)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	won, lost := 0, 0
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(device int) {
+			defer wg.Done()
+			dbResp := s.db.Update(s.ctx, model.Filter{"_id": "t1", "redeemed_at": nil}, model.Fields{"redeemed_at": time.Now(), "redeemed_by": fmt.Sprint(device)}, opts)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case dbResp.Err == nil:
+				won++
+			case errors.Is(dbResp.Err, model.ErrNotFound):
+				lost++
+			}
+		}(i)
+	}
+	wg.Wait()
+	s.Assert().Equal(1, won)
+	s.Assert().Equal(15, lost)
+
+	t := &model.Ticket{}
+	s.Assert().ErrorIs(s.db.Read(s.ctx, model.Filter{"_id": "t1", "redeemed_at": nil}, t, opts).Err, model.ErrNotFound)
+	s.Require().NoError(s.db.Read(s.ctx, model.ByID("t1"), t, opts).Err)
+	s.Assert().NotNil(t.RedeemedAt)
+}
+
+// TestUsers tests that the role set of a user maps to rows of user_roles
+func (s *SQLTest) TestUsers() {
+	opts := model.NewUnitOptions(model.UserDB, model.UserCollection)
+	birth := "22/07/1999"
+	admin, viewer := int64(7), int64(1)
+	user := &model.User{Name: &model.Name{FirstName: "ayobami", LastName: "bamigboye"}, Birth: &birth, Roles: &model.RoleSet{{"admin": &admin}, {"viewer": &viewer}}}
+	dbResp := s.db.Create(s.ctx, user, opts)
+	s.Require().NoError(dbResp.Err)
+	s.Assert().Len(dbResp.ID, 24)
+
+	var roles int
+	s.Require().NoError(s.db.db.QueryRow("SELECT COUNT(*) FROM user_roles WHERE user_id = $1", dbResp.ID).Scan(&roles))
+	s.Assert().Equal(2, roles)
+
+	read := &model.User{}
+	s.Require().NoError(s.db.Read(s.ctx, model.Filter{"firstname": "ayobami"}, read, opts).Err)
+	s.Assert().Equal(user.Roles, read.Roles)
+
+	var users []*model.User
+	s.Require().NoError(s.db.List(s.ctx, model.Filter{model.RoleField: "viewer"}, &users, nil, opts).Err)
+	s.Assert().Len(users, 1)
+	s.Require().NoError(s.db.List(s.ctx, model.Filter{model.RoleField: "owner"}, &users, nil, opts).Err)
+	s.Assert().Empty(users)
+
+	s.Require().NoError(s.db.Delete(s.ctx, model.ByID(dbResp.ID), model.HardDelete, opts).Err)
+	s.Require().NoError(s.db.db.QueryRow("SELECT COUNT(*) FROM user_roles WHERE user_id = $1", dbResp.ID).Scan(&roles))
+	s.Assert().Equal(0, roles)
+}
+
+// TestRanges tests that model.Between selects the records within its bounds, leaving unset bounds open
+func (s *SQLTest) TestRanges() {
+	opts := model.NewUnitOptions("port", model.ScanCollection)
+	start := time.Date(2026, 6, 1, 18, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		scan := model.NewScan(fmt.Sprintf("s%d", i), "l1", "mobile", "", "hash")
+		scan.At = start.Add(time.Duration(i) * time.Hour)
+		s.Require().NoError(s.db.Create(s.ctx, scan, opts).Err)
+	}
+	var scans []*model.Scan
+	s.Require().NoError(s.db.List(s.ctx, model.Filter{"link_id": "l1", "at": model.Between(start.Add(time.Hour), start.Add(3*time.Hour))}, &scans, &model.ListOpts{Sort: []model.Sort{{Field: "at"}}}, opts).Err)
+	s.Require().Len(scans, 2)
+	s.Assert().Equal("s1", scans[0].ID)
+	s.Assert().Equal("s2", scans[1].ID)
+
+	s.Require().NoError(s.db.List(s.ctx, model.Filter{"at": model.Between(start.Add(2*time.Hour), nil)}, &scans, nil, opts).Err)
+	s.Assert().Len(scans, 2)
+}
+
+func TestSQLTest(t *testing.T) {
+	suite.Run(t, new(SQLTest))
+}
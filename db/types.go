@@ -14,14 +14,79 @@ type DB interface {
 	Host() string
 
 	Create(context.Context, model.Unit, model.Opts) *model.DBResponse
-	CreateAll(context.Context, []model.Unit, model.CreateOpts) *model.DBResponse
-	//Read(ReadOpts) *DBResponse
-	//Update(UpdateOpts) *DBResponse
-	//Delete(DeleteOpts) *DBResponse
+	// CreateAll creates all the units passed in with a single call. The units must all be of the same kind. A
+	// unit failing doesn't stop the others: the call responds with model.ErrPartialWrite, the number of units
+	// created as its Count, and the error of each unit as its Errors
+	CreateAll(context.Context, []model.Unit, model.Opts) *model.DBResponse
+	// Read decodes the first record matching the filter into the unit passed in. It responds with model.ErrNotFound
+	// when no record matches. Reading by id is Read(ctx, model.ByID(id), unit, opts)
+	Read(context.Context, model.Filter, model.Unit, model.Opts) *model.DBResponse
+	// Update sets the fields on the first record matching the filter, atomically, and increments its
+	// model.VersionField. It responds with model.ErrNotFound when no record matches, so the filter doubles as the
+	// condition of the update, and with model.ErrConflict when the filter holds a version the record has moved past
+	Update(context.Context, model.Filter, model.Fields, model.Opts) *model.DBResponse
+	// List decodes the records matching the filter into the slice units points at, paged and ordered by list,
+	// which may be nil. It responds with the number of records decoded as its Count
+	List(ctx context.Context, filter model.Filter, units interface{}, list *model.ListOpts, opts model.Opts) *model.DBResponse
+	// Delete deletes every record matching the filter, softly or for good. It responds with the number of records
+	// deleted as its Count, with model.ErrNotFound when no record matches, and refuses empty filters
+	Delete(ctx context.Context, filter model.Filter, mode model.DeleteMode, opts model.Opts) *model.DBResponse
 
 	// Ensure the CRUD dependents is all set up, including databases, collections, tables, etc.
 	// This is DB engine specific. The override flag is used to decide if the missing scaffold chould be created or not
 	EnsureDBScaffold(ctx context.Context, override bool) error
+	// Close releases the DB on shutdown
+	Close(ctx context.Context) error
+}
+
+// Migrator is a DB whose schema is versioned by migrations
+type Migrator interface {
+	// Migrations lists the migrations of the DB, ordered by version
+	Migrations() []model.Migration
+	// SchemaVersion returns the version of the last migration applied, 0 before any
+	SchemaVersion(ctx context.Context) (int, error)
+	// MigrateTo applies, or reverts, migrations one at a time until the schema is at the target version
+	MigrateTo(ctx context.Context, target int) error
+}
+
+// Pending returns the migrations not yet applied to the DB
+func Pending(ctx context.Context, m Migrator) ([]model.Migration, error) {
+	version, err := m.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []model.Migration
+	for _, mig := range m.Migrations() {
+		if mig.Version > version {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Plan returns the migrations MigrateTo would go through to reach the target version, in the order it would, and
+// whether it would apply them or revert them
+func Plan(ctx context.Context, m Migrator, target int) ([]model.Migration, bool, error) {
+	version, err := m.SchemaVersion(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	migrations := m.Migrations()
+	var steps []model.Migration
+	if target >= version {
+		for _, mig := range migrations {
+			if mig.Version > version && mig.Version <= target {
+				steps = append(steps, mig)
+			}
+		}
+		return steps, true, nil
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if mig := migrations[i]; mig.Version <= version && mig.Version > target {
+			steps = append(steps, mig)
+		}
+	}
+	return steps, false, nil
 }
 
 type Unit interface {
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRU is a fixed size, least recently used cache. It's safe for concurrent use.
+type LRU[K comparable, V any] struct {
+	sync.Mutex
+	size    int
+	order   *list.List
+	entries map[K]*list.Element
+}
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// NewLRU returns an LRU holding up to size entries. A size below one holds nothing.
+func NewLRU[K comparable, V any](size int) *LRU[K, V] {
+	return &LRU[K, V]{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[K]*list.Element),
+	}
+}
+
+// Get returns the value cached under key, marking it as the most recently used
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.Lock()
+	defer c.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*entry[K, V]).value, true
+}
+
+// Add caches value under key, evicting the least recently used entry when the cache is full. It reports whether
+// an entry was evicted.
+func (c *LRU[K, V]) Add(key K, value V) bool {
+	c.Lock()
+	defer c.Unlock()
+	if c.size < 1 {
+		return false
+	}
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*entry[K, V]).value = value
+		c.order.MoveToFront(el)
+		return false
+	}
+	c.entries[key] = c.order.PushFront(&entry[K, V]{key: key, value: value})
+	if c.order.Len() <= c.size {
+		return false
+	}
+	oldest := c.order.Back()
+	c.order.Remove(oldest)
+	delete(c.entries, oldest.Value.(*entry[K, V]).key)
+	return true
+}
+
+// Remove drops the entry cached under key, if there is one
+func (c *LRU[K, V]) Remove(key K) {
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// Len returns the number of entries in the cache
+func (c *LRU[K, V]) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type LRUTest struct {
+	suite.Suite
+}
+
+// TestEviction tests that the least recently used entry is the one evicted once the cache is full
+func (s *LRUTest) TestEviction() {
+	c := NewLRU[string, int](2)
+	s.Assert().False(c.Add("a", 1))
+	s.Assert().False(c.Add("b", 2))
+
+	v, ok := c.Get("a")
+	s.Require().True(ok)
+	s.Assert().Equal(1, v)
+
+	s.Assert().True(c.Add("c", 3))
+	_, ok = c.Get("b")
+	s.Assert().False(ok)
+	_, ok = c.Get("a")
+	s.Assert().True(ok)
+	s.Assert().Equal(2, c.Len())
+
+	s.Assert().False(c.Add("a", 10))
+	v, _ = c.Get("a")
+	s.Assert().Equal(10, v)
+
+	c.Remove("a")
+	_, ok = c.Get("a")
+	s.Assert().False(ok)
+	s.Assert().Equal(1, c.Len())
+}
+
+// TestDisabled tests that a cache without room holds nothing
+func (s *LRUTest) TestDisabled() {
+	c := NewLRU[string, int](0)
+	s.Assert().False(c.Add("a", 1))
+	_, ok := c.Get("a")
+	s.Assert().False(ok)
+	s.Assert().Equal(0, c.Len())
+}
+
+// TestConcurrent tests that the cache can be used from many goroutines at once
+func (s *LRUTest) TestConcurrent() {
+	c := NewLRU[string, int](16)
+	done := make(chan struct{})
+	for g := 0; g < 8; g++ {
+		go func(g int) {
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprint(i % 32)
+				c.Add(key, g)
+				c.Get(key)
+			}
+			done <- struct{}{}
+		}(g)
+	}
+	for g := 0; g < 8; g++ {
+		<-done
+	}
+	s.Assert().Equal(16, c.Len())
+}
+
+func TestLRUTest(t *testing.T) {
+	suite.Run(t, new(LRUTest))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -19,8 +20,9 @@ var (
 )
 
 const (
-	SessionInContext = "session"
-	DefaultBucket    = "port-elvis-gargantuan-panda" // https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html
+	SessionInContext   = "session"
+	DefaultBucket      = "port-elvis-gargantuan-panda" // https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html
+	DefaultContentType = "image/png"
 )
 
 const (
@@ -69,7 +71,7 @@ func (c *Compose) NewSessionWithOptions(ctx context.Context) (*Interaction, erro
 		Region: aws.String("us-west-2"),
 	}})
 	if err != nil {
-		alog.Error().Msgf("creating session with aws failed with %w", err)
+		alog.Error().Msgf("creating session with aws failed with %v", err)
 		return nil, fmt.Errorf("creating session with aws failed with %w", err)
 	}
 	alog.Debug().Msg("creating session with aws successful")
@@ -152,16 +154,28 @@ type S3 struct {
 	store *s3.S3
 	// loc is the location of the file on disk to be uploaded
 	loc string
+	// mime is the content type the object is uploaded with
+	mime string
 }
 
 func NewS3(ctx context.Context) *S3 {
+	mime, _ := util.RetrieveFromCtx(ctx, util.QRMimeInContext).(string)
+	if mime == "" {
+		mime = DefaultContentType
+	}
 	return &S3{
 		id:    util.RetrieveReqIDFromCtx(ctx),
 		store: s3.New(util.RetrieveFromCtx(ctx, SessionInContext).(*session.Session)),
 		loc:   util.RetrieveFromCtx(ctx, util.QRLocInContext).(string),
+		mime:  mime,
 	}
 }
 
+// key returns the object key of the upload. It is the request id, carrying the extension of the file on disk
+func (s *S3) key() string {
+	return s.id + filepath.Ext(s.loc)
+}
+
 func (s *S3) List(ctx context.Context, response chan *Response) {
 	log := util.RetrieveLoggerFromCtx(ctx).WithMethod("S3.List()")
 	buckets, err := s.store.ListBuckets(nil)
@@ -171,6 +185,7 @@ func (s *S3) List(ctx context.Context, response chan *Response) {
 			Data: nil,
 			Err:  err,
 		}
+		return
 	}
 	log.Debug().Msgf("successfully listed s3 buckets: %#v", buckets)
 	response <- &Response{
@@ -198,102 +213,100 @@ func (s *S3) Upload(ctx context.Context, sess *session.Session, response chan *S
 	log := util.RetrieveLoggerFromCtx(ctx).WithMethod("S3.Upload()")
 	awsDefaultBucket := aws.String(DefaultBucket)
 
+	if err := s.ensureBucket(ctx, DefaultBucket); err != nil {
+		response <- &S3UploadFileResponse{Err: err}
+		return
+	}
+
+	log.Debug().Msgf("preparing to upload to bucket: %s", DefaultBucket)
+	uploader := s3manager.NewUploader(sess)
+
+	// open file for upload
+	log.Debug().Msgf("opening file to be uploaded: %s", DefaultBucket)
+	file, err := os.Open(s.loc)
+	if err != nil {
+		log.Error().Err(fmt.Errorf("error while trying to open file %v for upload: %w", s.loc, err))
+		response <- &S3UploadFileResponse{Err: err}
+		return
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Error().Err(fmt.Errorf("error while trying to close file: %v", s.loc))
+		}
+	}(file)
+
+	// begin upload
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		ACL:         aws.String("public-read"),
+		Bucket:      awsDefaultBucket,
+		Key:         aws.String(s.key()),
+		Body:        file,
+		ContentType: aws.String(s.mime),
+	})
+	if err != nil {
+		log.Error().Err(fmt.Errorf("error while trying to prepare file %v for upload: %w", s.loc, err))
+		response <- &S3UploadFileResponse{Err: err}
+		return
+	}
+	log.Debug().Msgf("file %v uploaded successfully", s.loc)
+
+	// retrieve the url to the file
+	log.Debug().Msgf("retrieving url to uploaded object")
+	urlLocation := objectURL(s.store, DefaultBucket, s.key())
+	log.Debug().Msgf("file uploaded to %s", urlLocation)
+	response <- &S3UploadFileResponse{
+		URL: urlLocation,
+		Err: err,
+	}
+}
+
+// ensureBucket creates bucket if it isn't among the buckets in the account configured in the credentials,
+// and waits for it to exist
+func (s *S3) ensureBucket(ctx context.Context, bucket string) error {
+	log := util.RetrieveLoggerFromCtx(ctx).WithMethod("S3.ensureBucket()")
+
 	// get the list of all the buckets in the account configured in the credentials
 	listResp := s.listBucket(ctx)
 	if listResp.Err != nil {
 		log.Error().Err(fmt.Errorf("received err from S3.List handler: %w", listResp.Err))
-		response <- &S3UploadFileResponse{
-			Err: listResp.Err,
-		}
+		return listResp.Err
 	}
 
 	// check if specified bucket is created already
 	//var bucket *s3.Bucket TODO: Enable Bucket CLS and uploaded object public access
-	found := false
 	for i := 0; i < len(listResp.Data); i++ {
-		if *listResp.Data[i].Name == DefaultBucket {
-			found = !found
-			//bucket = listResp.Data[i]
+		if *listResp.Data[i].Name == bucket {
+			return nil
 		}
 	}
 
-	if !found {
-		log.Debug().Msg("bucket not found, proceeding to create one")
+	log.Debug().Msg("bucket not found, proceeding to create one")
+	_, err := s.store.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		log.Error().Err(fmt.Errorf("encountered error: %w while trying to create s3 buckets", err))
+		return err
+	}
 
-		inp := &s3.CreateBucketInput{
-			Bucket: awsDefaultBucket,
-		}
-		_, err := s.store.CreateBucket(inp)
-		if err != nil {
-			log.Error().Err(fmt.Errorf("encountered error: %w while trying to create s3 buckets", err))
-			response <- &S3UploadFileResponse{
-				Err: err,
-			}
-		}
+	err = s.store.WaitUntilBucketExistsWithContext(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		log.Error().Err(fmt.Errorf("encountered error: %w while waiting for bucket to get created", err))
+		return err
+	}
 
-		err = s.store.WaitUntilBucketExists(&s3.HeadBucketInput{
-			Bucket: awsDefaultBucket,
-		})
-		if err != nil {
-			log.Error().Err(fmt.Errorf("encountered error: %w while waiting for bucket to get created", err))
-			response <- &S3UploadFileResponse{
-				Err: err,
-			}
-		}
+	log.Debug().Msgf("bucket created: %s", bucket)
+	return nil
+}
 
-		log.Debug().Msgf("bucket created: %s", DefaultBucket)
-		//} else { TODO: Enable Bucket CLS and uploaded object public access
-		//	//res, err := s.store.GetBucketAcl(&s3.GetBucketAclInput{Bucket: bucket.Name})
-		//	//if err != nil {
-		//	//	util.ExitOnErrorf("retrieving Bucket ACL failed with: %w", err)
-		//	//}
-		//	//res.Grants
-		//	s.store.PutBucketAcl(&s3.PutBucketAclInput{Bucket: aws.String(DefaultBucket), ACL: aws.String("public-read")})
-		//	s.store.PutBucketAcl()
-		//}
-
-		log.Debug().Msgf("preparing to upload to bucket: %s", DefaultBucket)
-		uploader := s3manager.NewUploader(sess)
-
-		// open file for upload
-		log.Debug().Msgf("opening file to be uploaded: %s", DefaultBucket)
-		file, err := os.Open(s.loc)
-		if err != nil {
-			log.Error().Err(fmt.Errorf("error while trying to open file %v for upload: %w", s.loc, err))
-			response <- &S3UploadFileResponse{Err: err}
-		}
-		defer func(file *os.File) {
-			err := file.Close()
-			if err != nil {
-				log.Error().Err(fmt.Errorf("error while trying to close file: %v", s.loc))
-			}
-		}(file)
-
-		// begin upload
-		_, err = uploader.Upload(&s3manager.UploadInput{
-			ACL:         aws.String("public-read"),
-			Bucket:      awsDefaultBucket,
-			Key:         aws.String(s.id),
-			Body:        file,
-			ContentType: aws.String("image/jpg"),
-		})
-		if err != nil {
-			log.Error().Err(fmt.Errorf("error while trying to prepare file %v for upload: %w", s.loc, err))
-			response <- &S3UploadFileResponse{Err: err}
-		}
-		log.Debug().Msgf("file %v uploaded successfully", s.loc)
-
-		// retrieve the url to the file
-		log.Debug().Msgf("retrieving url to uploaded object")
-		req, _ := s3.New(sess).GetObjectRequest(&s3.GetObjectInput{Bucket: awsDefaultBucket, Key: aws.String(s.id)})
-		rest.Build(req)
-		urlLocation := req.HTTPRequest.URL.String()
-		log.Debug().Msgf("file uploaded to %s", urlLocation)
-		response <- &S3UploadFileResponse{
-			URL: urlLocation,
-			Err: err,
-		}
-	}
+// objectURL builds the address of the object saved under key in bucket
+func objectURL(store *s3.S3, bucket, key string) string {
+	req, _ := store.GetObjectRequest(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	rest.Build(req)
+	return req.HTTPRequest.URL.String()
 }
 
 func (s *S3) CreateBucket(ctx context.Context, response chan *Response) {
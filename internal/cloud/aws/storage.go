@@ -0,0 +1,143 @@
+package amazon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/dark-enstein/port/internal/cloud"
+	"io"
+	"sync"
+)
+
+var (
+	S3Kind = "s3"
+)
+
+// S3Storage is a cloud.Storage backed by an S3 bucket. Objects are uploaded publicly readable, and clients fetch
+// them from S3 directly.
+type S3Storage struct {
+	bucket   string
+	sess     *session.Session
+	store    *s3.S3
+	uploader *s3manager.Uploader
+
+	// ready guards the one time creation of the bucket
+	ready sync.Once
+	err   error
+}
+
+// NewS3Storage creates an S3Storage on bucket, authenticating with the shared credentials of profile
+func NewS3Storage(profile, region, bucket string) (*S3Storage, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{Profile: profile, Config: aws.Config{
+		Region: aws.String(region),
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("creating session with aws failed with %w", err)
+	}
+	return &S3Storage{
+		bucket:   bucket,
+		sess:     sess,
+		store:    s3.New(sess),
+		uploader: s3manager.NewUploader(sess),
+	}, nil
+}
+
+func (s *S3Storage) Kind() string {
+	return S3Kind
+}
+
+// Put uploads data to the bucket, creating the bucket on first use
+func (s *S3Storage) Put(ctx context.Context, key, contentType string, data []byte) (*cloud.Object, error) {
+	if err := cloud.ValidateKey(key); err != nil {
+		return nil, err
+	}
+	s.ready.Do(func() {
+		s.err = (&S3{store: s.store}).ensureBucket(ctx, s.bucket)
+	})
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		ACL:         aws.String("public-read"),
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("uploading object %v failed with %w", key, err)
+	}
+	return &cloud.Object{Key: key, ContentType: contentType, Size: int64(len(data))}, nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) ([]byte, *cloud.Object, error) {
+	out, err := s.store.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, nil, notFound(err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading object %v failed with %w", key, err)
+	}
+	return data, &cloud.Object{
+		Key:         key,
+		ContentType: aws.StringValue(out.ContentType),
+		Size:        int64(len(data)),
+		Modified:    aws.TimeValue(out.LastModified),
+	}, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.store.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil {
+		return notFound(err)
+	}
+	_, err = s.store.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	return err
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]*cloud.Object, error) {
+	var res []*cloud.Object
+	err := s.store.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, o := range page.Contents {
+			res = append(res, &cloud.Object{
+				Key:      aws.StringValue(o.Key),
+				Size:     aws.Int64Value(o.Size),
+				Modified: aws.TimeValue(o.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return res, nil
+}
+
+// URL returns the address of the object in the bucket
+func (s *S3Storage) URL(key string) string {
+	return objectURL(s.store, s.bucket, key)
+}
+
+// notFound translates the S3 missing key and bucket errors into cloud.ErrObjectNotFound
+func notFound(err error) error {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchBucket, "NotFound":
+			return cloud.ErrObjectNotFound
+		}
+	}
+	return err
+}
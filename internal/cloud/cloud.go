@@ -1,5 +1,18 @@
 package cloud
 
+import (
+	"context"
+	"errors"
+	"github.com/dark-enstein/port/util"
+	"strings"
+	"time"
+)
+
+var (
+	ErrObjectNotFound = errors.New("object not found")
+	ErrInvalidKey     = errors.New("object key is invalid")
+)
+
 type Session interface {
 	Kind() string
 }
@@ -7,6 +20,44 @@ type Session interface {
 type Client interface {
 }
 
+// Storage defines a blob store that generated codes are saved to and served from
+type Storage interface {
+	// Kind returns the name of the storage backend
+	Kind() string
+	// Put saves data under key, overwriting any object already saved under it
+	Put(ctx context.Context, key, contentType string, data []byte) (*Object, error)
+	// Get returns the data and description of the object saved under key. It returns ErrObjectNotFound if there is none
+	Get(ctx context.Context, key string) ([]byte, *Object, error)
+	// Delete removes the object saved under key. It returns ErrObjectNotFound if there is none
+	Delete(ctx context.Context, key string) error
+	// List returns the description of every object whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]*Object, error)
+	// URL returns the address clients can fetch the object saved under key from
+	URL(key string) string
+}
+
+// Object describes a blob held by a Storage backend
+type Object struct {
+	Key         string
+	ContentType string
+	Size        int64
+	Modified    time.Time
+}
+
+// ValidateKey checks that key is usable as an object key on every backend. Keys are flat; they can't contain path
+// separators or traverse directories.
+func ValidateKey(key string) error {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// RetrieveStorageFromCtx returns the Storage stored in the request context
+func RetrieveStorageFromCtx(ctx context.Context) Storage {
+	return ctx.Value(util.StorageInContext).(Storage)
+}
+
 //type Cloud interface { // TODO: Implement an interface that can be an entry point to all the dependent cloud providers. See if that is possible. I don't think Go supports it as of yet.
 //	Do() (string, error)
 //	//BeginInteraction(ctx context.Context) (*Session, error) // TODO: when this method is defined under this interface I am not able to successfully initialize it in any of the cloud provider Compose consumer structs; the interface keeps rejecting the base cloud struct even though all of its methods are defined by the consumer struct.
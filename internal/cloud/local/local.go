@@ -0,0 +1,131 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/internal/cloud"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	Local       = "local"
+	FilesRoute  = "/files/"
+	DefaultMime = "application/octet-stream"
+)
+
+// Disk is a cloud.Storage backed by a directory on the local filesystem. Its objects are served by port itself,
+// from the files route.
+type Disk struct {
+	dir     string
+	baseURL string
+}
+
+// NewDisk creates a Disk storing its objects in dir, and addressing them relative to baseURL.
+// The directory is created if it doesn't exist.
+func NewDisk(dir, baseURL string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory %v: %w", dir, err)
+	}
+	return &Disk{dir: dir, baseURL: strings.TrimRight(baseURL, "/")}, nil
+}
+
+func (d *Disk) Kind() string {
+	return Local
+}
+
+// Put writes data to a file named after key
+func (d *Disk) Put(ctx context.Context, key, contentType string, data []byte) (*cloud.Object, error) {
+	if err := cloud.ValidateKey(key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(d.path(key), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write object %v: %w", key, err)
+	}
+	return d.stat(key)
+}
+
+// Get reads the file named after key
+func (d *Disk) Get(ctx context.Context, key string) ([]byte, *cloud.Object, error) {
+	if err := cloud.ValidateKey(key); err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(d.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, cloud.ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read object %v: %w", key, err)
+	}
+	obj, err := d.stat(key)
+	return data, obj, err
+}
+
+// Delete removes the file named after key
+func (d *Disk) Delete(ctx context.Context, key string) error {
+	if err := cloud.ValidateKey(key); err != nil {
+		return err
+	}
+	err := os.Remove(d.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return cloud.ErrObjectNotFound
+	}
+	return err
+}
+
+// List describes the files in the storage directory whose names start with prefix
+func (d *Disk) List(ctx context.Context, prefix string) ([]*cloud.Object, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage directory %v: %w", d.dir, err)
+	}
+	res := make([]*cloud.Object, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		obj, err := d.stat(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, obj)
+	}
+	return res, nil
+}
+
+// URL returns the address of the object on port's files route
+func (d *Disk) URL(key string) string {
+	return d.baseURL + FilesRoute + key
+}
+
+func (d *Disk) path(key string) string {
+	return filepath.Join(d.dir, key)
+}
+
+func (d *Disk) stat(key string) (*cloud.Object, error) {
+	info, err := os.Stat(d.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, cloud.ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cloud.Object{
+		Key:         key,
+		ContentType: contentType(key),
+		Size:        info.Size(),
+		Modified:    info.ModTime(),
+	}, nil
+}
+
+// contentType infers the content type of an object from the extension of its key
+func contentType(key string) string {
+	if t := mime.TypeByExtension(filepath.Ext(key)); t != "" {
+		return t
+	}
+	return DefaultMime
+}
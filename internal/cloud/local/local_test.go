@@ -0,0 +1,61 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"github.com/dark-enstein/port/internal/cloud"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type DiskTest struct {
+	disk *Disk
+	ctx  context.Context
+	suite.Suite
+}
+
+func (s *DiskTest) SetupTest() {
+	fmt.Println("Starting tests...")
+	var err error
+	s.ctx = context.Background()
+	s.disk, err = NewDisk(s.T().TempDir(), "http://localhost:8090/")
+	s.Require().NoError(err)
+	fmt.Println("Tests startup complete...")
+}
+
+// TestRoundTrip tests that objects put on disk can be read, listed and deleted
+func (s *DiskTest) TestRoundTrip() {
+	obj, err := s.disk.Put(s.ctx, "abc.svg", "image/svg+xml", []byte("<svg/>"))
+	s.Require().NoError(err)
+	s.Assert().Equal(int64(6), obj.Size)
+	s.Assert().Equal("http://localhost:8090/files/abc.svg", s.disk.URL(obj.Key))
+
+	data, obj, err := s.disk.Get(s.ctx, "abc.svg")
+	s.Require().NoError(err)
+	s.Assert().Equal("<svg/>", string(data))
+	s.Assert().Equal("image/svg+xml", obj.ContentType)
+
+	list, err := s.disk.List(s.ctx, "ab")
+	s.Require().NoError(err)
+	s.Assert().Len(list, 1)
+
+	s.Require().NoError(s.disk.Delete(s.ctx, "abc.svg"))
+	_, _, err = s.disk.Get(s.ctx, "abc.svg")
+	s.Assert().ErrorIs(err, cloud.ErrObjectNotFound)
+}
+
+// TestInvalidKey tests that keys can't escape the storage directory
+func (s *DiskTest) TestInvalidKey() {
+	_, err := s.disk.Put(s.ctx, "../escape.png", "image/png", []byte{})
+	s.Assert().ErrorIs(err, cloud.ErrInvalidKey)
+	_, _, err = s.disk.Get(s.ctx, "..")
+	s.Assert().ErrorIs(err, cloud.ErrInvalidKey)
+}
+
+func (s *DiskTest) TearDownSuite() {
+	fmt.Println("All testing complete")
+}
+
+func TestDiskTest(t *testing.T) {
+	suite.Run(t, new(DiskTest))
+}
@@ -0,0 +1,92 @@
+package memory
+
+import (
+	"context"
+	"github.com/dark-enstein/port/internal/cloud"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	Memory     = "memory"
+	FilesRoute = "/files/"
+)
+
+type object struct {
+	data []byte
+	desc cloud.Object
+}
+
+// Store is a cloud.Storage holding its objects in memory. It is meant for tests and throwaway deployments;
+// everything is lost when the process exits. Its objects are served by port itself, from the files route.
+type Store struct {
+	sync.RWMutex
+	objects map[string]*object
+	baseURL string
+}
+
+func NewStore(baseURL string) *Store {
+	return &Store{objects: map[string]*object{}, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+func (s *Store) Kind() string {
+	return Memory
+}
+
+func (s *Store) Put(ctx context.Context, key, contentType string, data []byte) (*cloud.Object, error) {
+	if err := cloud.ValidateKey(key); err != nil {
+		return nil, err
+	}
+	obj := &object{
+		data: append([]byte(nil), data...),
+		desc: cloud.Object{Key: key, ContentType: contentType, Size: int64(len(data)), Modified: time.Now()},
+	}
+	s.Lock()
+	s.objects[key] = obj
+	s.Unlock()
+	desc := obj.desc
+	return &desc, nil
+}
+
+func (s *Store) Get(ctx context.Context, key string) ([]byte, *cloud.Object, error) {
+	s.RLock()
+	defer s.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, nil, cloud.ErrObjectNotFound
+	}
+	desc := obj.desc
+	return append([]byte(nil), obj.data...), &desc, nil
+}
+
+func (s *Store) Delete(ctx context.Context, key string) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.objects[key]; !ok {
+		return cloud.ErrObjectNotFound
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+// List describes the objects whose keys start with prefix, ordered by key
+func (s *Store) List(ctx context.Context, prefix string) ([]*cloud.Object, error) {
+	s.RLock()
+	defer s.RUnlock()
+	res := make([]*cloud.Object, 0, len(s.objects))
+	for k, v := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			desc := v.desc
+			res = append(res, &desc)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res, nil
+}
+
+// URL returns the address of the object on port's files route
+func (s *Store) URL(key string) string {
+	return s.baseURL + FilesRoute + key
+}
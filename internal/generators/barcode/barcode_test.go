@@ -0,0 +1,128 @@
+package barcode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/internal/generators"
+	"github.com/dark-enstein/port/internal/generators/qr"
+	"github.com/dark-enstein/port/util"
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/aztec"
+	"github.com/makiuchi-d/gozxing/datamatrix"
+	"github.com/makiuchi-d/gozxing/oned"
+	"github.com/stretchr/testify/suite"
+	"image"
+	"image/png"
+	"testing"
+)
+
+type BarcodeTest struct {
+	ctx   context.Context
+	codes []CodeTable
+	suite.Suite
+}
+
+// CodeTable defines a sample symbol, the reader that decodes it, and the text it is expected to decode to
+type CodeTable struct {
+	kind     generators.Symbology
+	content  string
+	opts     generators.Options
+	reader   gozxing.Reader
+	expected string
+}
+
+func (s *BarcodeTest) SetupTest() {
+	fmt.Println("Starting tests...")
+	s.ctx = context.WithValue(context.Background(), util.LoggerInContext, config.NewLoggerWithWarn())
+	s.codes = []CodeTable{
+		{kind: KindCode128, content: "PORT-0042", reader: oned.NewCode128Reader(), expected: "PORT-0042"},
+		{kind: KindEAN13, content: "400638133393", reader: oned.NewEAN13Reader(), expected: "4006381333931"},
+		{kind: KindUPCA, content: "03600029145", reader: oned.NewEAN13Reader(), expected: "0036000291452"},
+		{kind: KindDataMatrix, content: "https://github.com/dark-enstein/port", reader: datamatrix.NewDataMatrixReader(), expected: "https://github.com/dark-enstein/port"},
+		{kind: KindAztec, content: "port aztec", opts: generators.Options{"ecc_percent": float64(50)}, reader: aztec.NewAztecReader(), expected: "port aztec"},
+	}
+	fmt.Println("Tests startup complete...")
+}
+
+// TestRegistry tests that every symbology is registered, and unknown kinds are rejected
+func (s *BarcodeTest) TestRegistry() {
+	for _, kind := range []generators.Symbology{KindCode128, KindEAN13, KindUPCA, KindDataMatrix, KindAztec, KindPDF417, qr.Kind} {
+		e, err := generators.Lookup(kind.String())
+		s.Require().NoError(err)
+		s.Assert().Equal(kind, e.Kind())
+	}
+	_, err := generators.Lookup("gif")
+	s.Assert().ErrorIs(err, generators.ErrUnknownKind)
+}
+
+// TestRoundTrip tests that rendered symbols decode back to their content
+func (s *BarcodeTest) TestRoundTrip() {
+	for _, table := range s.codes {
+		e, err := generators.Lookup(table.kind.String())
+		s.Require().NoError(err)
+		res, err := NewCode(s.ctx, "test", e, table.content, table.opts, 0, 0, qr.FormatPNG).Render()
+		s.Require().NoErrorf(err, "%v", table.kind)
+
+		img, err := png.Decode(bytes.NewReader(res.Data))
+		s.Require().NoError(err)
+		s.Assert().Equal(image.Pt(res.Width, res.Height), img.Bounds().Size())
+		bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+		s.Require().NoError(err)
+		decoded, err := table.reader.Decode(bmp, map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_TRY_HARDER: true})
+		s.Require().NoErrorf(err, "%v", table.kind)
+		s.Assert().Equal(table.expected, decoded.GetText())
+	}
+}
+
+// TestValidate tests that content and options are checked per symbology
+func (s *BarcodeTest) TestValidate() {
+	invalid := []struct {
+		kind    generators.Symbology
+		content string
+		opts    generators.Options
+	}{
+		{KindCode128, "", nil},
+		{KindCode128, "naïve", nil},
+		{KindCode128, "ok", generators.Options{"layers": float64(1)}},
+		{KindEAN13, "12345", nil},
+		{KindEAN13, "40063813339a", nil},
+		{KindEAN13, "4006381333932", nil},
+		{KindUPCA, "0360002914", nil},
+		{KindDataMatrix, "ünïcode", nil},
+		{KindAztec, "ok", generators.Options{"ecc_percent": float64(99)}},
+		{KindAztec, "ok", generators.Options{"layers": 1.5}},
+		{KindPDF417, "ok", generators.Options{"security_level": float64(9)}},
+		{KindPDF417, "ok", generators.Options{"security_level": "high"}},
+	}
+	for _, table := range invalid {
+		e, err := generators.Lookup(table.kind.String())
+		s.Require().NoError(err)
+		_, err = e.Encode(table.content, table.opts)
+		s.Assert().Errorf(err, "%v %q %v", table.kind, table.content, table.opts)
+	}
+}
+
+// TestSize tests that linear symbols stretch to the requested height and 2D symbols keep square modules
+func (s *BarcodeTest) TestSize() {
+	e, _ := generators.Lookup(KindPDF417.String())
+	res, err := NewCode(s.ctx, "test", e, "port", nil, 0, 0, qr.FormatSVG).Render()
+	s.Require().NoError(err)
+	s.Assert().True(bytes.HasPrefix(res.Data, []byte("<?xml")))
+
+	e, _ = generators.Lookup(KindCode128.String())
+	res, err = NewCode(s.ctx, "test", e, "port", nil, 300, 0, qr.FormatPDF).Render()
+	s.Require().NoError(err)
+	s.Assert().Equal(300, res.Width)
+	s.Assert().Equal(DefaultLinearHeight, res.Height)
+
+	e, _ = generators.Lookup(KindDataMatrix.String())
+	res, err = NewCode(s.ctx, "test", e, "port", nil, 200, 0, qr.FormatPNG).Render()
+	s.Require().NoError(err)
+	s.Assert().Equal(res.Width, res.Height)
+}
+
+func TestBarcodeTest(t *testing.T) {
+	suite.Run(t, new(BarcodeTest))
+}
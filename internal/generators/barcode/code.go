@@ -0,0 +1,140 @@
+package barcode
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/dark-enstein/port/internal/cloud"
+	"github.com/dark-enstein/port/internal/generators"
+	"github.com/dark-enstein/port/internal/generators/qr"
+	"github.com/dark-enstein/port/util"
+)
+
+var (
+	// DefaultScale is the number of pixels per module of a symbol when the request doesn't set its size
+	DefaultScale = 4
+	// DefaultLinearHeight is the height of linear symbols when the request doesn't set it
+	DefaultLinearHeight = 100
+)
+
+// Code defines the structure of a symbol of any registered symbology, and how it is rendered
+type Code struct {
+	id       string
+	encoder  generators.Encoder
+	content  string
+	opts     generators.Options
+	width    int
+	height   int
+	format   qr.Format
+	ctx      context.Context
+	rendered []byte
+	url      string
+}
+
+// NewCode generates a Code of the symbology the encoder passed in encodes. A width or height of zero is worked out
+// from the size of the symbol.
+func NewCode(ctx context.Context, id string, encoder generators.Encoder, content string, opts generators.Options, width, height int, format qr.Format) *Code {
+	return &Code{
+		id:      id,
+		encoder: encoder,
+		content: content,
+		opts:    opts,
+		width:   width,
+		height:  height,
+		format:  format,
+		ctx:     ctx,
+	}
+}
+
+// Generate renders the symbol and uploads it to the storage in the context. It returns the URL it can be fetched from.
+func (c *Code) Generate() (string, error) {
+	log := util.RetrieveLoggerFromCtx(c.ctx).WithMethod("Code.Generate()")
+	if err := c.encode(); err != nil {
+		return "", err
+	}
+	store := cloud.RetrieveStorageFromCtx(c.ctx)
+	obj, err := store.Put(c.ctx, c.id+c.format.Extension(), c.format.MIME(), c.rendered)
+	if err != nil {
+		log.Error().Err(fmt.Errorf("encountered error while trying to upload %v code: %w", c.encoder.Kind(), err)).Send()
+		return "", err
+	}
+	c.url = store.URL(obj.Key)
+	return c.url, nil
+}
+
+// Render renders the symbol in memory only. Nothing is uploaded.
+func (c *Code) Render() (*qr.Result, error) {
+	if err := c.encode(); err != nil {
+		return nil, err
+	}
+	return c.Result(), nil
+}
+
+// Result returns the description of the last rendered symbol. It returns nil if nothing has been rendered yet.
+func (c *Code) Result() *qr.Result {
+	if c.rendered == nil {
+		return nil
+	}
+	sum := sha256.Sum256(c.rendered)
+	return &qr.Result{
+		ID:       c.id,
+		URL:      c.url,
+		Format:   c.format,
+		Width:    c.width,
+		Height:   c.height,
+		Data:     c.rendered,
+		Checksum: "sha256:" + hex.EncodeToString(sum[:]),
+	}
+}
+
+// encode encodes the content into a symbol, and renders it into the receiver's output format
+func (c *Code) encode() error {
+	log := util.RetrieveLoggerFromCtx(c.ctx).WithMethod("Code.encode()")
+	bitmap, err := c.encoder.Encode(c.content, c.opts)
+	if err != nil {
+		log.Info().Msgf("encoding %v failed with error: %v", c.encoder.Kind(), err)
+		return err
+	}
+	c.width, c.height = c.size(len(bitmap[0]), len(bitmap))
+	c.rendered, err = qr.Draw(bitmap, c.width, c.height, c.format)
+	if err != nil {
+		log.Error().Msgf("rendering %v as %v failed with error: %v", c.encoder.Kind(), c.format, err)
+		return err
+	}
+	return nil
+}
+
+// size resolves the image size of a symbol of cols x rows modules. Images are never narrower than one pixel per
+// module, and 2D symbols keep square modules unless both sides are set.
+func (c *Code) size(cols, rows int) (int, int) {
+	width, height := c.width, c.height
+	switch {
+	case width <= 0 && height <= 0:
+		width = cols * DefaultScale
+		height = rows * DefaultScale
+		if c.encoder.Linear() {
+			height = DefaultLinearHeight
+		}
+	case width <= 0:
+		width = cols * DefaultScale
+		if !c.encoder.Linear() {
+			width = height * cols / rows
+		}
+	case height <= 0:
+		height = DefaultLinearHeight
+		if !c.encoder.Linear() {
+			height = width * rows / cols
+		}
+	}
+	if width < cols {
+		width = cols
+	}
+	if !c.encoder.Linear() && height < rows {
+		height = rows
+	}
+	if height < 1 {
+		height = 1
+	}
+	return width, height
+}
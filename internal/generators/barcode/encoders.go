@@ -0,0 +1,255 @@
+package barcode
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+	"unicode"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/aztec"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/datamatrix"
+	"github.com/boombuler/barcode/ean"
+	"github.com/boombuler/barcode/pdf417"
+	"github.com/dark-enstein/port/internal/generators"
+)
+
+var (
+	KindCode128    = generators.Symbology("code128")
+	KindEAN13      = generators.Symbology("ean13")
+	KindUPCA       = generators.Symbology("upca")
+	KindDataMatrix = generators.Symbology("datamatrix")
+	KindAztec      = generators.Symbology("aztec")
+	KindPDF417     = generators.Symbology("pdf417")
+
+	// MaxLinearContent bounds the content of linear symbols, which grow too wide to scan long before they fail to
+	// encode
+	MaxLinearContent = 80
+
+	ErrInvalidContent = errors.New("invalid content")
+)
+
+func init() {
+	generators.Register(code128Encoder{})
+	generators.Register(eanEncoder{kind: KindEAN13, digits: 13})
+	generators.Register(eanEncoder{kind: KindUPCA, digits: 12})
+	generators.Register(dataMatrixEncoder{})
+	generators.Register(aztecEncoder{})
+	generators.Register(pdf417Encoder{})
+}
+
+// code128Encoder encodes printable ASCII into Code 128 symbols
+type code128Encoder struct{}
+
+func (code128Encoder) Kind() generators.Kind { return KindCode128 }
+
+func (code128Encoder) Linear() bool { return true }
+
+func (code128Encoder) Validate(content string, opts generators.Options) error {
+	if err := opts.Only(); err != nil {
+		return err
+	}
+	if content == "" || len(content) > MaxLinearContent {
+		return fmt.Errorf("%w: code128 content must be 1 to %d characters", ErrInvalidContent, MaxLinearContent)
+	}
+	for _, r := range content {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return fmt.Errorf("%w: code128 content must be printable ASCII, found %q", ErrInvalidContent, r)
+		}
+	}
+	return nil
+}
+
+func (e code128Encoder) Encode(content string, opts generators.Options) ([][]bool, error) {
+	if err := e.Validate(content, opts); err != nil {
+		return nil, err
+	}
+	bc, err := code128.Encode(content)
+	if err != nil {
+		return nil, err
+	}
+	return pad(bitmap(bc), 10, 0), nil
+}
+
+// eanEncoder encodes EAN-13 symbols. UPC-A symbols are EAN-13 symbols with a leading zero, so they share it.
+// The check digit can be left out, and is worked out when it is.
+type eanEncoder struct {
+	kind   generators.Symbology
+	digits int
+}
+
+func (e eanEncoder) Kind() generators.Kind { return e.kind }
+
+func (eanEncoder) Linear() bool { return true }
+
+func (e eanEncoder) Validate(content string, opts generators.Options) error {
+	if err := opts.Only(); err != nil {
+		return err
+	}
+	if len(content) != e.digits && len(content) != e.digits-1 {
+		return fmt.Errorf("%w: %v content must be %d digits, or %d without the check digit", ErrInvalidContent, e.kind, e.digits, e.digits-1)
+	}
+	if strings.IndexFunc(content, func(r rune) bool { return r < '0' || r > '9' }) != -1 {
+		return fmt.Errorf("%w: %v content must only hold digits", ErrInvalidContent, e.kind)
+	}
+	return nil
+}
+
+func (e eanEncoder) Encode(content string, opts generators.Options) ([][]bool, error) {
+	if err := e.Validate(content, opts); err != nil {
+		return nil, err
+	}
+	if e.kind == KindUPCA {
+		content = "0" + content
+	}
+	bc, err := ean.Encode(content)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidContent, err)
+	}
+	return pad(bitmap(bc), 9, 0), nil
+}
+
+// dataMatrixEncoder encodes ASCII into Data Matrix symbols
+type dataMatrixEncoder struct{}
+
+func (dataMatrixEncoder) Kind() generators.Kind { return KindDataMatrix }
+
+func (dataMatrixEncoder) Linear() bool { return false }
+
+func (dataMatrixEncoder) Validate(content string, opts generators.Options) error {
+	if err := opts.Only(); err != nil {
+		return err
+	}
+	if content == "" {
+		return fmt.Errorf("%w: datamatrix content must not be empty", ErrInvalidContent)
+	}
+	for _, r := range content {
+		if r > unicode.MaxASCII {
+			return fmt.Errorf("%w: datamatrix content must be ASCII, found %q", ErrInvalidContent, r)
+		}
+	}
+	return nil
+}
+
+func (e dataMatrixEncoder) Encode(content string, opts generators.Options) ([][]bool, error) {
+	if err := e.Validate(content, opts); err != nil {
+		return nil, err
+	}
+	bc, err := datamatrix.Encode(content)
+	if err != nil {
+		return nil, err
+	}
+	return pad(bitmap(bc), 1, 1), nil
+}
+
+// aztecEncoder encodes bytes into Aztec symbols. The ecc_percent option sets the minimum share of error correction
+// in the symbol, and the layers option forces a number of layers, negative for compact symbols.
+type aztecEncoder struct{}
+
+func (aztecEncoder) Kind() generators.Kind { return KindAztec }
+
+func (aztecEncoder) Linear() bool { return false }
+
+func (aztecEncoder) options(opts generators.Options) (int, int, error) {
+	if err := opts.Only("ecc_percent", "layers"); err != nil {
+		return 0, 0, err
+	}
+	ecc, err := opts.Int("ecc_percent", 33, 5, 95)
+	if err != nil {
+		return 0, 0, err
+	}
+	layers, err := opts.Int("layers", 0, -4, 32)
+	return ecc, layers, err
+}
+
+func (e aztecEncoder) Validate(content string, opts generators.Options) error {
+	if content == "" {
+		return fmt.Errorf("%w: aztec content must not be empty", ErrInvalidContent)
+	}
+	_, _, err := e.options(opts)
+	return err
+}
+
+func (e aztecEncoder) Encode(content string, opts generators.Options) ([][]bool, error) {
+	if err := e.Validate(content, opts); err != nil {
+		return nil, err
+	}
+	ecc, layers, _ := e.options(opts)
+	bc, err := aztec.Encode([]byte(content), ecc, layers)
+	if err != nil {
+		return nil, err
+	}
+	return pad(bitmap(bc), 1, 1), nil
+}
+
+// pdf417Encoder encodes text into PDF417 symbols. The security_level option sets the error correction level.
+type pdf417Encoder struct{}
+
+func (pdf417Encoder) Kind() generators.Kind { return KindPDF417 }
+
+func (pdf417Encoder) Linear() bool { return false }
+
+func (pdf417Encoder) level(opts generators.Options) (int, error) {
+	if err := opts.Only("security_level"); err != nil {
+		return 0, err
+	}
+	return opts.Int("security_level", 2, 0, 8)
+}
+
+func (e pdf417Encoder) Validate(content string, opts generators.Options) error {
+	if content == "" {
+		return fmt.Errorf("%w: pdf417 content must not be empty", ErrInvalidContent)
+	}
+	_, err := e.level(opts)
+	return err
+}
+
+func (e pdf417Encoder) Encode(content string, opts generators.Options) ([][]bool, error) {
+	if err := e.Validate(content, opts); err != nil {
+		return nil, err
+	}
+	level, _ := e.level(opts)
+	bc, err := pdf417.Encode(content, byte(level))
+	if err != nil {
+		return nil, err
+	}
+	return pad(bitmap(bc), 2, 2), nil
+}
+
+// bitmap reads the modules of a symbol, one pixel per module
+func bitmap(bc barcode.Barcode) [][]bool {
+	b := bc.Bounds()
+	res := make([][]bool, b.Dy())
+	for y := range res {
+		res[y] = make([]bool, b.Dx())
+		for x := range res[y] {
+			res[y][x] = dark(bc, b.Min.X+x, b.Min.Y+y)
+		}
+	}
+	return res
+}
+
+func dark(img image.Image, x, y int) bool {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return (r+g+b)/3 < 0x8000
+}
+
+// pad surrounds a bitmap with a quiet zone of light modules, qx wide on the sides and qy high on the top and bottom
+func pad(bitmap [][]bool, qx, qy int) [][]bool {
+	cols := len(bitmap[0]) + 2*qx
+	res := make([][]bool, 0, len(bitmap)+2*qy)
+	for i := 0; i < qy; i++ {
+		res = append(res, make([]bool, cols))
+	}
+	for _, row := range bitmap {
+		padded := make([]bool, cols)
+		copy(padded[qx:], row)
+		res = append(res, padded)
+	}
+	for i := 0; i < qy; i++ {
+		res = append(res, make([]bool, cols))
+	}
+	return res
+}
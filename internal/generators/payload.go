@@ -0,0 +1,468 @@
+package generators
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PayloadKind names the structured payloads a code can be built from
+type PayloadKind string
+
+const (
+	PayloadVCard  PayloadKind = "vcard"
+	PayloadMeCard PayloadKind = "mecard"
+	PayloadWiFi   PayloadKind = "wifi"
+	PayloadGeo    PayloadKind = "geo"
+	PayloadEvent  PayloadKind = "event"
+	PayloadSMS    PayloadKind = "sms"
+	PayloadEmail  PayloadKind = "email"
+)
+
+var (
+	ErrInvalidPayload     = errors.New("invalid payload")
+	ErrPayloadKindMissing = fmt.Errorf("%w: payload kind is missing", ErrInvalidPayload)
+
+	// payloadBuilders maps each payload kind to a constructor of its empty builder
+	payloadBuilders = map[PayloadKind]func() Builder{
+		PayloadVCard:  func() Builder { return &VCard{} },
+		PayloadMeCard: func() Builder { return &MeCard{} },
+		PayloadWiFi:   func() Builder { return &WiFi{} },
+		PayloadGeo:    func() Builder { return &Geo{} },
+		PayloadEvent:  func() Builder { return &Event{} },
+		PayloadSMS:    func() Builder { return &SMS{} },
+		PayloadEmail:  func() Builder { return &Email{} },
+	}
+	SupportedPayloads = []PayloadKind{PayloadVCard, PayloadMeCard, PayloadWiFi, PayloadGeo, PayloadEvent, PayloadSMS, PayloadEmail}
+)
+
+// Builder validates a structured payload and encodes it into the content string of a code
+type Builder interface {
+	Kind() PayloadKind
+	// Validate checks that the payload has everything its encoding needs
+	Validate() error
+	// Encode validates the payload and encodes it, escaping every field as its format requires
+	Encode() (string, error)
+}
+
+// Payload is a structured payload as sent by clients. It is a JSON object whose "kind" field selects the builder
+// the rest of the object is decoded into.
+type Payload struct {
+	Kind    PayloadKind
+	Builder Builder
+}
+
+// NewPayload wraps a builder into a Payload
+func NewPayload(b Builder) *Payload {
+	return &Payload{Kind: b.Kind(), Builder: b}
+}
+
+// UnmarshalJSON decodes the builder selected by the "kind" field. Fields the builder doesn't know are rejected.
+func (p *Payload) UnmarshalJSON(b []byte) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return fmt.Errorf("%w: payload must be an object: %v", ErrInvalidPayload, err)
+	}
+	rawKind, ok := fields["kind"]
+	if !ok {
+		return ErrPayloadKindMissing
+	}
+	if err := json.Unmarshal(rawKind, &p.Kind); err != nil {
+		return fmt.Errorf("%w: payload kind must be a string", ErrInvalidPayload)
+	}
+	newBuilder, ok := payloadBuilders[p.Kind]
+	if !ok {
+		return fmt.Errorf("%w: payload kind %q is not supported. supported kinds: %v", ErrInvalidPayload, p.Kind, SupportedPayloads)
+	}
+	delete(fields, "kind")
+
+	rest, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(rest))
+	dec.DisallowUnknownFields()
+	p.Builder = newBuilder()
+	if err := dec.Decode(p.Builder); err != nil {
+		return fmt.Errorf("%w: %v payload: %v", ErrInvalidPayload, p.Kind, err)
+	}
+	return nil
+}
+
+// MarshalJSON encodes the builder fields along with the "kind" discriminator
+func (p *Payload) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(p.Builder)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	fields["kind"], _ = json.Marshal(p.Kind)
+	return json.Marshal(fields)
+}
+
+// Encode encodes the payload into the content string of a code
+func (p *Payload) Encode() (string, error) {
+	if p.Builder == nil {
+		return "", ErrPayloadKindMissing
+	}
+	return p.Builder.Encode()
+}
+
+// VCard is a vCard 3.0 contact card
+type VCard struct {
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Organization string `json:"organization,omitempty"`
+	Title        string `json:"title,omitempty"`
+	Phone        string `json:"phone,omitempty"`
+	Email        string `json:"email,omitempty"`
+	URL          string `json:"url,omitempty"`
+	Address      string `json:"address,omitempty"`
+	Note         string `json:"note,omitempty"`
+}
+
+func (v *VCard) Kind() PayloadKind {
+	return PayloadVCard
+}
+
+func (v *VCard) Validate() error {
+	if v.FirstName == "" && v.LastName == "" {
+		return errors.New("vcard needs a first or last name")
+	}
+	if v.Email != "" {
+		if _, err := mail.ParseAddress(v.Email); err != nil {
+			return fmt.Errorf("vcard email %q is invalid", v.Email)
+		}
+	}
+	if v.Phone != "" && !isPhone(v.Phone) {
+		return fmt.Errorf("vcard phone %q is invalid", v.Phone)
+	}
+	return nil
+}
+
+func (v *VCard) Encode() (string, error) {
+	if err := v.Validate(); err != nil {
+		return "", err
+	}
+	e := vcardEscaper.Replace
+	lines := []string{
+		"BEGIN:VCARD",
+		"VERSION:3.0",
+		fmt.Sprintf("N:%s;%s;;;", e(v.LastName), e(v.FirstName)),
+		"FN:" + e(strings.TrimSpace(v.FirstName+" "+v.LastName)),
+	}
+	lines = appendProperty(lines, "ORG", e(v.Organization))
+	lines = appendProperty(lines, "TITLE", e(v.Title))
+	lines = appendProperty(lines, "TEL", e(v.Phone))
+	lines = appendProperty(lines, "EMAIL", e(v.Email))
+	lines = appendProperty(lines, "URL", e(v.URL))
+	if v.Address != "" {
+		lines = append(lines, fmt.Sprintf("ADR:;;%s;;;;", e(v.Address)))
+	}
+	lines = appendProperty(lines, "NOTE", e(v.Note))
+	lines = append(lines, "END:VCARD")
+	return strings.Join(lines, "\r\n"), nil
+}
+
+// MeCard is the compact contact card format read by most phone cameras
+type MeCard struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Phone     string `json:"phone,omitempty"`
+	Email     string `json:"email,omitempty"`
+	URL       string `json:"url,omitempty"`
+	Address   string `json:"address,omitempty"`
+	Note      string `json:"note,omitempty"`
+}
+
+func (m *MeCard) Kind() PayloadKind {
+	return PayloadMeCard
+}
+
+func (m *MeCard) Validate() error {
+	if m.FirstName == "" && m.LastName == "" {
+		return errors.New("mecard needs a first or last name")
+	}
+	if m.Email != "" {
+		if _, err := mail.ParseAddress(m.Email); err != nil {
+			return fmt.Errorf("mecard email %q is invalid", m.Email)
+		}
+	}
+	if m.Phone != "" && !isPhone(m.Phone) {
+		return fmt.Errorf("mecard phone %q is invalid", m.Phone)
+	}
+	return nil
+}
+
+func (m *MeCard) Encode() (string, error) {
+	if err := m.Validate(); err != nil {
+		return "", err
+	}
+	e := mecardEscaper.Replace
+	var b strings.Builder
+	b.WriteString("MECARD:N:" + e(m.LastName))
+	if m.FirstName != "" {
+		b.WriteString("," + e(m.FirstName))
+	}
+	b.WriteString(";")
+	for _, f := range [][2]string{{"TEL", m.Phone}, {"EMAIL", m.Email}, {"URL", m.URL}, {"ADR", m.Address}, {"NOTE", m.Note}} {
+		if f[1] != "" {
+			b.WriteString(f[0] + ":" + e(f[1]) + ";")
+		}
+	}
+	b.WriteString(";")
+	return b.String(), nil
+}
+
+// WiFi is a wireless network join code
+type WiFi struct {
+	SSID     string `json:"ssid"`
+	Password string `json:"password,omitempty"`
+	// Security is one of WPA, WEP or nopass. It defaults to WPA when a password is set, and nopass otherwise
+	Security string `json:"security,omitempty"`
+	Hidden   bool   `json:"hidden,omitempty"`
+}
+
+var (
+	WiFiSecurities = []string{"WPA", "WEP", "nopass"}
+)
+
+func (w *WiFi) Kind() PayloadKind {
+	return PayloadWiFi
+}
+
+func (w *WiFi) security() string {
+	if w.Security != "" {
+		return w.Security
+	}
+	if w.Password == "" {
+		return "nopass"
+	}
+	return "WPA"
+}
+
+func (w *WiFi) Validate() error {
+	if w.SSID == "" {
+		return errors.New("wifi needs an ssid")
+	}
+	sec := w.security()
+	found := false
+	for _, s := range WiFiSecurities {
+		found = found || s == sec
+	}
+	if !found {
+		return fmt.Errorf("wifi security %q is not one of %v", sec, WiFiSecurities)
+	}
+	if sec != "nopass" && w.Password == "" {
+		return fmt.Errorf("wifi security %v needs a password", sec)
+	}
+	return nil
+}
+
+func (w *WiFi) Encode() (string, error) {
+	if err := w.Validate(); err != nil {
+		return "", err
+	}
+	e := mecardEscaper.Replace
+	var b strings.Builder
+	b.WriteString("WIFI:T:" + w.security() + ";S:" + e(w.SSID) + ";")
+	if w.security() != "nopass" {
+		b.WriteString("P:" + e(w.Password) + ";")
+	}
+	if w.Hidden {
+		b.WriteString("H:true;")
+	}
+	b.WriteString(";")
+	return b.String(), nil
+}
+
+// Geo is a geographic location, as a geo URI
+type Geo struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+}
+
+func (g *Geo) Kind() PayloadKind {
+	return PayloadGeo
+}
+
+func (g *Geo) Validate() error {
+	if math.IsNaN(g.Latitude) || g.Latitude < -90 || g.Latitude > 90 {
+		return fmt.Errorf("geo latitude %v is out of range [-90, 90]", g.Latitude)
+	}
+	if math.IsNaN(g.Longitude) || g.Longitude < -180 || g.Longitude > 180 {
+		return fmt.Errorf("geo longitude %v is out of range [-180, 180]", g.Longitude)
+	}
+	return nil
+}
+
+func (g *Geo) Encode() (string, error) {
+	if err := g.Validate(); err != nil {
+		return "", err
+	}
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	uri := "geo:" + f(g.Latitude) + "," + f(g.Longitude)
+	if g.Altitude != nil {
+		uri += "," + f(*g.Altitude)
+	}
+	return uri, nil
+}
+
+// Event is a calendar event, as an iCalendar VEVENT
+type Event struct {
+	Summary     string    `json:"summary"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Location    string    `json:"location,omitempty"`
+	Description string    `json:"description,omitempty"`
+}
+
+const (
+	icalTimeFormat = "20060102T150405Z"
+)
+
+func (ev *Event) Kind() PayloadKind {
+	return PayloadEvent
+}
+
+func (ev *Event) Validate() error {
+	if ev.Summary == "" {
+		return errors.New("event needs a summary")
+	}
+	if ev.Start.IsZero() || ev.End.IsZero() {
+		return errors.New("event needs a start and an end")
+	}
+	if !ev.End.After(ev.Start) {
+		return errors.New("event must end after it starts")
+	}
+	return nil
+}
+
+func (ev *Event) Encode() (string, error) {
+	if err := ev.Validate(); err != nil {
+		return "", err
+	}
+	e := icalEscaper.Replace
+	lines := []string{
+		"BEGIN:VEVENT",
+		"SUMMARY:" + e(ev.Summary),
+		"DTSTART:" + ev.Start.UTC().Format(icalTimeFormat),
+		"DTEND:" + ev.End.UTC().Format(icalTimeFormat),
+	}
+	lines = appendProperty(lines, "LOCATION", e(ev.Location))
+	lines = appendProperty(lines, "DESCRIPTION", e(ev.Description))
+	lines = append(lines, "END:VEVENT")
+	return strings.Join(lines, "\r\n"), nil
+}
+
+// SMS is a prefilled text message
+type SMS struct {
+	Number  string `json:"number"`
+	Message string `json:"message,omitempty"`
+}
+
+func (s *SMS) Kind() PayloadKind {
+	return PayloadSMS
+}
+
+func (s *SMS) Validate() error {
+	if !isPhone(s.Number) {
+		return fmt.Errorf("sms number %q is invalid", s.Number)
+	}
+	return nil
+}
+
+func (s *SMS) Encode() (string, error) {
+	if err := s.Validate(); err != nil {
+		return "", err
+	}
+	return "SMSTO:" + s.Number + ":" + s.Message, nil
+}
+
+// Email is a prefilled email, as a mailto URI
+type Email struct {
+	To      string `json:"to"`
+	Cc      string `json:"cc,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Body    string `json:"body,omitempty"`
+}
+
+func (m *Email) Kind() PayloadKind {
+	return PayloadEmail
+}
+
+func (m *Email) Validate() error {
+	if _, err := mail.ParseAddress(m.To); err != nil {
+		return fmt.Errorf("email recipient %q is invalid", m.To)
+	}
+	if m.Cc != "" {
+		if _, err := mail.ParseAddressList(m.Cc); err != nil {
+			return fmt.Errorf("email cc %q is invalid", m.Cc)
+		}
+	}
+	return nil
+}
+
+func (m *Email) Encode() (string, error) {
+	if err := m.Validate(); err != nil {
+		return "", err
+	}
+	var query []string
+	for _, f := range [][2]string{{"cc", m.Cc}, {"subject", m.Subject}, {"body", m.Body}} {
+		if f[1] != "" {
+			query = append(query, f[0]+"="+uriEscape(f[1]))
+		}
+	}
+	uri := "mailto:" + m.To
+	if len(query) > 0 {
+		uri += "?" + strings.Join(query, "&")
+	}
+	return uri, nil
+}
+
+var (
+	// vcardEscaper escapes text values as RFC 2426 requires
+	vcardEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `;`, `\;`, "\r\n", `\n`, "\n", `\n`)
+	// icalEscaper escapes text values as RFC 5545 requires
+	icalEscaper = vcardEscaper
+	// mecardEscaper escapes the reserved characters of the MECARD and WIFI formats
+	mecardEscaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `:`, `\:`, `"`, `\"`)
+)
+
+func appendProperty(lines []string, name, value string) []string {
+	if value == "" {
+		return lines
+	}
+	return append(lines, name+":"+value)
+}
+
+// isPhone checks that s looks like a dialable phone number
+func isPhone(s string) bool {
+	digits := 0
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case strings.ContainsRune("+-() .", r):
+		default:
+			return false
+		}
+	}
+	return digits >= 3
+}
+
+// uriEscape percent-encodes a mailto header value. Spaces become %20, since mail clients don't decode "+".
+func uriEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
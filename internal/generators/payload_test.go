@@ -0,0 +1,93 @@
+package generators
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type PayloadTest struct {
+	encodings []EncodingTable
+	suite.Suite
+}
+
+// EncodingTable defines a sample payload as a client sends it, and the content it is expected to encode into
+type EncodingTable struct {
+	payload  string
+	expected string
+}
+
+func (s *PayloadTest) SetupTest() {
+	fmt.Println("Starting tests...")
+	s.encodings = []EncodingTable{
+		{
+			payload:  `{"kind":"wifi","ssid":"port;guest","password":"p:ss"}`,
+			expected: `WIFI:T:WPA;S:port\;guest;P:p\:ss;;`,
+		},
+		{
+			payload:  `{"kind":"wifi","ssid":"lobby","hidden":true}`,
+			expected: `WIFI:T:nopass;S:lobby;H:true;;`,
+		},
+		{
+			payload:  `{"kind":"geo","latitude":6.5244,"longitude":3.3792}`,
+			expected: `geo:6.5244,3.3792`,
+		},
+		{
+			payload:  `{"kind":"sms","number":"+234 800 000","message":"hi: there"}`,
+			expected: `SMSTO:+234 800 000:hi: there`,
+		},
+		{
+			payload:  `{"kind":"email","to":"desk@port.dev","subject":"Lost & found","body":"a b"}`,
+			expected: `mailto:desk@port.dev?subject=Lost%20%26%20found&body=a%20b`,
+		},
+		{
+			payload:  `{"kind":"mecard","first_name":"Ayo","last_name":"Bami","phone":"0800"}`,
+			expected: `MECARD:N:Bami,Ayo;TEL:0800;;`,
+		},
+		{
+			payload:  `{"kind":"vcard","first_name":"Ayo","last_name":"Bami","organization":"Port, Inc"}`,
+			expected: "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Bami;Ayo;;;\r\nFN:Ayo Bami\r\nORG:Port\\, Inc\r\nEND:VCARD",
+		},
+		{
+			payload:  `{"kind":"event","summary":"Launch","start":"2023-10-01T09:00:00+01:00","end":"2023-10-01T10:00:00+01:00"}`,
+			expected: "BEGIN:VEVENT\r\nSUMMARY:Launch\r\nDTSTART:20231001T080000Z\r\nDTEND:20231001T090000Z\r\nEND:VEVENT",
+		},
+	}
+	fmt.Println("Tests startup complete...")
+}
+
+// TestEncode tests that client payloads decode into their builders and encode into the expected content
+func (s *PayloadTest) TestEncode() {
+	for _, table := range s.encodings {
+		var p Payload
+		s.Require().NoError(json.Unmarshal([]byte(table.payload), &p))
+		content, err := p.Encode()
+		s.Require().NoError(err)
+		s.Assert().Equal(table.expected, content)
+	}
+}
+
+// TestInvalid tests that malformed payloads are rejected
+func (s *PayloadTest) TestInvalid() {
+	var p Payload
+	s.Assert().ErrorIs(json.Unmarshal([]byte(`{"ssid":"x"}`), &p), ErrInvalidPayload)
+	s.Assert().ErrorIs(json.Unmarshal([]byte(`{"kind":"fax"}`), &p), ErrInvalidPayload)
+	s.Assert().ErrorIs(json.Unmarshal([]byte(`{"kind":"wifi","pin":"1"}`), &p), ErrInvalidPayload)
+
+	_, err := (&Geo{Latitude: 91}).Encode()
+	s.Assert().Error(err)
+	_, err = (&WiFi{SSID: "x", Security: "WEP"}).Encode()
+	s.Assert().Error(err)
+	_, err = (&Event{Summary: "x", Start: time.Now(), End: time.Now().Add(-time.Hour)}).Encode()
+	s.Assert().Error(err)
+}
+
+func (s *PayloadTest) TearDownSuite() {
+	fmt.Println("All testing complete")
+}
+
+func TestPayloadTest(t *testing.T) {
+	suite.Run(t, new(PayloadTest))
+}
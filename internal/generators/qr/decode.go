@@ -0,0 +1,153 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode/decoder"
+	"github.com/makiuchi-d/gozxing/qrcode/detector"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+var (
+	ErrNoCode       = errors.New("no qr code found in image")
+	ErrVerifyFailed = errors.New("rendered qr code doesn't decode to its content")
+
+	// verifyScale is the number of pixels per module vector formats are rasterized at to be verified
+	verifyScale = 4
+
+	levels = map[string]qrcode.RecoveryLevel{
+		"L": qrcode.Low,
+		"M": qrcode.Medium,
+		"Q": qrcode.High,
+		"H": qrcode.Highest,
+	}
+)
+
+// Decoded describes a QR code read from an image
+type Decoded struct {
+	Content string
+	// Version is the symbol version of the code, from 1 to 40
+	Version int
+	// Level is the error correction level of the code, one of L, M, Q or H
+	Level         string
+	RecoveryLevel qrcode.RecoveryLevel
+	// Bounds is the bounding box of the code in the image, in pixels. It includes the finder patterns, but not the
+	// quiet zone around the code.
+	Bounds image.Rectangle
+}
+
+// Decode reads the QR code in a PNG or JPEG image
+func Decode(r io.Reader) (*Decoded, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading image failed: %w", err)
+	}
+	return DecodeImage(img)
+}
+
+// DecodeImage reads the QR code in an image. It returns ErrNoCode when there's no code it can read.
+func DecodeImage(img image.Image) (*Decoded, error) {
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return nil, fmt.Errorf("reading image failed: %w", err)
+	}
+	matrix, err := bmp.GetBlackMatrix()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoCode, err)
+	}
+
+	hints := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_TRY_HARDER: true}
+	detected, err := detector.NewDetector(matrix).Detect(hints)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoCode, err)
+	}
+	res, err := decoder.NewDecoder().Decode(detected.GetBits(), hints)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoCode, err)
+	}
+	points := detected.GetPoints()
+	if meta, ok := res.GetOther().(*decoder.QRCodeDecoderMetaData); ok {
+		meta.ApplyMirroredCorrection(points)
+	}
+
+	dimension := detected.GetBits().GetHeight()
+	return &Decoded{
+		Content:       res.GetText(),
+		Version:       (dimension - 17) / 4,
+		Level:         res.GetECLevel(),
+		RecoveryLevel: levels[res.GetECLevel()],
+		Bounds:        bounds(points, dimension),
+	}, nil
+}
+
+// bounds works out the bounding box of a code from the centers of its finder patterns, which sit 3.5 modules in
+// from the corners of the code
+func bounds(points []gozxing.ResultPoint, dimension int) image.Rectangle {
+	if len(points) < 3 {
+		return image.Rectangle{}
+	}
+	bottomLeft, topLeft, topRight := points[0], points[1], points[2]
+	span := float64(dimension - 7)
+	ux, uy := (topRight.GetX()-topLeft.GetX())/span, (topRight.GetY()-topLeft.GetY())/span
+	vx, vy := (bottomLeft.GetX()-topLeft.GetX())/span, (bottomLeft.GetY()-topLeft.GetY())/span
+
+	corner := func(x, y, du, dv float64) (float64, float64) {
+		return x + du*ux + dv*vx, y + du*uy + dv*vy
+	}
+	var xs, ys [4]float64
+	xs[0], ys[0] = corner(topLeft.GetX(), topLeft.GetY(), -3.5, -3.5)
+	xs[1], ys[1] = corner(topRight.GetX(), topRight.GetY(), 3.5, -3.5)
+	xs[2], ys[2] = corner(bottomLeft.GetX(), bottomLeft.GetY(), -3.5, 3.5)
+	xs[3], ys[3] = corner(topRight.GetX()+bottomLeft.GetX()-topLeft.GetX(), topRight.GetY()+bottomLeft.GetY()-topLeft.GetY(), 3.5, 3.5)
+
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for i := range xs {
+		minX, maxX = math.Min(minX, xs[i]), math.Max(maxX, xs[i])
+		minY, maxY = math.Min(minY, ys[i]), math.Max(maxY, ys[i])
+	}
+	return image.Rect(int(math.Round(minX)), int(math.Round(minY)), int(math.Round(maxX)), int(math.Round(maxY)))
+}
+
+// verify decodes the rendered QR code and checks that it reads back as its content. Vector formats can't be
+// decoded as they are, so the bitmap they are drawn from is rasterized and decoded instead.
+func (q *QR) verify() error {
+	var img image.Image
+	switch {
+	case q.format == FormatPNG:
+		var err error
+		img, _, err = image.Decode(bytes.NewReader(q.rendered))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrVerifyFailed, err)
+		}
+	case q.style != nil:
+		bitmap := q.Code.Bitmap()
+		img = q.style.raster(bitmap, verifyScale*q.style.modules(len(bitmap)))
+	default:
+		img = q.Code.Image(-verifyScale)
+	}
+	decoded, err := DecodeImage(flatten(img))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrVerifyFailed, err)
+	}
+	if decoded.Content != q.content {
+		return fmt.Errorf("%w: decoded %q", ErrVerifyFailed, decoded.Content)
+	}
+	return nil
+}
+
+// flatten lays an image over white, the way a code with a transparent background is usually seen
+func flatten(img image.Image) image.Image {
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return flat
+}
@@ -0,0 +1,52 @@
+package qr
+
+import (
+	"github.com/dark-enstein/port/internal/generators"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+var (
+	// Kind is the symbology of QR codes
+	Kind = generators.Symbology("qr")
+)
+
+func init() {
+	generators.Register(encoder{})
+}
+
+// encoder registers QR codes with the generators registry, so they are listed with the other symbologies. The
+// "/generate/qr" endpoint has a richer pipeline of its own, driven by QR.
+type encoder struct{}
+
+func (encoder) Kind() generators.Kind {
+	return Kind
+}
+
+func (encoder) Linear() bool {
+	return false
+}
+
+func (e encoder) Validate(content string, opts generators.Options) error {
+	_, err := e.level(opts)
+	return err
+}
+
+func (e encoder) Encode(content string, opts generators.Options) ([][]bool, error) {
+	level, err := e.level(opts)
+	if err != nil {
+		return nil, err
+	}
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, err
+	}
+	return code.Bitmap(), nil
+}
+
+func (encoder) level(opts generators.Options) (qrcode.RecoveryLevel, error) {
+	if err := opts.Only("recovery_level"); err != nil {
+		return 0, err
+	}
+	level, err := opts.Int("recovery_level", int(qrcode.Medium), int(qrcode.Low), int(qrcode.Highest))
+	return qrcode.RecoveryLevel(level), err
+}
@@ -0,0 +1,218 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"mime"
+	"strings"
+)
+
+// Format defines the output encoding of a rendered QR code
+type Format string
+
+const (
+	FormatPNG Format = "png"
+	FormatSVG Format = "svg"
+	FormatPDF Format = "pdf"
+	FormatEPS Format = "eps"
+)
+
+var (
+	DefaultFormat    = FormatPNG
+	SupportedFormats = []Format{FormatPNG, FormatSVG, FormatPDF, FormatEPS}
+	formatMIME       = map[Format]string{
+		FormatPNG: "image/png",
+		FormatSVG: "image/svg+xml",
+		FormatPDF: "application/pdf",
+		FormatEPS: "application/postscript",
+	}
+)
+
+func init() {
+	// register the formats with the mime package, so file based storage can infer content types from keys
+	for f, m := range formatMIME {
+		_ = mime.AddExtensionType(f.Extension(), m)
+	}
+}
+
+// ParseFormat resolves the format name passed in by a client. An empty name resolves to DefaultFormat.
+func ParseFormat(name string) (Format, error) {
+	if name == "" {
+		return DefaultFormat, nil
+	}
+	f := Format(strings.ToLower(strings.TrimPrefix(name, ".")))
+	if _, ok := formatMIME[f]; !ok {
+		return "", fmt.Errorf("format %q is not supported. supported formats: %v", name, SupportedFormats)
+	}
+	return f, nil
+}
+
+// FormatFromMIME resolves a MIME type into its Format. It returns false when the MIME type isn't one port renders.
+func FormatFromMIME(mediaType string) (Format, bool) {
+	for k, v := range formatMIME {
+		if v == mediaType {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+func (f Format) String() string {
+	return string(f)
+}
+
+// Extension returns the file extension of the format, including the leading dot
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// MIME returns the media type of the format
+func (f Format) MIME() string {
+	return formatMIME[f]
+}
+
+// moduleWidth resolves the requested size into the rendered image width, following the semantics of
+// qrcode.QRCode.Write: a negative size is a per-module scale, and the image is never smaller than the bitmap.
+func moduleWidth(size, modules int) int {
+	if size < 0 {
+		size = -size * modules
+	}
+	if size < modules {
+		size = modules
+	}
+	return size
+}
+
+// run is a horizontal stretch of dark modules in a bitmap row
+type run struct {
+	x, y, length int
+}
+
+// runs collapses the dark modules of a bitmap into horizontal runs, so vector output stays compact
+func runs(bitmap [][]bool) []run {
+	var res []run
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			res = append(res, run{x: start, y: y, length: x - start})
+		}
+	}
+	return res
+}
+
+// Draw renders a bitmap of modules, dark where true, as an image of width x height in the format passed in. Modules
+// are stretched to fill the image, so bitmaps of any shape can be drawn.
+func Draw(bitmap [][]bool, width, height int, f Format) ([]byte, error) {
+	if len(bitmap) == 0 || len(bitmap[0]) == 0 {
+		return nil, errors.New("bitmap is empty")
+	}
+	switch f {
+	case FormatPNG:
+		return renderPNG(bitmap, width, height)
+	case FormatSVG:
+		return renderSVG(bitmap, width, height), nil
+	case FormatPDF:
+		return renderPDF(bitmap, width, height), nil
+	case FormatEPS:
+		return renderEPS(bitmap, width, height), nil
+	}
+	return nil, fmt.Errorf("format %q is not supported", f)
+}
+
+// renderPNG draws the bitmap as a two color PNG image, sampling the module under every pixel
+func renderPNG(bitmap [][]bool, width, height int) ([]byte, error) {
+	rows, cols := len(bitmap), len(bitmap[0])
+	img := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{color.White, color.Black})
+	for y := 0; y < height; y++ {
+		row := bitmap[y*rows/height]
+		for x := 0; x < width; x++ {
+			if row[x*cols/width] {
+				img.Pix[y*img.Stride+x] = 1
+			}
+		}
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// renderSVG draws the bitmap as an SVG document. The viewBox is in modules and the document is scaled to
+// width x height.
+func renderSVG(bitmap [][]bool, width, height int) []byte {
+	rows, cols := len(bitmap), len(bitmap[0])
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" preserveAspectRatio="none" shape-rendering="crispEdges">`, width, height, cols, rows)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#ffffff"/>`, cols, rows)
+	b.WriteString(`<path fill="#000000" d="`)
+	for _, r := range runs(bitmap) {
+		fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", r.x, r.y, r.length, r.length)
+	}
+	b.WriteString(`"/></svg>` + "\n")
+	return b.Bytes()
+}
+
+// renderPDF draws the bitmap as a single page PDF document of width x height points
+func renderPDF(bitmap [][]bool, width, height int) []byte {
+	rows, cols := len(bitmap), len(bitmap[0])
+	sx, sy := float64(width)/float64(cols), float64(height)/float64(rows)
+
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "1 1 1 rg\n0 0 %d %d re f\n0 0 0 rg\n", width, height)
+	for _, r := range runs(bitmap) {
+		fmt.Fprintf(&content, "%.4f %.4f %.4f %.4f re\n", float64(r.x)*sx, float64(rows-1-r.y)*sy, float64(r.length)*sx, sy)
+	}
+	content.WriteString("f\n")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Contents 4 0 R /Resources << >> >>", width, height),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return b.Bytes()
+}
+
+// renderEPS draws the bitmap as an encapsulated PostScript document of width x height points
+func renderEPS(bitmap [][]bool, width, height int) []byte {
+	rows, cols := len(bitmap), len(bitmap[0])
+	sx, sy := float64(width)/float64(cols), float64(height)/float64(rows)
+
+	var b bytes.Buffer
+	b.WriteString("%!PS-Adobe-3.0 EPSF-3.0\n")
+	b.WriteString("%%Creator: port\n")
+	fmt.Fprintf(&b, "%%%%BoundingBox: 0 0 %d %d\n", width, height)
+	b.WriteString("%%EndComments\n")
+	fmt.Fprintf(&b, "1 1 1 setrgbcolor\n0 0 %d %d rectfill\n0 0 0 setrgbcolor\n", width, height)
+	for _, r := range runs(bitmap) {
+		fmt.Fprintf(&b, "%.4f %.4f %.4f %.4f rectfill\n", float64(r.x)*sx, float64(rows-1-r.y)*sy, float64(r.length)*sx, sy)
+	}
+	b.WriteString("showpage\n%%EOF\n")
+	return b.Bytes()
+}
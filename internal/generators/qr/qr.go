@@ -2,15 +2,15 @@ package qr
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"github.com/dark-enstein/port/config"
-	amazon "github.com/dark-enstein/port/internal/cloud/aws"
+	"github.com/dark-enstein/port/internal/cloud"
 	"github.com/dark-enstein/port/util"
 	"github.com/google/uuid"
-	"github.com/rs/zerolog"
 	qrcode "github.com/skip2/go-qrcode"
-	"os"
-	"path/filepath"
 	"reflect"
 )
 
@@ -25,27 +25,65 @@ func (t *Type) String() string {
 }
 
 var (
-	Factory    = func(filename string) string { return filepath.Join(DefaultDir, filename) }
-	GetFactory = func(filename string, log *zerolog.Logger) (*os.File, error) {
-		if err := os.MkdirAll(DefaultDir, 0755); err != nil {
-			log.Error().Err(fmt.Errorf("failed to create qr directory: %w", err))
-			return nil, err
-		}
-		return os.OpenFile(Factory(filename), os.O_RDWR|os.O_CREATE, 0755)
-	}
-	DefaultFilename = uuid.New().String() + ".png"
-	DefaultDir      = filepath.Join(".qr", "generated")
+	DefaultFilename = uuid.New().String() + DefaultFormat.Extension()
 )
 
+// fingerprintVersion is bumped whenever rendering changes, so codes rendered before the change aren't reused
+const fingerprintVersion = 1
+
+// fingerprint holds the normalized parameters a QR code is rendered from
+type fingerprint struct {
+	Version int               `json:"v"`
+	Content string            `json:"content"`
+	Level   int               `json:"level"`
+	Format  Format            `json:"format"`
+	Width   int               `json:"width"`
+	Style   *styleFingerprint `json:"style,omitempty"`
+}
+
 // QR defines the structure of a QRcode
 type QR struct {
 	id            string
 	content       string
 	size          int
 	recoveryLevel qrcode.RecoveryLevel
+	format        Format
 	Code          *qrcode.QRCode
 	ctx           context.Context
 	uploadedLoc   string
+	rendered      []byte
+	url           string
+	selfCheck     bool
+	style         *Style
+	width         int
+}
+
+// Result describes a rendered QR code, and where it was uploaded to if it was. A result reused from an earlier
+// render carries its Bytes and Key, but not its Data, which stays in storage.
+type Result struct {
+	ID       string
+	Key      string
+	URL      string
+	Format   Format
+	Width    int
+	Height   int
+	Data     []byte
+	Bytes    int
+	Checksum string
+	Cached   bool
+}
+
+// Size returns the size of the rendered QR code in bytes
+func (r *Result) Size() int {
+	if r.Data == nil {
+		return r.Bytes
+	}
+	return len(r.Data)
+}
+
+// DataURI returns the rendered QR code as a base64 encoded data URI
+func (r *Result) DataURI() string {
+	return "data:" + r.Format.MIME() + ";base64," + base64.StdEncoding.EncodeToString(r.Data)
 }
 
 // NewQR generates an empty QR. It receives no arguments, and returns a QR pointer defined by empty fields
@@ -60,6 +98,7 @@ func NewQRWithArgs(ctx context.Context, id, content string, size int, recoveryLe
 		content:       content,
 		size:          size,
 		recoveryLevel: recoveryLevel,
+		format:        DefaultFormat,
 		Code:          &qrcode.QRCode{},
 		ctx:           ctx,
 	}
@@ -89,68 +128,176 @@ func (q *QR) WithRecoveryLevel(rec qrcode.RecoveryLevel) *QR {
 	return q
 }
 
+// WithFormat persists the passed in output format into the receiver QR.
+func (q *QR) WithFormat(f Format) *QR {
+	q.format = f
+	return q
+}
+
+// WithVerify sets whether the receiver QR decodes every code it renders, to check that it reads back as its content.
+func (q *QR) WithVerify(verify bool) *QR {
+	q.selfCheck = verify
+	return q
+}
+
+// WithStyle sets the style the receiver QR is drawn in. A nil style draws a plain black on white code.
+func (q *QR) WithStyle(style *Style) *QR {
+	q.style = style
+	return q
+}
+
 // Generate encodes the content from QR into a QRcode, and saves it on disk/or in buffer.
 func (q *QR) Generate() (string, error) {
 	return q.upload()
 }
 
+// Render encodes the content from QR into a QRcode in memory only. Nothing is written to disk or uploaded.
+func (q *QR) Render() (*Result, error) {
+	log := util.RetrieveLoggerFromCtx(q.ctx).WithMethod("Render()")
+	if err := q.encode(); err != nil {
+		log.Error().Msgf("encoding qrcode failed with error: %v", err)
+		return nil, err
+	}
+	return q.Result(), nil
+}
+
+// Result returns the description of the last rendered QRcode. It returns nil if nothing has been rendered yet.
+func (q *QR) Result() *Result {
+	if q.rendered == nil {
+		return nil
+	}
+	sum := sha256.Sum256(q.rendered)
+	return &Result{
+		ID:       q.id,
+		Key:      q.uploadedLoc,
+		URL:      q.url,
+		Format:   q.format,
+		Width:    q.width,
+		Height:   q.width,
+		Data:     q.rendered,
+		Checksum: "sha256:" + hex.EncodeToString(sum[:]),
+	}
+}
+
 func (q *QR) upload() (string, error) {
 	log := util.RetrieveLoggerFromCtx(q.ctx).WithMethod("Generate()")
 	err := q.generate()
 	if err != nil {
 		return "", err
 	}
-	q.ctx = context.WithValue(q.ctx, util.QRLocInContext, q.uploadedLoc)
 
-	comp := amazon.NewCompose(config.DefaultFlagLOC, amazon.S3E, util.UPLOAD)
-	interact, err := comp.NewSessionWithOptions(q.ctx)
+	store := cloud.RetrieveStorageFromCtx(q.ctx)
+	obj, err := store.Put(q.ctx, q.key(), q.format.MIME(), q.rendered)
 	if err != nil {
-		log.Error().Err(fmt.Errorf("encountered error while trying to upload qr code: %w", err))
+		log.Error().Err(fmt.Errorf("encountered error while trying to upload qr code: %w", err)).Send()
 		return "", err
 	}
+	q.uploadedLoc = obj.Key
+	q.url = store.URL(obj.Key)
+	log.Debug().Msgf("qr code saved to %v storage as %v", store.Kind(), obj.Key)
 
-	resp := interact.Do(q.ctx, comp.Service(), comp.Verb())
-	if resp.Err == context.DeadlineExceeded {
-		log.Error().Err(fmt.Errorf("file upload failed due to: %w", err))
-		return "", context.DeadlineExceeded
-	}
-
-	return resp.Name, resp.Err
+	return q.url, nil
 }
 
-// generate encodes the content from QR into a QRcode, and saves it on disk/or in buffer.
+// generate encodes the content from QR into a QRcode, ready to be saved to storage.
 func (q *QR) generate() error {
-	log := util.RetrieveLoggerFromCtx(q.ctx).WithMethod("Generate()")
+	return q.encode()
+}
+
+// key returns the storage key of the QR. It is the QR id, carrying the extension of the output format
+func (q *QR) key() string {
+	if q.id == "" {
+		return DefaultFilename
+	}
+	return q.id + q.format.Extension()
+}
+
+// encode encodes the content from QR into a QRcode, and renders it into the receiver's output format.
+func (q *QR) encode() error {
+	log := util.RetrieveLoggerFromCtx(q.ctx).WithMethod("encode()")
 	var err error
-	q.Code, err = qrcode.New(q.content, q.recoveryLevel)
+	q.Code, err = q.symbol()
 	if err != nil {
-		log.Error().Msgf("qrcode.New() failed with error: %w", err)
+		log.Error().Msgf("encoding symbol failed with error: %v", err)
 		return err
 	}
 
-	if q.id != "" {
-		DefaultFilename = q.id + ".png"
-	}
-	factory, err := GetFactory(DefaultFilename, log)
+	q.rendered, err = q.render()
 	if err != nil {
-		log.Error().Msgf("GetFactory() failed with error: %w", err.Error())
+		log.Error().Msgf("rendering qrcode as %v failed with error: %v", q.format, err)
 		return err
 	}
-	q.uploadedLoc = factory.Name()
 
-	defer func(factory *os.File) {
-		err = factory.Close()
-		if err != nil {
-			log.Error().Msgf("Closing open file failed with error: %w", err.Error())
-			return
+	if q.selfCheck {
+		if err := q.verify(); err != nil {
+			log.Error().Msgf("verifying qrcode failed with error: %v", err)
+			return err
 		}
-	}(factory)
+	}
+	return nil
+}
 
-	err = q.Code.Write(q.size, factory)
+// symbol encodes the content into the modules of a QRcode, loading the style of the receiver first
+func (q *QR) symbol() (*qrcode.QRCode, error) {
+	level := q.recoveryLevel
+	if q.style != nil {
+		if err := q.style.Load(q.ctx); err != nil {
+			return nil, err
+		}
+		// a logo hides part of the code, which has to be recovered from the error correction
+		if q.style.HasLogo() {
+			level = qrcode.Highest
+		}
+	}
+	code, err := qrcode.New(q.content, level)
 	if err != nil {
-		log.Error().Msgf("Writing qrcode image to file failed with error: %w", err)
-		return err
-	} //write to file and buffer
+		return nil, err
+	}
+	// styled codes draw their own quiet zone
+	code.DisableBorder = q.style != nil
+	return code, nil
+}
 
-	return nil
+// Fingerprint returns a hash of everything that decides the bytes the receiver QR renders to: QRs with the same
+// fingerprint render identically. The content is encoded to work out the size of the image, but not rendered.
+func (q *QR) Fingerprint() (string, error) {
+	code, err := q.symbol()
+	if err != nil {
+		return "", err
+	}
+	format := q.format
+	if format == "" {
+		format = DefaultFormat
+	}
+	modules := len(code.Bitmap())
+	params := fingerprint{Version: fingerprintVersion, Content: q.content, Level: int(code.Level), Format: format}
+	if q.style != nil {
+		params.Style = q.style.fingerprint()
+		modules = q.style.modules(modules)
+	}
+	params.Width = moduleWidth(q.size, modules)
+	b, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// render encodes the generated QRcode bitmap into the bytes of the receiver's output format.
+func (q *QR) render() ([]byte, error) {
+	if q.format == "" {
+		q.format = DefaultFormat
+	}
+	bitmap := q.Code.Bitmap()
+	if q.style != nil {
+		b, width, err := q.style.render(bitmap, q.size, q.format)
+		q.width = width
+		return b, err
+	}
+	q.width = moduleWidth(q.size, len(bitmap))
+	if q.format == FormatPNG {
+		return q.Code.PNG(q.size)
+	}
+	return Draw(bitmap, q.width, q.width, q.format)
 }
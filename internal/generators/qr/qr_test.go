@@ -0,0 +1,235 @@
+package qr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/internal/cloud/memory"
+	"github.com/dark-enstein/port/util"
+	"github.com/skip2/go-qrcode"
+	"github.com/stretchr/testify/suite"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+type QRTest struct {
+	ctx     context.Context
+	formats []FormatTable
+	suite.Suite
+}
+
+// FormatTable defines a sample output format and the signature its rendered bytes are expected to start with
+type FormatTable struct {
+	format    Format
+	signature []byte
+}
+
+func (s *QRTest) SetupTest() {
+	fmt.Println("Starting tests...")
+	s.ctx = context.WithValue(context.Background(), util.LoggerInContext, config.NewLoggerWithWarn())
+	s.formats = []FormatTable{
+		{format: FormatPNG, signature: []byte("\x89PNG")},
+		{format: FormatSVG, signature: []byte("<?xml")},
+		{format: FormatPDF, signature: []byte("%PDF-1.4")},
+		{format: FormatEPS, signature: []byte("%!PS-Adobe-3.0 EPSF-3.0")},
+	}
+	fmt.Println("Tests startup complete...")
+}
+
+// TestParseFormat tests that client format names resolve to the supported formats
+func (s *QRTest) TestParseFormat() {
+	f, err := ParseFormat("")
+	s.Require().NoError(err)
+	s.Assert().Equal(DefaultFormat, f)
+
+	f, err = ParseFormat("SVG")
+	s.Require().NoError(err)
+	s.Assert().Equal(FormatSVG, f)
+	s.Assert().Equal(".svg", f.Extension())
+	s.Assert().Equal("image/svg+xml", f.MIME())
+
+	_, err = ParseFormat("gif")
+	s.Assert().Error(err)
+}
+
+// TestRender tests that every supported format renders from the QR bitmap
+func (s *QRTest) TestRender() {
+	for _, table := range s.formats {
+		q := NewQRWithArgs(context.Background(), "test", "https://github.com/dark-enstein/port", 256, qrcode.Medium).WithFormat(table.format)
+		var err error
+		q.Code, err = qrcode.New(q.content, q.recoveryLevel)
+		s.Require().NoError(err)
+
+		b, err := q.render()
+		s.Require().NoError(err)
+		s.Assert().Truef(bytes.HasPrefix(b, table.signature), "%v output doesn't start with %q", table.format, table.signature)
+	}
+}
+
+// TestDecode tests that rendered codes decode back to their content, symbol version and error correction level
+func (s *QRTest) TestDecode() {
+	q := NewQRWithArgs(s.ctx, "test", "https://github.com/dark-enstein/port", 256, qrcode.High)
+	res, err := q.Render()
+	s.Require().NoError(err)
+
+	decoded, err := Decode(bytes.NewReader(res.Data))
+	s.Require().NoError(err)
+	s.Assert().Equal(q.content, decoded.Content)
+	s.Assert().Equal((len(q.Code.Bitmap())-8-17)/4, decoded.Version)
+	s.Assert().Equal("Q", decoded.Level)
+	s.Assert().Equal(qrcode.High, decoded.RecoveryLevel)
+	s.Assert().False(decoded.Bounds.Empty())
+	s.Assert().True(decoded.Bounds.In(image.Rect(0, 0, res.Width, res.Height)))
+
+	var jpg bytes.Buffer
+	s.Require().NoError(jpeg.Encode(&jpg, q.Code.Image(256), nil))
+	decoded, err = Decode(&jpg)
+	s.Require().NoError(err)
+	s.Assert().Equal(q.content, decoded.Content)
+
+	blank := image.NewGray(image.Rect(0, 0, 64, 64))
+	_, err = DecodeImage(blank)
+	s.Assert().ErrorIs(err, ErrNoCode)
+}
+
+// TestVerify tests that the self-check decodes codes in every format
+func (s *QRTest) TestVerify() {
+	for _, table := range s.formats {
+		q := NewQRWithArgs(s.ctx, "test", "https://github.com/dark-enstein/port", 256, qrcode.Medium).WithFormat(table.format).WithVerify(true)
+		_, err := q.Render()
+		s.Require().NoErrorf(err, "%v", table.format)
+	}
+}
+
+// TestStyleValidate tests that styles are checked, and that low contrast colors are rejected
+func (s *QRTest) TestStyleValidate() {
+	zone := 2
+	style := &Style{Foreground: "#1a237e", Background: "#ffffff00", QuietZone: &zone, Modules: ShapeDot}
+	s.Require().NoError(style.Validate())
+	s.Assert().Equal(ShapeSquare, style.Finder)
+	s.Assert().Equal(color.NRGBA{R: 0x1a, G: 0x23, B: 0x7e, A: 0xff}, style.fg)
+	s.Assert().Equal(uint8(0), style.bg.A)
+
+	negative := -1
+	for _, invalid := range []*Style{
+		{Foreground: "black"},
+		{Background: "#fffff"},
+		{Modules: "star"},
+		{QuietZone: &negative},
+		{Logo: &Logo{}},
+		{Logo: &Logo{ID: "logo", Data: []byte{}}},
+		{Logo: &Logo{ID: "logo", Size: 0.5}},
+		{Logo: &Logo{ID: "../logo"}},
+		{Logo: &Logo{Data: []byte("not an image")}},
+	} {
+		s.Assert().Errorf(invalid.Validate(), "%+v", invalid)
+	}
+	s.Assert().ErrorIs((&Style{Foreground: "#777777", Background: "#888888"}).Validate(), ErrLowContrast)
+	s.Assert().ErrorIs((&Style{Foreground: "#00000033"}).Validate(), ErrLowContrast)
+	s.Assert().ErrorIs((&Style{Foreground: "#ffffff", Background: "#000000"}).Validate(), ErrLowContrast)
+	s.Assert().ErrorIs((&Style{}).Supports(FormatPDF), ErrInvalidStyle)
+}
+
+// TestStyledRender tests that styled codes, in every shape, color and with a logo, read back as their content
+func (s *QRTest) TestStyledRender() {
+	store := memory.NewStore("http://localhost")
+	ctx := context.WithValue(s.ctx, util.StorageInContext, store)
+	_, err := store.Put(ctx, LogoKey("brand"), FormatPNG.MIME(), s.logo(color.NRGBA{R: 0xe5, G: 0x39, B: 0x35, A: 0xff}))
+	s.Require().NoError(err)
+
+	zone := 2
+	styles := []*Style{
+		{},
+		{Foreground: "#1a237e", Background: "#fff8e1", Modules: ShapeRounded, Finder: ShapeRounded},
+		{Foreground: "#000000cc", Background: "#00000000", Modules: ShapeDot, Finder: ShapeDot, QuietZone: &zone},
+		{Modules: ShapeDot, Finder: ShapeRounded, Logo: &Logo{ID: "brand"}},
+		{Foreground: "#004d40", Logo: &Logo{Data: s.logo(color.NRGBA{G: 0x80, B: 0xff, A: 0xff}), Size: MaxLogoSize}},
+	}
+	for i, style := range styles {
+		for _, f := range StyledFormats {
+			q := NewQRWithArgs(ctx, "test", "https://github.com/dark-enstein/port", 300, qrcode.Low).WithFormat(f).WithStyle(style).WithVerify(true)
+			res, err := q.Render()
+			s.Require().NoErrorf(err, "style %d as %v", i, f)
+			s.Assert().Equal(300, res.Width)
+			if style.HasLogo() {
+				s.Assert().Equal(qrcode.Highest, q.Code.Level)
+			}
+		}
+	}
+
+	q := NewQRWithArgs(ctx, "test", "https://github.com/dark-enstein/port", -4, qrcode.Low).WithStyle(&Style{QuietZone: &zone})
+	res, err := q.Render()
+	s.Require().NoError(err)
+	s.Assert().Equal(4*(len(q.Code.Bitmap())+2*zone), res.Width)
+
+	_, err = NewQRWithArgs(ctx, "test", "content", 256, qrcode.Low).WithStyle(&Style{Logo: &Logo{ID: "missing"}}).Render()
+	s.Assert().ErrorIs(err, ErrLogoNotFound)
+	_, err = NewQRWithArgs(ctx, "test", "content", 256, qrcode.Low).WithFormat(FormatEPS).WithStyle(&Style{}).Render()
+	s.Assert().ErrorIs(err, ErrInvalidStyle)
+}
+
+// TestFingerprint tests that QRs rendering the same bytes share a fingerprint, and that any change to what is
+// rendered changes it
+func (s *QRTest) TestFingerprint() {
+	fingerprint := func(q *QR) string {
+		fp, err := q.Fingerprint()
+		s.Require().NoError(err)
+		return fp
+	}
+	content := "https://github.com/dark-enstein/port"
+	base := fingerprint(NewQRWithArgs(s.ctx, "a", content, 256, qrcode.Medium))
+	s.Assert().Len(base, 64)
+	s.Assert().Equal(base, fingerprint(NewQRWithArgs(s.ctx, "b", content, 256, qrcode.Medium).WithFormat(FormatPNG).WithVerify(true)))
+
+	zone := DefaultQuietZone
+	for _, other := range []*QR{
+		NewQRWithArgs(s.ctx, "a", content+"/", 256, qrcode.Medium),
+		NewQRWithArgs(s.ctx, "a", content, 512, qrcode.Medium),
+		NewQRWithArgs(s.ctx, "a", content, 256, qrcode.High),
+		NewQRWithArgs(s.ctx, "a", content, 256, qrcode.Medium).WithFormat(FormatSVG),
+		NewQRWithArgs(s.ctx, "a", content, 256, qrcode.Medium).WithStyle(&Style{QuietZone: &zone}),
+		NewQRWithArgs(s.ctx, "a", content, 256, qrcode.Medium).WithStyle(&Style{Foreground: "#1a237e"}),
+	} {
+		s.Assert().NotEqual(base, fingerprint(other))
+	}
+
+	// styles are normalized before they're hashed
+	s.Assert().Equal(
+		fingerprint(NewQRWithArgs(s.ctx, "a", content, 256, qrcode.Medium).WithStyle(&Style{Foreground: "#000", Modules: ShapeSquare})),
+		fingerprint(NewQRWithArgs(s.ctx, "a", content, 256, qrcode.Medium).WithStyle(&Style{QuietZone: &zone})),
+	)
+	// a logo raises the recovery level, whatever level was asked for
+	logo := s.logo(color.NRGBA{R: 0xff, A: 0xff})
+	s.Assert().Equal(
+		fingerprint(NewQRWithArgs(s.ctx, "a", content, 256, qrcode.Low).WithStyle(&Style{Logo: &Logo{Data: logo}})),
+		fingerprint(NewQRWithArgs(s.ctx, "a", content, 256, qrcode.Highest).WithStyle(&Style{Logo: &Logo{Data: logo}})),
+	)
+}
+
+// logo returns a PNG logo: a disc of the color passed in, on white
+func (s *QRTest) logo(c color.NRGBA) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+			if (x-32)*(x-32)+(y-32)*(y-32) < 30*30 {
+				img.SetNRGBA(x, y, c)
+			}
+		}
+	}
+	var b bytes.Buffer
+	s.Require().NoError(png.Encode(&b, img))
+	return b.Bytes()
+}
+
+func (s *QRTest) TearDownSuite() {
+	fmt.Println("All testing complete")
+}
+
+func TestQRTest(t *testing.T) {
+	suite.Run(t, new(QRTest))
+}
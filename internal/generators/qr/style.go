@@ -0,0 +1,530 @@
+package qr
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/dark-enstein/port/internal/cloud"
+	xdraw "golang.org/x/image/draw"
+)
+
+// Shape defines how the modules, or the finder patterns, of a styled QR code are drawn
+type Shape string
+
+const (
+	ShapeSquare  Shape = "square"
+	ShapeRounded Shape = "rounded"
+	ShapeDot     Shape = "dot"
+)
+
+var (
+	SupportedShapes = []Shape{ShapeSquare, ShapeRounded, ShapeDot}
+	StyledFormats   = []Format{FormatPNG, FormatSVG}
+
+	DefaultForeground = "#000000"
+	DefaultBackground = "#ffffff"
+	// DefaultQuietZone is the width, in modules, of the margin around the code. It's the width ISO/IEC 18004 asks for.
+	DefaultQuietZone = 4
+	MaxQuietZone     = 16
+	// MinContrast is the lowest contrast ratio, as WCAG defines it, allowed between the foreground and the background.
+	// It's the ratio WCAG asks of graphical objects; fainter codes fail on cheap cameras and in poor light.
+	MinContrast = 3.0
+
+	// DefaultLogoSize and MaxLogoSize are the width of a logo, as a fraction of the width of the code without its
+	// quiet zone. Codes carrying a logo are encoded with the highest recovery level, which restores up to 30% of
+	// the code, and a logo of MaxLogoSize covers about half of that.
+	DefaultLogoSize = 0.2
+	MinLogoSize     = 0.05
+	MaxLogoSize     = 0.3
+	// MaxLogoPixels bounds the width and height of logo images, so small uploads can't decode into huge images
+	MaxLogoPixels = 4096
+
+	ErrInvalidStyle = errors.New("style is invalid")
+	ErrLowContrast  = errors.New("colors don't contrast enough to be scanned reliably")
+	ErrInvalidLogo  = errors.New("logo is invalid")
+	ErrLogoNotFound = errors.New("logo not found")
+)
+
+const (
+	// roundness is the radius of the corners of rounded shapes, as a fraction of their width
+	roundness = 0.35
+	// dotSize is the diameter of dot modules, as a fraction of a module. A small gap keeps neighbouring dots apart.
+	dotSize = 0.9
+	// logoMargin is the space, in modules, cleared between a logo and the modules around it
+	logoMargin = 0.5
+	// finderSize is the width, in modules, of a finder pattern
+	finderSize = 7
+	// samples is the number of samples taken along each axis of a pixel, to smooth the edges of shapes
+	samples = 3
+)
+
+// Style describes how a QR code is drawn. Its zero value draws black square modules on white, like an unstyled code.
+type Style struct {
+	// Foreground and Background are hex colors: #rgb, #rrggbb, or #rrggbbaa to carry transparency
+	Foreground string `bson:"foreground,omitempty" json:"foreground,omitempty"`
+	Background string `bson:"background,omitempty" json:"background,omitempty"`
+	// QuietZone is the width, in modules, of the margin around the code. It defaults to DefaultQuietZone.
+	QuietZone *int  `bson:"quiet_zone,omitempty" json:"quiet_zone,omitempty"`
+	Modules   Shape `bson:"modules,omitempty" json:"modules,omitempty"`
+	Finder    Shape `bson:"finder,omitempty" json:"finder,omitempty"`
+	Logo      *Logo `bson:"logo,omitempty" json:"logo,omitempty"`
+
+	fg, bg color.NRGBA
+	quiet  int
+	logo   image.Image
+}
+
+// Logo is an image drawn over the center of a QR code. It's either uploaded inline, or references a logo uploaded
+// beforehand by its ID.
+type Logo struct {
+	ID   string  `bson:"id,omitempty" json:"id,omitempty"`
+	Data []byte  `bson:"data,omitempty" json:"data,omitempty"`
+	Size float64 `bson:"size,omitempty" json:"size,omitempty"`
+}
+
+// LogoKey returns the storage key of the logo with the ID passed in
+func LogoKey(id string) string {
+	return "logo-" + id + FormatPNG.Extension()
+}
+
+// ParseLogo decodes a PNG or JPEG logo, and checks that it isn't too large to be drawn
+func ParseLogo(data []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLogo, err)
+	}
+	if cfg.Width > MaxLogoPixels || cfg.Height > MaxLogoPixels {
+		return nil, fmt.Errorf("%w: logo must not be larger than %dx%d pixels", ErrInvalidLogo, MaxLogoPixels, MaxLogoPixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLogo, err)
+	}
+	return img, nil
+}
+
+// Validate checks the style, and fills in the defaults of the fields left empty. Logos referenced by ID are only
+// checked once loaded.
+func (s *Style) Validate() error {
+	var err error
+	if s.fg, err = parseColor(s.Foreground, DefaultForeground); err != nil {
+		return fmt.Errorf("%w: foreground %v", ErrInvalidStyle, err)
+	}
+	if s.bg, err = parseColor(s.Background, DefaultBackground); err != nil {
+		return fmt.Errorf("%w: background %v", ErrInvalidStyle, err)
+	}
+	if ratio := contrast(s.fg, s.bg); ratio < MinContrast {
+		return fmt.Errorf("%w: contrast ratio is %.2f, it must be at least %.1f", ErrLowContrast, ratio, MinContrast)
+	}
+	if luminance(s.fg) > luminance(s.bg) {
+		return fmt.Errorf("%w: foreground must be darker than background, most scanners can't read inverted codes", ErrLowContrast)
+	}
+
+	s.quiet = DefaultQuietZone
+	if s.QuietZone != nil {
+		s.quiet = *s.QuietZone
+	}
+	if s.quiet < 0 || s.quiet > MaxQuietZone {
+		return fmt.Errorf("%w: quiet_zone must be between 0 and %d modules", ErrInvalidStyle, MaxQuietZone)
+	}
+
+	if s.Modules == "" {
+		s.Modules = ShapeSquare
+	}
+	if s.Finder == "" {
+		s.Finder = ShapeSquare
+	}
+	for field, shape := range map[string]Shape{"modules": s.Modules, "finder": s.Finder} {
+		if !shape.isValid() {
+			return fmt.Errorf("%w: %v shape %q is not one of %v", ErrInvalidStyle, field, shape, SupportedShapes)
+		}
+	}
+
+	if s.Logo == nil {
+		return nil
+	}
+	if (s.Logo.ID == "") == (s.Logo.Data == nil) {
+		return fmt.Errorf("%w: logo must carry either an id or data", ErrInvalidLogo)
+	}
+	if s.Logo.Size == 0 {
+		s.Logo.Size = DefaultLogoSize
+	}
+	if s.Logo.Size < MinLogoSize || s.Logo.Size > MaxLogoSize {
+		return fmt.Errorf("%w: logo size must be between %v and %v", ErrInvalidLogo, MinLogoSize, MaxLogoSize)
+	}
+	if s.Logo.Data != nil {
+		s.logo, err = ParseLogo(s.Logo.Data)
+		return err
+	}
+	if err := cloud.ValidateKey(LogoKey(s.Logo.ID)); err != nil {
+		return fmt.Errorf("%w: id %q is not a valid logo id", ErrInvalidLogo, s.Logo.ID)
+	}
+	return nil
+}
+
+// Supports checks that the style can be drawn in the format passed in
+func (s *Style) Supports(f Format) error {
+	for _, supported := range StyledFormats {
+		if f == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: styled codes can only be rendered as one of %v", ErrInvalidStyle, StyledFormats)
+}
+
+// Load validates the style, and loads the logo it references from the storage in ctx
+func (s *Style) Load(ctx context.Context) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if s.Logo == nil || s.logo != nil {
+		return nil
+	}
+	data, _, err := cloud.RetrieveStorageFromCtx(ctx).Get(ctx, LogoKey(s.Logo.ID))
+	if errors.Is(err, cloud.ErrObjectNotFound) {
+		return fmt.Errorf("%w: %v", ErrLogoNotFound, s.Logo.ID)
+	}
+	if err != nil {
+		return err
+	}
+	s.logo, err = ParseLogo(data)
+	return err
+}
+
+// styleFingerprint holds the normalized parameters of a style
+type styleFingerprint struct {
+	Foreground string  `json:"fg"`
+	Background string  `json:"bg"`
+	QuietZone  int     `json:"quiet_zone"`
+	Modules    Shape   `json:"modules"`
+	Finder     Shape   `json:"finder"`
+	Logo       string  `json:"logo,omitempty"`
+	LogoSize   float64 `json:"logo_size,omitempty"`
+}
+
+// fingerprint returns the normalized parameters of a validated style. Uploaded logos are immutable, so they're
+// identified by ID, and inline logos by a hash of their data.
+func (s *Style) fingerprint() *styleFingerprint {
+	f := &styleFingerprint{
+		Foreground: hexColor(s.fg),
+		Background: hexColor(s.bg),
+		QuietZone:  s.quiet,
+		Modules:    s.Modules,
+		Finder:     s.Finder,
+	}
+	switch {
+	case s.Logo == nil:
+	case s.Logo.ID != "":
+		f.Logo, f.LogoSize = "id:"+s.Logo.ID, s.Logo.Size
+	default:
+		sum := sha256.Sum256(s.Logo.Data)
+		f.Logo, f.LogoSize = "sha256:"+hex.EncodeToString(sum[:]), s.Logo.Size
+	}
+	return f
+}
+
+// HasLogo reports whether a logo is drawn over the code
+func (s *Style) HasLogo() bool {
+	return s != nil && s.Logo != nil
+}
+
+func (sh Shape) isValid() bool {
+	for _, supported := range SupportedShapes {
+		if sh == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// modules returns the width, in modules, of the code drawn from a bitmap of n modules, quiet zone included
+func (s *Style) modules(n int) int {
+	return n + 2*s.quiet
+}
+
+// render draws a bitmap of n x n modules, without a quiet zone, in the format passed in. It returns the rendered
+// bytes along with the width of the image.
+func (s *Style) render(bitmap [][]bool, size int, f Format) ([]byte, int, error) {
+	if err := s.Supports(f); err != nil {
+		return nil, 0, err
+	}
+	width := moduleWidth(size, s.modules(len(bitmap)))
+	if f == FormatSVG {
+		b, err := s.svg(bitmap, width)
+		return b, width, err
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, s.raster(bitmap, width)); err != nil {
+		return nil, 0, err
+	}
+	return b.Bytes(), width, nil
+}
+
+// logoBox returns the area, in the modules of a bitmap of n modules, the logo is drawn into, and the area cleared of
+// modules around it. The cleared area is snapped to whole modules, so no module is drawn partly.
+func (s *Style) logoBox(n int) (box, hole [4]float64) {
+	w := s.Logo.Size * float64(n)
+	lo, hi := (float64(n)-w)/2, (float64(n)+w)/2
+	box = [4]float64{lo, lo, hi, hi}
+	hlo, hhi := math.Floor(lo-logoMargin), math.Ceil(hi+logoMargin)
+	hole = [4]float64{hlo, hlo, hhi, hhi}
+	return box, hole
+}
+
+// finders returns the top left corner of the three finder patterns of a bitmap of n modules
+func finders(n int) [][2]int {
+	return [][2]int{{0, 0}, {n - finderSize, 0}, {0, n - finderSize}}
+}
+
+// dark reports whether the point (x, y), in the modules of a bitmap without its quiet zone, is painted with the
+// foreground color
+func (s *Style) dark(bitmap [][]bool, x, y float64) bool {
+	n := len(bitmap)
+	if x < 0 || y < 0 || x >= float64(n) || y >= float64(n) {
+		return false
+	}
+	for _, f := range finders(n) {
+		fx, fy := x-float64(f[0]), y-float64(f[1])
+		if fx >= 0 && fy >= 0 && fx < finderSize && fy < finderSize {
+			ring := inside(s.Finder, fx, fy, finderSize) && !inside(s.Finder, fx-1, fy-1, finderSize-2)
+			return ring || inside(s.Finder, fx-2, fy-2, 3)
+		}
+	}
+	if s.Logo != nil {
+		if _, hole := s.logoBox(n); x >= hole[0] && y >= hole[1] && x < hole[2] && y < hole[3] {
+			return false
+		}
+	}
+	mx, my := math.Floor(x), math.Floor(y)
+	if !bitmap[int(my)][int(mx)] {
+		return false
+	}
+	if s.Modules == ShapeDot {
+		inset := (1 - dotSize) / 2
+		return inside(ShapeDot, x-mx-inset, y-my-inset, dotSize)
+	}
+	return inside(s.Modules, x-mx, y-my, 1)
+}
+
+// inside reports whether the point (x, y) lies in a shape of width size, whose bounding box sits at the origin
+func inside(shape Shape, x, y, size float64) bool {
+	if x < 0 || y < 0 || x >= size || y >= size {
+		return false
+	}
+	switch shape {
+	case ShapeDot:
+		r := size / 2
+		return (x-r)*(x-r)+(y-r)*(y-r) <= r*r
+	case ShapeRounded:
+		r := size * roundness
+		cx, cy := math.Max(r, math.Min(x, size-r)), math.Max(r, math.Min(y, size-r))
+		return (x-cx)*(x-cx)+(y-cy)*(y-cy) <= r*r
+	}
+	return true
+}
+
+// raster draws a bitmap as an image of width x width pixels. Each pixel is sampled a few times, so the edges of
+// round shapes are smooth.
+func (s *Style) raster(bitmap [][]bool, width int) *image.NRGBA {
+	n := len(bitmap)
+	scale := float64(s.modules(n)) / float64(width)
+	quiet := float64(s.quiet)
+	img := image.NewNRGBA(image.Rect(0, 0, width, width))
+	for py := 0; py < width; py++ {
+		for px := 0; px < width; px++ {
+			hits := 0
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					x := (float64(px)+(float64(sx)+0.5)/samples)*scale - quiet
+					y := (float64(py)+(float64(sy)+0.5)/samples)*scale - quiet
+					if s.dark(bitmap, x, y) {
+						hits++
+					}
+				}
+			}
+			img.SetNRGBA(px, py, mix(s.bg, s.fg, float64(hits)/(samples*samples)))
+		}
+	}
+	if s.logo != nil {
+		box, _ := s.logoBox(n)
+		xdraw.CatmullRom.Scale(img, fit(s.logo.Bounds(), box, quiet, scale), s.logo, s.logo.Bounds(), xdraw.Over, nil)
+	}
+	return img
+}
+
+// fit returns the pixel rectangle a logo is scaled into: the largest one fitting the box, in modules, that keeps the
+// aspect ratio of the logo
+func fit(logo image.Rectangle, box [4]float64, quiet, scale float64) image.Rectangle {
+	bw := (box[2] - box[0]) / scale
+	w, h := bw, bw
+	if logo.Dx() > logo.Dy() {
+		h = bw * float64(logo.Dy()) / float64(logo.Dx())
+	} else {
+		w = bw * float64(logo.Dx()) / float64(logo.Dy())
+	}
+	cx, cy := (box[0]+box[2])/2/scale+quiet/scale, (box[1]+box[3])/2/scale+quiet/scale
+	return image.Rect(int(math.Round(cx-w/2)), int(math.Round(cy-h/2)), int(math.Round(cx+w/2)), int(math.Round(cy+h/2)))
+}
+
+// svg draws a bitmap as an SVG document of width x width. The viewBox is in modules, quiet zone included.
+func (s *Style) svg(bitmap [][]bool, width int) ([]byte, error) {
+	n := len(bitmap)
+	total := s.modules(n)
+	q := float64(s.quiet)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" version="1.1" width="%d" height="%d" viewBox="0 0 %d %d">`, width, width, total, total)
+	if s.bg.A > 0 {
+		fmt.Fprintf(&b, `<rect width="%d" height="%d" %v/>`, total, total, paint("fill", s.bg))
+	}
+	fmt.Fprintf(&b, `<g %v>`, paint("fill", s.fg))
+
+	// modules, leaving out the finder patterns and the area under the logo, which are drawn on their own
+	modules := make([][]bool, n)
+	for y := range bitmap {
+		modules[y] = make([]bool, n)
+		for x := range bitmap[y] {
+			modules[y][x] = bitmap[y][x] && !inFinder(n, x, y) && s.dark(bitmap, float64(x)+0.5, float64(y)+0.5)
+		}
+	}
+	switch s.Modules {
+	case ShapeSquare:
+		b.WriteString(`<path shape-rendering="crispEdges" d="`)
+		for _, r := range runs(modules) {
+			fmt.Fprintf(&b, "M%v %vh%dv1h-%dz", float64(r.x)+q, float64(r.y)+q, r.length, r.length)
+		}
+		b.WriteString(`"/>`)
+	default:
+		for y := range modules {
+			for x := range modules[y] {
+				if modules[y][x] {
+					b.WriteString(svgShape(s.Modules, float64(x)+q, float64(y)+q, 1, ""))
+				}
+			}
+		}
+	}
+
+	// finder patterns: a ring one module thick, drawn as a stroke so it stays hollow on transparent backgrounds,
+	// around a 3x3 eye
+	for _, f := range finders(n) {
+		fx, fy := float64(f[0])+q, float64(f[1])+q
+		b.WriteString(svgShape(s.Finder, fx+0.5, fy+0.5, finderSize-1, `fill="none" stroke-width="1" `+paint("stroke", s.fg)))
+		b.WriteString(svgShape(s.Finder, fx+2, fy+2, 3, ""))
+	}
+	b.WriteString(`</g>`)
+
+	if s.logo != nil {
+		var logo bytes.Buffer
+		if err := png.Encode(&logo, s.logo); err != nil {
+			return nil, err
+		}
+		box, _ := s.logoBox(n)
+		fmt.Fprintf(&b, `<image x="%.3f" y="%.3f" width="%.3f" height="%.3f" preserveAspectRatio="xMidYMid meet" xlink:href="data:image/png;base64,%s"/>`,
+			box[0]+q, box[1]+q, box[2]-box[0], box[3]-box[1], base64.StdEncoding.EncodeToString(logo.Bytes()))
+	}
+	b.WriteString("</svg>\n")
+	return b.Bytes(), nil
+}
+
+// inFinder reports whether the module (x, y) of a bitmap of n modules belongs to a finder pattern
+func inFinder(n, x, y int) bool {
+	for _, f := range finders(n) {
+		if x >= f[0] && y >= f[1] && x < f[0]+finderSize && y < f[1]+finderSize {
+			return true
+		}
+	}
+	return false
+}
+
+// svgShape returns the SVG element drawing a shape of width size at (x, y)
+func svgShape(shape Shape, x, y, size float64, attrs string) string {
+	if attrs != "" {
+		attrs = " " + attrs
+	}
+	switch shape {
+	case ShapeDot:
+		if size == 1 {
+			size = dotSize
+			x, y = x+(1-dotSize)/2, y+(1-dotSize)/2
+		}
+		return fmt.Sprintf(`<circle cx="%v" cy="%v" r="%v"%v/>`, x+size/2, y+size/2, size/2, attrs)
+	case ShapeRounded:
+		return fmt.Sprintf(`<rect x="%v" y="%v" width="%v" height="%v" rx="%v"%v/>`, x, y, size, size, size*roundness, attrs)
+	}
+	return fmt.Sprintf(`<rect x="%v" y="%v" width="%v" height="%v"%v/>`, x, y, size, size, attrs)
+}
+
+// paint returns the SVG attributes painting the fill or stroke passed in with a color
+func paint(attr string, c color.NRGBA) string {
+	p := fmt.Sprintf(`%v="#%02x%02x%02x"`, attr, c.R, c.G, c.B)
+	if c.A < 0xff {
+		p += fmt.Sprintf(` %v-opacity="%.3f"`, attr, float64(c.A)/0xff)
+	}
+	return p
+}
+
+// parseColor parses a hex color: #rgb, #rrggbb or #rrggbbaa. An empty string parses as the default passed in.
+func parseColor(hex, def string) (color.NRGBA, error) {
+	if hex == "" {
+		hex = def
+	}
+	h := strings.TrimPrefix(hex, "#")
+	if len(h) == 3 {
+		h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]})
+	}
+	if len(h) == 6 {
+		h += "ff"
+	}
+	v, err := strconv.ParseUint(h, 16, 32)
+	if len(h) != 8 || err != nil {
+		return color.NRGBA{}, fmt.Errorf("%q is not a #rgb, #rrggbb or #rrggbbaa color", hex)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// hexColor formats a color as #rrggbbaa
+func hexColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+// mix blends the color from into to, by the fraction t
+func mix(from, to color.NRGBA, t float64) color.NRGBA {
+	lerp := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a) + (float64(b)-float64(a))*t))
+	}
+	return color.NRGBA{R: lerp(from.R, to.R), G: lerp(from.G, to.G), B: lerp(from.B, to.B), A: lerp(from.A, to.A)}
+}
+
+// luminance returns the relative luminance, as WCAG defines it, of a color laid over white. Transparent colors
+// are judged against white, the surface codes are most often printed on or shown over.
+func luminance(c color.NRGBA) float64 {
+	channel := func(v uint8) float64 {
+		// blend over white, then linearize the sRGB value
+		s := (float64(v)*float64(c.A) + 0xff*float64(0xff-c.A)) / (0xff * 0xff)
+		if s <= 0.03928 {
+			return s / 12.92
+		}
+		return math.Pow((s+0.055)/1.055, 2.4)
+	}
+	return 0.2126*channel(c.R) + 0.7152*channel(c.G) + 0.0722*channel(c.B)
+}
+
+// contrast returns the contrast ratio, as WCAG defines it, between two colors
+func contrast(a, b color.NRGBA) float64 {
+	la, lb := luminance(a), luminance(b)
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
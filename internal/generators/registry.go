@@ -0,0 +1,120 @@
+package generators
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrUnknownKind   = errors.New("kind is not supported")
+	ErrInvalidOption = errors.New("invalid option")
+
+	registry = struct {
+		sync.RWMutex
+		encoders map[string]Encoder
+	}{encoders: map[string]Encoder{}}
+)
+
+// Symbology is the Kind of a code port can generate, as it appears in "/generate/{type}"
+type Symbology string
+
+func (s Symbology) String() string {
+	return string(s)
+}
+
+// Options holds the symbology specific options of a generation request, as decoded from JSON
+type Options map[string]interface{}
+
+// Encoder encodes content into the symbol of a symbology
+type Encoder interface {
+	Kind() Kind
+	// Linear reports whether the symbols are one dimensional, so their bars are stretched to the requested height
+	Linear() bool
+	// Validate checks that content can be encoded with the options passed in. Unknown options are rejected.
+	Validate(content string, opts Options) error
+	// Encode encodes content into the bitmap of a symbol, dark where true. The bitmap includes the quiet zone of
+	// the symbology.
+	Encode(content string, opts Options) ([][]bool, error)
+}
+
+// Register adds an encoder to the registry, keyed by its Kind. Registering a Kind twice panics.
+func Register(e Encoder) {
+	registry.Lock()
+	defer registry.Unlock()
+	kind := e.Kind().String()
+	if _, ok := registry.encoders[kind]; ok {
+		panic(fmt.Sprintf("encoder for %q is already registered", kind))
+	}
+	registry.encoders[kind] = e
+}
+
+// Lookup returns the encoder registered for the kind passed in
+func Lookup(kind string) (Encoder, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	e, ok := registry.encoders[kind]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q. supported kinds: %v", ErrUnknownKind, kind, kinds())
+	}
+	return e, nil
+}
+
+// Kinds returns the names of every registered kind, sorted
+func Kinds() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	return kinds()
+}
+
+func kinds() []string {
+	res := make([]string, 0, len(registry.encoders))
+	for k := range registry.encoders {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Only checks that the options don't hold anything but the keys passed in
+func (o Options) Only(keys ...string) error {
+	for k := range o {
+		known := false
+		for _, key := range keys {
+			known = known || k == key
+		}
+		if !known {
+			if len(keys) == 0 {
+				return fmt.Errorf("%w: %q. this kind takes no options", ErrInvalidOption, k)
+			}
+			return fmt.Errorf("%w: %q. known options: %v", ErrInvalidOption, k, keys)
+		}
+	}
+	return nil
+}
+
+// Int returns the integer option at key, or def when it isn't set. It fails if the option isn't a whole number
+// between min and max.
+func (o Options) Int(key string, def, min, max int) (int, error) {
+	v, ok := o[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	var n int
+	switch t := v.(type) {
+	case float64:
+		if t != float64(int(t)) {
+			return 0, fmt.Errorf("%w: %v must be a whole number", ErrInvalidOption, key)
+		}
+		n = int(t)
+	case int:
+		n = t
+	default:
+		return 0, fmt.Errorf("%w: %v must be a number", ErrInvalidOption, key)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("%w: %v must be between %d and %d", ErrInvalidOption, key, min, max)
+	}
+	return n, nil
+}
@@ -32,12 +32,14 @@ type DateOfBirth struct {
 	Day   string `json:"day"`
 }
 
+// GetPermissions returns the effective permissions of the agent, merged from its roles
 func (a *Agent) GetPermissions() auth.PermissionSet {
-	// db calls
-	return auth.PermissionSet{}
+	return a.Roles.Permissions()
 }
 
 func (a *Agent) GetRoles() auth.RoleSet {
-	// db calls
-	return auth.RoleSet{}
+	if a.Roles == nil {
+		return auth.RoleSet{}
+	}
+	return a.Roles
 }
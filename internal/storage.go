@@ -0,0 +1,23 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/internal/cloud"
+	amazon "github.com/dark-enstein/port/internal/cloud/aws"
+	"github.com/dark-enstein/port/internal/cloud/local"
+	"github.com/dark-enstein/port/internal/cloud/memory"
+)
+
+// NewStorage creates the blob store selected in the server config
+func NewStorage(cfg *config.Config) (cloud.Storage, error) {
+	switch cfg.Storage.Kind {
+	case config.StorageLocal, "":
+		return local.NewDisk(cfg.Storage.Dir, cfg.ConstructPublicURL())
+	case config.StorageS3:
+		return amazon.NewS3Storage(cfg.Storage.Profile, cfg.Storage.Region, cfg.Storage.Bucket)
+	case config.StorageMemory:
+		return memory.NewStore(cfg.ConstructPublicURL()), nil
+	}
+	return nil, fmt.Errorf("storage backend %q not supported. supported backends: %v", cfg.Storage.Kind, config.StorageBackends)
+}
@@ -0,0 +1,243 @@
+// Package jwt issues and verifies the JSON Web Tokens API clients authenticate with. Tokens are signed with EdDSA
+// over Ed25519 (RFC 8037), by keys that rotate, and are short-lived:
+//
+//	<base64url header>.<base64url claims>.<base64url signature>
+//
+// The header names the key a token is signed with. Keys are published as a JWKS, in the format of the ticket keys,
+// so other services can verify tokens without calling port.
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Algorithm is the only signing algorithm tokens are issued and accepted with
+const Algorithm = "EdDSA"
+
+var (
+	// DefaultLeeway is the clock skew tolerated when checking when a token is valid
+	DefaultLeeway = 30 * time.Second
+
+	ErrMalformed       = errors.New("token is malformed")
+	ErrUnsupportedAlg  = errors.New("token is signed with an unsupported algorithm")
+	ErrUnknownKey      = errors.New("token is signed with an unknown key")
+	ErrBadSignature    = errors.New("token signature is invalid")
+	ErrNotYetValid     = errors.New("token is not valid yet")
+	ErrExpired         = errors.New("token has expired")
+	ErrInvalidIssuer   = errors.New("token is issued by someone else")
+	ErrInvalidAudience = errors.New("token is meant for someone else")
+	ErrMissingSubject  = errors.New("token has no subject")
+	ErrNoKeys          = errors.New("authority has no keys")
+
+	encoding = base64.RawURLEncoding
+)
+
+// Header is the JOSE header of a token
+type Header struct {
+	Alg   string `json:"alg"`
+	Type  string `json:"typ,omitempty"`
+	KeyID string `json:"kid"`
+}
+
+// Audience is the "aud" claim. It is a single string or a list of strings on the wire, and issued as a string.
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = Audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// Contains checks that the audience holds aud
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the registered claims of a token (RFC 7519). The subject is the id of the user the token was issued to.
+type Claims struct {
+	ID        string   `json:"jti"`
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  Audience `json:"aud"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf"`
+	Expires   int64    `json:"exp"`
+}
+
+// ValidAt checks that the token is valid at t, give or take leeway
+func (c *Claims) ValidAt(t time.Time, leeway time.Duration) error {
+	if t.Add(leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return fmt.Errorf("%w: valid from %v", ErrNotYetValid, time.Unix(c.NotBefore, 0).UTC())
+	}
+	if !t.Add(-leeway).Before(time.Unix(c.Expires, 0)) {
+		return fmt.Errorf("%w: valid until %v", ErrExpired, time.Unix(c.Expires, 0).UTC())
+	}
+	return nil
+}
+
+// ExpiresAt returns when the token expires
+func (c *Claims) ExpiresAt() time.Time {
+	return time.Unix(c.Expires, 0).UTC()
+}
+
+// Authority issues tokens for an issuer and an audience, signed by the current key of its Keyring, and verifies
+// them against every key the Keyring still trusts
+type Authority struct {
+	Keys     *Keyring
+	Issuer   string
+	Audience string
+	// TTL is how long the tokens issued are valid for
+	TTL time.Duration
+	// Leeway is the clock skew tolerated when checking when a token is valid
+	Leeway time.Duration
+}
+
+// NewAuthority returns an Authority issuing tokens valid for ttl
+func NewAuthority(keys *Keyring, issuer, audience string, ttl time.Duration) *Authority {
+	return &Authority{Keys: keys, Issuer: issuer, Audience: audience, TTL: ttl, Leeway: DefaultLeeway}
+}
+
+// Issue signs a token for the subject, valid from now for the TTL of the Authority
+func (a *Authority) Issue(subject string) (string, *Claims, error) {
+	return a.IssueAt(subject, time.Now())
+}
+
+// IssueAt signs a token for the subject, valid from t for the TTL of the Authority
+func (a *Authority) IssueAt(subject string, t time.Time) (string, *Claims, error) {
+	if subject == "" {
+		return "", nil, ErrMissingSubject
+	}
+	if a.Keys == nil {
+		return "", nil, ErrNoKeys
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	c := &Claims{
+		ID:        hex.EncodeToString(id),
+		Issuer:    a.Issuer,
+		Subject:   subject,
+		Audience:  Audience{a.Audience},
+		IssuedAt:  t.Unix(),
+		NotBefore: t.Unix(),
+		Expires:   t.Add(a.TTL).Unix(),
+	}
+	token, err := a.Keys.sign(c, t)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, c, nil
+}
+
+// Verify checks the signature of a token, that it is valid now, and that it was issued by and for the Authority.
+// It returns the claims of the token.
+func (a *Authority) Verify(token string) (*Claims, error) {
+	return a.VerifyAt(token, time.Now())
+}
+
+// VerifyAt checks the signature of a token, that it is valid at t, and that it was issued by and for the Authority
+func (a *Authority) VerifyAt(token string, t time.Time) (*Claims, error) {
+	if a.Keys == nil {
+		return nil, ErrNoKeys
+	}
+	c, err := a.Keys.parse(token, t)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ValidAt(t, a.Leeway); err != nil {
+		return nil, err
+	}
+	if c.Issuer != a.Issuer {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIssuer, c.Issuer)
+	}
+	if !c.Audience.Contains(a.Audience) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAudience, strings.Join(c.Audience, ", "))
+	}
+	if c.Subject == "" {
+		return nil, ErrMissingSubject
+	}
+	return c, nil
+}
+
+// encode signs the claims with the key passed in, naming it by kid in the header
+func encode(c *Claims, kid string, key ed25519.PrivateKey) (string, error) {
+	header, err := json.Marshal(&Header{Alg: Algorithm, Type: "JWT", KeyID: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signed := encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload)
+	return signed + "." + encoding.EncodeToString(ed25519.Sign(key, []byte(signed))), nil
+}
+
+// decode checks the signature of a token against the key lookup returns for its key id, and returns its claims
+func decode(token string, lookup func(kid string) (ed25519.PublicKey, bool)) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	raw, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	h := &Header{}
+	if err := json.Unmarshal(raw, h); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	// the algorithm is fixed, not taken from the token, so "none" and HMAC confusion are refused outright
+	if h.Alg != Algorithm {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedAlg, h.Alg)
+	}
+	key, ok := lookup(h.KeyID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownKey, h.KeyID)
+	}
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if !ed25519.Verify(key, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrBadSignature
+	}
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	c := &Claims{}
+	if err := json.Unmarshal(payload, c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return c, nil
+}
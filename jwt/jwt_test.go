@@ -0,0 +1,149 @@
+package jwt
+
+import (
+	"encoding/json"
+	"github.com/dark-enstein/port/ticket"
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type JWTTest struct {
+	keys      *Keyring
+	authority *Authority
+	now       time.Time
+	suite.Suite
+}
+
+func (s *JWTTest) SetupTest() {
+	var err error
+	s.keys, err = NewKeyring(24*time.Hour, time.Hour)
+	s.Require().NoError(err)
+	s.authority = NewAuthority(s.keys, "https://port.example.com", "port", 15*time.Minute)
+	s.now = time.Now()
+}
+
+// TestRoundTrip tests that an issued token verifies, and carries its claims
+func (s *JWTTest) TestRoundTrip() {
+	token, issued, err := s.authority.IssueAt("u1", s.now)
+	s.Require().NoError(err)
+	s.Assert().Len(strings.Split(token, "."), 3)
+
+	claims, err := s.authority.VerifyAt(token, s.now.Add(time.Minute))
+	s.Require().NoError(err)
+	s.Assert().Equal(issued, claims)
+	s.Assert().Equal("u1", claims.Subject)
+	s.Assert().Equal(s.now.Add(15*time.Minute).Unix(), claims.Expires)
+
+	_, _, err = s.authority.IssueAt("", s.now)
+	s.Assert().ErrorIs(err, ErrMissingSubject)
+}
+
+// TestValidity tests that tokens are refused before and after they are valid, and by other issuers and audiences
+func (s *JWTTest) TestValidity() {
+	token, _, err := s.authority.IssueAt("u1", s.now)
+	s.Require().NoError(err)
+
+	_, err = s.authority.VerifyAt(token, s.now.Add(-time.Hour))
+	s.Assert().ErrorIs(err, ErrNotYetValid)
+	_, err = s.authority.VerifyAt(token, s.now.Add(time.Hour))
+	s.Assert().ErrorIs(err, ErrExpired)
+
+	other := NewAuthority(s.keys, "https://elsewhere.example.com", "port", time.Minute)
+	_, err = other.VerifyAt(token, s.now)
+	s.Assert().ErrorIs(err, ErrInvalidIssuer)
+	other = NewAuthority(s.keys, "https://port.example.com", "scanner", time.Minute)
+	_, err = other.VerifyAt(token, s.now)
+	s.Assert().ErrorIs(err, ErrInvalidAudience)
+}
+
+// TestTampering tests that tokens with their claims changed, or signed without EdDSA, are refused
+func (s *JWTTest) TestTampering() {
+	token, _, err := s.authority.IssueAt("u1", s.now)
+	s.Require().NoError(err)
+	parts := strings.Split(token, ".")
+
+	forged, _ := json.Marshal(&Claims{Issuer: s.authority.Issuer, Subject: "admin", Audience: Audience{"port"},
+		NotBefore: s.now.Unix(), Expires: s.now.Add(time.Hour).Unix()})
+	_, err = s.authority.VerifyAt(parts[0]+"."+encoding.EncodeToString(forged)+"."+parts[2], s.now)
+	s.Assert().ErrorIs(err, ErrBadSignature)
+
+	none, _ := json.Marshal(&Header{Alg: "none", KeyID: s.keys.KeyID()})
+	_, err = s.authority.VerifyAt(encoding.EncodeToString(none)+"."+parts[1]+".", s.now)
+	s.Assert().ErrorIs(err, ErrUnsupportedAlg)
+
+	unknown, _ := json.Marshal(&Header{Alg: Algorithm, KeyID: "unknown"})
+	_, err = s.authority.VerifyAt(encoding.EncodeToString(unknown)+"."+parts[1]+"."+parts[2], s.now)
+	s.Assert().ErrorIs(err, ErrUnknownKey)
+
+	_, err = s.authority.VerifyAt("not.a token", s.now)
+	s.Assert().ErrorIs(err, ErrMalformed)
+}
+
+// TestRotation tests that the key signing rotates when it is due, that tokens of a retired key verify until it is
+// dropped, and that the key set holds the keys trusted
+func (s *JWTTest) TestRotation() {
+	before, _, err := s.authority.IssueAt("u1", s.now)
+	s.Require().NoError(err)
+	first := s.keys.KeyID()
+
+	rotated := s.now.Add(24 * time.Hour)
+	after, _, err := s.authority.IssueAt("u1", rotated)
+	s.Require().NoError(err)
+	s.Assert().NotEqual(first, s.keys.KeyID())
+	_, err = s.authority.VerifyAt(after, rotated)
+	s.Assert().NoError(err)
+
+	_, err = s.keys.parse(before, rotated.Add(30*time.Minute))
+	s.Assert().NoError(err)
+	_, err = s.keys.parse(before, rotated.Add(2*time.Hour))
+	s.Assert().ErrorIs(err, ErrUnknownKey)
+
+	s.Require().NoError(s.keys.Rotate())
+	set := s.keys.JWKS()
+	s.Require().NotEmpty(set.Keys)
+	s.Assert().Equal(s.keys.KeyID(), set.Keys[0].KeyID)
+	data, _ := json.Marshal(set)
+	_, err = ticket.NewVerifierFromJWKS(data)
+	s.Assert().NoError(err)
+}
+
+// TestConcurrentRotation tests that signers racing across the time the key is due rotate it once, so no key is retired
+// as soon as it is issued
+func (s *JWTTest) TestConcurrentRotation() {
+	for round := 0; round < 100; round++ {
+		keys, err := NewKeyring(24*time.Hour, time.Hour)
+		s.Require().NoError(err)
+		rotated := time.Now().Add(24 * time.Hour)
+		authority := NewAuthority(keys, "https://port.example.com", "port", 15*time.Minute)
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		tokens := make([]string, 16)
+		for i := range tokens {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				tokens[i], _, _ = authority.IssueAt("u1", rotated)
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+
+		s.Require().Len(keys.retired, 1)
+		for _, token := range tokens {
+			header, _, _ := strings.Cut(token, ".")
+			raw, err := encoding.DecodeString(header)
+			s.Require().NoError(err)
+			h := &Header{}
+			s.Require().NoError(json.Unmarshal(raw, h))
+			s.Require().Equal(keys.KeyID(), h.KeyID)
+		}
+	}
+}
+
+func TestJWTTest(t *testing.T) {
+	suite.Run(t, new(JWTTest))
+}
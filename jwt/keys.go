@@ -0,0 +1,143 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/dark-enstein/port/ticket"
+	"sync"
+	"time"
+)
+
+// key is a signing key of a Keyring. A retired key no longer signs, but still verifies the tokens it signed.
+type key struct {
+	kid     string
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+	created time.Time
+	retired time.Time
+}
+
+func newKey(t time.Time) (*key, error) {
+	pub, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &key{kid: ticket.KeyID(pub), private: private, public: pub, created: t}, nil
+}
+
+// Keyring holds the Ed25519 keys tokens are signed with. The current key signs for Rotation, then a new key takes
+// over. Retired keys keep verifying the tokens they signed for Retain, which must outlast the tokens, and are then
+// dropped. Keys are generated in memory, so a restart retires every token issued before it.
+type Keyring struct {
+	sync.RWMutex
+	Rotation time.Duration
+	Retain   time.Duration
+
+	current *key
+	retired []*key
+}
+
+// NewKeyring returns a Keyring with a fresh current key
+func NewKeyring(rotation, retain time.Duration) (*Keyring, error) {
+	k := &Keyring{Rotation: rotation, Retain: retain}
+	if err := k.RotateAt(time.Now()); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Rotate retires the current key for a new one
+func (k *Keyring) Rotate() error {
+	return k.RotateAt(time.Now())
+}
+
+// RotateAt retires the current key for a new one, as of t
+func (k *Keyring) RotateAt(t time.Time) error {
+	k.Lock()
+	defer k.Unlock()
+	return k.rotateLocked(t)
+}
+
+// rotateLocked retires the current key for a new one, as of t. The caller holds the write lock.
+func (k *Keyring) rotateLocked(t time.Time) error {
+	next, err := newKey(t)
+	if err != nil {
+		return err
+	}
+	if k.current != nil {
+		k.current.retired = t
+		k.retired = append(k.retired, k.current)
+	}
+	k.current = next
+	k.prune(t)
+	return nil
+}
+
+// prune drops the retired keys no token they signed can still be valid for
+func (k *Keyring) prune(t time.Time) {
+	kept := k.retired[:0]
+	for _, r := range k.retired {
+		if t.Sub(r.retired) < k.Retain {
+			kept = append(kept, r)
+		}
+	}
+	k.retired = kept
+}
+
+// sign signs the claims with the current key, rotating it first when it is due
+func (k *Keyring) sign(c *Claims, t time.Time) (string, error) {
+	k.RLock()
+	current := k.current
+	k.RUnlock()
+	if k.Rotation > 0 && t.Sub(current.created) >= k.Rotation {
+		k.Lock()
+		// another signer may have rotated it in the meantime: checking and rotating under one lock rotates it once
+		if k.current == current {
+			if err := k.rotateLocked(t); err != nil {
+				k.Unlock()
+				return "", err
+			}
+		}
+		current = k.current
+		k.Unlock()
+	}
+	return encode(c, current.kid, current.private)
+}
+
+// parse checks the signature of a token against the keys trusted at t
+func (k *Keyring) parse(token string, t time.Time) (*Claims, error) {
+	return decode(token, func(kid string) (ed25519.PublicKey, bool) {
+		k.RLock()
+		defer k.RUnlock()
+		if k.current.kid == kid {
+			return k.current.public, true
+		}
+		for _, r := range k.retired {
+			if r.kid == kid && t.Sub(r.retired) < k.Retain {
+				return r.public, true
+			}
+		}
+		return nil, false
+	})
+}
+
+// KeyID returns the ID of the key currently signing
+func (k *Keyring) KeyID() string {
+	k.RLock()
+	defer k.RUnlock()
+	return k.current.kid
+}
+
+// JWKS returns the public keys trusted now, the current one first, as served at "/.well-known/jwks.json"
+func (k *Keyring) JWKS() ticket.JWKS {
+	k.RLock()
+	defer k.RUnlock()
+	now := time.Now()
+	set := ticket.JWKS{Keys: []ticket.JWK{ticket.NewJWK(k.current.public)}}
+	for i := len(k.retired) - 1; i >= 0; i-- {
+		if now.Sub(k.retired[i].retired) < k.Retain {
+			set.Keys = append(set.Keys, ticket.NewJWK(k.retired[i].public))
+		}
+	}
+	return set
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"github.com/dark-enstein/port/auth"
 	"github.com/dark-enstein/port/config"
 	"github.com/dark-enstein/port/db"
+	"github.com/dark-enstein/port/internal"
 	"github.com/dark-enstein/port/server"
 	"github.com/dark-enstein/port/util"
 	"os"
@@ -35,6 +37,36 @@ func SetStage() error {
 	set.StringVar(&S.Cfg.Port, config.FlagPort, config.DefaultFlagPort, "-")
 	set.StringVar(&S.Cfg.EnabledDB, config.FlagDB, config.DefaultFlagDB, "-")
 	set.StringVar(&S.Cfg.DBHost, config.FlagDBHost, config.DefaultFlagDBHost, "-")
+	set.StringVar(&S.Cfg.DBSnapshot, config.FlagDBSnapshot, config.DefaultFlagDBSnapshot, "-")
+	set.BoolVar(&S.Cfg.DBMigrate, config.FlagDBMigrate, config.DefaultFlagDBMigrate, "-")
+	set.StringVar(&S.Cfg.PublicURL, config.FlagPublicURL, config.DefaultFlagPublicURL, "-")
+	set.StringVar(&S.Cfg.Storage.Kind, config.FlagStorage, config.DefaultFlagStorage, "-")
+	set.StringVar(&S.Cfg.Storage.Dir, config.FlagStorageDir, config.DefaultFlagStorageDir, "-")
+	set.StringVar(&S.Cfg.Storage.Bucket, config.FlagS3Bucket, config.DefaultFlagS3Bucket, "-")
+	set.StringVar(&S.Cfg.Storage.Profile, config.FlagAWSProfile, config.DefaultFlagAWSProfile, "-")
+	set.StringVar(&S.Cfg.Storage.Region, config.FlagAWSRegion, config.DefaultFlagAWSRegion, "-")
+	set.IntVar(&S.Cfg.Batch.Workers, config.FlagBatchWork, config.DefaultFlagBatchWork, "-")
+	set.IntVar(&S.Cfg.Batch.MaxItems, config.FlagBatchMax, config.DefaultFlagBatchMax, "-")
+	set.IntVar(&S.Cfg.Jobs.Workers, config.FlagJobWork, config.DefaultFlagJobWork, "-")
+	set.StringVar(&S.Cfg.Jobs.CallbackAllow, config.FlagCallbacks, config.DefaultFlagCallbacks, "-")
+	set.IntVar(&S.Cfg.Scans.BatchSize, config.FlagScanBatch, config.DefaultFlagScanBatch, "-")
+	set.DurationVar(&S.Cfg.Scans.FlushInterval, config.FlagScanFlush, config.DefaultFlagScanFlush, "-")
+	set.IntVar(&S.Cfg.Cache.Size, config.FlagCacheSize, config.DefaultFlagCacheSize, "-")
+	set.StringVar(&S.Cfg.Tickets.KeyFile, config.FlagTicketKey, config.DefaultFlagTicketKey, "-")
+	set.UintVar(&S.Cfg.Logins.HashTime, config.FlagHashTime, config.DefaultFlagHashTime, "-")
+	set.UintVar(&S.Cfg.Logins.HashMemory, config.FlagHashMemory, config.DefaultFlagHashMemory, "-")
+	set.UintVar(&S.Cfg.Logins.HashThreads, config.FlagHashThread, config.DefaultFlagHashThread, "-")
+	set.IntVar(&S.Cfg.Logins.MaxFailures, config.FlagMaxFailure, config.DefaultFlagMaxFailure, "-")
+	set.DurationVar(&S.Cfg.Logins.Lockout, config.FlagLockout, config.DefaultFlagLockout, "-")
+	set.StringVar(&S.Cfg.Tokens.Issuer, config.FlagJWTIssuer, config.DefaultFlagJWTIssuer, "-")
+	set.StringVar(&S.Cfg.Tokens.Audience, config.FlagJWTAud, config.DefaultFlagJWTAud, "-")
+	set.DurationVar(&S.Cfg.Tokens.AccessTTL, config.FlagAccessTTL, config.DefaultFlagAccessTTL, "-")
+	set.DurationVar(&S.Cfg.Tokens.RefreshTTL, config.FlagRefreshTTL, config.DefaultFlagRefreshTTL, "-")
+	set.DurationVar(&S.Cfg.Tokens.KeyRotation, config.FlagKeyRotate, config.DefaultFlagKeyRotate, "-")
+	set.StringVar(&S.Cfg.Tokens.Exempt, config.FlagAuthExempt, config.DefaultFlagAuthExempt, "-")
+	set.StringVar(&S.Cfg.Admins, config.FlagAdmins, config.DefaultFlagAdmins, "-")
+	set.IntVar(&S.Cfg.Tokens.KeyRateLimit, config.FlagKeyRate, config.DefaultFlagKeyRate, "-")
+	set.StringVar(&S.Cfg.TrustedProxies, config.FlagProxies, config.DefaultFlagProxies, "-")
 	err := set.Parse(os.Args[1:])
 	if err != nil {
 		return fmt.Errorf("unable to parse arguments: %w", err)
@@ -50,10 +82,67 @@ func SetStage() error {
 		return ConfigInvalid
 	}
 
-	S.DB, err = db.NewClient(S.Ctx, S.Cfg.EnabledDB, S.Cfg.DBHost)
+	S.DB, err = db.NewClient(S.Ctx, S.Cfg.EnabledDB, S.Cfg.ConstructDBHost())
 	if err != nil {
 		return err
 	}
+	if m, ok := S.DB.(db.Migrator); ok {
+		pending, err := db.Pending(S.Ctx, m)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 && !S.Cfg.DBMigrate {
+			return fmt.Errorf("%d migrations are pending on the %v db: run port migrate up, or start with --%v", len(pending), S.DB.Kind(), config.FlagDBMigrate)
+		}
+		if len(pending) > 0 {
+			logger.Info().Msgf("applying %d pending migrations to the %v db", len(pending), S.DB.Kind())
+			if err := S.DB.EnsureDBScaffold(S.Ctx, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	S.Store, err = internal.NewStorage(S.Cfg)
+	if err != nil {
+		return err
+	}
+	logger.Info().Msgf("storing generated files in %v storage", S.Store.Kind())
+
+	callbackNets, _ := S.Cfg.Jobs.CallbackNetworks()
+	S.Jobs = server.NewJobQueue(S.Cfg.Jobs.Workers, callbackNets)
+	S.Scans = server.NewScanRecorder(S.Cfg.Scans.BatchSize, S.Cfg.Scans.FlushInterval)
+	S.Proxies, _ = S.Cfg.ProxyNetworks()
+	S.Cache = auth.NewRenderCache(S.Cfg.Cache.Size)
+	recovered, err := S.Jobs.Recover(S.Ctx)
+	if err != nil {
+		return fmt.Errorf("cannot recover unfinished jobs: %w", err)
+	}
+	if recovered > 0 {
+		logger.Info().Msgf("running again %d jobs left unfinished by the last process", recovered)
+	}
+	S.Tickets, err = server.NewTicketSigner(S.Cfg.Tickets.KeyFile)
+	if err != nil {
+		return err
+	}
+	if S.Cfg.Tickets.KeyFile == "" {
+		logger.Warn().Msgf("no %v set: signing tickets with a key generated for this process only", config.FlagTicketKey)
+	}
+	logger.Info().Msgf("signing tickets with key %v", S.Tickets.KeyID())
+
+	auth.Passwords = auth.NewPasswordHasher(uint32(S.Cfg.Logins.HashTime), uint32(S.Cfg.Logins.HashMemory), uint8(S.Cfg.Logins.HashThreads))
+	auth.MaxFailedLogins, auth.LockoutDuration = S.Cfg.Logins.MaxFailures, S.Cfg.Logins.Lockout
+	S.Resets = &auth.LogResetSender{Log: S.Log}
+	logger.Warn().Msg("password reset tokens can't be emailed yet: they are written to the log")
+
+	S.Tokens, err = server.NewTokenAuthority(S.Cfg)
+	if err != nil {
+		return err
+	}
+	auth.RefreshTokenTTL = S.Cfg.Tokens.RefreshTTL
+	if err := server.SetupRoles(S.Cfg.AdminEmails()); err != nil {
+		return err
+	}
+	logger.Info().Msgf("issuing tokens as %v for %v, signed with key %v", S.Tokens.Issuer, S.Tokens.Audience, S.Tokens.Keys.KeyID())
 
 	isConnected := S.DB.Ping()
 	if !isConnected {
@@ -66,10 +155,18 @@ func SetStage() error {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := Migrate(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "port migrate: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	cancel := InitSys()
 	err := SetStage()
 	if err != nil {
-		_ = fmt.Errorf("couldn't start server: %w", err)
+		fmt.Fprintf(os.Stderr, "couldn't start server: %v\n", err)
 		os.Exit(1)
 	}
 
@@ -92,5 +189,19 @@ func main() {
 		S.Log.Info().Msgf("server shutdown failed %v", err)
 	}
 
+	// jobs that don't finish in time are left queued, for the next process to run
+	if err := S.Jobs.Stop(ctx); err != nil {
+		S.Log.Info().Msgf("job queue shutdown failed %v", err)
+	}
+
+	if err := S.Scans.Stop(ctx); err != nil {
+		S.Log.Info().Msgf("scan recorder shutdown failed %v", err)
+	}
+
+	// after the scan recorder, so the scans it flushed make it into a memory DB's snapshot
+	if err := S.DB.Close(ctx); err != nil {
+		S.Log.Info().Msgf("db shutdown failed %v", err)
+	}
+
 	S.Log.Info().Msg("server shutdown properly")
 }
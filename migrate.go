@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/db"
+	"github.com/dark-enstein/port/util"
+	"strconv"
+	"strings"
+)
+
+// MigrateUsage describes the "port migrate" command
+var MigrateUsage = `usage: port migrate [--db=mongo|sqlite|postgres] [--db-host=...] [--dry-run] <command>
+
+commands:
+  up [version]   apply the pending migrations, up to version if passed in
+  down [steps]   revert the last migration, or the last steps migrations
+  status         list the migrations, and whether they are applied
+
+--dry-run prints what up and down would do, without doing it`
+
+// Migrate runs "port migrate", moving the schema of the DB through its migrations
+func Migrate(args []string) error {
+	cfg := config.NewConfig()
+	set := flag.NewFlagSet("port migrate", flag.ExitOnError)
+	set.Usage = func() { fmt.Fprintln(set.Output(), MigrateUsage) }
+	set.StringVar(&cfg.LogLevel, config.FlagLogLevel, config.DefaultFLagLogLevel, "-")
+	set.StringVar(&cfg.EnabledDB, config.FlagDB, config.DefaultFlagDB, "-")
+	set.StringVar(&cfg.DBHost, config.FlagDBHost, config.DefaultFlagDBHost, "-")
+	dryRun := set.Bool("dry-run", false, "-")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.WithValue(context.Background(), util.LoggerInContext, config.NewLogger(cfg.LogLevel))
+	cli, err := db.NewClient(ctx, cfg.EnabledDB, cfg.ConstructDBHost())
+	if err != nil {
+		return err
+	}
+	defer cli.Close(ctx)
+	m, ok := cli.(db.Migrator)
+	if !ok {
+		return fmt.Errorf("the %v db has no migrations", cfg.EnabledDB)
+	}
+	migrations := m.Migrations()
+	current, err := m.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch set.Arg(0) {
+	case "up":
+		if len(migrations) == 0 {
+			return nil
+		}
+		target := migrations[len(migrations)-1].Version
+		if set.Arg(1) != "" {
+			if target, err = strconv.Atoi(set.Arg(1)); err != nil || target < current {
+				return fmt.Errorf("up takes a version after the current one, %d", current)
+			}
+		}
+		if *dryRun {
+			return printPlan(ctx, m, target)
+		}
+		return m.MigrateTo(ctx, target)
+	case "down":
+		steps := 1
+		if set.Arg(1) != "" {
+			if steps, err = strconv.Atoi(set.Arg(1)); err != nil || steps < 1 {
+				return fmt.Errorf("down takes a number of steps")
+			}
+		}
+		// the target is the version steps migrations before the current one, or none at all
+		applied := 0
+		for _, mig := range migrations {
+			if mig.Version <= current {
+				applied++
+			}
+		}
+		target := 0
+		if applied > steps {
+			target = migrations[applied-steps-1].Version
+		}
+		if *dryRun {
+			return printPlan(ctx, m, target)
+		}
+		return m.MigrateTo(ctx, target)
+	case "status":
+		fmt.Printf("%v schema at version %d\n", cfg.EnabledDB, current)
+		for _, mig := range migrations {
+			state := "pending"
+			if mig.Version <= current {
+				state = "applied"
+			}
+			fmt.Printf("%6d  %-32v %v\n", mig.Version, mig.Name, state)
+		}
+		return nil
+	}
+	set.Usage()
+	return fmt.Errorf("unknown command %q", set.Arg(0))
+}
+
+// printPlan prints the steps of the migrations that would take the schema to the target version
+func printPlan(ctx context.Context, m db.Migrator, target int) error {
+	steps, up, err := db.Plan(ctx, m, target)
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		fmt.Printf("schema is at version %d: nothing to do\n", target)
+		return nil
+	}
+	for _, mig := range steps {
+		verb, step := "apply", mig.Up
+		if !up {
+			verb, step = "revert", mig.Down
+		}
+		fmt.Printf("would %v migration %d_%v:\n", verb, mig.Version, mig.Name)
+		for _, line := range strings.Split(strings.TrimSpace(step), "\n") {
+			fmt.Printf("  %v\n", line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
+)
+
+// AuditEntryResponse describes a change made to who may do what on Port
+type AuditEntryResponse struct {
+	ID     string                 `json:"id"`
+	At     time.Time              `json:"at"`
+	Actor  string                 `json:"actor"`
+	Action string                 `json:"action"`
+	Target string                 `json:"target"`
+	Detail map[string]interface{} `json:"detail,omitempty"`
+}
+
+// AuditResponse is a page of the audit log, latest first. NextOffset is set when there may be more entries after
+// the page.
+type AuditResponse struct {
+	Entries    []*AuditEntryResponse `json:"entries"`
+	Offset     int                   `json:"offset"`
+	Limit      int                   `json:"limit"`
+	NextOffset *int                  `json:"next_offset,omitempty"`
+}
+
+func (r *AuditResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// audit records a change made by the caller of a request to the audit log. The change is made already, so failing
+// to record it is logged rather than failing the request.
+func audit(ctx context.Context, req *http.Request, action, target string, detail map[string]interface{}) {
+	actor := ""
+	if claims, ok := RequestClaims(req.Context()); ok {
+		actor = claims.Subject
+	}
+	if _, err := auth.NewAuditDirector(ctx).Record(actor, action, target, detail); err != nil {
+		S.Log.Error().Str("method", "audit()").Msgf("recording %v of %v by %v failed with %v", action, target, actor, err)
+	}
+}
+
+// listAudit handles calls to "GET /audit". It lists a page of the audit log, latest first, filtered by the actor,
+// action and target query parameters. The page is set by the offset and limit parameters.
+func listAudit(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "listAudit()").Logger()
+	query := req.URL.Query()
+	offset, limit, ok := pageParams(resp, query, "entries")
+	if !ok {
+		return
+	}
+	filter := model.Filter{}
+	for _, param := range []string{"actor", "action", "target"} {
+		if v := query.Get(param); v != "" {
+			filter[param] = v
+		}
+	}
+
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	entries, err := auth.NewAuditDirector(ctx).List(filter, offset, limit)
+	if err != nil {
+		log.Error().Msgf("listing the audit log failed with %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	page := &AuditResponse{Entries: make([]*AuditEntryResponse, len(entries)), Offset: offset, Limit: limit}
+	for i, e := range entries {
+		page.Entries[i] = &AuditEntryResponse{ID: e.ID, At: e.At, Actor: e.Actor, Action: e.Action, Target: e.Target, Detail: e.Detail}
+	}
+	if len(entries) == limit {
+		next := offset + limit
+		page.NextOffset = &next
+	}
+	pageResponse, _ := page.MarshalJson()
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set(HeaderRequestID, uuid.NewString())
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(pageResponse)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/internal/generators"
+	"github.com/dark-enstein/port/internal/generators/qr"
+	"github.com/dark-enstein/port/util"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+// MaxDimension is the widest and tallest image a Barcode request can ask for, in pixels or points
+const MaxDimension = 4096
+
+// Barcode is the body of a call to "/generate/{type}" for every kind but qr. Width and height are in pixels, or
+// points for vector formats, and are worked out from the size of the symbol when left out. Options are specific to
+// the kind.
+type Barcode struct {
+	Content string             `json:"content"`
+	Width   int                `json:"width,omitempty"`
+	Height  int                `json:"height,omitempty"`
+	Format  string             `json:"format,omitempty"`
+	Options generators.Options `json:"options,omitempty"`
+	DataURI bool               `json:"data_uri,omitempty"`
+}
+
+// Resolve validates the Barcode request against the encoder of its kind, and resolves the output format it asks for
+func (b *Barcode) Resolve(encoder generators.Encoder) (qr.Format, error) {
+	if b.Width < 0 || b.Height < 0 {
+		return "", fmt.Errorf("width and height must not be negative")
+	}
+	if b.Width > MaxDimension || b.Height > MaxDimension {
+		return "", fmt.Errorf("width and height must not be over %d", MaxDimension)
+	}
+	if err := encoder.Validate(b.Content, b.Options); err != nil {
+		return "", err
+	}
+	return qr.ParseFormat(b.Format)
+}
+
+// generateSymbol handles calls to "/generate/{type}" for every kind but qr. It validates the request against the
+// encoder registered for the kind, and generates the symbol the way generate does for QR codes.
+func generateSymbol(resp http.ResponseWriter, req *http.Request, ctx context.Context, dec *json.Decoder, encoder generators.Encoder) {
+	log := S.Log.With().Str("method", "generateSymbol()").Str("kind", encoder.Kind().String()).Logger()
+
+	body := &Barcode{}
+	if err := dec.Decode(body); err != nil {
+		log.Info().Msgf("umarshaling request into json failed with: %v", err)
+		writeDecodeError(resp, err, &log)
+		return
+	}
+	format, err := body.Resolve(encoder)
+	if err != nil {
+		log.Info().Msgf("request validation failed with: %v", err)
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	accepted, streamImage := negotiateFormat(req)
+	if streamImage {
+		if body.Format != "" && accepted != format {
+			msg := fmt.Sprintf("Accept header %v conflicts with the requested format %v", accepted.MIME(), format)
+			http.Error(resp, msg, http.StatusNotAcceptable)
+			return
+		}
+		format = accepted
+	}
+
+	requestId := uuid.New()
+	ctx = context.WithValue(ctx, util.RequestIDInContext, requestId.String())
+	director := auth.NewBarcodeDirector(ctx, requestId, encoder, body.Content, body.Options, body.Width, body.Height, format, S.Cfg)
+
+	if streamImage {
+		res, err := director.Render()
+		if err != nil {
+			log.Error().Msgf("rendering failed with %v", err)
+			genResponse, _ := ConstructErrResponse(requestId.String(), fmt.Sprintf("%v generation failed with %v", encoder.Kind(), err)).MarshalJson()
+			resp.WriteHeader(http.StatusInternalServerError)
+			_, _ = resp.Write(genResponse)
+			return
+		}
+		writeImage(resp, requestId.String(), res)
+		log.Info().Msgf("streamed %v bytes of %v to client", res.Size(), res.Format)
+		return
+	}
+
+	if _, err := director.Generate(); err != nil {
+		log.Error().Msgf("generation failed with %v", err)
+		genResponse, _ := ConstructErrResponse(requestId.String(), fmt.Sprintf("%v generation failed with %v", encoder.Kind(), err)).MarshalJson()
+		resp.WriteHeader(http.StatusInternalServerError)
+		_, _ = resp.Write(genResponse)
+		return
+	}
+
+	genResponse, err := ConstructGenerateResponse(requestId.String(), director.Result(), body.DataURI).MarshalJson()
+	if err != nil {
+		log.Error().Msgf("ConstructGenerateResponse() failed with %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(genResponse)
+	log.Info().Msgf("file at %v", director.Result().URL)
+}
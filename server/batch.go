@@ -0,0 +1,401 @@
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/internal/generators"
+	"github.com/dark-enstein/port/internal/generators/qr"
+	"github.com/dark-enstein/port/util"
+	"github.com/golang/gddo/httputil"
+	"github.com/golang/gddo/httputil/header"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	BatchOutputZip  = "zip"
+	BatchOutputList = "list"
+	BatchManifest   = "manifest.json"
+	BatchFormField  = "file"
+	MimeZip         = "application/zip"
+	MimeCSV         = "text/csv"
+	MimeMultipart   = "multipart/form-data"
+)
+
+var (
+	// BatchMaxBody limits the size of the request body of a batch to 10MB
+	BatchMaxBody   int64 = 10 << 20
+	BatchTimeout         = 5 * time.Minute
+	BatchCSVFields       = []string{"content", "size", "recovery_level", "format", "verify", "payload"}
+)
+
+// Batch is the JSON request body of a call to "/generate/qr/batch"
+type Batch struct {
+	Items  []*QR  `json:"items"`
+	Output string `json:"output,omitempty"`
+}
+
+// BatchItemResult is the outcome of generating a single item of a batch
+type BatchItemResult struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id,omitempty"`
+	File     string `json:"file,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Format   string `json:"format,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Bytes    int    `json:"bytes,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BatchResponse is the JSON response of a batch, listing the outcome of every item in request order.
+// It doubles as the manifest of ZIP archives.
+type BatchResponse struct {
+	ReqID     string             `json:"req_id"`
+	Time      string             `json:"time"`
+	Total     int                `json:"total"`
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+	Items     []*BatchItemResult `json:"items"`
+}
+
+func (r *BatchResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// batchItem is a validated item of a batch, ready to be generated
+type batchItem struct {
+	index  int
+	req    *QR
+	format qr.Format
+	err    error
+}
+
+// batchOutcome is a generated item of a batch
+type batchOutcome struct {
+	result *BatchItemResult
+	data   []byte
+}
+
+// generateBatch handles calls to the "/generate/qr/batch". It generates many codes concurrently, and returns them
+// either as a streamed ZIP archive with a manifest, or as a list of per item results with their URLs.
+func generateBatch(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "generateBatch()").Logger()
+	ctx := context.WithValue(context.Background(), util.LoggerInContext, S.Log)
+	ctx = context.WithValue(ctx, util.DBInContext, S.DB)
+	ctx = context.WithValue(ctx, util.StorageInContext, S.Store)
+	ctx = context.WithValue(ctx, util.CacheInContext, S.Cache)
+	ctx, cancelFunc := context.WithTimeout(ctx, BatchTimeout)
+	defer cancelFunc()
+
+	req.Body = http.MaxBytesReader(resp, req.Body, BatchMaxBody)
+	batch, err := decodeBatch(req)
+	if err != nil {
+		log.Info().Msgf("decoding batch failed with: %v", err)
+		var csvErr *csv.ParseError
+		switch {
+		case errors.Is(err, errUnsupportedBatchType):
+			http.Error(resp, err.Error(), http.StatusUnsupportedMediaType)
+		case errors.As(err, &csvErr), errors.Is(err, errInvalidBatchCSV):
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+		default:
+			writeDecodeError(resp, err, &log)
+		}
+		return
+	}
+
+	switch {
+	case len(batch.Items) == 0:
+		http.Error(resp, "batch must contain at least one item", http.StatusBadRequest)
+		return
+	case len(batch.Items) > S.Cfg.Batch.MaxItems:
+		msg := fmt.Sprintf("batch must not contain more than %d items", S.Cfg.Batch.MaxItems)
+		http.Error(resp, msg, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	output := batchOutput(req, batch)
+	if output != BatchOutputZip && output != BatchOutputList {
+		msg := fmt.Sprintf("batch output %q is not one of %v", output, []string{BatchOutputZip, BatchOutputList})
+		http.Error(resp, msg, http.StatusBadRequest)
+		return
+	}
+
+	items := make([]*batchItem, len(batch.Items))
+	for i, r := range batch.Items {
+		items[i] = &batchItem{index: i, req: r}
+		items[i].format, items[i].err = r.Resolve()
+		if items[i].err == nil && (r.Dynamic || r.CallbackURL != "") {
+			items[i].err = errUnsupportedBatchItem
+		}
+	}
+
+	batchID := uuid.New().String()
+	outcomes := runBatch(ctx, items, S.Cfg.Batch.Workers, output == BatchOutputZip)
+	summary := &BatchResponse{ReqID: batchID, Time: time.Now().String(), Total: len(items), Items: make([]*BatchItemResult, len(items))}
+
+	if output == BatchOutputZip {
+		writeBatchZip(resp, summary, outcomes)
+	} else {
+		for o := range outcomes {
+			summary.Items[o.result.Index] = o.result
+		}
+		summary.count()
+		respBytes, err := summary.MarshalJson()
+		if err != nil {
+			log.Error().Msgf("marshalling batch response failed with %v", err)
+			http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		resp.Header().Set("Content-Type", MimeJSON)
+		resp.WriteHeader(http.StatusOK)
+		_, _ = resp.Write(respBytes)
+	}
+	log.Info().Msgf("batch %v generated %d of %d items as %v", batchID, summary.Succeeded, summary.Total, output)
+}
+
+var (
+	errUnsupportedBatchType = errors.New("Content-Type header must be one of application/json, text/csv or multipart/form-data")
+	errInvalidBatchCSV      = errors.New("invalid batch csv")
+	errUnsupportedBatchItem = errors.New("dynamic and callback_url are not supported on batch items")
+)
+
+// decodeBatch reads the items of a batch from the request body. It can be a JSON Batch, a CSV document, or a
+// multipart form carrying a CSV document in its "file" field.
+func decodeBatch(req *http.Request) (*Batch, error) {
+	value, _ := header.ParseValueAndParams(req.Header, "Content-Type")
+	switch value {
+	case MimeJSON, "":
+		dec := json.NewDecoder(req.Body)
+		dec.DisallowUnknownFields()
+		batch := &Batch{}
+		if err := dec.Decode(batch); err != nil {
+			return nil, err
+		}
+		return batch, nil
+	case MimeCSV:
+		items, err := parseBatchCSV(req.Body)
+		return &Batch{Items: items}, err
+	case MimeMultipart:
+		file, _, err := req.FormFile(BatchFormField)
+		if err != nil {
+			return nil, fmt.Errorf("%w: multipart form must carry the csv in the %q field: %v", errInvalidBatchCSV, BatchFormField, err)
+		}
+		defer file.Close()
+		items, err := parseBatchCSV(file)
+		return &Batch{Items: items, Output: req.FormValue("output")}, err
+	}
+	return nil, errUnsupportedBatchType
+}
+
+// parseBatchCSV reads batch items from a CSV document. The first row is a header naming the columns of the document;
+// the columns are those of the QR request, and the payload column holds a JSON payload.
+func parseBatchCSV(r io.Reader) ([]*QR, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	head, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range head {
+		if !util.IsIn(h, BatchCSVFields) {
+			return nil, fmt.Errorf("%w: unknown column %q. known columns: %v", errInvalidBatchCSV, h, BatchCSVFields)
+		}
+	}
+
+	var items []*QR
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		item := NewQR()
+		for i, h := range head {
+			cell := row[i]
+			if cell == "" {
+				continue
+			}
+			switch h {
+			case "content":
+				item.Content = cell
+			case "size":
+				if item.Size, err = strconv.Atoi(cell); err != nil {
+					return nil, fmt.Errorf("%w: row %d: size %q is not a number", errInvalidBatchCSV, len(items)+1, cell)
+				}
+			case "recovery_level":
+				item.RecoveryLevel = cell
+			case "format":
+				item.Format = cell
+			case "verify":
+				if item.Verify, err = strconv.ParseBool(cell); err != nil {
+					return nil, fmt.Errorf("%w: row %d: verify %q is not a boolean", errInvalidBatchCSV, len(items)+1, cell)
+				}
+			case "payload":
+				item.Payload = &generators.Payload{}
+				if err := json.Unmarshal([]byte(cell), item.Payload); err != nil {
+					return nil, fmt.Errorf("%w: row %d: %v", errInvalidBatchCSV, len(items)+1, err)
+				}
+			}
+		}
+		items = append(items, item)
+	}
+}
+
+// batchOutput resolves how the outcome of a batch is returned. The output query parameter comes first, then the
+// output field of the request body, then the Accept header.
+func batchOutput(req *http.Request, batch *Batch) string {
+	if o := req.URL.Query().Get("output"); o != "" {
+		return o
+	}
+	if batch.Output != "" {
+		return batch.Output
+	}
+	if httputil.NegotiateContentType(req, []string{MimeJSON, MimeZip}, MimeJSON) == MimeZip {
+		return BatchOutputZip
+	}
+	return BatchOutputList
+}
+
+// runBatch generates the items of a batch on a pool of at most workers goroutines. Each item goes through its own
+// auth.QRDirector; with render set codes are only rendered in memory, otherwise they are saved to storage.
+// Outcomes are sent as soon as their item is done, and the channel is closed once every item is.
+func runBatch(ctx context.Context, items []*batchItem, workers int, render bool) <-chan *batchOutcome {
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan *batchItem)
+	outcomes := make(chan *batchOutcome, workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(items); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				outcomes <- generateBatchItem(ctx, item, render)
+			}
+		}()
+	}
+
+	go func() {
+		for _, item := range items {
+			queue <- item
+		}
+		close(queue)
+		wg.Wait()
+		close(outcomes)
+	}()
+	return outcomes
+}
+
+// generateBatchItem generates a single item of a batch
+func generateBatchItem(ctx context.Context, item *batchItem, render bool) *batchOutcome {
+	res := &BatchItemResult{Index: item.index}
+	if item.err != nil {
+		res.Error = item.err.Error()
+		return &batchOutcome{result: res}
+	}
+	if ctx.Err() != nil {
+		res.Error = fmt.Sprintf("batch cancelled: %v", ctx.Err())
+		return &batchOutcome{result: res}
+	}
+
+	id := uuid.New()
+	res.ID = id.String()
+	ctx = context.WithValue(ctx, util.RequestIDInContext, res.ID)
+	director := auth.NewQRDirector(ctx, id, item.req.Content, item.req.RecoveryLevel, item.req.Size, item.format, S.Cfg).WithVerify(item.req.Verify).WithStyle(item.req.Style)
+
+	var err error
+	if render {
+		_, err = director.Render()
+	} else {
+		_, err = director.Generate()
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return &batchOutcome{result: res}
+	}
+
+	r := director.Result()
+	res.URL, res.Format, res.Width, res.Height, res.Bytes, res.Checksum = r.URL, r.Format.String(), r.Width, r.Height, r.Size(), r.Checksum
+	if render {
+		res.File = fmt.Sprintf("%04d-%s%s", item.index, res.ID, r.Format.Extension())
+	}
+	return &batchOutcome{result: res, data: r.Data}
+}
+
+// writeBatchZip streams the generated codes into a ZIP archive as they complete, and closes the archive with the
+// manifest of the batch. Failures past the first byte can't change the status code; they are recorded in the
+// manifest instead.
+func writeBatchZip(resp http.ResponseWriter, summary *BatchResponse, outcomes <-chan *batchOutcome) {
+	log := S.Log.With().Str("method", "writeBatchZip()").Logger()
+	resp.Header().Set("Content-Type", MimeZip)
+	resp.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="batch-%s.zip"`, summary.ReqID))
+	resp.Header().Set(HeaderRequestID, summary.ReqID)
+	resp.WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(resp)
+	for o := range outcomes {
+		summary.Items[o.result.Index] = o.result
+		if o.result.Error != "" {
+			continue
+		}
+		w, err := archive.Create(o.result.File)
+		if err == nil {
+			_, err = w.Write(o.data)
+		}
+		if err != nil {
+			log.Error().Msgf("writing %v to archive failed with %v", o.result.File, err)
+			o.result.Error = err.Error()
+			o.result.File = ""
+		}
+		if f, ok := resp.(http.Flusher); ok {
+			_ = archive.Flush()
+			f.Flush()
+		}
+	}
+
+	summary.count()
+	manifest, err := json.MarshalIndent(summary, "", "  ")
+	if err == nil {
+		var w io.Writer
+		w, err = archive.Create(BatchManifest)
+		if err == nil {
+			_, err = w.Write(manifest)
+		}
+	}
+	if err != nil {
+		log.Error().Msgf("writing batch manifest failed with %v", err)
+	}
+	if err := archive.Close(); err != nil {
+		log.Error().Msgf("closing batch archive failed with %v", err)
+	}
+}
+
+// count tallies the succeeded and failed items of the batch
+func (r *BatchResponse) count() {
+	r.Succeeded, r.Failed = 0, 0
+	for _, item := range r.Items {
+		if item == nil || item.Error != "" {
+			r.Failed++
+			continue
+		}
+		r.Succeeded++
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/internal/generators/qr"
+	"github.com/golang/gddo/httputil/header"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+)
+
+var (
+	// MaxDecodeBytes limits the size of the images "/decode" reads
+	MaxDecodeBytes int64 = 10 << 20
+
+	errUnsupportedDecodeType = errors.New("Content-Type header must be one of image/png, image/jpeg or multipart/form-data")
+)
+
+// DecodeResponse is the structured JSON response of a successful call to "/decode"
+type DecodeResponse struct {
+	Response
+	Content       string       `json:"content"`
+	Version       int          `json:"version"`
+	Level         string       `json:"error_correction"`
+	RecoveryLevel int          `json:"recovery_level"`
+	Bounds        *BoundingBox `json:"bounding_box"`
+}
+
+// BoundingBox is the box a decoded code sits in, in the pixels of the uploaded image
+type BoundingBox struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func ConstructDecodeResponse(reqID string, d *qr.Decoded) *DecodeResponse {
+	return &DecodeResponse{
+		Response:      *ConstructResponse(reqID, "decoded qr code"),
+		Content:       d.Content,
+		Version:       d.Version,
+		Level:         d.Level,
+		RecoveryLevel: int(d.RecoveryLevel),
+		Bounds: &BoundingBox{
+			X:      d.Bounds.Min.X,
+			Y:      d.Bounds.Min.Y,
+			Width:  d.Bounds.Dx(),
+			Height: d.Bounds.Dy(),
+		},
+	}
+}
+
+func (r *DecodeResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// decode handles calls to "/decode". It reads the QR code in an uploaded PNG or JPEG image, sent either as the raw
+// request body or in the "file" field of a multipart form.
+func decode(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "decode()").Logger()
+	req.Body = http.MaxBytesReader(resp, req.Body, MaxDecodeBytes)
+
+	upload, err := decodeUpload(req)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.Is(err, errUnsupportedDecodeType):
+			http.Error(resp, err.Error(), http.StatusUnsupportedMediaType)
+		case errors.As(err, &maxBytesError):
+			http.Error(resp, fmt.Sprintf("Request body must not be larger than %d bytes", MaxDecodeBytes), http.StatusRequestEntityTooLarge)
+		default:
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	defer upload.Close()
+
+	decoded, err := qr.Decode(upload)
+	if err != nil {
+		log.Info().Msgf("decoding upload failed with: %v", err)
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			http.Error(resp, fmt.Sprintf("Request body must not be larger than %d bytes", MaxDecodeBytes), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, qr.ErrNoCode):
+			http.Error(resp, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	reqID := uuid.NewString()
+	decodeResponse, _ := ConstructDecodeResponse(reqID, decoded).MarshalJson()
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set(HeaderRequestID, reqID)
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(decodeResponse)
+	log.Info().Msgf("decoded version %d code", decoded.Version)
+}
+
+// decodeUpload returns the image uploaded to "/decode"
+func decodeUpload(req *http.Request) (io.ReadCloser, error) {
+	value, _ := header.ParseValueAndParams(req.Header, "Content-Type")
+	switch value {
+	case "image/png", "image/jpeg":
+		return req.Body, nil
+	case "multipart/form-data":
+		if err := req.ParseMultipartForm(MaxDecodeBytes); err != nil {
+			return nil, err
+		}
+		file, _, err := req.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("multipart form must carry the image in its \"file\" field: %w", err)
+		}
+		return file, nil
+	}
+	return nil, errUnsupportedDecodeType
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/dark-enstein/port/internal/cloud"
+	"github.com/dark-enstein/port/util"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// serveFile handles calls to the "/files/{id}". It serves objects saved to storage backends that don't have their
+// own public address, like the local disk.
+func serveFile(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "serveFile()").Logger()
+	ctx := context.WithValue(req.Context(), util.LoggerInContext, S.Log)
+	ctx, cancelFunc := context.WithTimeout(ctx, time.Second*30)
+	defer cancelFunc()
+
+	id := mux.Vars(req)["id"]
+	data, obj, err := S.Store.Get(ctx, id)
+	switch {
+	case errors.Is(err, cloud.ErrObjectNotFound), errors.Is(err, cloud.ErrInvalidKey):
+		http.Error(resp, "file not found", http.StatusNotFound)
+		return
+	case err != nil:
+		log.Error().Msgf("retrieving %v from %v storage failed with %v", id, S.Store.Kind(), err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set("Content-Type", obj.ContentType)
+	resp.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	resp.Header().Set("Cache-Control", "public, max-age=86400")
+	resp.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodHead {
+		return
+	}
+	_, err = resp.Write(data)
+	if err != nil {
+		log.Debug().Msgf("error while writing http response %v", err)
+	}
+}
@@ -6,14 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/internal/generators"
 	"github.com/dark-enstein/port/internal/generators/qr"
 	"github.com/dark-enstein/port/util"
+	"github.com/golang/gddo/httputil"
 	"github.com/golang/gddo/httputil/header"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
-	"io"
 	"net/http"
-	"strings"
+	"strconv"
 	"time"
 )
 
@@ -22,20 +24,101 @@ var (
 )
 
 type QR struct {
-	Content       string `json:"content"`
-	Size          int    `json:"size"`
-	RecoveryLevel string `json:"recovery_level"`
+	Content       string              `json:"content"`
+	Payload       *generators.Payload `json:"payload,omitempty"`
+	Size          int                 `json:"size"`
+	RecoveryLevel string              `json:"recovery_level"`
+	Format        string              `json:"format,omitempty"`
+	DataURI       bool                `json:"data_uri,omitempty"`
+	CallbackURL   string              `json:"callback_url,omitempty"`
+	Dynamic       bool                `json:"dynamic,omitempty"`
+	Verify        bool                `json:"verify,omitempty"`
+	Style         *qr.Style           `json:"style,omitempty"`
+}
+
+// GenerateResponse is the structured JSON response of a successful call to "/generate"
+type GenerateResponse struct {
+	Response
+	URL      string        `json:"url,omitempty"`
+	Format   string        `json:"format"`
+	Width    int           `json:"width"`
+	Height   int           `json:"height"`
+	Bytes    int           `json:"bytes"`
+	Checksum string        `json:"checksum"`
+	DataURI  string        `json:"data_uri,omitempty"`
+	Link     *LinkResponse `json:"link,omitempty"`
+}
+
+// ConstructGenerateResponse packages the result of a generation into a GenerateResponse. The rendered bytes are
+// only embedded, as a data URI, when withDataURI is set.
+func ConstructGenerateResponse(reqID string, res *qr.Result, withDataURI bool) *GenerateResponse {
+	r := GenerateResponse{
+		Response: *ConstructResponse(reqID, fmt.Sprintf("generated file at %v", res.URL)),
+		URL:      res.URL,
+		Format:   res.Format.String(),
+		Width:    res.Width,
+		Height:   res.Height,
+		Bytes:    res.Size(),
+		Checksum: res.Checksum,
+	}
+	if withDataURI {
+		r.DataURI = res.DataURI()
+	}
+	return &r
+}
+
+func (r *GenerateResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// negotiateFormat resolves the representation a client accepts for a generated code. It returns the image format
+// to stream when the client asked for image bytes, or false when the client should get a JSON response.
+func negotiateFormat(req *http.Request) (qr.Format, bool) {
+	offers := []string{MimeJSON}
+	for _, f := range qr.SupportedFormats {
+		offers = append(offers, f.MIME())
+	}
+	accepted := httputil.NegotiateContentType(req, offers, MimeJSON)
+	return qr.FormatFromMIME(accepted)
 }
 
 func NewQR() *QR {
 	return &QR{}
 }
 
+// Resolve validates the QR request, encoding a structured payload into the content of the code, and resolves the
+// output format it asks for
+func (q *QR) Resolve() (qr.Format, error) {
+	if q.Payload != nil {
+		if q.Content != "" {
+			return "", errors.New("request must contain either content or payload, not both")
+		}
+		var err error
+		q.Content, err = q.Payload.Encode()
+		if err != nil {
+			return "", err
+		}
+	}
+	if q.Content == "" {
+		return "", errors.New("request must contain content or a payload")
+	}
+	format, err := qr.ParseFormat(q.Format)
+	if err != nil || q.Style == nil {
+		return format, err
+	}
+	if err := q.Style.Validate(); err != nil {
+		return "", err
+	}
+	return format, q.Style.Supports(format)
+}
+
 // generate handles calls to the "/generate". It validates requests and generates a qr code and a link.
 func generate(resp http.ResponseWriter, req *http.Request) {
 	log := S.Log.With().Str("method", "generate()").Logger()
 	ctx := context.WithValue(context.Background(), util.LoggerInContext, S.Log)
 	ctx = context.WithValue(ctx, util.DBInContext, S.DB)
+	ctx = context.WithValue(ctx, util.StorageInContext, S.Store)
+	ctx = context.WithValue(ctx, util.CacheInContext, S.Cache)
 	log.Debug().Msg("received a call on /generate, the generate handler is picking it up")
 
 	// if Content-Type header doesn't have its value as "application/json", then return invalid
@@ -58,68 +141,75 @@ func generate(resp http.ResponseWriter, req *http.Request) {
 	ctx, cancelFunc := context.WithDeadline(ctx, time.Now().Add(time.Second*60))
 	defer cancelFunc()
 
+	// every kind but qr is generated by the encoder registered for it
+	if kind := mux.Vars(req)["type"]; kind != TypeQR.String() {
+		encoder, err := generators.Lookup(kind)
+		if err != nil {
+			log.Info().Msgf("request for unsupported kind: %v", err)
+			http.Error(resp, err.Error(), http.StatusNotFound)
+			return
+		}
+		generateSymbol(resp, req, ctx, dec, encoder)
+		return
+	}
+
 	// validate inputs
 	log.Debug().Msg("initiating request validation")
 	//isValid := generateValidate(dec, generator, resp)
 	qrReq := NewQR()
 	err := dec.Decode(&qrReq)
 	if err != nil {
-		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
-
 		log.Info().Msgf("umarshaling request into json failed with: %v", err)
-		switch {
-		// Catch any syntax errors in the JSON and send an error message
-		// which interpolates the location of the problem to make it
-		// easier for the client to fix.
-		case errors.As(err, &syntaxError):
-			msg := fmt.Sprintf("Request body contains badly-formed JSON (at position %d)", syntaxError.Offset)
-			http.Error(resp, msg, http.StatusBadRequest)
-
-		// In some circumstances Decode() may also return an
-		// io.ErrUnexpectedEOF error for syntax errors in the JSON. There
-		// is an open issue regarding this at
-		// https://github.com/golang/go/issues/25956.
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			msg := fmt.Sprintf("Request body contains badly-formed JSON")
-			http.Error(resp, msg, http.StatusBadRequest)
-
-		// Catch any type errors, like trying to assign a string in the
-		// JSON request body to a int field in our Person struct. We can
-		// interpolate the relevant field name and position into the error
-		// message to make it easier for the client to fix.
-		case errors.As(err, &unmarshalTypeError):
-			msg := fmt.Sprintf("Request body contains an invalid value for the %q field (at position %d)", unmarshalTypeError.Field, unmarshalTypeError.Offset)
-			http.Error(resp, msg, http.StatusBadRequest)
-
-		// Catch the error caused by extra unexpected fields in the request
-		// body. We extract the field name from the error message and
-		// interpolate it in our custom error message. There is an open
-		// issue at https://github.com/golang/go/issues/29035 regarding
-		// turning this into a sentinel error.
-		case strings.HasPrefix(err.Error(), "json: unknown field "):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			msg := fmt.Sprintf("Request body contains unknown field %s", fieldName)
-			http.Error(resp, msg, http.StatusBadRequest)
-
-		// An io.EOF error is returned by Decode() if the request body is
-		// empty.
-		case errors.Is(err, io.EOF):
-			msg := "Request body must not be empty"
-			http.Error(resp, msg, http.StatusBadRequest)
-
-		// Catch the error caused by the request body being too large. Again
-		// there is an open issue regarding turning this into a sentinel
-		// error at https://github.com/golang/go/issues/30715.
-		case err.Error() == "http: request body too large":
-			msg := "Request body must not be larger than 1MB"
-			http.Error(resp, msg, http.StatusRequestEntityTooLarge)
-
-		// Otherwise default to logging the error and sending a 500 Internal
-		// Server Error response.
-		default:
-			log.Print(err.Error())
-			http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		writeDecodeError(resp, err, &log)
+		return
+	}
+
+	format, err := qrReq.Resolve()
+	if err != nil {
+		log.Info().Msgf("request validation failed with: %v", err)
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// a dynamic code encodes a short link redirecting to its content, so the content must be a URL
+	if qrReq.Dynamic {
+		if err := validateAbsoluteURL("content", qrReq.Content); err != nil {
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// ?async=true queues the generation as a job instead of running it in the request
+	async, _ := strconv.ParseBool(req.URL.Query().Get("async"))
+	if qrReq.CallbackURL != "" {
+		if !async {
+			http.Error(resp, "callback_url is only supported on asynchronous requests", http.StatusBadRequest)
+			return
+		}
+		if err := validateAbsoluteURL("callback_url", qrReq.CallbackURL); err != nil {
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// an Accept header naming an image type asks for the image bytes directly
+	accepted, streamImage := negotiateFormat(req)
+	if streamImage {
+		if async {
+			http.Error(resp, "asynchronous requests respond with a job, not image bytes", http.StatusNotAcceptable)
+			return
+		}
+		if qrReq.Format != "" && accepted != format {
+			msg := fmt.Sprintf("Accept header %v conflicts with the requested format %v", accepted.MIME(), format)
+			http.Error(resp, msg, http.StatusNotAcceptable)
+			return
+		}
+		format = accepted
+		if qrReq.Style != nil {
+			if err := qrReq.Style.Supports(format); err != nil {
+				http.Error(resp, err.Error(), http.StatusNotAcceptable)
+				return
+			}
 		}
 	}
 
@@ -143,16 +233,68 @@ func generate(resp http.ResponseWriter, req *http.Request) {
 	requestId := uuid.New()
 	ctx = context.WithValue(ctx, util.RequestIDInContext, requestId.String())
 
-	var director auth.Director
-	switch mux.Vars(req)["type"] {
-	case TypeQR.String():
-		director = auth.NewQRDirector(ctx, requestId, qrReq.Content, qrReq.RecoveryLevel, qrReq.Size, S.Cfg)
+	if qrReq.Style != nil && !loadStyle(resp, ctx, qrReq.Style) {
+		return
+	}
+
+	var link *LinkResponse
+	// served tells whether the code of a dynamic link made it to the client: its link is deleted otherwise
+	served := false
+	if qrReq.Dynamic {
+		if !linksAvailable(resp) {
+			return
+		}
+		link, err = createLink(ctx, requestId.String(), qrReq.Content)
+		if err != nil {
+			log.Error().Msgf("creating short link failed with %v", err)
+			genResponse, _ := ConstructErrResponse(requestId.String(), fmt.Sprintf("creating short link failed with %v", err)).MarshalJson()
+			resp.WriteHeader(http.StatusInternalServerError)
+			_, _ = resp.Write(genResponse)
+			return
+		}
+		defer func() {
+			if !served {
+				dropLink(link)
+			}
+		}()
+		qrReq.Content = link.ShortURL
+	}
+
+	if async {
+		if !jobsAvailable(resp) {
+			return
+		}
+		spec := model.JobSpec{Content: qrReq.Content, Size: qrReq.Size, RecoveryLevel: qrReq.RecoveryLevel, Format: format.String(), Verify: qrReq.Verify, Style: qrReq.Style}
+		served = enqueueJob(resp, req, ctx, requestId.String(), mux.Vars(req)["type"], spec, qrReq.CallbackURL, link)
+		return
 	}
 
-	s, err := director.(*auth.QRDirector).Generate()
+	director := auth.NewQRDirector(ctx, requestId, qrReq.Content, qrReq.RecoveryLevel, qrReq.Size, format, S.Cfg).WithVerify(qrReq.Verify).WithStyle(qrReq.Style)
+
+	if streamImage {
+		res, err := director.Render()
+		if err != nil {
+			log.Error().Msgf("qr rendering failed with %v", err)
+			genResponse, _ := ConstructErrResponse(requestId.String(), fmt.Sprintf("qr generation failed with %v", err)).MarshalJson()
+			resp.WriteHeader(http.StatusInternalServerError)
+			_, _ = resp.Write(genResponse)
+			return
+		}
+		if link != nil {
+			resp.Header().Set(HeaderShortURL, link.ShortURL)
+			resp.Header().Set(HeaderEditToken, link.EditToken)
+		}
+		writeImage(resp, requestId.String(), res)
+		served = true
+		log.Info().Msgf("streamed %v bytes of %v to client", res.Size(), res.Format)
+		return
+	}
+
+	s, err := director.Generate()
 	if err != nil {
 		log.Error().Msgf("qr generation failed with %v", err)
 		genResponse, _ := ConstructErrResponse(requestId.String(), fmt.Sprintf("qr generation failed with %v", err)).MarshalJson()
+		resp.WriteHeader(http.StatusInternalServerError)
 		_, err := resp.Write(genResponse)
 		if err != nil {
 			fmt.Fprint(resp, genResponse)
@@ -162,14 +304,29 @@ func generate(resp http.ResponseWriter, req *http.Request) {
 	}
 	log.Debug().Msgf("qr generated: %v", s)
 
+	// a reused render only carries where its bytes are, so they're read back to be embedded
+	res := director.Result()
+	if qrReq.DataURI && res.Data == nil {
+		if res.Data, _, err = S.Store.Get(ctx, res.Key); err != nil {
+			log.Error().Msgf("reading cached render %v failed with %v", res.Key, err)
+			genResponse, _ := ConstructErrResponse(requestId.String(), fmt.Sprintf("reading generated file failed with %v", err)).MarshalJson()
+			resp.WriteHeader(http.StatusInternalServerError)
+			_, _ = resp.Write(genResponse)
+			return
+		}
+	}
+
 	// writing response
-	genResponse, err := ConstructResponse(requestId.String(), fmt.Sprintf("generated file at %v", s)).MarshalJson()
+	generateResponse := ConstructGenerateResponse(requestId.String(), res, qrReq.DataURI)
+	generateResponse.Link = link
+	genResponse, err := generateResponse.MarshalJson()
 	if err != nil {
-		log.Error().Msgf("ConstructResponse() failed with %v", err)
+		log.Error().Msgf("ConstructGenerateResponse() failed with %v", err)
 		_, err = resp.Write(genResponse)
 		return
 	}
 	resp.WriteHeader(http.StatusOK)
+	served = true
 	log.Info().Msgf("file at %v\n", s)
 	_, err = resp.Write(genResponse)
 	if err != nil {
@@ -179,3 +336,13 @@ func generate(resp http.ResponseWriter, req *http.Request) {
 
 	return
 }
+
+// writeImage streams the rendered bytes of a code to the client
+func writeImage(resp http.ResponseWriter, reqID string, res *qr.Result) {
+	resp.Header().Set("Content-Type", res.Format.MIME())
+	resp.Header().Set("Content-Length", strconv.Itoa(res.Size()))
+	resp.Header().Set(HeaderRequestID, reqID)
+	resp.Header().Set(HeaderChecksum, res.Checksum)
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(res.Data)
+}
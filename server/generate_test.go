@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/config"
+	dbmemory "github.com/dark-enstein/port/db/memory"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/internal/cloud"
+	"github.com/dark-enstein/port/internal/cloud/memory"
+	"github.com/dark-enstein/port/util"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingStore is a store whose uploads fail
+type failingStore struct {
+	*memory.Store
+}
+
+func (f *failingStore) Put(ctx context.Context, key, contentType string, data []byte) (*cloud.Object, error) {
+	return nil, errors.New("store is down")
+}
+
+type GenerateTest struct {
+	ctx context.Context
+	suite.Suite
+}
+
+func (s *GenerateTest) SetupTest() {
+	S = &Service{Log: config.NewLoggerWithWarn(), Cfg: &config.Config{}}
+	s.ctx = context.WithValue(context.Background(), util.LoggerInContext, S.Log)
+	mem, err := dbmemory.NewMemoryClient(s.ctx, "")
+	s.Require().NoError(err)
+	S.DB, S.Store, S.Cache = mem, memory.NewStore("http://localhost"), auth.NewRenderCache(8)
+	s.ctx = context.WithValue(s.ctx, util.DBInContext, mem)
+}
+
+// generate calls "POST /generate/{type}" with the kind and body passed in
+func (s *GenerateTest) generate(kind, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/generate/"+kind, strings.NewReader(body))
+	req.Header.Set("Content-Type", MimeJSON)
+	req = mux.SetURLVars(req, map[string]string{"type": kind})
+	resp := httptest.NewRecorder()
+	generate(resp, req)
+	return resp
+}
+
+// links returns the links in the DB
+func (s *GenerateTest) links() []*model.Link {
+	var links []*model.Link
+	s.Require().NoError(S.DB.List(s.ctx, model.Filter{}, &links, nil, model.NewUnitOptions(auth.UserDB, auth.LinkCollection)).Err)
+	return links
+}
+
+// TestDynamic tests that dynamic codes keep their link, and that the link of a code failing to be stored is deleted
+func (s *GenerateTest) TestDynamic() {
+	body := `{"content": "https://github.com/dark-enstein/port", "dynamic": true}`
+	resp := s.generate("qr", body)
+	s.Require().Equal(http.StatusOK, resp.Code, resp.Body.String())
+	s.Assert().Len(s.links(), 1)
+
+	S.Store = &failingStore{Store: memory.NewStore("http://localhost")}
+	resp = s.generate("qr", `{"content": "https://github.com/dark-enstein/port/issues", "dynamic": true}`)
+	s.Require().Equal(http.StatusInternalServerError, resp.Code)
+	s.Assert().Len(s.links(), 1)
+}
+
+// TestDimensions tests that barcodes can't be asked for images over MaxDimension on a side
+func (s *GenerateTest) TestDimensions() {
+	for _, body := range []string{
+		`{"content": "port", "width": 100000, "height": 100000}`,
+		`{"content": "port", "width": 4097}`,
+		`{"content": "port", "height": 4097}`,
+		`{"content": "port", "width": -1}`,
+	} {
+		resp := s.generate("code128", body)
+		s.Assert().Equal(http.StatusBadRequest, resp.Code, body)
+	}
+	resp := s.generate("code128", `{"content": "port", "width": 4096, "height": 4096}`)
+	s.Assert().Equal(http.StatusOK, resp.Code, resp.Body.String())
+}
+
+func TestGenerate(t *testing.T) {
+	suite.Run(t, new(GenerateTest))
+}
@@ -0,0 +1,428 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/internal/generators/qr"
+	"github.com/dark-enstein/port/util"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// JobTimeout bounds the generation of a single asynchronous job
+	JobTimeout = 5 * time.Minute
+	// JobQueueDepth is the number of jobs each worker can have waiting before new jobs are turned away
+	JobQueueDepth = 64
+
+	CallbackAttempts = 3
+	CallbackTimeout  = 10 * time.Second
+	CallbackBackoff  = time.Second
+
+	// JobRequeueGrace is how long a stopped queue waits for the jobs it cut short to be put back to queued
+	JobRequeueGrace = 5 * time.Second
+	// JobRecoverInterval is how often recovered jobs are offered again to a queue that is full
+	JobRecoverInterval = 100 * time.Millisecond
+
+	// ReadAnyJob is the permission letting callers read the jobs other callers created
+	ReadAnyJob = auth.Need(auth.ResourceUsers, auth.ActionUpdate)
+
+	CallbackDelivered = "delivered"
+	CallbackFailed    = "failed"
+
+	ErrQueueFull   = errors.New("job queue is full")
+	ErrQueueClosed = errors.New("job queue is closed")
+	// ErrCallbackForbidden is the response to delivering a callback to an address that isn't public, and isn't allowed
+	ErrCallbackForbidden = errors.New("callback address is not public")
+)
+
+// JobResponse is the JSON response of the "/jobs" endpoints
+type JobResponse struct {
+	Response
+	Job  *model.Job    `json:"job"`
+	Link *LinkResponse `json:"link,omitempty"`
+}
+
+func ConstructJobResponse(reqID string, job *model.Job) *JobResponse {
+	return &JobResponse{
+		Response: *ConstructResponse(reqID, fmt.Sprintf("job %v is %v", job.ID, job.Status)),
+		Job:      job,
+	}
+}
+
+func (r *JobResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// JobQueue runs asynchronous generation jobs on a fixed pool of workers. The state of every job is persisted
+// through the DB as it moves along, so it can be polled, and outlives the process: jobs a stopped process left
+// unfinished are run again by Recover.
+type JobQueue struct {
+	sync.Mutex
+	jobs   chan *model.Job
+	wg     sync.WaitGroup
+	closed bool
+	client *http.Client
+	// ctx is cancelled when the queue stops before its jobs are done, cutting the running ones short
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewJobQueue starts a queue running jobs on the number of workers passed in. Callbacks are only delivered to public
+// addresses, and to the allowed networks passed in.
+func NewJobQueue(workers int, allowed []*net.IPNet) *JobQueue {
+	q := &JobQueue{
+		jobs:   make(chan *model.Job, workers*JobQueueDepth),
+		client: newCallbackClient(allowed),
+	}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue hands a persisted job over to the workers. It doesn't block: it returns ErrQueueFull when the workers
+// are too far behind to take the job.
+func (q *JobQueue) Enqueue(job *model.Job) error {
+	q.Lock()
+	defer q.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Recover queues the jobs a stopped process left unfinished, running again the ones it cut short. It returns how many
+// jobs it found, and queues them in the background as the workers make room, until the queue stops: the jobs it
+// couldn't queue are left for the next process.
+func (q *JobQueue) Recover(ctx context.Context) (int, error) {
+	log := S.Log.With().Str("method", "JobQueue.Recover()").Logger()
+	director := auth.NewJobDirector(ctx)
+	jobs, err := director.Unfinished()
+	if err != nil {
+		return 0, err
+	}
+	for _, job := range jobs {
+		if job.Status != model.JobRunning {
+			continue
+		}
+		if err := director.Requeue(job.ID); err != nil {
+			log.Error().Msgf("cannot requeue job %v: %v", job.ID, err)
+			continue
+		}
+		job.Status = model.JobQueued
+	}
+
+	go func() {
+		for _, job := range jobs {
+			for {
+				err := q.Enqueue(job)
+				if errors.Is(err, ErrQueueFull) {
+					time.Sleep(JobRecoverInterval)
+					continue
+				}
+				if err != nil {
+					log.Info().Msgf("recovered job %v left queued: %v", job.ID, err)
+					return
+				}
+				break
+			}
+		}
+	}()
+	return len(jobs), nil
+}
+
+// Stop stops taking jobs and waits for the workers to drain the queue, or for ctx to be done. Jobs still running
+// then are cut short and put back to queued, like the ones left in the queue, for Recover to run them again.
+func (q *JobQueue) Stop(ctx context.Context) error {
+	q.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	q.cancel()
+	select {
+	case <-done:
+	case <-time.After(JobRequeueGrace):
+	}
+	return fmt.Errorf("jobs didn't drain in time, left queued: %w", ctx.Err())
+}
+
+func (q *JobQueue) work() {
+	defer q.wg.Done()
+	for job := range q.jobs {
+		q.run(job)
+	}
+}
+
+// run generates the code of a job, records its outcome and notifies its callback URL. Jobs the queue cuts short
+// are put back to queued instead.
+func (q *JobQueue) run(job *model.Job) {
+	log := S.Log.With().Str("method", "JobQueue.run()").Str("job", job.ID).Logger()
+	if q.ctx.Err() != nil {
+		// the queue stopped: the job stays queued
+		return
+	}
+	director := auth.NewJobDirector(jobContext(context.Background(), job.ID))
+
+	if err := director.Start(job.ID); err != nil {
+		log.Error().Msgf("cannot start job: %v", err)
+		return
+	}
+
+	genCtx, cancel := context.WithTimeout(jobContext(q.ctx, job.ID), JobTimeout)
+	defer cancel()
+	qrDirector := auth.NewQRDirector(genCtx, uuid.MustParse(job.ID), job.Spec.Content, job.Spec.RecoveryLevel, job.Spec.Size, formatOf(job.Spec), S.Cfg).WithVerify(job.Spec.Verify).WithStyle(job.Spec.Style)
+	_, err := qrDirector.Generate()
+	switch {
+	case err != nil && q.ctx.Err() != nil:
+		log.Info().Msgf("job cut short by the queue stopping: %v", err)
+		if err := director.Requeue(job.ID); err != nil {
+			log.Error().Msgf("cannot requeue job: %v", err)
+		}
+		return
+	case err != nil:
+		log.Info().Msgf("job failed with: %v", err)
+		if err := director.Fail(job.ID, model.JobRunning, err); err != nil {
+			log.Error().Msgf("cannot record failure of job: %v", err)
+		}
+	default:
+		res := qrDirector.Result()
+		if err := director.Succeed(job.ID, &model.JobResult{
+			URL:      res.URL,
+			Format:   res.Format.String(),
+			Width:    res.Width,
+			Height:   res.Height,
+			Bytes:    res.Size(),
+			Checksum: res.Checksum,
+		}); err != nil {
+			log.Error().Msgf("cannot record success of job: %v", err)
+		}
+	}
+
+	if job.CallbackURL == "" {
+		return
+	}
+	done, err := director.Get(job.ID)
+	if err != nil {
+		log.Error().Msgf("cannot read job for its callback: %v", err)
+		return
+	}
+	status := CallbackDelivered
+	if err := q.notify(done); err != nil {
+		log.Info().Msgf("callback to %v failed with: %v", job.CallbackURL, err)
+		status = fmt.Sprintf("%v: %v", CallbackFailed, err)
+	}
+	if err := director.RecordCallback(job.ID, status); err != nil {
+		log.Error().Msgf("cannot record callback of job: %v", err)
+	}
+}
+
+// notify POSTs the job to its callback URL, retrying with a growing backoff until the receiver responds with a 2xx
+func (q *JobQueue) notify(job *model.Job) error {
+	body, err := ConstructJobResponse(job.ID, job).MarshalJson()
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err = q.post(job, body)
+		if err == nil || attempt == CallbackAttempts {
+			return err
+		}
+		time.Sleep(CallbackBackoff * time.Duration(attempt))
+	}
+}
+
+func (q *JobQueue) post(job *model.Job, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, job.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", MimeJSON)
+	req.Header.Set(HeaderRequestID, job.ID)
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver responded with %v", resp.Status)
+	}
+	return nil
+}
+
+// newCallbackClient builds the client delivering callbacks. Its dialer checks every address it connects to, once
+// resolved, so neither the host of a callback URL nor its redirects nor DNS answers changing in between can reach
+// the addresses around the server.
+func newCallbackClient(allowed []*net.IPNet) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: CallbackTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !callbackAllowed(ip, allowed) {
+				return fmt.Errorf("%w: %v", ErrCallbackForbidden, host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed instead of the callback host, leaving it unchecked
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: CallbackTimeout, Transport: transport}
+}
+
+// callbackAllowed checks that callbacks may be delivered to ip: it must be public, or in one of the allowed networks
+func callbackAllowed(ip net.IP, allowed []*net.IPNet) bool {
+	for _, n := range allowed {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// formatOf resolves the format of a job. Specs are validated before they're queued, so it can't fail.
+func formatOf(spec model.JobSpec) qr.Format {
+	f, _ := qr.ParseFormat(spec.Format)
+	return f
+}
+
+// jobContext builds the context a job runs in from parent. Jobs outlive the requests that create them, so it doesn't
+// derive from a request context.
+func jobContext(parent context.Context, id string) context.Context {
+	ctx := context.WithValue(parent, util.LoggerInContext, S.Log)
+	ctx = context.WithValue(ctx, util.DBInContext, S.DB)
+	ctx = context.WithValue(ctx, util.StorageInContext, S.Store)
+	ctx = context.WithValue(ctx, util.CacheInContext, S.Cache)
+	return context.WithValue(ctx, util.RequestIDInContext, id)
+}
+
+// validateAbsoluteURL checks that the URL in the request field passed in is an absolute http(s) URL
+func validateAbsoluteURL(field, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%v is invalid: %w", field, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%v %q must be an absolute http or https URL", field, raw)
+	}
+	return nil
+}
+
+// jobsAvailable checks that asynchronous jobs can be taken, responding with 503 when they can't
+func jobsAvailable(resp http.ResponseWriter) bool {
+	if S.DB == nil || S.Jobs == nil {
+		http.Error(resp, "asynchronous jobs are not available", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// enqueueJob persists a generation job for the request and queues it, responding with 202 and where to poll it. The
+// job belongs to the caller. It returns whether the job was queued.
+func enqueueJob(resp http.ResponseWriter, req *http.Request, ctx context.Context, reqID string, kind string, spec model.JobSpec, callbackURL string, link *LinkResponse) bool {
+	log := S.Log.With().Str("method", "enqueueJob()").Logger()
+	job := model.NewJob(reqID, kind, spec, callbackURL)
+	if claims, ok := RequestClaims(req.Context()); ok {
+		job.UserID = claims.Subject
+	}
+	if err := auth.NewJobDirector(ctx).Create(job); err != nil {
+		log.Error().Msgf("persisting job failed with %v", err)
+		genResponse, _ := ConstructErrResponse(reqID, fmt.Sprintf("creating job failed with %v", err)).MarshalJson()
+		resp.WriteHeader(http.StatusInternalServerError)
+		_, _ = resp.Write(genResponse)
+		return false
+	}
+	if err := S.Jobs.Enqueue(job); err != nil {
+		log.Info().Msgf("queueing job failed with %v", err)
+		_ = auth.NewJobDirector(ctx).Fail(job.ID, model.JobQueued, err)
+		http.Error(resp, err.Error(), http.StatusServiceUnavailable)
+		return false
+	}
+
+	jobResponse := ConstructJobResponse(reqID, job)
+	jobResponse.Link = link
+	respBytes, _ := jobResponse.MarshalJson()
+	resp.Header().Set("Location", "/jobs/"+job.ID)
+	resp.Header().Set(HeaderRequestID, reqID)
+	resp.WriteHeader(http.StatusAccepted)
+	_, _ = resp.Write(respBytes)
+	log.Info().Msgf("queued job %v", job.ID)
+	return true
+}
+
+// getJob handles calls to "/jobs/{id}". It reports the status of an asynchronous job, with its result once done.
+// Jobs are only reported to the caller that created them, and to callers holding ReadAnyJob: others get 404.
+func getJob(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "getJob()").Logger()
+	if !jobsAvailable(resp) {
+		return
+	}
+	id := mux.Vars(req)["id"]
+	ctx := jobContext(context.Background(), id)
+	ctx, cancelFunc := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelFunc()
+
+	job, err := auth.NewJobDirector(ctx).Get(id)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("job %v not found", id), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Error().Msgf("reading job %v failed with %v", id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if claims, ok := RequestClaims(req.Context()); !ok || job.UserID == "" || job.UserID != claims.Subject {
+		allowed, err := callerAllows(req, ReadAnyJob)
+		if err != nil {
+			log.Error().Msgf("checking the permissions of the caller of job %v failed with %v", id, err)
+			http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(resp, fmt.Sprintf("job %v not found", id), http.StatusNotFound)
+			return
+		}
+	}
+
+	jobResponse, _ := ConstructJobResponse(uuid.NewString(), job).MarshalJson()
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(jobResponse)
+}
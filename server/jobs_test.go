@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/config"
+	dbmemory "github.com/dark-enstein/port/db/memory"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/internal/cloud/memory"
+	"github.com/dark-enstein/port/util"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type JobsTest struct {
+	ctx context.Context
+	suite.Suite
+}
+
+func (s *JobsTest) SetupTest() {
+	S = &Service{Log: config.NewLoggerWithWarn()}
+	s.ctx = context.WithValue(context.Background(), util.LoggerInContext, S.Log)
+	mem, err := dbmemory.NewMemoryClient(s.ctx, "")
+	s.Require().NoError(err)
+	S.DB, S.Store, S.Cache = mem, memory.NewStore("http://localhost"), auth.NewRenderCache(8)
+	s.ctx = context.WithValue(s.ctx, util.DBInContext, mem)
+}
+
+// persist creates a job left in status by a process that stopped
+func (s *JobsTest) persist(status model.JobStatus) *model.Job {
+	job := model.NewJob(uuid.NewString(), "qr", model.JobSpec{Content: "https://github.com/dark-enstein/port", Size: 128, RecoveryLevel: "1", Format: "png"}, "")
+	jobs := auth.NewJobDirector(s.ctx)
+	s.Require().NoError(jobs.Create(job))
+	if status == model.JobRunning {
+		s.Require().NoError(jobs.Start(job.ID))
+	}
+	return job
+}
+
+// await waits for the job with the id passed in to be done, and returns it
+func (s *JobsTest) await(id string) *model.Job {
+	var job *model.Job
+	s.Require().Eventually(func() bool {
+		var err error
+		job, err = auth.NewJobDirector(s.ctx).Get(id)
+		s.Require().NoError(err)
+		return job.Status.IsDone()
+	}, 10*time.Second, 10*time.Millisecond)
+	return job
+}
+
+// TestRecover tests that a new queue runs the jobs a stopped process left queued, and again the ones it left running
+func (s *JobsTest) TestRecover() {
+	queued, running := s.persist(model.JobQueued), s.persist(model.JobRunning)
+
+	q := NewJobQueue(1, nil)
+	defer func() { _ = q.Stop(context.Background()) }()
+	recovered, err := q.Recover(s.ctx)
+	s.Require().NoError(err)
+	s.Assert().Equal(2, recovered)
+
+	for _, job := range []*model.Job{queued, running} {
+		done := s.await(job.ID)
+		s.Assert().Equal(model.JobSucceeded, done.Status, done.Error)
+		s.Require().NotNil(done.Result)
+		s.Assert().NotEmpty(done.Result.URL)
+	}
+}
+
+// TestStop tests that the jobs a queue stopping in a hurry didn't get to are left queued, for the next queue to run
+func (s *JobsTest) TestStop() {
+	q := NewJobQueue(1, nil)
+	// as when the queue doesn't drain before the deadline of Stop
+	q.cancel()
+	job := s.persist(model.JobQueued)
+	s.Require().NoError(q.Enqueue(job))
+	s.Require().NoError(q.Stop(context.Background()))
+	left, err := auth.NewJobDirector(s.ctx).Get(job.ID)
+	s.Require().NoError(err)
+	s.Assert().Equal(model.JobQueued, left.Status)
+	s.Assert().ErrorIs(q.Enqueue(job), ErrQueueClosed)
+
+	next := NewJobQueue(1, nil)
+	defer func() { _ = next.Stop(context.Background()) }()
+	_, err = next.Recover(s.ctx)
+	s.Require().NoError(err)
+	s.Assert().Equal(model.JobSucceeded, s.await(job.ID).Status)
+}
+
+// TestCallback tests that callbacks are only delivered to public addresses, unless their network is allowed
+func (s *JobsTest) TestCallback() {
+	received := make(chan string, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		received <- req.Header.Get(HeaderRequestID)
+	}))
+	defer receiver.Close()
+	job := model.NewJob(uuid.NewString(), "qr", model.JobSpec{}, receiver.URL)
+
+	q := NewJobQueue(1, nil)
+	defer func() { _ = q.Stop(context.Background()) }()
+	s.Assert().ErrorIs(q.post(job, []byte("{}")), ErrCallbackForbidden)
+	for _, host := range []string{"169.254.169.254", "10.0.0.1", "[::1]", "0.0.0.0"} {
+		job.CallbackURL = "http://" + host + "/hook"
+		s.Assert().ErrorIs(q.post(job, []byte("{}")), ErrCallbackForbidden, host)
+	}
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	allowed := NewJobQueue(1, []*net.IPNet{loopback})
+	defer func() { _ = allowed.Stop(context.Background()) }()
+	job.CallbackURL = receiver.URL
+	s.Require().NoError(allowed.post(job, []byte("{}")))
+	s.Assert().Equal(job.ID, <-received)
+}
+
+func TestJobs(t *testing.T) {
+	suite.Run(t, new(JobsTest))
+}
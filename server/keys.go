@@ -0,0 +1,333 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/jwt"
+	"github.com/dark-enstein/port/util"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// HeaderAPIKey carries an API key, for clients that can't send it as "Authorization: Bearer <key>"
+	HeaderAPIKey = "X-API-Key"
+	// MaxKeyRateLimit is the most calls a minute an API key can be allowed
+	MaxKeyRateLimit = 60000
+
+	// serviceName is what the names of service accounts are made of
+	serviceName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,63}$`)
+)
+
+// APIKeyRequest is the body of a call to "POST /users/{id}/keys". A zero rate limit leaves the key to the default
+// of the server, and a key without expires_at is valid until it is revoked.
+type APIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	RateLimit int        `json:"rate_limit"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyResponse describes an API key. Key is the key itself, and is only ever set in the response creating it.
+type APIKeyResponse struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	RateLimit  int        `json:"rate_limit"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Key        string     `json:"key,omitempty"`
+}
+
+func (r *APIKeyResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// APIKeysResponse lists the API keys of a user
+type APIKeysResponse struct {
+	Keys []*APIKeyResponse `json:"keys"`
+}
+
+func (r *APIKeysResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// ServiceAccountRequest is the body of a call to "POST /service-accounts"
+type ServiceAccountRequest struct {
+	Name string `json:"name"`
+}
+
+func ConstructAPIKeyResponse(key *model.APIKey) *APIKeyResponse {
+	rateLimit := key.RateLimit
+	if rateLimit == 0 {
+		rateLimit = S.Cfg.Tokens.KeyRateLimit
+	}
+	return &APIKeyResponse{
+		ID:         key.ID,
+		UserID:     key.UserID,
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		RateLimit:  rateLimit,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		CreatedAt:  key.CreatedAt,
+	}
+}
+
+// RequestKey returns the API key a request was authenticated with, if it was
+func RequestKey(ctx context.Context) (*model.APIKey, bool) {
+	k, ok := ctx.Value(util.APIKeyInContext).(*model.APIKey)
+	return k, ok
+}
+
+// apiKey returns the API key a request carries, as X-API-Key or as a bearer token starting with the key prefix
+func apiKey(req *http.Request) (string, bool) {
+	if key := strings.TrimSpace(req.Header.Get(HeaderAPIKey)); key != "" {
+		return key, true
+	}
+	if token, ok := bearerToken(req); ok && strings.HasPrefix(token, auth.APIKeyPrefix) {
+		return token, true
+	}
+	return "", false
+}
+
+// authenticateKey authenticates a call made with an API key, for authenticate. The call is made as the owner of the
+// key, and counts against the rate limit of the key: calls over it get 429 with a Retry-After.
+func authenticateKey(next http.Handler, resp http.ResponseWriter, req *http.Request, raw string) {
+	log := S.Log.With().Str("method", "authenticateKey()").Logger()
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	key, err := auth.NewAPIKeyDirector(ctx).Authenticate(raw)
+	switch {
+	case errors.Is(err, auth.ErrInvalidAPIKey):
+		resp.Header().Set("WWW-Authenticate", `Bearer realm="port", error="invalid_token"`)
+		http.Error(resp, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		log.Error().Msgf("authenticating api key failed with %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	limit := key.RateLimit
+	if limit == 0 {
+		limit = S.Cfg.Tokens.KeyRateLimit
+	}
+	allowed, remaining, retry := S.limits.allow(key.ID, limit, time.Now())
+	resp.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	resp.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if !allowed {
+		resp.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		http.Error(resp, fmt.Sprintf("api key %v made more than %d calls a minute", key.ID, limit), http.StatusTooManyRequests)
+		return
+	}
+
+	keyLog := S.Log.With().Str("user_id", key.UserID).Str("api_key", key.ID).Logger()
+	reqCtx := context.WithValue(req.Context(), util.LoggerInContext, &keyLog)
+	reqCtx = context.WithValue(reqCtx, util.ClaimsInContext, &jwt.Claims{ID: key.ID, Subject: key.UserID})
+	reqCtx = context.WithValue(reqCtx, util.APIKeyInContext, key)
+	next.ServeHTTP(resp, req.WithContext(reqCtx))
+}
+
+// keyLimiter holds a bucket of calls for each API key. A bucket holds a minute of calls at most, and refills at the
+// rate limit of its key. Buckets are kept by this process, so each instance of Port limits keys on its own.
+type keyLimiter struct {
+	sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	calls float64
+	at    time.Time
+}
+
+// allow takes a call out of the bucket of the key, if one is left. It returns whether the call is allowed, the
+// calls left, and how long until the next call is when none is.
+func (l *keyLimiter) allow(id string, perMinute int, now time.Time) (bool, int, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	if l.buckets == nil {
+		l.buckets = map[string]*bucket{}
+	}
+	capacity, rate := float64(perMinute), float64(perMinute)/time.Minute.Seconds()
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{calls: capacity, at: now}
+		l.buckets[id] = b
+	}
+	b.calls = math.Min(capacity, b.calls+now.Sub(b.at).Seconds()*rate)
+	b.at = now
+	if b.calls < 1 {
+		return false, 0, time.Duration((1 - b.calls) / rate * float64(time.Second))
+	}
+	b.calls--
+	return true, int(b.calls), 0
+}
+
+// forget drops the bucket of a revoked key
+func (l *keyLimiter) forget(id string) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.buckets, id)
+}
+
+// scopesWithin checks that every scope passed in is one of the scopes of a key, or covered by one of its wildcards.
+// Keys can only create keys as narrow as they are, so a leaked key can't be widened.
+func scopesWithin(scopes, of []string) bool {
+	held := map[string]bool{}
+	for _, s := range of {
+		held[s] = true
+	}
+	for _, s := range scopes {
+		resource, _, _ := strings.Cut(s, ":")
+		if !held[s] && !held[resource+":*"] {
+			return false
+		}
+	}
+	return true
+}
+
+// createKey handles calls to "POST /users/{id}/keys". It issues an API key to the user, and responds with the key
+// itself, which is never shown again.
+func createKey(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "createKey()").Logger()
+	body := &APIKeyRequest{}
+	if !decodeJSON(resp, req, body, &log) {
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	switch {
+	case body.Name == "" || len(body.Name) > 64:
+		http.Error(resp, "name must hold from 1 to 64 characters", http.StatusBadRequest)
+		return
+	case strings.ContainsAny(body.Name, util.Forbidden):
+		http.Error(resp, fmt.Sprintf("name must not contain any of %v", util.Forbidden), http.StatusBadRequest)
+		return
+	case body.RateLimit < 0 || body.RateLimit > MaxKeyRateLimit:
+		http.Error(resp, fmt.Sprintf("rate_limit must be a number of calls a minute, from 1 to %d, or 0 for the default", MaxKeyRateLimit), http.StatusBadRequest)
+		return
+	case body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now()):
+		http.Error(resp, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+	if err := auth.ValidateScopes(body.Scopes); err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if key, ok := RequestKey(req.Context()); ok && !scopesWithin(body.Scopes, key.Scopes) {
+		http.Error(resp, "api keys can only create keys with scopes they have", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	id := mux.Vars(req)["id"]
+	raw, key, err := auth.NewAPIKeyDirector(ctx).Create(id, body.Name, body.Scopes, body.RateLimit, body.ExpiresAt)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("user %v not found", id), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Error().Msgf("creating api key for user %v failed with %v", id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	audit(ctx, req, auth.AuditKeyCreate, auth.AuditUser(id), map[string]interface{}{"key_id": key.ID, "scopes": key.Scopes})
+
+	keyResponse := ConstructAPIKeyResponse(key)
+	keyResponse.Key = raw
+	keyJSON, _ := keyResponse.MarshalJson()
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set("Cache-Control", "no-store")
+	resp.Header().Set(HeaderRequestID, uuid.NewString())
+	resp.WriteHeader(http.StatusCreated)
+	_, _ = resp.Write(keyJSON)
+	log.Info().Msgf("api key %v created for user %v", key.ID, id)
+}
+
+// listKeys handles calls to "GET /users/{id}/keys". It lists the API keys of the user, without the keys themselves.
+func listKeys(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "listKeys()").Logger()
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+
+	id := mux.Vars(req)["id"]
+	keys, err := auth.NewAPIKeyDirector(ctx).List(id)
+	if err != nil {
+		log.Error().Msgf("listing the api keys of user %v failed with %v", id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	list := &APIKeysResponse{Keys: make([]*APIKeyResponse, len(keys))}
+	for i, key := range keys {
+		list.Keys[i] = ConstructAPIKeyResponse(key)
+	}
+	keysResponse, _ := list.MarshalJson()
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set(HeaderRequestID, uuid.NewString())
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(keysResponse)
+}
+
+// revokeKey handles calls to "DELETE /users/{id}/keys/{key}". The key stops working right away.
+func revokeKey(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "revokeKey()").Logger()
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+
+	id, keyID := mux.Vars(req)["id"], mux.Vars(req)["key"]
+	err := auth.NewAPIKeyDirector(ctx).Revoke(id, keyID)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("user %v has no api key %v", id, keyID), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Error().Msgf("revoking api key %v of user %v failed with %v", keyID, id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	S.limits.forget(keyID)
+	audit(ctx, req, auth.AuditKeyRevoke, auth.AuditUser(id), map[string]interface{}{"key_id": keyID})
+	resp.WriteHeader(http.StatusNoContent)
+	log.Info().Msgf("api key %v of user %v revoked", keyID, id)
+}
+
+// createServiceAccount handles calls to "POST /service-accounts". It creates a user that can't log in, for machine
+// clients to call Port as with the API keys issued to it.
+func createServiceAccount(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "createServiceAccount()").Logger()
+	body := &ServiceAccountRequest{}
+	if !decodeJSON(resp, req, body, &log) {
+		return
+	}
+	if !serviceName.MatchString(body.Name) {
+		http.Error(resp, "name must start with a letter, and hold up to 64 letters, digits, - and _", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	created, err := auth.NewUserDirector(ctx).CreateUser(*auth.NewServiceAccount(body.Name))
+	if err != nil {
+		log.Error().Msgf("creating service account %v failed with %v", body.Name, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	audit(ctx, req, auth.AuditServiceAccount, auth.AuditUser(created.ID), map[string]interface{}{"name": body.Name})
+	resp.Header().Set("Location", "/users/"+created.ID)
+	writeUser(resp, created, http.StatusCreated)
+	log.Info().Msgf("service account %v created with id: %s", body.Name, created.ID)
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/util"
+	"github.com/golang/gddo/httputil/header"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LinkResponse describes the short link behind a dynamic QR code. The edit token is only ever included in the
+// response to the request that created the link.
+type LinkResponse struct {
+	ID        string    `json:"id"`
+	Slug      string    `json:"slug"`
+	ShortURL  string    `json:"short_url"`
+	Target    string    `json:"target"`
+	EditToken string    `json:"edit_token,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func ConstructLinkResponse(link *model.Link, token string) *LinkResponse {
+	return &LinkResponse{
+		ID:        link.ID,
+		Slug:      link.Slug,
+		ShortURL:  shortURL(link.Slug),
+		Target:    link.Target,
+		EditToken: token,
+		CreatedAt: link.CreatedAt,
+		UpdatedAt: link.UpdatedAt,
+	}
+}
+
+func (r *LinkResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// Retarget is the body of a call to "PATCH /qr/{id}"
+type Retarget struct {
+	Target string `json:"target"`
+}
+
+// shortURL returns the URL a dynamic code with the slug passed in encodes
+func shortURL(slug string) string {
+	return S.Cfg.ConstructPublicURL() + "/r/" + slug
+}
+
+// createLink creates the short link of a dynamic code, pointing at target
+func createLink(ctx context.Context, id, target string) (*LinkResponse, error) {
+	link, token, err := auth.NewLinkDirector(ctx).Create(id, target)
+	if err != nil {
+		return nil, err
+	}
+	return ConstructLinkResponse(link, token), nil
+}
+
+// dropLink deletes the link of a dynamic code the request creating it failed to hand out, so its slug isn't left
+// live with an edit token nobody has
+func dropLink(link *LinkResponse) {
+	log := S.Log.With().Str("method", "dropLink()").Logger()
+	ctx, cancelFunc := linkContext()
+	defer cancelFunc()
+	if err := auth.NewLinkDirector(ctx).Delete(link.ID); err != nil {
+		log.Error().Msgf("deleting link %v of a failed request failed with %v", link.ID, err)
+	}
+}
+
+// linksAvailable checks that dynamic codes can be served, responding with 503 when they can't
+func linksAvailable(resp http.ResponseWriter) bool {
+	if S.DB == nil {
+		http.Error(resp, "dynamic codes are not available", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+func linkContext() (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(context.Background(), util.LoggerInContext, S.Log)
+	ctx = context.WithValue(ctx, util.DBInContext, S.DB)
+	return context.WithTimeout(ctx, 10*time.Second)
+}
+
+// redirectLink handles calls to "/r/{slug}". It redirects the scanner of a dynamic code to the current target of
+// its link.
+func redirectLink(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "redirectLink()").Logger()
+	if !linksAvailable(resp) {
+		return
+	}
+	ctx, cancelFunc := linkContext()
+	defer cancelFunc()
+
+	slug := mux.Vars(req)["slug"]
+	link, err := auth.NewLinkDirector(ctx).Resolve(slug)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.NotFound(resp, req)
+		return
+	case err != nil:
+		log.Error().Msgf("resolving slug %v failed with %v", slug, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	recordScan(req, link)
+
+	// targets can change, so neither the client nor intermediaries may hold on to the redirect
+	resp.Header().Set("Cache-Control", "no-store")
+	http.Redirect(resp, req, link.Target, http.StatusFound)
+}
+
+// retargetLink handles calls to "PATCH /qr/{id}". It points the link of a dynamic code at a new target, if the
+// request carries the edit token of the link as a bearer token.
+func retargetLink(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "retargetLink()").Logger()
+	if !linksAvailable(resp) {
+		return
+	}
+
+	token, ok := bearerToken(req)
+	if !ok {
+		resp.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(resp, "request must carry the edit token of the code as a bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	if req.Header.Get("Content-Type") != "" {
+		value, _ := header.ParseValueAndParams(req.Header, "Content-Type")
+		if value != MimeJSON {
+			http.Error(resp, "Content-Type header is not application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+	req.Body = http.MaxBytesReader(resp, req.Body, 1<<16)
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	body := &Retarget{}
+	if err := dec.Decode(body); err != nil {
+		log.Info().Msgf("umarshaling request into json failed with: %v", err)
+		writeDecodeError(resp, err, &log)
+		return
+	}
+	if err := validateAbsoluteURL("target", body.Target); err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancelFunc := linkContext()
+	defer cancelFunc()
+	id := mux.Vars(req)["id"]
+	link, err := auth.NewLinkDirector(ctx).Retarget(id, token, body.Target)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("code %v not found", id), http.StatusNotFound)
+		return
+	case errors.Is(err, auth.ErrInvalidToken):
+		http.Error(resp, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, model.ErrConflict):
+		http.Error(resp, fmt.Sprintf("code %v was retargeted concurrently, retry", id), http.StatusConflict)
+		return
+	case err != nil:
+		log.Error().Msgf("retargeting code %v failed with %v", id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	linkResponse, _ := ConstructLinkResponse(link, "").MarshalJson()
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set(HeaderRequestID, uuid.NewString())
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(linkResponse)
+	log.Info().Msgf("code %v now redirects to %v", id, link.Target)
+}
+
+// bearerToken returns the bearer token in the Authorization header of the request
+func bearerToken(req *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
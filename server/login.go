@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/util"
+	"github.com/golang/gddo/httputil/header"
+	"github.com/rs/zerolog"
+	"math"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"time"
+)
+
+var _ auth.Authentication = (*Service)(nil)
+
+// LoginRequest is the body of a call to "/login". Code is the second factor of users with two-factor
+// authentication: a code of their authenticator app, or a recovery code.
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Code     string `json:"code,omitempty"`
+}
+
+// ForgotRequest is the body of a call to "/password/forgot"
+type ForgotRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetRequest is the body of a call to "/password/reset"
+type ResetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Authenticate returns the user the email and password, and second factor, belong to
+func (s *Service) Authenticate(ctx context.Context, email, password, code string) (*model.User, error) {
+	ctx = context.WithValue(ctx, util.LoggerInContext, s.Log)
+	ctx = context.WithValue(ctx, util.DBInContext, s.DB)
+	return auth.NewUserDirector(ctx).Login(email, password, code)
+}
+
+// decodeJSON decodes the JSON body of a request into v, responding with what is wrong with it when it can't
+func decodeJSON(resp http.ResponseWriter, req *http.Request, v interface{}, log *zerolog.Logger) bool {
+	if req.Header.Get("Content-Type") != "" {
+		value, _ := header.ParseValueAndParams(req.Header, "Content-Type")
+		if value != MimeJSON {
+			http.Error(resp, "Content-Type header is not application/json", http.StatusUnsupportedMediaType)
+			return false
+		}
+	}
+	req.Body = http.MaxBytesReader(resp, req.Body, 1<<16)
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		log.Info().Msgf("umarshaling request into json failed with: %v", err)
+		writeDecodeError(resp, err, log)
+		return false
+	}
+	return true
+}
+
+// login handles calls to "/login". It checks the email and password of a user, and responds with the user, an access
+// token and the refresh token starting a new session. Users with two-factor authentication logging in without a code
+// get 401 with the X-Second-Factor header, and log in again with it. Users failing too many logins in a row, wrong
+// codes included, are locked out for a while, and get 429 with a Retry-After until then.
+func login(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "login()").Logger()
+	body := &LoginRequest{}
+	if !decodeJSON(resp, req, body, &log) {
+		return
+	}
+	if body.Email == "" || body.Password == "" {
+		http.Error(resp, "request must carry an email and a password", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancelFunc := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancelFunc()
+	user, err := S.Authenticate(ctx, body.Email, body.Password, body.Code)
+	var locked *auth.LockedError
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials), errors.Is(err, auth.ErrInvalidSecondFactor):
+		http.Error(resp, err.Error(), http.StatusUnauthorized)
+		return
+	case errors.Is(err, auth.ErrSecondFactorRequired):
+		resp.Header().Set(HeaderSecondFactor, "required")
+		http.Error(resp, err.Error(), http.StatusUnauthorized)
+		return
+	case errors.As(err, &locked):
+		resp.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(locked.Until).Seconds()))))
+		http.Error(resp, err.Error(), http.StatusTooManyRequests)
+		return
+	case err != nil:
+		log.Error().Msgf("logging in failed with %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	sessionCtx, cancelSession := userContext()
+	defer cancelSession()
+	refresh, expires, err := auth.NewUserDirector(sessionCtx).StartSession(user.ID)
+	if err == nil {
+		err = writeTokens(resp, user, refresh, expires)
+	}
+	if err != nil {
+		log.Error().Msgf("issuing tokens failed with %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	log.Info().Msgf("user %v logged in", user.ID)
+}
+
+// forgotPassword handles calls to "/password/forgot". It issues a password reset token to the user with the email
+// passed in. It responds with 202 whether there is such a user or not, so it can't be used to find out.
+func forgotPassword(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "forgotPassword()").Logger()
+	body := &ForgotRequest{}
+	if !decodeJSON(resp, req, body, &log) {
+		return
+	}
+	if err := validateEmail(body.Email); err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	err := auth.NewUserDirector(ctx).RequestReset(body.Email, S.Resets)
+	if err != nil && !errors.Is(err, model.ErrNotFound) {
+		log.Error().Msgf("issuing password reset token failed with %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	resp.WriteHeader(http.StatusAccepted)
+}
+
+// resetPassword handles calls to "/password/reset". It sets the password of the user a reset token was issued to.
+func resetPassword(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "resetPassword()").Logger()
+	body := &ResetRequest{}
+	if !decodeJSON(resp, req, body, &log) {
+		return
+	}
+	if err := auth.ValidatePassword(body.Password); err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	user, err := auth.NewUserDirector(ctx).ResetPassword(body.Token, body.Password)
+	switch {
+	case errors.Is(err, auth.ErrInvalidResetToken):
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Error().Msgf("resetting password failed with %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+	log.Info().Msgf("password of user %v reset", user.ID)
+}
+
+// validateEmail checks that an email is a bare email address
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return fmt.Errorf("email must be an email address, like ada@example.com")
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/internal/generators/qr"
+	"github.com/google/uuid"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+var (
+	// MaxLogoBytes limits the size of the logos "/logos" takes
+	MaxLogoBytes int64 = 2 << 20
+)
+
+// LogoResponse is the structured JSON response of a successful call to "/logos". Styled codes reference the logo
+// by its ID.
+type LogoResponse struct {
+	Response
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+func (r *LogoResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// uploadLogo handles calls to "/logos". It saves an uploaded PNG or JPEG logo, sent either as the raw request body
+// or in the "file" field of a multipart form, so styled codes can reference it by ID.
+func uploadLogo(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "uploadLogo()").Logger()
+	req.Body = http.MaxBytesReader(resp, req.Body, MaxLogoBytes)
+
+	var data []byte
+	upload, err := decodeUpload(req)
+	if err == nil {
+		defer upload.Close()
+		data, err = io.ReadAll(upload)
+	}
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.Is(err, errUnsupportedDecodeType):
+			http.Error(resp, err.Error(), http.StatusUnsupportedMediaType)
+		case errors.As(err, &maxBytesError):
+			http.Error(resp, fmt.Sprintf("Request body must not be larger than %d bytes", MaxLogoBytes), http.StatusRequestEntityTooLarge)
+		default:
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	img, err := qr.ParseLogo(data)
+	if err != nil {
+		log.Info().Msgf("parsing logo failed with: %v", err)
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// logos are saved as PNG whatever they were uploaded as, so they keep their transparency once drawn
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		log.Error().Msgf("encoding logo failed with: %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	id := uuid.NewString()
+	obj, err := S.Store.Put(req.Context(), qr.LogoKey(id), qr.FormatPNG.MIME(), b.Bytes())
+	if err != nil {
+		log.Error().Msgf("saving logo failed with: %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	logoResponse, _ := (&LogoResponse{
+		Response: *ConstructResponse(id, fmt.Sprintf("saved logo %v", id)),
+		ID:       id,
+		URL:      S.Store.URL(obj.Key),
+		Width:    img.Bounds().Dx(),
+		Height:   img.Bounds().Dy(),
+	}).MarshalJson()
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set("Location", "/files/"+obj.Key)
+	resp.Header().Set(HeaderRequestID, id)
+	resp.WriteHeader(http.StatusCreated)
+	_, _ = resp.Write(logoResponse)
+	log.Info().Msgf("saved logo %v", id)
+}
+
+// loadStyle loads the logo a style references, responding with 400 when the style can't be drawn
+func loadStyle(resp http.ResponseWriter, ctx context.Context, style *qr.Style) bool {
+	err := style.Load(ctx)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, qr.ErrLogoNotFound), errors.Is(err, qr.ErrInvalidLogo), errors.Is(err, qr.ErrInvalidStyle), errors.Is(err, qr.ErrLowContrast):
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+	default:
+		S.Log.Error().Str("method", "loadStyle()").Msgf("loading style failed with: %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+	return false
+}
@@ -3,21 +3,23 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/db/model"
 	"github.com/dark-enstein/port/util"
 	"github.com/golang/gddo/httputil/header"
-	"github.com/google/uuid"
 	"net/http"
 	"time"
 )
 
-// registerUser handles calls to the "/register". It validates requests and creates a user on Port.
+// registerUser handles calls to "/register" and "POST /users". It validates requests and creates a user on Port,
+// responding with the user and its location.
 func registerUser(resp http.ResponseWriter, req *http.Request) {
 	log := S.Log.With().Str("method", "registerUser()").Logger()
 	ctx := context.WithValue(context.Background(), util.LoggerInContext, S.Log)
 	ctx = context.WithValue(ctx, util.DBInContext, S.DB)
-	log.Debug().Msg("received a call on /register, the registerUser handler is picking it up")
+	log.Debug().Msgf("received a call on %v, the registerUser handler is picking it up", req.URL.Path)
 
 	// if Content-Type header doesn't have its value as "application/json", then return invalid
 	// application type
@@ -43,80 +45,24 @@ func registerUser(resp http.ResponseWriter, req *http.Request) {
 	log.Debug().Msg("initiating data validation")
 	user, isValid := createUserValidate(dec, resp)
 	if !isValid {
-		resp.WriteHeader(http.StatusBadRequest)
+		// createUserValidate has responded with what is wrong with the data
 		log.Debug().Msg("error with data passed in")
-		_, err := resp.Write([]byte("data passed in failed validation"))
-		if err != nil {
-			log.Debug().Err(fmt.Errorf("error while writing http response %w", err))
-			_, err := resp.Write([]byte(fmt.Sprint("server error")))
-			if err != nil {
-				log.Debug().Err(fmt.Errorf("error while writing http response %w", err))
-				return
-			}
-			return
-		}
 		return
 	}
 
-	reqId, err := uuid.NewUUID()
-	if err != nil {
-		resp.WriteHeader(http.StatusInternalServerError)
-		log.Debug().Msgf("generating UUID failed with error: %v", err)
-		_, err := resp.Write([]byte(fmt.Sprint("server error")))
-		if err != nil {
-			log.Debug().Err(fmt.Errorf("error while writing http response %w", err))
-			return
-		}
-	}
-
 	// from here on out copy data into internals
-	director := auth.NewUserDirector(ctx)
-	log.Debug().Msg("setting up director")
-
-	tx := director.CreateUsers([]auth.InternalUser{*user})
-	log.Debug().Msg("tx: ready to execute db call. locking..")
-	director.Mutex.Lock()
-	ids, err := tx()
-	if err != nil {
-		log.Debug().Err(fmt.Errorf("error while executing db call: %w", err))
-		resp.WriteHeader(http.StatusInternalServerError)
-		_, err := resp.Write([]byte(fmt.Sprint("server error")))
-		if err != nil {
-			log.Debug().Err(fmt.Errorf("error while writing http response %w", err))
-			return
-		}
+	created, err := auth.NewUserDirector(ctx).CreateUser(*user)
+	if errors.Is(err, model.ErrDuplicate) {
+		http.Error(resp, fmt.Sprintf("a user with email %v is already registered", user.Email()), http.StatusConflict)
 		return
 	}
-	log.Debug().Msg("executed db call. unlocking...")
-	director.Mutex.Unlock()
-
-	// build http response
-	respBytes, err := ConstructResponse(reqId.String(), fmt.Sprintf("user created with ids: %v", ids)).MarshalJson()
-	log.Debug().Msg("contents of response to client " + string(respBytes))
-	if len(respBytes) < 1 || err != nil {
-		resp.WriteHeader(http.StatusInternalServerError)
-		log.Debug().Msg("error with marshalling json into struct")
-		_, err := resp.Write([]byte("server error"))
-		if err != nil {
-			return
-		}
-		return
-	}
-
-	resp.WriteHeader(http.StatusOK)
-	_, err = resp.Write(respBytes)
-	//_, err = resp.Write([]byte(samp))
 	if err != nil {
-		log.Debug().Err(fmt.Errorf("failed to marshal api response into response: %w", err))
-		resp.WriteHeader(http.StatusInternalServerError)
-		_, err := resp.Write([]byte(fmt.Sprint("server error")))
-		if err != nil {
-			log.Debug().Err(fmt.Errorf("error while writing http response %w", err))
-			return
-		}
+		log.Error().Msgf("creating user failed with %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	log.Info().Msgf("user created with id: %s", ids)
-	return
+	resp.Header().Set("Location", "/users/"+created.ID)
+	writeUser(resp, created, http.StatusCreated)
+	log.Info().Msgf("user created with id: %s", created.ID)
 }
@@ -0,0 +1,542 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// rule is the permission a route needs. Self routes also let users act on themselves: on the user whose id is the
+// "id" of the route. Scope is the narrower API key scope also covering the route, like "generate:{type}", with the
+// variables of the route in braces.
+type rule struct {
+	need     auth.Requirement
+	self     bool
+	selfOnly bool
+	scope    string
+}
+
+// require marks a route as needing the permission passed in
+func (s *Service) require(route *mux.Route, need auth.Requirement) {
+	s.setRule(route, rule{need: need})
+}
+
+// requireOrSelf marks a route on a user as needing the permission passed in, unless the caller is that user
+func (s *Service) requireOrSelf(route *mux.Route, need auth.Requirement) {
+	s.setRule(route, rule{need: need, self: true})
+}
+
+// self marks a route on a user as only served to that user, logged in: neither other users, whatever their roles,
+// nor API keys can call it
+func (s *Service) self(route *mux.Route) {
+	s.setRule(route, rule{selfOnly: true})
+}
+
+// requireScoped marks a route as needing the permission passed in, and lets API keys call it with the narrower scope
+// passed in too
+func (s *Service) requireScoped(route *mux.Route, need auth.Requirement, scope string) {
+	s.setRule(route, rule{need: need, scope: scope})
+}
+
+func (s *Service) setRule(route *mux.Route, r rule) {
+	if s.rules == nil {
+		s.rules = map[*mux.Route]rule{}
+	}
+	s.rules[route] = r
+}
+
+// scopeOf returns the narrower scope of a rule, with the variables of the request in place
+func (r rule) scopeOf(req *http.Request) string {
+	scope := r.scope
+	for k, v := range mux.Vars(req) {
+		scope = strings.ReplaceAll(scope, "{"+k+"}", v)
+	}
+	return scope
+}
+
+// authorize is the middleware checking that the caller of a route holds the permission the route needs, with the
+// roles it holds now. Calls made with an API key also need a scope of the key covering the route. It responds with
+// 403 and the missing permission or scope when the caller doesn't, and with 401 when the caller no longer exists.
+// Routes only served to their user are refused to everyone else with 403. It runs after authenticate: routes without
+// a rule aren't checked, and routes with one are refused with 401 to calls authenticate let through without a token,
+// like calls to a path the config exempts.
+func authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		r, ok := S.rules[mux.CurrentRoute(req)]
+		if !ok {
+			next.ServeHTTP(resp, req)
+			return
+		}
+		claims, authenticated := RequestClaims(req.Context())
+		if !authenticated {
+			resp.Header().Set("WWW-Authenticate", `Bearer realm="port"`)
+			http.Error(resp, "request must carry an access token, as Authorization: Bearer <token>, or an API key", http.StatusUnauthorized)
+			return
+		}
+		key, isKey := RequestKey(req.Context())
+		if r.selfOnly && (isKey || mux.Vars(req)["id"] != claims.Subject) {
+			http.Error(resp, "only the user can call this route, with an access token", http.StatusForbidden)
+			return
+		}
+		if isKey && !auth.ScopesAllow(key.Scopes, r.need, r.scopeOf(req)) {
+			http.Error(resp, fmt.Sprintf("api key lacks scope %v", r.need), http.StatusForbidden)
+			return
+		}
+
+		log := S.Log.With().Str("method", "authorize()").Logger()
+		ctx, cancelFunc := userContext()
+		defer cancelFunc()
+		user, err := auth.NewUserDirector(ctx).Get(claims.Subject)
+		switch {
+		case errors.Is(err, model.ErrNotFound):
+			http.Error(resp, "user of the access token no longer exists", http.StatusUnauthorized)
+			return
+		case err != nil:
+			log.Error().Msgf("reading user %v failed with %v", claims.Subject, err)
+			http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if r.selfOnly || (r.self && mux.Vars(req)["id"] == claims.Subject) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+		roles := auth.DecodeRoleSet(user.Roles)
+		effective := roles.Permissions()
+		if !effective.Allows(r.need) {
+			log.Info().Msgf("user %v lacks permission %v for %v %v", user.ID, r.need, req.Method, req.URL.Path)
+			http.Error(resp, fmt.Sprintf("missing permission %v", r.need), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// callerAllows checks that the caller of a request holds the permission passed in, with the roles it holds now, for
+// handlers whose checks depend on what they read. Calls made with an API key also need a scope of the key covering
+// the permission.
+func callerAllows(req *http.Request, need auth.Requirement) (bool, error) {
+	claims, ok := RequestClaims(req.Context())
+	if !ok {
+		return false, nil
+	}
+	if key, ok := RequestKey(req.Context()); ok && !auth.ScopesAllow(key.Scopes, need, "") {
+		return false, nil
+	}
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	user, err := auth.NewUserDirector(ctx).Get(claims.Subject)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	roles := auth.DecodeRoleSet(user.Roles)
+	effective := roles.Permissions()
+	return effective.Allows(need), nil
+}
+
+// SetupRoles creates the built in roles that don't exist yet, and grants the Administrator role to the users with
+// the emails passed in. Users that haven't registered yet are skipped, and granted it on the next startup.
+func SetupRoles(admins []string) error {
+	log := S.Log.With().Str("method", "SetupRoles()").Logger()
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	roles := auth.NewRoleDirector(ctx)
+	if err := roles.EnsureBuiltins(); err != nil {
+		return fmt.Errorf("creating built in roles: %w", err)
+	}
+	if len(admins) == 0 {
+		return nil
+	}
+	admin, err := roles.Get(auth.Administrator.Name)
+	if err != nil {
+		return err
+	}
+	users := auth.NewUserDirector(ctx)
+	for _, email := range admins {
+		user, err := users.GetByEmail(email)
+		if errors.Is(err, model.ErrNotFound) {
+			log.Warn().Msgf("no user has email %v yet: it is granted %v once it registers, and port restarts", email, admin.Name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := users.GrantRole(user.ID, *admin); err != nil {
+			return fmt.Errorf("granting %v to %v: %w", admin.Name, email, err)
+		}
+		log.Info().Msgf("granted %v to user %v", admin.Name, user.ID)
+	}
+	return nil
+}
+
+// PermissionBody is a permission a role grants on a resource, as the role endpoints read and write it
+type PermissionBody struct {
+	Resource string `json:"resource"`
+	Create   bool   `json:"create"`
+	Read     bool   `json:"read"`
+	Update   bool   `json:"update"`
+	Delete   bool   `json:"delete"`
+}
+
+// RoleRequest is the body of a call to "POST /roles"
+type RoleRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Permissions []*PermissionBody `json:"permissions"`
+}
+
+// RolePatch is the body of a call to "PATCH /roles/{name}". Only the fields passed in are changed, and permissions
+// passed in replace every permission of the role.
+type RolePatch struct {
+	Description *string           `json:"description"`
+	Permissions []*PermissionBody `json:"permissions"`
+}
+
+// RoleResponse describes a role of Port
+type RoleResponse struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Permissions []*PermissionBody `json:"permissions"`
+	Builtin     bool              `json:"builtin"`
+	Version     int64             `json:"version"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+func (r *RoleResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// RolesResponse lists every role of Port
+type RolesResponse struct {
+	Roles []*RoleResponse `json:"roles"`
+}
+
+func (r *RolesResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// HeldRole is a role a user holds, with the permissions it was granted with
+type HeldRole struct {
+	Name        string            `json:"name"`
+	Permissions []*PermissionBody `json:"permissions"`
+}
+
+// PermissionsResponse is the response to "GET /users/{id}/permissions". Permissions are the effective permissions of
+// the user: every permission any of its roles grants.
+type PermissionsResponse struct {
+	UserID      string            `json:"user_id"`
+	Roles       []*HeldRole       `json:"roles"`
+	Permissions []*PermissionBody `json:"permissions"`
+}
+
+func (r *PermissionsResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+func constructPermissions(ps auth.PermissionSet) []*PermissionBody {
+	bodies := make([]*PermissionBody, len(ps))
+	for i, p := range ps {
+		bodies[i] = &PermissionBody{Resource: p.Name, Create: p.Create, Read: p.Read, Update: p.Update, Delete: p.Delete}
+	}
+	return bodies
+}
+
+// permissionSet reads the permissions of a request into a PermissionSet, rejecting resources listed twice
+func permissionSet(bodies []*PermissionBody) (auth.PermissionSet, error) {
+	ps := auth.PermissionSet{}
+	seen := map[string]bool{}
+	for _, b := range bodies {
+		if b == nil {
+			continue
+		}
+		if seen[b.Resource] {
+			return nil, fmt.Errorf("permissions list resource %q more than once", b.Resource)
+		}
+		seen[b.Resource] = true
+		ps = append(ps, auth.Permission{Name: b.Resource, Create: b.Create, Read: b.Read, Update: b.Update, Delete: b.Delete})
+	}
+	return ps, nil
+}
+
+func ConstructRoleResponse(m *model.Role) *RoleResponse {
+	return &RoleResponse{
+		Name:        m.ID,
+		Description: m.Description,
+		Permissions: constructPermissions(auth.RoleFromModel(m).User),
+		Builtin:     m.Builtin,
+		Version:     m.Version,
+		CreatedAt:   m.CreatedAt,
+		UpdatedAt:   m.UpdatedAt,
+	}
+}
+
+// writeRole writes the role as the JSON body of a response with the status passed in
+func writeRole(resp http.ResponseWriter, m *model.Role, status int) {
+	roleResponse, _ := ConstructRoleResponse(m).MarshalJson()
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set(HeaderRequestID, uuid.NewString())
+	resp.WriteHeader(status)
+	_, _ = resp.Write(roleResponse)
+}
+
+// listRoles handles calls to "GET /roles". It lists every role, ordered by name.
+func listRoles(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "listRoles()").Logger()
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	roles, err := auth.NewRoleDirector(ctx).List()
+	if err != nil {
+		log.Error().Msgf("listing roles failed with %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	list := &RolesResponse{Roles: make([]*RoleResponse, len(roles))}
+	for i, m := range roles {
+		list.Roles[i] = ConstructRoleResponse(m)
+	}
+	rolesResponse, _ := list.MarshalJson()
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set(HeaderRequestID, uuid.NewString())
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(rolesResponse)
+}
+
+// getRole handles calls to "GET /roles/{name}"
+func getRole(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "getRole()").Logger()
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+
+	name := mux.Vars(req)["name"]
+	m, err := auth.NewRoleDirector(ctx).Read(name)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("role %v not found", name), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Error().Msgf("reading role %v failed with %v", name, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeRole(resp, m, http.StatusOK)
+}
+
+// createRole handles calls to "POST /roles". It creates a role granting the permissions passed in, which can then be
+// granted to users.
+func createRole(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "createRole()").Logger()
+	body := &RoleRequest{}
+	if !decodeJSON(resp, req, body, &log) {
+		return
+	}
+	ps, err := permissionSet(body.Permissions)
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	role := auth.Role{Name: body.Name, User: ps}
+	if err := auth.ValidateRole(role); err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	m, err := auth.NewRoleDirector(ctx).Create(role, body.Description)
+	switch {
+	case errors.Is(err, model.ErrDuplicate):
+		http.Error(resp, fmt.Sprintf("role %v already exists", role.Name), http.StatusConflict)
+		return
+	case err != nil:
+		log.Error().Msgf("creating role %v failed with %v", role.Name, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	audit(ctx, req, auth.AuditRoleCreate, auth.AuditRole(m.ID), map[string]interface{}{"permissions": constructPermissions(role.User)})
+	resp.Header().Set("Location", "/roles/"+m.ID)
+	writeRole(resp, m, http.StatusCreated)
+	log.Info().Msgf("role %v created", m.ID)
+}
+
+// updateRole handles calls to "PATCH /roles/{name}". It changes the description and permissions of a role, and
+// brings the permissions of the users holding it up to date.
+func updateRole(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "updateRole()").Logger()
+	body := &RolePatch{}
+	if !decodeJSON(resp, req, body, &log) {
+		return
+	}
+
+	name := mux.Vars(req)["name"]
+	fields, detail := model.Fields{}, map[string]interface{}{}
+	if body.Description != nil {
+		fields["description"] = *body.Description
+		detail["description"] = *body.Description
+	}
+	if body.Permissions != nil {
+		ps, err := permissionSet(body.Permissions)
+		if err == nil {
+			err = auth.ValidateRole(auth.Role{Name: name, User: ps})
+		}
+		if err != nil {
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fields["permissions"] = ps.ToBinary()
+		detail["permissions"] = constructPermissions(ps)
+	}
+	if len(fields) == 0 {
+		http.Error(resp, "request must change the description or the permissions of the role", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	m, updated, err := auth.NewRoleDirector(ctx).Update(name, fields)
+	switch {
+	case errors.Is(err, model.ErrNotFound) && m == nil:
+		http.Error(resp, fmt.Sprintf("role %v not found", name), http.StatusNotFound)
+		return
+	case errors.Is(err, model.ErrConflict) && m == nil:
+		http.Error(resp, fmt.Sprintf("role %v was updated concurrently, retry", name), http.StatusConflict)
+		return
+	case err != nil:
+		log.Error().Msgf("updating role %v failed with %v", name, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	detail["users_updated"] = updated
+	audit(ctx, req, auth.AuditRoleUpdate, auth.AuditRole(name), detail)
+	writeRole(resp, m, http.StatusOK)
+	log.Info().Msgf("role %v updated, and %d users holding it", name, updated)
+}
+
+// deleteRole handles calls to "DELETE /roles/{name}". It revokes the role from the users holding it, and deletes it.
+// Built in roles can't be deleted.
+func deleteRole(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "deleteRole()").Logger()
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+
+	name := mux.Vars(req)["name"]
+	revoked, err := auth.NewRoleDirector(ctx).Delete(name)
+	switch {
+	case errors.Is(err, model.ErrNotFound) && revoked == 0:
+		http.Error(resp, fmt.Sprintf("role %v not found", name), http.StatusNotFound)
+		return
+	case errors.Is(err, auth.ErrBuiltinRole):
+		http.Error(resp, fmt.Sprintf("role %v is built in, and can't be deleted", name), http.StatusConflict)
+		return
+	case err != nil:
+		log.Error().Msgf("deleting role %v failed with %v", name, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	audit(ctx, req, auth.AuditRoleDelete, auth.AuditRole(name), map[string]interface{}{"users_revoked": revoked})
+	resp.WriteHeader(http.StatusNoContent)
+	log.Info().Msgf("role %v deleted, and revoked from %d users", name, revoked)
+}
+
+// grantRole handles calls to "PUT /users/{id}/roles/{name}". It grants the role to the user, with the permissions
+// the role grants now.
+func grantRole(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "grantRole()").Logger()
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+
+	id, name := mux.Vars(req)["id"], mux.Vars(req)["name"]
+	role, err := auth.NewRoleDirector(ctx).Get(name)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("role %v not found", name), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Error().Msgf("reading role %v failed with %v", name, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	user, err := auth.NewUserDirector(ctx).GrantRole(id, *role)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("user %v not found", id), http.StatusNotFound)
+		return
+	case errors.Is(err, model.ErrConflict):
+		http.Error(resp, fmt.Sprintf("user %v was updated concurrently, retry", id), http.StatusConflict)
+		return
+	case err != nil:
+		log.Error().Msgf("granting role %v to user %v failed with %v", name, id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	audit(ctx, req, auth.AuditRoleGrant, auth.AuditUser(id), map[string]interface{}{"role": name})
+	writeUser(resp, user, http.StatusOK)
+	log.Info().Msgf("role %v granted to user %v", name, id)
+}
+
+// revokeRole handles calls to "DELETE /users/{id}/roles/{name}"
+func revokeRole(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "revokeRole()").Logger()
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+
+	id, name := mux.Vars(req)["id"], mux.Vars(req)["name"]
+	user, err := auth.NewUserDirector(ctx).RevokeRole(id, name)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("user %v not found", id), http.StatusNotFound)
+		return
+	case errors.Is(err, auth.ErrRoleNotHeld):
+		http.Error(resp, fmt.Sprintf("user %v doesn't hold role %v", id, name), http.StatusNotFound)
+		return
+	case errors.Is(err, model.ErrConflict):
+		http.Error(resp, fmt.Sprintf("user %v was updated concurrently, retry", id), http.StatusConflict)
+		return
+	case err != nil:
+		log.Error().Msgf("revoking role %v from user %v failed with %v", name, id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	audit(ctx, req, auth.AuditRoleRevoke, auth.AuditUser(id), map[string]interface{}{"role": name})
+	writeUser(resp, user, http.StatusOK)
+	log.Info().Msgf("role %v revoked from user %v", name, id)
+}
+
+// userPermissions handles calls to "GET /users/{id}/permissions". It lists the roles a user holds, with the
+// permissions each was granted with, and the effective permissions they merge into.
+func userPermissions(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "userPermissions()").Logger()
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+
+	id := mux.Vars(req)["id"]
+	user, err := auth.NewUserDirector(ctx).Get(id)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("user %v not found", id), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Error().Msgf("reading user %v failed with %v", id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	roles := auth.DecodeRoleSet(user.Roles)
+	page := &PermissionsResponse{UserID: user.ID, Roles: make([]*HeldRole, len(roles)), Permissions: constructPermissions(roles.Permissions())}
+	for i, r := range roles {
+		page.Roles[i] = &HeldRole{Name: r.Name, Permissions: constructPermissions(r.User)}
+	}
+	permissionsResponse, _ := page.MarshalJson()
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set(HeaderRequestID, uuid.NewString())
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(permissionsResponse)
+}
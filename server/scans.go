@@ -0,0 +1,275 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/util"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ScanBufferDepth is the number of batches of scans that can be waiting for a flush before new scans are dropped
+	ScanBufferDepth = 4
+
+	scansTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "port",
+		Name:      "qr_scans_total",
+		Help:      "Redirects served through the short links of dynamic QR codes, by user agent class.",
+	}, []string{"agent"})
+	scansDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "port",
+		Name:      "qr_scans_dropped_total",
+		Help:      "Scans that weren't recorded because the scan buffer was full or its flush failed.",
+	})
+	scanFlushes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "port",
+		Name:      "qr_scan_flushes_total",
+		Help:      "Flushes of buffered scans to the DB, by outcome.",
+	}, []string{"outcome"})
+)
+
+// ScanRecorder buffers the scans of dynamic QR codes, and flushes them to the DB in batches, so recording a scan
+// never holds up a redirect
+type ScanRecorder struct {
+	sync.Mutex
+	scans     chan *model.Scan
+	batchSize int
+	interval  time.Duration
+	closed    bool
+	done      chan struct{}
+}
+
+func NewScanRecorder(batchSize int, interval time.Duration) *ScanRecorder {
+	r := &ScanRecorder{
+		scans:     make(chan *model.Scan, batchSize*ScanBufferDepth),
+		batchSize: batchSize,
+		interval:  interval,
+		done:      make(chan struct{}),
+	}
+	go r.flushLoop()
+	return r
+}
+
+// Record buffers a scan. It doesn't block: the scan is dropped when the buffer is full.
+func (r *ScanRecorder) Record(scan *model.Scan) {
+	scansTotal.WithLabelValues(scan.Agent).Inc()
+	r.Lock()
+	defer r.Unlock()
+	if r.closed {
+		scansDropped.Inc()
+		return
+	}
+	select {
+	case r.scans <- scan:
+	default:
+		scansDropped.Inc()
+	}
+}
+
+// Stop stops taking scans and flushes the ones buffered, or gives up when ctx is done
+func (r *ScanRecorder) Stop(ctx context.Context) error {
+	r.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.scans)
+	}
+	r.Unlock()
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("scans didn't flush in time: %w", ctx.Err())
+	}
+}
+
+// flushLoop flushes the buffered scans whenever a batch fills up, or the flush interval passes
+func (r *ScanRecorder) flushLoop() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	batch := make([]*model.Scan, 0, r.batchSize)
+	for {
+		select {
+		case scan, ok := <-r.scans:
+			if !ok {
+				r.flush(batch)
+				return
+			}
+			batch = append(batch, scan)
+			if len(batch) >= r.batchSize {
+				r.flush(batch)
+				batch = make([]*model.Scan, 0, r.batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				r.flush(batch)
+				batch = make([]*model.Scan, 0, r.batchSize)
+			}
+		}
+	}
+}
+
+func (r *ScanRecorder) flush(batch []*model.Scan) {
+	if len(batch) == 0 {
+		return
+	}
+	log := S.Log.With().Str("method", "ScanRecorder.flush()").Logger()
+	ctx, cancelFunc := linkContext()
+	defer cancelFunc()
+	if err := auth.NewScanDirector(ctx).Record(batch); err != nil {
+		log.Error().Msgf("flushing scans failed with: %v", err)
+		scanFlushes.WithLabelValues("error").Inc()
+		scansDropped.Add(float64(len(batch)))
+		return
+	}
+	scanFlushes.WithLabelValues("ok").Inc()
+	log.Debug().Msgf("flushed %d scans", len(batch))
+}
+
+// recordScan records the scan of a link the request was redirected through
+func recordScan(req *http.Request, link *model.Link) {
+	if S.Scans == nil {
+		return
+	}
+	S.Scans.Record(model.NewScan(uuid.NewString(), link.ID, auth.ClassifyAgent(req.UserAgent()), req.Referer(), auth.HashIP(link.ID, clientIP(req))))
+}
+
+// clientIP returns the address of the client of the request. The X-Forwarded-For header is only read off requests
+// from trusted proxies: clients write whatever they like in it. The client is then the last address in it that isn't
+// a trusted proxy.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !trustedProxy(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+// trustedProxy checks if addr is the address of a trusted proxy
+func trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range S.Proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// statsWindow reads the window of scans a call to "/qr/{id}/stats" asks for, from its RFC 3339 from and to query
+// parameters. The window ends now and lasts auth.StatsWindow unless asked otherwise, and lasts auth.MaxStatsWindow at
+// most.
+func statsWindow(query url.Values) (time.Time, time.Time, error) {
+	parse := func(name string, fallback time.Time) (time.Time, error) {
+		raw := query.Get(name)
+		if raw == "" {
+			return fallback, nil
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%v %q is not an RFC 3339 time", name, raw)
+		}
+		return t, nil
+	}
+	to, err := parse("to", time.Now())
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	from, err := parse("from", to.Add(-auth.StatsWindow))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+	if to.Sub(from) > auth.MaxStatsWindow {
+		return time.Time{}, time.Time{}, fmt.Errorf("windows of scans last %v at most", auth.MaxStatsWindow)
+	}
+	return from, to, nil
+}
+
+// linkStats handles calls to "/qr/{id}/stats". It summarizes the scans of a dynamic code for the holder of its edit
+// token, bucketed by the hour or by the day, over the window the from and to query parameters ask for.
+func linkStats(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "linkStats()").Logger()
+	if !linksAvailable(resp) {
+		return
+	}
+	token, ok := bearerToken(req)
+	if !ok {
+		resp.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(resp, "request must carry the edit token of the code as a bearer token", http.StatusUnauthorized)
+		return
+	}
+	bucket := req.URL.Query().Get("bucket")
+	if bucket == "" {
+		bucket = auth.BucketDay
+	}
+	if !util.IsIn(bucket, auth.Buckets) {
+		http.Error(resp, fmt.Sprintf("bucket %q is not one of %v", bucket, auth.Buckets), http.StatusBadRequest)
+		return
+	}
+	from, to, err := statsWindow(req.URL.Query())
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancelFunc := linkContext()
+	defer cancelFunc()
+	id := mux.Vars(req)["id"]
+	_, err = auth.NewLinkDirector(ctx).Authorize(id, token)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("code %v not found", id), http.StatusNotFound)
+		return
+	case errors.Is(err, auth.ErrInvalidToken):
+		http.Error(resp, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		log.Error().Msgf("reading code %v failed with %v", id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	stats, err := auth.NewScanDirector(ctx).Stats(id, bucket, from, to)
+	if err != nil {
+		log.Error().Msgf("summarizing scans of code %v failed with %v", id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	statsResponse, _ := json.Marshal(stats)
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(statsResponse)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"github.com/dark-enstein/port/config"
+	"github.com/stretchr/testify/suite"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type ScansTest struct {
+	suite.Suite
+}
+
+func (s *ScansTest) SetupTest() {
+	S = &Service{Log: config.NewLoggerWithWarn()}
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	S.Proxies = []*net.IPNet{proxies}
+}
+
+// TestClientIP tests that X-Forwarded-For is only read off requests from trusted proxies, and that the client is the
+// last address in it that isn't one
+func (s *ScansTest) TestClientIP() {
+	cases := []struct {
+		remote, forwarded, client string
+	}{
+		{"203.0.113.7:4000", "", "203.0.113.7"},
+		{"203.0.113.7:4000", "198.51.100.1", "203.0.113.7"},
+		{"10.0.0.2:4000", "", "10.0.0.2"},
+		{"10.0.0.2:4000", "198.51.100.1", "198.51.100.1"},
+		{"10.0.0.2:4000", "1.2.3.4, 198.51.100.1, 10.0.0.3", "198.51.100.1"},
+		{"10.0.0.2:4000", "10.0.0.4, 10.0.0.3", "10.0.0.4"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/r/slug", nil)
+		req.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		s.Assert().Equal(c.client, clientIP(req), "%v forwarding %q", c.remote, c.forwarded)
+	}
+}
+
+func TestScans(t *testing.T) {
+	suite.Run(t, new(ScansTest))
+}
@@ -3,14 +3,19 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/dark-enstein/port/auth"
 	"github.com/dark-enstein/port/config"
 	"github.com/dark-enstein/port/db"
 	"github.com/dark-enstein/port/internal"
+	"github.com/dark-enstein/port/internal/cloud"
+	"github.com/dark-enstein/port/jwt"
+	"github.com/dark-enstein/port/ticket"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
+	"net"
 	"net/http"
 	"sync"
 	"time"
@@ -28,7 +33,7 @@ type Server interface {
 	auth.Authentication
 	internal.Internal
 	//PingDependencies(bool) error // TODO. Implement Ping dependencies
-	//ValidateJWT(string) error // TODO. Implement JWT. Validate JWT
+	ValidateJWT(string) error
 
 	//service.Service // TODO representing all the seperate services in port
 	IsLive() bool
@@ -39,6 +44,16 @@ var (
 	StartTime = "ServerStartTime"
 )
 
+const (
+	MimeJSON        = "application/json"
+	HeaderRequestID = "X-Request-ID"
+	HeaderChecksum  = "X-Checksum"
+	HeaderShortURL  = "X-Short-URL"
+	HeaderEditToken = "X-Edit-Token"
+	// HeaderSecondFactor tells clients that a login must carry a code of the authenticator app of the user
+	HeaderSecondFactor = "X-Second-Factor"
+)
+
 type Response struct {
 	ReqID string `json:"req_id"`
 	Time  string `json:"time"`
@@ -87,12 +102,28 @@ type Service struct {
 	Ctx   context.Context
 	ready bool
 
-	Srv http.Server
-	Cfg *config.Config
-	r   *mux.Router
-	DB  db.DB
+	Srv     http.Server
+	Cfg     *config.Config
+	r       *mux.Router
+	DB      db.DB
+	Store   cloud.Storage
+	Jobs    *JobQueue
+	Scans   *ScanRecorder
+	Cache   *auth.RenderCache
+	Tickets *ticket.Signer
+	// Resets hands password reset tokens to the users they were issued to
+	Resets auth.ResetSender
+	// Tokens issues and verifies the access tokens API calls are authenticated with
+	Tokens *jwt.Authority
+	// open holds the routes served without a token
+	open map[*mux.Route]bool
+	// rules holds the permissions routes need
+	rules map[*mux.Route]rule
+	// limits holds the calls left to each API key
+	limits keyLimiter
+	// Proxies holds the networks of the proxies trusted to name the clients of requests
+	Proxies []*net.IPNet
 
-	auth.Authentication
 	internal.Repository
 }
 
@@ -105,14 +136,55 @@ func (s *Service) IsLive() bool {
 	return true
 }
 
-// RegisterRoutes registers the servers routes binding it to the server handlers.
+// RegisterRoutes registers the servers routes binding it to the server handlers. Every route needs an access token
+// or an API key, except the paths the config exempts and the public routes: the ones a token is got from, the ones reached by
+// scanning codes, and the ones the edit token of a code authenticates. Routes needing a permission are only served
+// to callers whose roles grant it, and the routes of a user's authenticator app to that user alone.
 func (s *Service) RegisterRoutes() *Service {
 	s.r = mux.NewRouter()
+	s.r.Use(authenticate, authorize)
 	s.r.HandleFunc("/ping", ping).Methods(http.MethodGet)
-	s.r.HandleFunc("/register", registerUser).Methods(http.MethodPost)
-	s.r.HandleFunc("/generate/{type}", generate).Methods(http.MethodPost)
+	s.public(s.r.HandleFunc("/register", registerUser).Methods(http.MethodPost))
+	s.public(s.r.HandleFunc("/users", registerUser).Methods(http.MethodPost))
+	s.public(s.r.HandleFunc("/login", login).Methods(http.MethodPost))
+	s.public(s.r.HandleFunc("/token/refresh", refreshToken).Methods(http.MethodPost))
+	s.public(s.r.HandleFunc("/logout", logout).Methods(http.MethodPost))
+	s.public(s.r.HandleFunc("/.well-known/jwks.json", tokenKeys).Methods(http.MethodGet))
+	s.public(s.r.HandleFunc("/password/forgot", forgotPassword).Methods(http.MethodPost))
+	s.public(s.r.HandleFunc("/password/reset", resetPassword).Methods(http.MethodPost))
+	s.require(s.r.HandleFunc("/users", listUsers).Methods(http.MethodGet), auth.Need(auth.ResourceUsers, auth.ActionRead))
+	s.requireOrSelf(s.r.HandleFunc("/users/{id}", getUser).Methods(http.MethodGet), auth.Need(auth.ResourceUsers, auth.ActionRead))
+	s.requireOrSelf(s.r.HandleFunc("/users/{id}", updateUser).Methods(http.MethodPatch), auth.Need(auth.ResourceUsers, auth.ActionUpdate))
+	s.requireOrSelf(s.r.HandleFunc("/users/{id}", deleteUser).Methods(http.MethodDelete), auth.Need(auth.ResourceUsers, auth.ActionDelete))
+	s.requireOrSelf(s.r.HandleFunc("/users/{id}/permissions", userPermissions).Methods(http.MethodGet), auth.Need(auth.ResourceUsers, auth.ActionRead))
+	s.requireOrSelf(s.r.HandleFunc("/users/{id}/keys", createKey).Methods(http.MethodPost), auth.Need(auth.ResourceKeys, auth.ActionCreate))
+	s.requireOrSelf(s.r.HandleFunc("/users/{id}/keys", listKeys).Methods(http.MethodGet), auth.Need(auth.ResourceKeys, auth.ActionRead))
+	s.requireOrSelf(s.r.HandleFunc("/users/{id}/keys/{key}", revokeKey).Methods(http.MethodDelete), auth.Need(auth.ResourceKeys, auth.ActionDelete))
+	s.self(s.r.HandleFunc("/users/{id}/2fa/enroll", enrollTOTP).Methods(http.MethodPost))
+	s.self(s.r.HandleFunc("/users/{id}/2fa/confirm", confirmTOTP).Methods(http.MethodPost))
+	s.requireOrSelf(s.r.HandleFunc("/users/{id}/2fa/disable", disableTOTP).Methods(http.MethodPost), auth.Need(auth.ResourceUsers, auth.ActionUpdate))
+	s.require(s.r.HandleFunc("/service-accounts", createServiceAccount).Methods(http.MethodPost), auth.Need(auth.ResourceUsers, auth.ActionCreate))
+	s.require(s.r.HandleFunc("/users/{id}/roles/{name}", grantRole).Methods(http.MethodPut), auth.Need(auth.ResourceRoles, auth.ActionUpdate))
+	s.require(s.r.HandleFunc("/users/{id}/roles/{name}", revokeRole).Methods(http.MethodDelete), auth.Need(auth.ResourceRoles, auth.ActionUpdate))
+	s.require(s.r.HandleFunc("/roles", listRoles).Methods(http.MethodGet), auth.Need(auth.ResourceRoles, auth.ActionRead))
+	s.require(s.r.HandleFunc("/roles", createRole).Methods(http.MethodPost), auth.Need(auth.ResourceRoles, auth.ActionCreate))
+	s.require(s.r.HandleFunc("/roles/{name}", getRole).Methods(http.MethodGet), auth.Need(auth.ResourceRoles, auth.ActionRead))
+	s.require(s.r.HandleFunc("/roles/{name}", updateRole).Methods(http.MethodPatch), auth.Need(auth.ResourceRoles, auth.ActionUpdate))
+	s.require(s.r.HandleFunc("/roles/{name}", deleteRole).Methods(http.MethodDelete), auth.Need(auth.ResourceRoles, auth.ActionDelete))
+	s.require(s.r.HandleFunc("/audit", listAudit).Methods(http.MethodGet), auth.Need(auth.ResourceRoles, auth.ActionRead))
+	s.requireScoped(s.r.HandleFunc("/generate/qr/batch", generateBatch).Methods(http.MethodPost), auth.Need(auth.ResourceCodes, auth.ActionCreate), "generate:qr")
+	s.requireScoped(s.r.HandleFunc("/generate/{type}", generate).Methods(http.MethodPost), auth.Need(auth.ResourceCodes, auth.ActionCreate), "generate:{type}")
+	s.require(s.r.HandleFunc("/decode", decode).Methods(http.MethodPost), auth.Need(auth.ResourceCodes, auth.ActionRead))
+	s.require(s.r.HandleFunc("/logos", uploadLogo).Methods(http.MethodPost), auth.Need(auth.ResourceCodes, auth.ActionCreate))
+	s.require(s.r.HandleFunc("/jobs/{id}", getJob).Methods(http.MethodGet), auth.Need(auth.ResourceCodes, auth.ActionRead))
+	s.public(s.r.HandleFunc("/r/{slug}", redirectLink).Methods(http.MethodGet, http.MethodHead))
+	s.public(s.r.HandleFunc("/qr/{id}", retargetLink).Methods(http.MethodPatch))
+	s.public(s.r.HandleFunc("/qr/{id}/stats", linkStats).Methods(http.MethodGet))
+	s.public(s.r.HandleFunc("/files/{id}", serveFile).Methods(http.MethodGet, http.MethodHead))
 	s.r.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
-	//s.r.HandleFunc("/register-tickets", register).Methods(http.MethodPost)
+	s.require(s.r.HandleFunc("/tickets", issueTicket).Methods(http.MethodPost), auth.Need(auth.ResourceTickets, auth.ActionCreate))
+	s.public(s.r.HandleFunc("/tickets/keys", ticketKeys).Methods(http.MethodGet))
+	s.require(s.r.HandleFunc("/tickets/{id}/redeem", redeemTicket).Methods(http.MethodPost), auth.Need(auth.ResourceTickets, auth.ActionUpdate))
 	return s
 }
 
@@ -137,7 +209,8 @@ func (s *Service) ListenAndServe() {
 	s.ready = false
 	s.Ctx = context.WithValue(s.Ctx, StartTime, time.Now())
 	go func() {
-		if err := s.Srv.ListenAndServe(); err != nil {
+		// a graceful shutdown closes the server too, and must be left to finish
+		if err := s.Srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal().Msgf("server startup failed with: (%v)", err)
 		}
 	}()
@@ -156,8 +229,8 @@ func NewService() *Service {
 // ValidateConfig validates that user config is correct
 // it logs an error when one of the configs isn't correct, and returns an appropriate boolean appropriately
 func (s *Service) ValidateConfig() bool {
-	S = s                                       // reference Service pointer created in main()
-	return logLevelIsValid() && dbHostIsValid() // && the rest
+	S = s                                                                                                                                                                                            // reference Service pointer created in main()
+	return logLevelIsValid() && dbHostIsValid() && storageIsValid() && batchIsValid() && jobsIsValid() && scansIsValid() && cacheIsValid() && loginsIsValid() && tokensIsValid() && proxiesIsValid() // && the rest
 }
 
 // Run inits the logger and runs the port service.
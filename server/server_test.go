@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/config"
+	dbmemory "github.com/dark-enstein/port/db/memory"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/internal/cloud/memory"
+	"github.com/dark-enstein/port/totp"
+	"github.com/dark-enstein/port/util"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ServerTest struct {
+	ctx context.Context
+	// ada holds the VanillaUser role, and grace the Administrator role
+	ada, grace *model.User
+	suite.Suite
+}
+
+func (s *ServerTest) SetupTest() {
+	auth.Passwords = auth.NewPasswordHasher(1, 8*1024, 1)
+	S = &Service{Log: config.NewLoggerWithWarn(), Cfg: &config.Config{Tokens: config.TokensConfig{
+		Audience:     config.DefaultFlagJWTAud,
+		AccessTTL:    config.DefaultFlagAccessTTL,
+		KeyRotation:  config.DefaultFlagKeyRotate,
+		Exempt:       config.DefaultFlagAuthExempt,
+		KeyRateLimit: config.DefaultFlagKeyRate,
+	}}}
+	s.ctx = context.WithValue(context.Background(), util.LoggerInContext, S.Log)
+	mem, err := dbmemory.NewMemoryClient(s.ctx, "")
+	s.Require().NoError(err)
+	S.DB = mem
+	s.ctx = context.WithValue(s.ctx, util.DBInContext, mem)
+	S.Tokens, err = NewTokenAuthority(S.Cfg)
+	s.Require().NoError(err)
+	s.Require().NoError(SetupRoles(nil))
+
+	users := auth.NewUserDirector(s.ctx)
+	s.ada, err = users.CreateUser(*(&auth.User{Name: "ada lovelace", Birth: "10/12/1815", Email: "ada@example.com", Password: "correct horse battery"}).IntoInternal())
+	s.Require().NoError(err)
+	s.grace, err = users.CreateUser(*(&auth.User{Name: "grace hopper", Birth: "09/12/1906", Email: "grace@example.com", Password: "correct horse battery"}).IntoInternal())
+	s.Require().NoError(err)
+	_, err = users.GrantRole(s.grace.ID, auth.Administrator)
+	s.Require().NoError(err)
+	S.RegisterRoutes()
+}
+
+// token returns the Authorization header of an access token of the user with the id passed in
+func (s *ServerTest) token(id string) string {
+	token, _, err := S.Tokens.Issue(id)
+	s.Require().NoError(err)
+	return "Bearer " + token
+}
+
+// key returns the Authorization header of an API key of ada, with the scopes passed in
+func (s *ServerTest) key(scopes ...string) string {
+	raw, _, err := auth.NewAPIKeyDirector(s.ctx).Create(s.ada.ID, "test", scopes, 0, nil)
+	s.Require().NoError(err)
+	return "Bearer " + raw
+}
+
+// TestAccess tests that authenticate and authorize let through the calls each kind of route rule allows, and refuse
+// the others
+func (s *ServerTest) TestAccess() {
+	expired, _, err := S.Tokens.IssueAt(s.ada.ID, time.Now().Add(-time.Hour))
+	s.Require().NoError(err)
+	other, err := NewTokenAuthority(S.Cfg)
+	s.Require().NoError(err)
+	foreign, _, err := other.Issue(s.ada.ID)
+	s.Require().NoError(err)
+
+	ada, grace := "/users/"+s.ada.ID, "/users/"+s.grace.ID
+	cases := []struct {
+		name          string
+		method, path  string
+		body          string
+		authorization string
+		status        int
+		message       string
+		// challenged is set for calls authenticate refuses, which get a WWW-Authenticate header
+		challenged bool
+	}{
+		{name: "exempt path without token", method: http.MethodGet, path: "/ping", status: http.StatusOK},
+		{name: "public route without token", method: http.MethodGet, path: "/.well-known/jwks.json", status: http.StatusOK},
+		{name: "missing token", method: http.MethodGet, path: ada, status: http.StatusUnauthorized, challenged: true},
+		{name: "malformed token", method: http.MethodGet, path: ada, authorization: "Bearer not.a.token", status: http.StatusUnauthorized, challenged: true},
+		{name: "expired token", method: http.MethodGet, path: ada, authorization: "Bearer " + expired, status: http.StatusUnauthorized, challenged: true},
+		{name: "token of unknown kid", method: http.MethodGet, path: ada, authorization: "Bearer " + foreign, status: http.StatusUnauthorized, challenged: true},
+		{name: "token of a user gone", method: http.MethodGet, path: "/users", authorization: s.token("gone"), status: http.StatusUnauthorized, message: "no longer exists"},
+		{name: "requireOrSelf on self, of a user gone", method: http.MethodGet, path: "/users/gone", authorization: s.token("gone"), status: http.StatusUnauthorized, message: "no longer exists"},
+		{name: "self on self, of a user gone", method: http.MethodPost, path: "/users/gone/2fa/enroll", authorization: s.token("gone"), status: http.StatusUnauthorized, message: "no longer exists"},
+
+		{name: "require without permission", method: http.MethodGet, path: "/users", authorization: s.token(s.ada.ID), status: http.StatusForbidden, message: "missing permission"},
+		{name: "require with permission", method: http.MethodGet, path: "/users", authorization: s.token(s.grace.ID), status: http.StatusOK},
+		{name: "requireOrSelf on self", method: http.MethodGet, path: ada, authorization: s.token(s.ada.ID), status: http.StatusOK},
+		{name: "requireOrSelf on another user", method: http.MethodGet, path: grace, authorization: s.token(s.ada.ID), status: http.StatusForbidden},
+		{name: "requireOrSelf with permission", method: http.MethodGet, path: ada, authorization: s.token(s.grace.ID), status: http.StatusOK},
+		{name: "self on self", method: http.MethodPost, path: ada + "/2fa/enroll", authorization: s.token(s.ada.ID), status: http.StatusOK},
+		{name: "self on another user with permission", method: http.MethodPost, path: ada + "/2fa/enroll", authorization: s.token(s.grace.ID), status: http.StatusForbidden},
+		{name: "self with an api key", method: http.MethodPost, path: ada + "/2fa/enroll", authorization: s.key("users:*"), status: http.StatusForbidden},
+
+		{name: "invalid api key", method: http.MethodGet, path: ada, authorization: "Bearer " + auth.APIKeyPrefix + "0123_secret", status: http.StatusUnauthorized, challenged: true},
+		{name: "api key with scope", method: http.MethodGet, path: ada, authorization: s.key("users:read"), status: http.StatusOK},
+		{name: "api key without scope", method: http.MethodGet, path: ada, authorization: s.key("generate:qr"), status: http.StatusForbidden, message: "api key lacks scope"},
+		{name: "api key scope beyond the roles of its user", method: http.MethodGet, path: "/users", authorization: s.key("users:read"), status: http.StatusForbidden, message: "missing permission"},
+		{name: "requireScoped with narrower scope", method: http.MethodPost, path: "/generate/qr", body: "{}", authorization: s.key("generate:qr"), status: http.StatusBadRequest},
+		{name: "requireScoped with another narrower scope", method: http.MethodPost, path: "/generate/code128", body: "{}", authorization: s.key("generate:qr"), status: http.StatusForbidden, message: "api key lacks scope"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		req.Header.Set("Content-Type", MimeJSON)
+		if c.authorization != "" {
+			req.Header.Set("Authorization", c.authorization)
+		}
+		resp := httptest.NewRecorder()
+		S.r.ServeHTTP(resp, req)
+		s.Assert().Equal(c.status, resp.Code, "%v: %v", c.name, resp.Body.String())
+		s.Assert().Contains(resp.Body.String(), c.message, c.name)
+		s.Assert().Equal(c.challenged, resp.Header().Get("WWW-Authenticate") != "", c.name)
+	}
+}
+
+// TestExempt tests that exempting the path of a route with a rule doesn't open it to calls without a token
+func (s *ServerTest) TestExempt() {
+	S.Cfg.Tokens.Exempt = "/ping,/users"
+	for _, path := range []string{"/users", "/users/" + s.ada.ID, "/users/" + s.ada.ID + "/2fa/enroll"} {
+		method := http.MethodGet
+		if strings.HasSuffix(path, "/enroll") {
+			method = http.MethodPost
+		}
+		resp := httptest.NewRecorder()
+		S.r.ServeHTTP(resp, httptest.NewRequest(method, path, nil))
+		s.Assert().Equal(http.StatusUnauthorized, resp.Code, path)
+	}
+	resp := httptest.NewRecorder()
+	S.r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	s.Assert().Equal(http.StatusOK, resp.Code)
+}
+
+// TestDisableTOTP tests that two-factor authentication is only turned off without a code for callers, other than the
+// user, that authorize let through: never for calls without a token
+func (s *ServerTest) TestDisableTOTP() {
+	users := auth.NewUserDirector(s.ctx)
+	_, secret, err := users.EnrollTOTP(s.ada.ID)
+	s.Require().NoError(err)
+	code, err := totp.Code(secret, time.Now())
+	s.Require().NoError(err)
+	_, err = users.ConfirmTOTP(s.ada.ID, code)
+	s.Require().NoError(err)
+
+	disable := func(authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users/"+s.ada.ID+"/2fa/disable", strings.NewReader("{}"))
+		req.Header.Set("Content-Type", MimeJSON)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp := httptest.NewRecorder()
+		S.r.ServeHTTP(resp, req)
+		return resp
+	}
+	s.Assert().Equal(http.StatusUnauthorized, disable("").Code)
+	s.Assert().Equal(http.StatusForbidden, disable(s.token(s.ada.ID)).Code)
+
+	// the handler alone, as if a path exemption let the call through
+	req := httptest.NewRequest(http.MethodPost, "/users/"+s.ada.ID+"/2fa/disable", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", MimeJSON)
+	resp := httptest.NewRecorder()
+	disableTOTP(resp, mux.SetURLVars(req, map[string]string{"id": s.ada.ID}))
+	s.Assert().Equal(http.StatusForbidden, resp.Code)
+	user, err := users.Get(s.ada.ID)
+	s.Require().NoError(err)
+	s.Assert().True(user.TOTPEnabled)
+
+	s.Assert().Equal(http.StatusNoContent, disable(s.token(s.grace.ID)).Code)
+	user, err = users.Get(s.ada.ID)
+	s.Require().NoError(err)
+	s.Assert().False(user.TOTPEnabled)
+}
+
+// TestJobOwner tests that jobs are only reported to the caller that created them, and to callers allowed to read
+// any job
+func (s *ServerTest) TestJobOwner() {
+	S.Store, S.Cache = memory.NewStore("http://localhost"), auth.NewRenderCache(8)
+	S.Jobs = NewJobQueue(1, nil)
+	defer S.Jobs.Stop(context.Background())
+	alan, err := auth.NewUserDirector(s.ctx).CreateUser(*(&auth.User{Name: "alan turing", Birth: "23/06/1912", Email: "alan@example.com", Password: "correct horse battery"}).IntoInternal())
+	s.Require().NoError(err)
+
+	call := func(method, path, body, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", MimeJSON)
+		req.Header.Set("Authorization", authorization)
+		resp := httptest.NewRecorder()
+		S.r.ServeHTTP(resp, req)
+		return resp
+	}
+	resp := call(http.MethodPost, "/generate/qr?async=true", `{"content": "https://github.com/dark-enstein/port"}`, s.token(s.ada.ID))
+	s.Require().Equal(http.StatusAccepted, resp.Code, resp.Body.String())
+	location := resp.Header().Get("Location")
+
+	s.Assert().Equal(http.StatusOK, call(http.MethodGet, location, "", s.token(s.ada.ID)).Code)
+	s.Assert().Equal(http.StatusOK, call(http.MethodGet, location, "", s.token(s.grace.ID)).Code)
+	s.Assert().Equal(http.StatusNotFound, call(http.MethodGet, location, "", s.token(alan.ID)).Code)
+	s.Assert().Equal(http.StatusOK, call(http.MethodGet, location, "", s.key("codes:read")).Code)
+
+	// jobs created before they had an owner are only reported to callers allowed to read any job
+	unowned := model.NewJob("unowned", "qr", model.JobSpec{}, "")
+	s.Require().NoError(auth.NewJobDirector(s.ctx).Create(unowned))
+	s.Assert().Equal(http.StatusNotFound, call(http.MethodGet, "/jobs/unowned", "", s.token(s.ada.ID)).Code)
+	s.Assert().Equal(http.StatusOK, call(http.MethodGet, "/jobs/unowned", "", s.token(s.grace.ID)).Code)
+}
+
+// TestTicketHolder tests that tickets are only issued to users that exist
+func (s *ServerTest) TestTicketHolder() {
+	var err error
+	S.Store, S.Cache = memory.NewStore("http://localhost"), auth.NewRenderCache(8)
+	S.Tickets, err = NewTicketSigner("")
+	s.Require().NoError(err)
+	validUntil := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+
+	issue := func(userID string) *httptest.ResponseRecorder {
+		body := `{"user_id": "` + userID + `", "event": "ada's birthday", "valid_until": "` + validUntil + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/tickets", strings.NewReader(body))
+		req.Header.Set("Content-Type", MimeJSON)
+		req.Header.Set("Authorization", s.token(s.grace.ID))
+		resp := httptest.NewRecorder()
+		S.r.ServeHTTP(resp, req)
+		return resp
+	}
+	resp := issue("gone")
+	s.Assert().Equal(http.StatusUnprocessableEntity, resp.Code, resp.Body.String())
+	var tickets []*model.Ticket
+	s.Require().NoError(S.DB.List(s.ctx, model.Filter{}, &tickets, nil, model.NewUnitOptions(auth.UserDB, model.TicketCollection)).Err)
+	s.Assert().Empty(tickets)
+
+	resp = issue(s.ada.ID)
+	s.Assert().Equal(http.StatusCreated, resp.Code, resp.Body.String())
+	s.Require().NoError(S.DB.List(s.ctx, model.Filter{}, &tickets, nil, model.NewUnitOptions(auth.UserDB, model.TicketCollection)).Err)
+	s.Assert().Len(tickets, 1)
+}
+
+func TestServer(t *testing.T) {
+	suite.Run(t, new(ServerTest))
+}
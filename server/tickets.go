@@ -0,0 +1,315 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/internal/generators/qr"
+	"github.com/dark-enstein/port/ticket"
+	"github.com/dark-enstein/port/util"
+	"github.com/golang/gddo/httputil/header"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	// MaxTicketFieldLength bounds the fields signed into a ticket, so its token fits in a code that scans easily
+	MaxTicketFieldLength = 64
+	// DefaultTicketRecoveryLevel is the recovery level of ticket codes. Tickets get crumpled and scanned off
+	// cracked phone screens.
+	DefaultTicketRecoveryLevel = "2"
+)
+
+// TicketRequest is the JSON body of a call to "/tickets"
+type TicketRequest struct {
+	UserID        string     `json:"user_id"`
+	Event         string     `json:"event"`
+	Seat          string     `json:"seat,omitempty"`
+	ValidFrom     *time.Time `json:"valid_from,omitempty"`
+	ValidUntil    time.Time  `json:"valid_until"`
+	Size          int        `json:"size"`
+	RecoveryLevel string     `json:"recovery_level"`
+	Format        string     `json:"format,omitempty"`
+	DataURI       bool       `json:"data_uri,omitempty"`
+}
+
+// Resolve validates the ticket request, filling in its defaults, and resolves the output format of its code
+func (t *TicketRequest) Resolve(now time.Time) (qr.Format, error) {
+	for field, value := range map[string]string{"user_id": t.UserID, "event": t.Event} {
+		if value == "" {
+			return "", fmt.Errorf("request must contain %v", field)
+		}
+	}
+	for field, value := range map[string]string{"user_id": t.UserID, "event": t.Event, "seat": t.Seat} {
+		if len(value) > MaxTicketFieldLength {
+			return "", fmt.Errorf("%v must not be longer than %d characters", field, MaxTicketFieldLength)
+		}
+	}
+	if t.ValidFrom == nil {
+		t.ValidFrom = &now
+	}
+	if t.ValidUntil.IsZero() {
+		return "", errors.New("request must contain valid_until")
+	}
+	if !t.ValidUntil.After(*t.ValidFrom) || !t.ValidUntil.After(now) {
+		return "", errors.New("valid_until must be in the future, and after valid_from")
+	}
+	if t.RecoveryLevel == "" {
+		t.RecoveryLevel = DefaultTicketRecoveryLevel
+	}
+	return qr.ParseFormat(t.Format)
+}
+
+// TicketResponse is the structured JSON response of a successful call to "/tickets". It carries the token the
+// ticket's code encodes, along with the code.
+type TicketResponse struct {
+	Response
+	Ticket   *model.Ticket `json:"ticket"`
+	Token    string        `json:"token"`
+	URL      string        `json:"url"`
+	Format   string        `json:"format"`
+	Width    int           `json:"width"`
+	Height   int           `json:"height"`
+	Bytes    int           `json:"bytes"`
+	Checksum string        `json:"checksum"`
+	DataURI  string        `json:"data_uri,omitempty"`
+}
+
+func ConstructTicketResponse(reqID string, t *model.Ticket, token string, res *qr.Result, withDataURI bool) *TicketResponse {
+	r := TicketResponse{
+		Response: *ConstructResponse(reqID, fmt.Sprintf("issued ticket %v to %v for %v", t.ID, t.UserID, t.Event)),
+		Ticket:   t,
+		Token:    token,
+		URL:      res.URL,
+		Format:   res.Format.String(),
+		Width:    res.Width,
+		Height:   res.Height,
+		Bytes:    res.Size(),
+		Checksum: res.Checksum,
+	}
+	if withDataURI {
+		r.DataURI = res.DataURI()
+	}
+	return &r
+}
+
+func (r *TicketResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// NewTicketSigner loads the Ed25519 key tickets are signed with from the PEM file passed in. Without a file, a key
+// is generated for the life of the process, and tickets stop verifying once it restarts.
+func NewTicketSigner(path string) (*ticket.Signer, error) {
+	if path == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return ticket.NewSigner(key)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading ticket key: %w", err)
+	}
+	key, err := ticket.ParsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return ticket.NewSigner(key)
+}
+
+// ticketsAvailable checks that tickets can be issued, responding with 503 when they can't
+func ticketsAvailable(resp http.ResponseWriter) bool {
+	if S.DB == nil || S.Tickets == nil {
+		http.Error(resp, "tickets are not available", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// issueTicket handles calls to "/tickets". It issues a ticket to a user for an event, and generates the code
+// carrying its signed token. Tickets are only issued to users that exist: others get 422.
+func issueTicket(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "issueTicket()").Logger()
+	if !ticketsAvailable(resp) {
+		return
+	}
+	if req.Header.Get("Content-Type") != "" {
+		value, _ := header.ParseValueAndParams(req.Header, "Content-Type")
+		if value != MimeJSON {
+			http.Error(resp, "Content-Type header is not application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+
+	req.Body = http.MaxBytesReader(resp, req.Body, 1048576)
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	ticketReq := &TicketRequest{}
+	if err := dec.Decode(ticketReq); err != nil {
+		log.Info().Msgf("umarshaling request into json failed with: %v", err)
+		writeDecodeError(resp, err, &log)
+		return
+	}
+	format, err := ticketReq.Resolve(time.Now())
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := uuid.New()
+	ctx := context.WithValue(context.Background(), util.LoggerInContext, S.Log)
+	ctx = context.WithValue(ctx, util.DBInContext, S.DB)
+	ctx = context.WithValue(ctx, util.StorageInContext, S.Store)
+	ctx = context.WithValue(ctx, util.RequestIDInContext, id.String())
+	ctx, cancelFunc := context.WithTimeout(ctx, 60*time.Second)
+	defer cancelFunc()
+
+	_, err = auth.NewUserDirector(ctx).Get(ticketReq.UserID)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("user %v not found", ticketReq.UserID), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		log.Error().Msgf("reading user %v failed with %v", ticketReq.UserID, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	t := model.NewTicket(id.String(), ticketReq.UserID, ticketReq.Event, ticketReq.Seat, *ticketReq.ValidFrom, ticketReq.ValidUntil)
+	token, err := auth.NewTicketDirector(ctx).Issue(t, S.Tickets)
+	if err != nil {
+		log.Error().Msgf("issuing ticket failed with %v", err)
+		genResponse, _ := ConstructErrResponse(id.String(), fmt.Sprintf("issuing ticket failed with %v", err)).MarshalJson()
+		resp.Header().Set("Content-Type", MimeJSON)
+		resp.WriteHeader(http.StatusInternalServerError)
+		_, _ = resp.Write(genResponse)
+		return
+	}
+
+	director := auth.NewQRDirector(ctx, id, token, ticketReq.RecoveryLevel, ticketReq.Size, format, S.Cfg)
+	if _, err := director.Generate(); err != nil {
+		log.Error().Msgf("generating ticket code failed with %v", err)
+		genResponse, _ := ConstructErrResponse(id.String(), fmt.Sprintf("generating ticket code failed with %v", err)).MarshalJson()
+		resp.Header().Set("Content-Type", MimeJSON)
+		resp.WriteHeader(http.StatusInternalServerError)
+		_, _ = resp.Write(genResponse)
+		return
+	}
+
+	ticketResponse, _ := ConstructTicketResponse(id.String(), t, token, director.Result(), ticketReq.DataURI).MarshalJson()
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set(HeaderRequestID, id.String())
+	resp.WriteHeader(http.StatusCreated)
+	_, _ = resp.Write(ticketResponse)
+	log.Info().Msgf("issued ticket %v for %v", t.ID, t.Event)
+}
+
+// RedeemRequest is the JSON body of a call to "/tickets/{id}/redeem", sent by the scanner at the door
+type RedeemRequest struct {
+	Token    string `json:"token"`
+	DeviceID string `json:"device_id"`
+}
+
+// RedeemResponse is the structured JSON response of a call to "/tickets/{id}/redeem"
+type RedeemResponse struct {
+	Response
+	Outcome model.CheckInOutcome `json:"outcome"`
+	Ticket  *model.Ticket        `json:"ticket,omitempty"`
+}
+
+func (r *RedeemResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// redeemTicket handles calls to "/tickets/{id}/redeem". It verifies the token a scanner read off a ticket, and
+// marks the ticket used. Every check-in is recorded, whatever its outcome.
+func redeemTicket(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "redeemTicket()").Logger()
+	if !ticketsAvailable(resp) {
+		return
+	}
+	id := mux.Vars(req)["id"]
+
+	req.Body = http.MaxBytesReader(resp, req.Body, 1048576)
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	redeemReq := &RedeemRequest{}
+	if err := dec.Decode(redeemReq); err != nil {
+		writeDecodeError(resp, err, &log)
+		return
+	}
+	if redeemReq.Token == "" || redeemReq.DeviceID == "" {
+		http.Error(resp, "request must contain token and device_id", http.StatusBadRequest)
+		return
+	}
+	if len(redeemReq.DeviceID) > MaxTicketFieldLength {
+		http.Error(resp, fmt.Sprintf("device_id must not be longer than %d characters", MaxTicketFieldLength), http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.WithValue(context.Background(), util.LoggerInContext, S.Log)
+	ctx = context.WithValue(ctx, util.DBInContext, S.DB)
+	ctx, cancelFunc := context.WithTimeout(ctx, 10*time.Second)
+	defer cancelFunc()
+	director := auth.NewTicketDirector(ctx)
+	reqID := uuid.NewString()
+
+	respond := func(status int, outcome model.CheckInOutcome, reason string, t *model.Ticket) {
+		_ = director.RecordCheckIn(model.NewCheckIn(reqID, id, redeemReq.DeviceID, outcome, reason))
+		redeemResponse, _ := (&RedeemResponse{Response: *ConstructResponse(reqID, reason), Outcome: outcome, Ticket: t}).MarshalJson()
+		resp.Header().Set("Content-Type", MimeJSON)
+		resp.Header().Set(HeaderRequestID, reqID)
+		resp.WriteHeader(status)
+		_, _ = resp.Write(redeemResponse)
+	}
+
+	verifier, _ := ticket.NewVerifier(S.Tickets.Public())
+	claims, err := verifier.Verify(redeemReq.Token)
+	switch {
+	case err != nil:
+		log.Info().Msgf("rejected ticket %v from %v: %v", id, redeemReq.DeviceID, err)
+		respond(http.StatusForbidden, model.CheckInRejected, err.Error(), nil)
+		return
+	case claims.ID != id:
+		respond(http.StatusForbidden, model.CheckInRejected, fmt.Sprintf("token is for ticket %v, not %v", claims.ID, id), nil)
+		return
+	}
+
+	t, err := director.Redeem(id, redeemReq.DeviceID)
+	switch {
+	case errors.Is(err, auth.ErrAlreadyRedeemed):
+		reason := fmt.Sprintf("ticket %v already redeemed at %v by device %v", id, t.RedeemedAt.Format(time.RFC3339), t.RedeemedBy)
+		respond(http.StatusConflict, model.CheckInAlreadyRedeemed, reason, t)
+	case errors.Is(err, model.ErrNotFound):
+		respond(http.StatusNotFound, model.CheckInRejected, fmt.Sprintf("ticket %v not found", id), nil)
+	case err != nil:
+		log.Error().Msgf("redeeming ticket %v failed with %v", id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	default:
+		log.Info().Msgf("ticket %v redeemed by %v", id, redeemReq.DeviceID)
+		respond(http.StatusOK, model.CheckInRedeemed, fmt.Sprintf("ticket %v redeemed for %v", id, t.Event), t)
+	}
+}
+
+// ticketKeys handles calls to "/tickets/keys". It serves the public keys tickets are verified with, as a JWKS door
+// scanners load into a ticket.Verifier.
+func ticketKeys(resp http.ResponseWriter, req *http.Request) {
+	if S.Tickets == nil {
+		http.Error(resp, "tickets are not available", http.StatusServiceUnavailable)
+		return
+	}
+	keys, _ := json.Marshal(ticket.JWKS{Keys: []ticket.JWK{ticket.NewJWK(S.Tickets.Public())}})
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set("Cache-Control", "public, max-age=300")
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(keys)
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/config"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/jwt"
+	"github.com/dark-enstein/port/util"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TokenResponse is the response to a login, or to exchanging a refresh token. The access token authenticates API
+// calls, as "Authorization: Bearer <access token>", for ExpiresIn seconds. The refresh token is exchanged for the
+// next tokens at "/token/refresh", once.
+type TokenResponse struct {
+	AccessToken      string        `json:"access_token"`
+	TokenType        string        `json:"token_type"`
+	ExpiresIn        int64         `json:"expires_in"`
+	RefreshToken     string        `json:"refresh_token"`
+	RefreshExpiresAt time.Time     `json:"refresh_expires_at"`
+	User             *UserResponse `json:"user"`
+}
+
+func (r *TokenResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// RefreshRequest is the body of a call to "/token/refresh" or "/logout"
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// NewTokenAuthority returns the authority issuing the access tokens of the config. Its keys are generated for this
+// process, and retired keys are trusted for as long as the access tokens they signed can be valid.
+func NewTokenAuthority(cfg *config.Config) (*jwt.Authority, error) {
+	keys, err := jwt.NewKeyring(cfg.Tokens.KeyRotation, cfg.Tokens.AccessTTL+jwt.DefaultLeeway)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.NewAuthority(keys, cfg.ConstructIssuer(), cfg.Tokens.Audience, cfg.Tokens.AccessTTL), nil
+}
+
+// ValidateJWT checks that an access token was issued by Port, for Port, and is valid now
+func (s *Service) ValidateJWT(token string) error {
+	if s.Tokens == nil {
+		return jwt.ErrNoKeys
+	}
+	_, err := s.Tokens.Verify(token)
+	return err
+}
+
+// RequestClaims returns the claims of the access token a request was authenticated with
+func RequestClaims(ctx context.Context) (*jwt.Claims, bool) {
+	c, ok := ctx.Value(util.ClaimsInContext).(*jwt.Claims)
+	return c, ok
+}
+
+// public marks a route as served without a token
+func (s *Service) public(route *mux.Route) {
+	if s.open == nil {
+		s.open = map[*mux.Route]bool{}
+	}
+	s.open[route] = true
+}
+
+// exempt checks that a path is one of the paths the config serves without a token, or below one
+func (s *Service) exempt(path string) bool {
+	for _, p := range s.Cfg.Tokens.ExemptPaths() {
+		if path == p || strings.HasPrefix(path, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate is the middleware checking the access token, or API key, of calls to every route that isn't public or
+// exempt. It puts the claims of the token, and a logger naming its subject, into the request context, and responds
+// with 401 to calls without a valid token.
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if S.open[mux.CurrentRoute(req)] || S.exempt(req.URL.Path) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+		if key, ok := apiKey(req); ok {
+			authenticateKey(next, resp, req, key)
+			return
+		}
+		if S.Tokens == nil {
+			http.Error(resp, "authentication is not available", http.StatusServiceUnavailable)
+			return
+		}
+		token, ok := bearerToken(req)
+		if !ok {
+			resp.Header().Set("WWW-Authenticate", `Bearer realm="port"`)
+			http.Error(resp, "request must carry an access token, as Authorization: Bearer <token>, or an API key", http.StatusUnauthorized)
+			return
+		}
+		claims, err := S.Tokens.Verify(token)
+		if err != nil {
+			resp.Header().Set("WWW-Authenticate", `Bearer realm="port", error="invalid_token"`)
+			http.Error(resp, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		log := S.Log.With().Str("user_id", claims.Subject).Logger()
+		ctx := context.WithValue(req.Context(), util.LoggerInContext, &log)
+		ctx = context.WithValue(ctx, util.ClaimsInContext, claims)
+		next.ServeHTTP(resp, req.WithContext(ctx))
+	})
+}
+
+// writeTokens issues an access token to the user, and writes it with the refresh token as the response
+func writeTokens(resp http.ResponseWriter, user *model.User, refresh string, refreshExpires time.Time) error {
+	access, claims, err := S.Tokens.Issue(user.ID)
+	if err != nil {
+		return err
+	}
+	tokenResponse, _ := (&TokenResponse{
+		AccessToken:      access,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(time.Until(claims.ExpiresAt()).Seconds()),
+		RefreshToken:     refresh,
+		RefreshExpiresAt: refreshExpires,
+		User:             ConstructUserResponse(user),
+	}).MarshalJson()
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set("Cache-Control", "no-store")
+	resp.Header().Set(HeaderRequestID, uuid.NewString())
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(tokenResponse)
+	return nil
+}
+
+// refreshToken handles calls to "/token/refresh". It exchanges a refresh token for a new access token and the next
+// refresh token.
+func refreshToken(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "refreshToken()").Logger()
+	body := &RefreshRequest{}
+	if !decodeJSON(resp, req, body, &log) {
+		return
+	}
+	if body.RefreshToken == "" {
+		http.Error(resp, "request must carry a refresh_token", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	user, next, expires, err := auth.NewUserDirector(ctx).RefreshSession(body.RefreshToken)
+	switch {
+	case errors.Is(err, auth.ErrInvalidRefreshToken):
+		http.Error(resp, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		log.Error().Msgf("refreshing tokens failed with %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := writeTokens(resp, user, next, expires); err != nil {
+		log.Error().Msgf("issuing access token failed with %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// logout handles calls to "/logout". It revokes the refresh token passed in, and every refresh token of its family,
+// so the session can't be refreshed again. Access tokens already issued stay valid until they expire.
+func logout(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "logout()").Logger()
+	body := &RefreshRequest{}
+	if !decodeJSON(resp, req, body, &log) {
+		return
+	}
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	if err := auth.NewUserDirector(ctx).EndSession(body.RefreshToken); err != nil {
+		log.Error().Msgf("revoking refresh token failed with %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// tokenKeys handles calls to "/.well-known/jwks.json". It serves the public keys access tokens are verified with.
+func tokenKeys(resp http.ResponseWriter, req *http.Request) {
+	if S.Tokens == nil {
+		http.Error(resp, "authentication is not available", http.StatusServiceUnavailable)
+		return
+	}
+	keys, _ := json.Marshal(S.Tokens.Keys.JWKS())
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set("Cache-Control", "public, max-age=300")
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(keys)
+}
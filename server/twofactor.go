@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/internal/generators/qr"
+	"github.com/dark-enstein/port/totp"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	qrcode "github.com/skip2/go-qrcode"
+	"net/http"
+)
+
+var (
+	// TOTPIssuer names Port in authenticator apps
+	TOTPIssuer = "Port"
+	// EnrollQRSize is the width of the enrollment QR codes, in pixels
+	EnrollQRSize = 256
+)
+
+// EnrollResponse is the response to "POST /users/{id}/2fa/enroll". Authenticator apps scan QR, a PNG data URI of
+// the URI, or take the secret typed in.
+type EnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+	QR     string `json:"qr"`
+}
+
+func (r *EnrollResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// CodeRequest is the body of the calls checking a code of an authenticator app, or a recovery code
+type CodeRequest struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodesResponse is the response to "POST /users/{id}/2fa/confirm". Each recovery code logs in once, in place
+// of a code of the authenticator app, and is never shown again.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (r *RecoveryCodesResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// writeSecret writes the JSON body of a response holding secrets, which no cache may keep
+func writeSecret(resp http.ResponseWriter, body []byte, status int) {
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set("Cache-Control", "no-store")
+	resp.Header().Set(HeaderRequestID, uuid.NewString())
+	resp.WriteHeader(status)
+	_, _ = resp.Write(body)
+}
+
+// enrollTOTP handles calls to "POST /users/{id}/2fa/enroll". It creates the secret of a new authenticator app, and
+// responds with it and the QR code enrolling it. Logins don't need a code until confirmTOTP checks the first one.
+func enrollTOTP(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "enrollTOTP()").Logger()
+	id := mux.Vars(req)["id"]
+
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	user, secret, err := auth.NewUserDirector(ctx).EnrollTOTP(id)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("user %v not found", id), http.StatusNotFound)
+		return
+	case errors.Is(err, auth.ErrTwoFactorEnabled):
+		http.Error(resp, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, model.ErrConflict):
+		http.Error(resp, fmt.Sprintf("user %v was updated concurrently, retry", id), http.StatusConflict)
+		return
+	case err != nil:
+		log.Error().Msgf("enrolling an authenticator app for user %v failed with %v", id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	account := user.ID
+	if user.Email != nil {
+		account = *user.Email
+	}
+	uri := totp.URI(TOTPIssuer, account, secret)
+	rendered, err := qr.NewQRWithArgs(ctx, user.ID, uri, EnrollQRSize, qrcode.Medium).WithFormat(qr.FormatPNG).Render()
+	if err != nil {
+		log.Error().Msgf("rendering the enrollment code of user %v failed with %v", id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	enrollResponse, _ := (&EnrollResponse{Secret: secret, URI: uri, QR: rendered.DataURI()}).MarshalJson()
+	writeSecret(resp, enrollResponse, http.StatusOK)
+	log.Info().Msgf("user %v enrolled an authenticator app", id)
+}
+
+// confirmTOTP handles calls to "POST /users/{id}/2fa/confirm". It checks the first code of the authenticator app
+// enrolled, enables two-factor authentication, and responds with the recovery codes of the user.
+func confirmTOTP(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "confirmTOTP()").Logger()
+	id := mux.Vars(req)["id"]
+	body := &CodeRequest{}
+	if !decodeJSON(resp, req, body, &log) {
+		return
+	}
+
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	codes, err := auth.NewUserDirector(ctx).ConfirmTOTP(id, body.Code)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("user %v not found", id), http.StatusNotFound)
+		return
+	case errors.Is(err, auth.ErrTwoFactorEnabled), errors.Is(err, auth.ErrTwoFactorNotPending):
+		http.Error(resp, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, auth.ErrInvalidSecondFactor):
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, model.ErrConflict):
+		http.Error(resp, fmt.Sprintf("user %v was updated concurrently, retry", id), http.StatusConflict)
+		return
+	case err != nil:
+		log.Error().Msgf("confirming the authenticator app of user %v failed with %v", id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	audit(ctx, req, auth.AuditTOTPEnable, auth.AuditUser(id), nil)
+	codesResponse, _ := (&RecoveryCodesResponse{RecoveryCodes: codes}).MarshalJson()
+	writeSecret(resp, codesResponse, http.StatusOK)
+	log.Info().Msgf("user %v enabled two-factor authentication", id)
+}
+
+// disableTOTP handles calls to "POST /users/{id}/2fa/disable". Users turn two-factor authentication off with a code
+// of their authenticator app, or a recovery code. Callers allowed to update users turn it off for the users who
+// lost both, without one.
+func disableTOTP(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "disableTOTP()").Logger()
+	body := &CodeRequest{}
+	if !decodeJSON(resp, req, body, &log) {
+		return
+	}
+	id := mux.Vars(req)["id"]
+	claims, _ := RequestClaims(req.Context())
+	// authorize let the call through, so callers other than the user hold the permission. Calls without claims prove
+	// nothing, and need a code like the user does.
+	trusted := claims != nil && claims.Subject != id
+
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	err := auth.NewUserDirector(ctx).DisableTOTP(id, body.Code, trusted)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("user %v not found", id), http.StatusNotFound)
+		return
+	case errors.Is(err, auth.ErrTwoFactorNotEnabled):
+		http.Error(resp, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, auth.ErrInvalidSecondFactor):
+		http.Error(resp, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, model.ErrConflict):
+		http.Error(resp, fmt.Sprintf("user %v was updated concurrently, retry", id), http.StatusConflict)
+		return
+	case err != nil:
+		log.Error().Msgf("disabling two-factor authentication of user %v failed with %v", id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	audit(ctx, req, auth.AuditTOTPDisable, auth.AuditUser(id), nil)
+	resp.WriteHeader(http.StatusNoContent)
+	log.Info().Msgf("two-factor authentication of user %v disabled", id)
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dark-enstein/port/auth"
+	"github.com/dark-enstein/port/db/model"
+	"github.com/dark-enstein/port/util"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// DefaultPageSize is the number of users, or audit entries, listed when the request doesn't say
+	DefaultPageSize = 50
+	// MaxPageSize is the most users, or audit entries, a single request lists
+	MaxPageSize = 500
+)
+
+// UserResponse describes a user of Port
+type UserResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Birth     string    `json:"dob,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	Roles     []string  `json:"roles"`
+	Service   bool      `json:"service,omitempty"`
+	TwoFactor bool      `json:"two_factor,omitempty"`
+	Version   int64     `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func ConstructUserResponse(user *model.User) *UserResponse {
+	r := &UserResponse{
+		ID:        user.ID,
+		Roles:     user.Roles.Names(),
+		Service:   user.Service,
+		TwoFactor: user.TOTPEnabled,
+		Version:   user.Version,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}
+	if r.Roles == nil {
+		r.Roles = []string{}
+	}
+	if user.Name != nil {
+		r.Name, r.FirstName, r.LastName = user.NameStr(), user.Name.FirstName, user.Name.LastName
+	}
+	if user.Birth != nil {
+		r.Birth = *user.Birth
+	}
+	if user.Email != nil {
+		r.Email = *user.Email
+	}
+	return r
+}
+
+func (r *UserResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// UsersResponse is a page of the users of Port. NextOffset is set when there may be more users after the page.
+type UsersResponse struct {
+	Users      []*UserResponse `json:"users"`
+	Offset     int             `json:"offset"`
+	Limit      int             `json:"limit"`
+	NextOffset *int            `json:"next_offset,omitempty"`
+}
+
+func (r *UsersResponse) MarshalJson() ([]byte, error) {
+	return json.Marshal(&r)
+}
+
+// UserPatch is the body of a call to "PATCH /users/{id}". Only the fields passed in are changed.
+type UserPatch struct {
+	Name  *string `json:"name"`
+	Birth *string `json:"dob"`
+}
+
+func userContext() (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(context.Background(), util.LoggerInContext, S.Log)
+	ctx = context.WithValue(ctx, util.DBInContext, S.DB)
+	return context.WithTimeout(ctx, 10*time.Second)
+}
+
+// writeUser writes the user as the JSON body of a response with the status passed in
+func writeUser(resp http.ResponseWriter, user *model.User, status int) {
+	userResponse, _ := ConstructUserResponse(user).MarshalJson()
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set(HeaderRequestID, uuid.NewString())
+	resp.WriteHeader(status)
+	_, _ = resp.Write(userResponse)
+}
+
+// getUser handles calls to "GET /users/{id}"
+func getUser(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "getUser()").Logger()
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+
+	id := mux.Vars(req)["id"]
+	user, err := auth.NewUserDirector(ctx).Get(id)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("user %v not found", id), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Error().Msgf("reading user %v failed with %v", id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeUser(resp, user, http.StatusOK)
+}
+
+// listUsers handles calls to "GET /users". It lists a page of the users, in the order they were created, filtered
+// by the first_name, last_name and role query parameters. The page is set by the offset and limit parameters.
+func listUsers(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "listUsers()").Logger()
+	query := req.URL.Query()
+	offset, limit, ok := pageParams(resp, query, "users")
+	if !ok {
+		return
+	}
+	filter := model.Filter{}
+	for param, field := range map[string]string{"first_name": model.FirstNameField, "last_name": model.LastNameField, "role": model.RoleField} {
+		if v := query.Get(param); v != "" {
+			filter[field] = v
+		}
+	}
+
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	users, err := auth.NewUserDirector(ctx).List(filter, offset, limit)
+	if err != nil {
+		log.Error().Msgf("listing users failed with %v", err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	page := &UsersResponse{Users: make([]*UserResponse, len(users)), Offset: offset, Limit: limit}
+	for i, user := range users {
+		page.Users[i] = ConstructUserResponse(user)
+	}
+	if len(users) == limit {
+		next := offset + limit
+		page.NextOffset = &next
+	}
+	pageResponse, _ := page.MarshalJson()
+	resp.Header().Set("Content-Type", MimeJSON)
+	resp.Header().Set(HeaderRequestID, uuid.NewString())
+	resp.WriteHeader(http.StatusOK)
+	_, _ = resp.Write(pageResponse)
+}
+
+// pageParams reads the page a list call asks for from its offset and limit query parameters, and responds with 400
+// when they are out of range
+func pageParams(resp http.ResponseWriter, query url.Values, what string) (int, int, bool) {
+	offset, limit := 0, DefaultPageSize
+	var err error
+	if o := query.Get("offset"); o != "" {
+		if offset, err = strconv.Atoi(o); err != nil || offset < 0 {
+			http.Error(resp, fmt.Sprintf("offset must be a number of %v, 0 or more", what), http.StatusBadRequest)
+			return 0, 0, false
+		}
+	}
+	if l := query.Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > MaxPageSize {
+			http.Error(resp, fmt.Sprintf("limit must be a number of %v, from 1 to %d", what, MaxPageSize), http.StatusBadRequest)
+			return 0, 0, false
+		}
+	}
+	return offset, limit, true
+}
+
+// updateUser handles calls to "PATCH /users/{id}". It changes the name and date of birth of a user.
+func updateUser(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "updateUser()").Logger()
+	body := &UserPatch{}
+	if !decodeJSON(resp, req, body, &log) {
+		return
+	}
+
+	fields := model.Fields{}
+	if body.Name != nil {
+		if err := validateUserName(*body.Name); err != nil {
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := auth.NewName(strings.Join(strings.Fields(*body.Name), " "))
+		fields[model.FirstNameField], fields[model.LastNameField] = name.RetrieveFirstName(), name.RetrieveLastName()
+	}
+	if body.Birth != nil {
+		if err := validateBirthDate(*body.Birth); err != nil {
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fields[model.BirthField] = *body.Birth
+	}
+	if len(fields) == 0 {
+		http.Error(resp, "request must change the name or the dob of the user", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+	id := mux.Vars(req)["id"]
+	user, err := auth.NewUserDirector(ctx).Update(id, fields)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("user %v not found", id), http.StatusNotFound)
+		return
+	case errors.Is(err, model.ErrConflict):
+		http.Error(resp, fmt.Sprintf("user %v was updated concurrently, retry", id), http.StatusConflict)
+		return
+	case err != nil:
+		log.Error().Msgf("updating user %v failed with %v", id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeUser(resp, user, http.StatusOK)
+	log.Info().Msgf("user %v updated", id)
+}
+
+// deleteUser handles calls to "DELETE /users/{id}"
+func deleteUser(resp http.ResponseWriter, req *http.Request) {
+	log := S.Log.With().Str("method", "deleteUser()").Logger()
+	ctx, cancelFunc := userContext()
+	defer cancelFunc()
+
+	id := mux.Vars(req)["id"]
+	err := auth.NewUserDirector(ctx).Delete(id)
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		http.Error(resp, fmt.Sprintf("user %v not found", id), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Error().Msgf("deleting user %v failed with %v", id, err)
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	resp.WriteHeader(http.StatusNoContent)
+	log.Info().Msgf("user %v deleted", id)
+}
+
+// validateUserName checks that a name is a first and a last name, free of forbidden characters
+func validateUserName(name string) error {
+	if len(strings.Fields(name)) != 2 {
+		return fmt.Errorf("name must be a first and a last name, separated by a space")
+	}
+	if strings.ContainsAny(name, util.Forbidden) {
+		return fmt.Errorf("name must not contain any of %v", util.Forbidden)
+	}
+	return nil
+}
+
+// validateBirthDate checks that a date of birth is a past date, in the format DD/MM/YYYY
+func validateBirthDate(dob string) error {
+	date, err := time.Parse("02/01/2006", dob)
+	if err != nil {
+		return fmt.Errorf("dob must be a date in the format DD/MM/YYYY")
+	}
+	if date.After(time.Now()) {
+		return fmt.Errorf("dob must not be in the future")
+	}
+	return nil
+}
@@ -7,7 +7,9 @@ import (
 	"github.com/dark-enstein/port/auth"
 	"github.com/dark-enstein/port/config"
 	"github.com/dark-enstein/port/db/mongo"
+	"github.com/dark-enstein/port/internal/generators"
 	"github.com/dark-enstein/port/util"
+	"github.com/rs/zerolog"
 	"io"
 	"net"
 	"net/http"
@@ -19,6 +21,16 @@ import (
 // it logs an error if the dbHost config isn't correct
 func dbHostIsValid() bool {
 	log := S.Log.With().Str("method", "dbHostIsValid()").Logger()
+	switch S.Cfg.EnabledDB {
+	case config.DBMemory, config.DBSQLite:
+		return true
+	case config.DBPostgres:
+		if S.Cfg.DBHost == config.DefaultFlagDBHost {
+			log.Info().Msgf("%v must be set to the URI of the postgres server", config.FlagDBHost)
+			return false
+		}
+		return true
+	}
 	if S.Cfg.DBHost == mongo.LocalMongoHost || strings.Contains(mongo.LocalMongoHost, S.Cfg.DBHost) {
 		return true
 	}
@@ -40,6 +52,103 @@ func dbHostIsValid() bool {
 	return true
 }
 
+// storageIsValid does the low level validation that the storage backend passed in is supported
+// it logs an error if the storage config isn't correct
+func storageIsValid() bool {
+	log := S.Log.With().Str("method", "storageIsValid()").Logger()
+	if S.Cfg.Storage.Kind == "" {
+		S.Cfg.Storage.Kind = config.DefaultFlagStorage
+	}
+	if !util.IsIn(S.Cfg.Storage.Kind, config.StorageBackends) {
+		log.Info().Msgf("storage backend passed in: %v isn't supported", S.Cfg.Storage.Kind)
+		return false
+	}
+	if S.Cfg.Storage.Kind == config.StorageLocal && S.Cfg.Storage.Dir == "" {
+		S.Cfg.Storage.Dir = config.DefaultFlagStorageDir
+	}
+	return true
+}
+
+// batchIsValid does the low level validation that the batch limits passed in are usable
+// it falls back to the default limits when they aren't set
+func batchIsValid() bool {
+	log := S.Log.With().Str("method", "batchIsValid()").Logger()
+	if S.Cfg.Batch.Workers < 1 {
+		S.Cfg.Batch.Workers = config.DefaultFlagBatchWork
+	}
+	if S.Cfg.Batch.MaxItems < 1 {
+		S.Cfg.Batch.MaxItems = config.DefaultFlagBatchMax
+	}
+	log.Debug().Msgf("batches limited to %d items on %d workers", S.Cfg.Batch.MaxItems, S.Cfg.Batch.Workers)
+	return true
+}
+
+// jobsIsValid does the low level validation that the job worker count and callback networks passed in are usable
+// it falls back to the default count when it isn't set
+func jobsIsValid() bool {
+	log := S.Log.With().Str("method", "jobsIsValid()").Logger()
+	if S.Cfg.Jobs.Workers < 1 {
+		S.Cfg.Jobs.Workers = config.DefaultFlagJobWork
+	}
+	if _, err := S.Cfg.Jobs.CallbackNetworks(); err != nil {
+		log.Error().Msgf("%v must list IPs and CIDRs: %v", config.FlagCallbacks, err)
+		return false
+	}
+	return true
+}
+
+// scansIsValid does the low level validation that the scan buffering passed in is usable
+// it falls back to the defaults when they aren't set
+func scansIsValid() bool {
+	if S.Cfg.Scans.BatchSize < 1 {
+		S.Cfg.Scans.BatchSize = config.DefaultFlagScanBatch
+	}
+	if S.Cfg.Scans.FlushInterval <= 0 {
+		S.Cfg.Scans.FlushInterval = config.DefaultFlagScanFlush
+	}
+	return true
+}
+
+// cacheIsValid does the low level validation that the render cache size passed in is usable
+// it falls back to the default when it is negative
+func cacheIsValid() bool {
+	if S.Cfg.Cache.Size < 0 {
+		S.Cfg.Cache.Size = config.DefaultFlagCacheSize
+	}
+	return true
+}
+
+// loginsIsValid does the low level validation that the password hashing cost and lockout passed in are usable
+// it falls back to the defaults when they aren't set, and refuses costs argon2id can't take
+func loginsIsValid() bool {
+	log := S.Log.With().Str("method", "loginsIsValid()").Logger()
+	l := &S.Cfg.Logins
+	if l.HashTime == 0 {
+		l.HashTime = config.DefaultFlagHashTime
+	}
+	if l.HashMemory == 0 {
+		l.HashMemory = config.DefaultFlagHashMemory
+	}
+	if l.HashThreads == 0 {
+		l.HashThreads = config.DefaultFlagHashThread
+	}
+	if l.MaxFailures < 1 {
+		l.MaxFailures = config.DefaultFlagMaxFailure
+	}
+	if l.Lockout <= 0 {
+		l.Lockout = config.DefaultFlagLockout
+	}
+	if l.HashThreads > 255 || l.HashMemory > 1<<32-1 || l.HashTime > 1<<32-1 {
+		log.Error().Msgf("%v must be at most 255, and %v and %v must fit in 32 bits", config.FlagHashThread, config.FlagHashMemory, config.FlagHashTime)
+		return false
+	}
+	if l.HashMemory < 8*l.HashThreads {
+		log.Error().Msgf("%v must be at least 8 KiB per thread of %v", config.FlagHashMemory, config.FlagHashThread)
+		return false
+	}
+	return true
+}
+
 // logLevelIsValid does the low level validation that the loglevel passed in is valid
 // it logs an error if the log-level config isn't correct
 func logLevelIsValid() bool {
@@ -143,8 +252,132 @@ func createUserValidate(j *json.Decoder, resp http.ResponseWriter) (*auth.Intern
 		return nil, false
 	}
 
-	log.Info().Msgf("User validated: %v %v", aga.Name, aga.Birth)
+	if err := validateUserName(aga.Name); err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := validateBirthDate(aga.Birth); err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := validateEmail(aga.Email); err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if err := auth.ValidatePassword(aga.Password); err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	aga.Name = strings.Join(strings.Fields(aga.Name), " ")
+	log.Info().Msgf("User validated: %v %v %v", aga.Name, aga.Birth, aga.Email)
+
+	return aga.IntoInternal(), true
+
+}
+
+// writeDecodeError translates the error of decoding a JSON request body into the client error response it calls for
+func writeDecodeError(resp http.ResponseWriter, err error, log *zerolog.Logger) {
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	var maxBytesError *http.MaxBytesError
+
+	switch {
+	// Catch any syntax errors in the JSON and send an error message
+	// which interpolates the location of the problem to make it
+	// easier for the client to fix.
+	case errors.As(err, &syntaxError):
+		msg := fmt.Sprintf("Request body contains badly-formed JSON (at position %d)", syntaxError.Offset)
+		http.Error(resp, msg, http.StatusBadRequest)
 
-	return aga.IntoInternal(), !(strings.ContainsAny(aga.IntoInternal().Name().String(), util.Forbidden) && aga.String() == "") // add more validation
+	// In some circumstances Decode() may also return an
+	// io.ErrUnexpectedEOF error for syntax errors in the JSON. There
+	// is an open issue regarding this at
+	// https://github.com/golang/go/issues/25956.
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		msg := fmt.Sprintf("Request body contains badly-formed JSON")
+		http.Error(resp, msg, http.StatusBadRequest)
 
+	// Catch any type errors, like trying to assign a string in the
+	// JSON request body to a int field in our Person struct. We can
+	// interpolate the relevant field name and position into the error
+	// message to make it easier for the client to fix.
+	case errors.As(err, &unmarshalTypeError):
+		msg := fmt.Sprintf("Request body contains an invalid value for the %q field (at position %d)", unmarshalTypeError.Field, unmarshalTypeError.Offset)
+		http.Error(resp, msg, http.StatusBadRequest)
+
+	// Catch the errors of a structured payload that doesn't decode into
+	// the builder its kind selects.
+	case errors.Is(err, generators.ErrInvalidPayload):
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+
+	// Catch the error caused by extra unexpected fields in the request
+	// body. We extract the field name from the error message and
+	// interpolate it in our custom error message. There is an open
+	// issue at https://github.com/golang/go/issues/29035 regarding
+	// turning this into a sentinel error.
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		msg := fmt.Sprintf("Request body contains unknown field %s", fieldName)
+		http.Error(resp, msg, http.StatusBadRequest)
+
+	// An io.EOF error is returned by Decode() if the request body is
+	// empty.
+	case errors.Is(err, io.EOF):
+		msg := "Request body must not be empty"
+		http.Error(resp, msg, http.StatusBadRequest)
+
+	// Catch the error caused by the request body being too large.
+	case errors.As(err, &maxBytesError):
+		msg := fmt.Sprintf("Request body must not be larger than %d bytes", maxBytesError.Limit)
+		http.Error(resp, msg, http.StatusRequestEntityTooLarge)
+
+	// Otherwise default to logging the error and sending a 500 Internal
+	// Server Error response.
+	default:
+		log.Print(err.Error())
+		http.Error(resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// tokensIsValid does the low level validation that the token lifetimes passed in are usable
+// it falls back to the defaults when they aren't set, and refuses keys rotating faster than access tokens expire
+func tokensIsValid() bool {
+	log := S.Log.With().Str("method", "tokensIsValid()").Logger()
+	t := &S.Cfg.Tokens
+	if t.Audience == "" {
+		t.Audience = config.DefaultFlagJWTAud
+	}
+	if t.AccessTTL <= 0 {
+		t.AccessTTL = config.DefaultFlagAccessTTL
+	}
+	if t.RefreshTTL <= 0 {
+		t.RefreshTTL = config.DefaultFlagRefreshTTL
+	}
+	if t.KeyRotation <= 0 {
+		t.KeyRotation = config.DefaultFlagKeyRotate
+	}
+	if t.KeyRateLimit <= 0 {
+		t.KeyRateLimit = config.DefaultFlagKeyRate
+	}
+	if t.KeyRotation < t.AccessTTL {
+		log.Error().Msgf("%v must be at least %v", config.FlagKeyRotate, config.FlagAccessTTL)
+		return false
+	}
+	for _, p := range t.ExemptPaths() {
+		if !strings.HasPrefix(p, "/") {
+			log.Error().Msgf("%v must list paths, like /ping: %v isn't one", config.FlagAuthExempt, p)
+			return false
+		}
+	}
+	return true
+}
+
+// proxiesIsValid does the low level validation that the trusted proxies passed in are usable
+func proxiesIsValid() bool {
+	log := S.Log.With().Str("method", "proxiesIsValid()").Logger()
+	if _, err := S.Cfg.ProxyNetworks(); err != nil {
+		log.Error().Msgf("%v must list IPs and CIDRs: %v", config.FlagProxies, err)
+		return false
+	}
+	return true
 }
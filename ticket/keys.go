@@ -0,0 +1,89 @@
+package ticket
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+)
+
+// JWK is an Ed25519 public key, encoded as a JSON Web Key (RFC 8037)
+type JWK struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv"`
+	Use     string `json:"use,omitempty"`
+	Alg     string `json:"alg,omitempty"`
+	KeyID   string `json:"kid"`
+	X       string `json:"x"`
+}
+
+// JWKS is a set of JSON Web Keys. It's the format port serves its ticket keys in.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK encodes an Ed25519 public key as a JWK
+func NewJWK(pub ed25519.PublicKey) JWK {
+	return JWK{KeyType: "OKP", Curve: "Ed25519", Use: "sig", Alg: "EdDSA", KeyID: KeyID(pub), X: encoding.EncodeToString(pub)}
+}
+
+// PublicKey decodes the Ed25519 public key of a JWK, checking that its key ID matches it
+func (k JWK) PublicKey() (ed25519.PublicKey, error) {
+	if k.KeyType != "OKP" || k.Curve != "Ed25519" {
+		return nil, fmt.Errorf("%w: key %v is not an Ed25519 key", ErrInvalidKey, k.KeyID)
+	}
+	x, err := encoding.DecodeString(k.X)
+	if err != nil || len(x) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: key %v doesn't hold an Ed25519 public key", ErrInvalidKey, k.KeyID)
+	}
+	pub := ed25519.PublicKey(x)
+	if k.KeyID != "" && k.KeyID != KeyID(pub) {
+		return nil, fmt.Errorf("%w: key id %v doesn't match its key", ErrInvalidKey, k.KeyID)
+	}
+	return pub, nil
+}
+
+// NewVerifierFromJWKS returns a Verifier trusting every key of a JSON encoded key set, as served at "/tickets/keys"
+func NewVerifierFromJWKS(data []byte) (*Verifier, error) {
+	var set JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	v, _ := NewVerifier()
+	for _, k := range set.Keys {
+		pub, err := k.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		_ = v.AddKey(pub)
+	}
+	return v, nil
+}
+
+// ParsePrivateKey decodes a PEM encoded PKCS #8 Ed25519 private key, like the ones
+// "openssl genpkey -algorithm ed25519" generates
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block found", ErrInvalidKey)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: key is a %T, not an Ed25519 key", ErrInvalidKey, key)
+	}
+	return ed, nil
+}
+
+// MarshalPrivateKey encodes an Ed25519 private key as PEM encoded PKCS #8
+func MarshalPrivateKey(key ed25519.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
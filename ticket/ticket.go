@@ -0,0 +1,174 @@
+// Package ticket issues and verifies the signed tokens carried by ticket QR codes. A token is compact enough to be
+// encoded in a small code, and is signed with Ed25519, so door scanners holding the public keys can verify tickets
+// offline:
+//
+//	PT1.<key id>.<base64url claims>.<base64url signature>
+//
+// The signature covers everything before the last dot. Scanners embed a Verifier, loaded with the key set served
+// by port at "/tickets/keys".
+package ticket
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Prefix marks a ticket token, and the version of its format
+const Prefix = "PT1"
+
+var (
+	// DefaultLeeway is the clock skew tolerated at both ends of the validity window of a ticket. Door scanners
+	// are often offline, and their clocks drift.
+	DefaultLeeway = 2 * time.Minute
+
+	ErrMalformed    = errors.New("ticket token is malformed")
+	ErrUnknownKey   = errors.New("ticket is signed with an unknown key")
+	ErrBadSignature = errors.New("ticket signature is invalid")
+	ErrNotYetValid  = errors.New("ticket is not valid yet")
+	ErrExpired      = errors.New("ticket has expired")
+	ErrInvalidKey   = errors.New("key is invalid")
+
+	encoding = base64.RawURLEncoding
+)
+
+// Claims are the contents of a ticket. Keys are kept short, so the token stays small enough for a low density code.
+type Claims struct {
+	ID        string `json:"tid"`
+	Event     string `json:"evt"`
+	Seat      string `json:"seat,omitempty"`
+	Holder    string `json:"sub,omitempty"`
+	NotBefore int64  `json:"nbf"`
+	Expires   int64  `json:"exp"`
+}
+
+// ValidAt checks that the ticket is valid at t, give or take leeway
+func (c *Claims) ValidAt(t time.Time, leeway time.Duration) error {
+	if t.Add(leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return fmt.Errorf("%w: valid from %v", ErrNotYetValid, time.Unix(c.NotBefore, 0).UTC())
+	}
+	if !t.Add(-leeway).Before(time.Unix(c.Expires, 0)) {
+		return fmt.Errorf("%w: valid until %v", ErrExpired, time.Unix(c.Expires, 0).UTC())
+	}
+	return nil
+}
+
+// KeyID derives the ID of a public key from its bytes, so keys never need to be named by hand
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return encoding.EncodeToString(sum[:8])
+}
+
+// Signer signs the claims of tickets into tokens
+type Signer struct {
+	kid string
+	key ed25519.PrivateKey
+}
+
+// NewSigner returns a Signer signing with the private key passed in
+func NewSigner(key ed25519.PrivateKey) (*Signer, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("%w: ed25519 private keys are %d bytes long", ErrInvalidKey, ed25519.PrivateKeySize)
+	}
+	return &Signer{kid: KeyID(key.Public().(ed25519.PublicKey)), key: key}, nil
+}
+
+// KeyID returns the ID of the key the Signer signs with
+func (s *Signer) KeyID() string {
+	return s.kid
+}
+
+// Public returns the public key verifying the tokens the Signer signs
+func (s *Signer) Public() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// Sign encodes and signs the claims into a token
+func (s *Signer) Sign(c *Claims) (string, error) {
+	if c.ID == "" || c.Event == "" {
+		return "", fmt.Errorf("%w: claims must carry an id and an event", ErrMalformed)
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signed := Prefix + "." + s.kid + "." + encoding.EncodeToString(payload)
+	return signed + "." + encoding.EncodeToString(ed25519.Sign(s.key, []byte(signed))), nil
+}
+
+// Verifier verifies tokens against a set of public keys. It needs no network access.
+type Verifier struct {
+	keys map[string]ed25519.PublicKey
+	// Leeway is the clock skew tolerated at both ends of the validity window of a ticket
+	Leeway time.Duration
+}
+
+// NewVerifier returns a Verifier trusting the public keys passed in
+func NewVerifier(keys ...ed25519.PublicKey) (*Verifier, error) {
+	v := &Verifier{keys: make(map[string]ed25519.PublicKey, len(keys)), Leeway: DefaultLeeway}
+	for _, k := range keys {
+		if err := v.AddKey(k); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// AddKey trusts another public key, so keys can be rotated without invalidating tickets already issued
+func (v *Verifier) AddKey(key ed25519.PublicKey) error {
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: ed25519 public keys are %d bytes long", ErrInvalidKey, ed25519.PublicKeySize)
+	}
+	v.keys[KeyID(key)] = key
+	return nil
+}
+
+// Verify checks the signature of a token, and that the ticket is valid now. It returns the claims of the ticket.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	return v.VerifyAt(token, time.Now())
+}
+
+// VerifyAt checks the signature of a token, and that the ticket is valid at t
+func (v *Verifier) VerifyAt(token string, t time.Time) (*Claims, error) {
+	c, err := v.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ValidAt(t, v.Leeway); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// Parse checks the signature of a token, without checking when the ticket is valid
+func (v *Verifier) Parse(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 || parts[0] != Prefix {
+		return nil, ErrMalformed
+	}
+	key, ok := v.keys[parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownKey, parts[1])
+	}
+	sig, err := encoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if !ed25519.Verify(key, []byte(token[:strings.LastIndexByte(token, '.')]), sig) {
+		return nil, ErrBadSignature
+	}
+	payload, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	c := &Claims{}
+	if err := json.Unmarshal(payload, c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return c, nil
+}
@@ -0,0 +1,120 @@
+package ticket
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+	"time"
+)
+
+type TicketTest struct {
+	signer *Signer
+	claims *Claims
+	now    time.Time
+	suite.Suite
+}
+
+func (s *TicketTest) SetupTest() {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	s.Require().NoError(err)
+	s.signer, err = NewSigner(key)
+	s.Require().NoError(err)
+	s.now = time.Date(2026, 6, 1, 18, 0, 0, 0, time.UTC)
+	s.claims = &Claims{
+		ID:        "7b0e5ad6-50c5-4f3b-9d0a-3b1c1f2a9e11",
+		Event:     "gophercon-2026",
+		Seat:      "B-12",
+		Holder:    "65a1f0c2e4b0a1b2c3d4e5f6",
+		NotBefore: s.now.Add(-time.Hour).Unix(),
+		Expires:   s.now.Add(time.Hour).Unix(),
+	}
+}
+
+// TestRoundTrip tests that a signed ticket verifies with the public key, and carries its claims
+func (s *TicketTest) TestRoundTrip() {
+	token, err := s.signer.Sign(s.claims)
+	s.Require().NoError(err)
+	s.Assert().True(strings.HasPrefix(token, Prefix+"."+s.signer.KeyID()+"."))
+
+	v, err := NewVerifier(s.signer.Public())
+	s.Require().NoError(err)
+	claims, err := v.VerifyAt(token, s.now)
+	s.Require().NoError(err)
+	s.Assert().Equal(s.claims, claims)
+}
+
+// TestValidity tests the validity window of tickets, and the leeway around it
+func (s *TicketTest) TestValidity() {
+	token, err := s.signer.Sign(s.claims)
+	s.Require().NoError(err)
+	v, _ := NewVerifier(s.signer.Public())
+
+	_, err = v.VerifyAt(token, s.now.Add(-2*time.Hour))
+	s.Assert().ErrorIs(err, ErrNotYetValid)
+	_, err = v.VerifyAt(token, s.now.Add(2*time.Hour))
+	s.Assert().ErrorIs(err, ErrExpired)
+	_, err = v.VerifyAt(token, s.now.Add(time.Hour+time.Minute))
+	s.Assert().NoError(err)
+
+	v.Leeway = 0
+	_, err = v.VerifyAt(token, s.now.Add(time.Hour))
+	s.Assert().ErrorIs(err, ErrExpired)
+}
+
+// TestTampering tests that tokens that were altered, or signed with other keys, are rejected
+func (s *TicketTest) TestTampering() {
+	token, err := s.signer.Sign(s.claims)
+	s.Require().NoError(err)
+	v, _ := NewVerifier(s.signer.Public())
+
+	parts := strings.Split(token, ".")
+	forged := *s.claims
+	forged.Seat = "A-1"
+	payload, _ := json.Marshal(forged)
+	parts[2] = encoding.EncodeToString(payload)
+	_, err = v.VerifyAt(strings.Join(parts, "."), s.now)
+	s.Assert().ErrorIs(err, ErrBadSignature)
+
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := NewSigner(other)
+	token, err = otherSigner.Sign(s.claims)
+	s.Require().NoError(err)
+	_, err = v.VerifyAt(token, s.now)
+	s.Assert().ErrorIs(err, ErrUnknownKey)
+
+	for _, malformed := range []string{"", "PT1", "PT2.a.b.c", "PT1." + s.signer.KeyID() + ".e30.!!"} {
+		_, err = v.VerifyAt(malformed, s.now)
+		s.Assert().Errorf(err, "%q", malformed)
+	}
+}
+
+// TestKeys tests that keys survive their encodings: PEM for the signing key, and a JWKS for the verifying keys
+func (s *TicketTest) TestKeys() {
+	pemKey, err := MarshalPrivateKey(s.signer.key)
+	s.Require().NoError(err)
+	key, err := ParsePrivateKey(pemKey)
+	s.Require().NoError(err)
+	s.Assert().Equal(s.signer.key, key)
+
+	set, err := json.Marshal(JWKS{Keys: []JWK{NewJWK(s.signer.Public())}})
+	s.Require().NoError(err)
+	v, err := NewVerifierFromJWKS(set)
+	s.Require().NoError(err)
+	token, _ := s.signer.Sign(s.claims)
+	_, err = v.VerifyAt(token, s.now)
+	s.Assert().NoError(err)
+
+	jwk := NewJWK(s.signer.Public())
+	jwk.KeyID = "not-its-id"
+	_, err = jwk.PublicKey()
+	s.Assert().ErrorIs(err, ErrInvalidKey)
+	_, err = ParsePrivateKey([]byte("not a key"))
+	s.Assert().ErrorIs(err, ErrInvalidKey)
+}
+
+func TestTicketTest(t *testing.T) {
+	suite.Run(t, new(TicketTest))
+}
@@ -0,0 +1,119 @@
+// Package totp generates and checks the time-based one-time passwords of authenticator apps (RFC 6238). Codes are
+// the HOTP (RFC 4226) of the number of periods elapsed since the Unix epoch, keyed by a secret shared once, through
+// an otpauth:// URI:
+//
+//	otpauth://totp/<issuer>:<account>?secret=<base32 secret>&issuer=<issuer>&algorithm=SHA1&digits=6&period=30
+//
+// SHA-1, 6 digits and 30 second periods are what every authenticator app supports, so they are the only ones used.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// Digits is the length of codes
+	Digits = 6
+	// Period is how long a code is valid for
+	Period = 30 * time.Second
+	// Skew is the number of periods before and after the current one whose codes are accepted too, for clocks that
+	// drift and codes typed slowly
+	Skew = 1
+	// SecretSize is the size of secrets in bytes, the size of the SHA-1 output RFC 4226 recommends
+	SecretSize = 20
+
+	ErrMalformedSecret = errors.New("secret is not base32")
+	ErrInvalidCode     = errors.New("code is invalid")
+
+	encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// NewSecret returns a random secret, base32 encoded without padding, the way authenticator apps take them
+func NewSecret() (string, error) {
+	b := make([]byte, SecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Step returns the number of the period t falls in
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code of the secret for the period t falls in
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decode(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, Step(t), Digits), nil
+}
+
+// Validate checks a code against the secret at t, and returns the step it is the code of. Codes of the Skew periods
+// around t are accepted too. Callers keep the step of the last code accepted, and refuse codes of steps up to it,
+// so a code can't be used twice.
+func Validate(secret, code string, t time.Time) (int64, error) {
+	key, err := decode(secret)
+	if err != nil {
+		return 0, err
+	}
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != Digits {
+		return 0, ErrInvalidCode
+	}
+	now := Step(t)
+	for i := -Skew; i <= Skew; i++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, now+int64(i), Digits)), []byte(code)) == 1 {
+			return now + int64(i), nil
+		}
+	}
+	return 0, ErrInvalidCode
+}
+
+// URI returns the otpauth:// URI authenticator apps enroll the secret with, labeled with the issuer and account
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+func decode(secret string) ([]byte, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil || len(key) == 0 {
+		return nil, ErrMalformedSecret
+	}
+	return key, nil
+}
+
+// hotp is the HMAC-based one-time password of the counter (RFC 4226 section 5.3)
+func hotp(key []byte, counter int64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0F
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7FFFFFFF
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
@@ -0,0 +1,68 @@
+package totp
+
+import (
+	"github.com/stretchr/testify/suite"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type TOTPTest struct {
+	suite.Suite
+}
+
+// TestVectors tests codes against the SHA-1 test vectors of RFC 6238 appendix B
+func (s *TOTPTest) TestVectors() {
+	key := []byte("12345678901234567890")
+	for unix, want := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	} {
+		s.Assert().Equal(want, hotp(key, Step(time.Unix(unix, 0)), 8), unix)
+	}
+	code, err := Code(encoding.EncodeToString(key), time.Unix(59, 0))
+	s.Require().NoError(err)
+	s.Assert().Equal("287082", code)
+}
+
+// TestValidate tests that codes are accepted within the skew, and refused outside of it
+func (s *TOTPTest) TestValidate() {
+	secret, err := NewSecret()
+	s.Require().NoError(err)
+	now := time.Unix(1700000000, 0)
+	code, err := Code(secret, now)
+	s.Require().NoError(err)
+
+	step, err := Validate(secret, code, now)
+	s.Require().NoError(err)
+	s.Assert().Equal(Step(now), step)
+	step, err = Validate(secret, code, now.Add(Period))
+	s.Require().NoError(err)
+	s.Assert().Equal(Step(now), step)
+	_, err = Validate(secret, code, now.Add(3*Period))
+	s.Assert().ErrorIs(err, ErrInvalidCode)
+	_, err = Validate(secret, "12345", now)
+	s.Assert().ErrorIs(err, ErrInvalidCode)
+	_, err = Validate("not base32!", code, now)
+	s.Assert().ErrorIs(err, ErrMalformedSecret)
+}
+
+// TestURI tests that enrollment URIs carry the secret and label the account
+func (s *TOTPTest) TestURI() {
+	u, err := url.Parse(URI("Port", "ada@example.com", "JBSWY3DPEHPK3PXP"))
+	s.Require().NoError(err)
+	s.Assert().Equal("otpauth", u.Scheme)
+	s.Assert().Equal("totp", u.Host)
+	s.Assert().Equal("/Port:ada@example.com", u.Path)
+	s.Assert().Equal("JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+	s.Assert().Equal("Port", u.Query().Get("issuer"))
+	s.Assert().Equal("30", u.Query().Get("period"))
+}
+
+func TestTOTPTest(t *testing.T) {
+	suite.Run(t, new(TOTPTest))
+}
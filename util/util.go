@@ -15,6 +15,11 @@ const (
 	ConfigInContext    = "serverConfig"
 	RequestIDInContext = "requestID"
 	QRLocInContext     = "qrLoc"
+	QRMimeInContext    = "qrMime"
+	StorageInContext   = "storage"
+	CacheInContext     = "renderCache"
+	ClaimsInContext    = "claims"
+	APIKeyInContext    = "apiKey"
 )
 
 const (
@@ -32,7 +37,7 @@ var (
 
 // IsIn checks if the bee is in the hive
 func IsIn(bee string, hive []string) bool {
-	for i := 0; i <= len(hive); i++ {
+	for i := 0; i < len(hive); i++ {
 		if bee == hive[i] {
 			return true
 		}